@@ -0,0 +1,148 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"unicode"
+
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/bcdb"
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/signagent"
+	"github.com/hyperledger-labs/orion-server/pkg/crypto"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func agentCmd() *cobra.Command {
+	agentCmd := &cobra.Command{
+		Use:   "agent",
+		Short: "Run a signing agent",
+		Long: "The agent command runs a signing agent that holds user private keys and serves sign requests over a Unix domain socket, " +
+			"until it is interrupted. Applications sign through the agent without holding key material.",
+		Example: "cli agent -s /tmp/bcdb-agent.sock -k alice=crypto/alice/alice.key -k bob=crypto/bob/bob.key",
+		Args:    cobra.NoArgs,
+		RunE:    runAgent,
+	}
+
+	agentCmd.Flags().StringP("socket", "s", "", "set the path of the Unix domain socket the agent listens on")
+	if err := agentCmd.MarkFlagRequired("socket"); err != nil {
+		panic(err.Error())
+	}
+	agentCmd.Flags().StringArrayP("key", "k", nil, "add the private key of a user, as <user-id>=<path-to-private-key>, can be repeated")
+	if err := agentCmd.MarkFlagRequired("key"); err != nil {
+		panic(err.Error())
+	}
+	agentCmd.Flags().Bool("confirm", false, "ask for confirmation on the terminal before serving each sign request")
+
+	return agentCmd
+}
+
+func runAgent(cmd *cobra.Command, args []string) error {
+	socketPath, err := cmd.Flags().GetString("socket")
+	if err != nil {
+		return errors.Wrapf(err, "failed to fetch the path of the agent socket")
+	}
+	keys, err := cmd.Flags().GetStringArray("key")
+	if err != nil {
+		return errors.Wrapf(err, "failed to fetch the user private keys")
+	}
+	confirm, err := cmd.Flags().GetBool("confirm")
+	if err != nil {
+		return errors.Wrapf(err, "failed to fetch the confirm flag")
+	}
+
+	var signers []crypto.Signer
+	for _, key := range keys {
+		userID, keyPath, ok := strings.Cut(key, "=")
+		if !ok || userID == "" || keyPath == "" {
+			return errors.Errorf("invalid key [%s], expected <user-id>=<path-to-private-key>", key)
+		}
+		signer, err := bcdb.NewEncryptedFileSigner(userID, keyPath, keyPassphrase())
+		if err != nil {
+			return errors.WithMessagef(err, "failed to load the private key of user [%s]", userID)
+		}
+		signers = append(signers, signer)
+	}
+
+	agentConfig := &signagent.AgentConfig{
+		Signers: signers,
+	}
+	if confirm {
+		agentConfig.Approve = confirmOnTerminal()
+	}
+	agent, err := signagent.NewAgent(agentConfig)
+	if err != nil {
+		return errors.WithMessage(err, "failed to create the signing agent")
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- agent.ListenAndServe(socketPath)
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case err = <-serveErr:
+		return err
+	case <-signals:
+	case <-cmd.Context().Done():
+	}
+	return agent.Close()
+}
+
+// confirmOnTerminal returns an approval hook that asks for the confirmation of each sign request on the terminal,
+// one request at a time
+func confirmOnTerminal() signagent.ApprovalFunc {
+	var lock sync.Mutex
+	reader := bufio.NewReader(os.Stdin)
+
+	return func(req *signagent.SignRequest) error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		fmt.Fprintf(os.Stderr, "%s\nApprove? [y/N]: ", describeSignRequest(req))
+		answer, err := reader.ReadString('\n')
+		if err != nil {
+			return errors.Wrap(err, "failed to read the confirmation")
+		}
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+			return errors.New("rejected by the agent operator")
+		}
+		return nil
+	}
+}
+
+// describeSignRequest renders a sign request for the agent operator: the identity, the SHA-256 digest of the message,
+// and the message indented if it is JSON, as the payloads of the SDK are. The request comes from any client of the
+// socket, hence the control characters it may hold are removed, so that it cannot drive the terminal.
+func describeSignRequest(req *signagent.SignRequest) string {
+	digest := sha256.Sum256(req.Message)
+	message := "(not a JSON payload)"
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, req.Message, "", "  "); err == nil {
+		message = indented.String()
+	}
+	return fmt.Sprintf("Sign request of user [%s]\nSHA-256: %s\n%s",
+		stripControlCharacters(req.Identity), hex.EncodeToString(digest[:]), stripControlCharacters(message))
+}
+
+// stripControlCharacters removes the control characters, but new lines, from the text
+func stripControlCharacters(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r != '\n' && unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text)
+}
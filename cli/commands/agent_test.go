@@ -0,0 +1,135 @@
+package commands
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/signagent"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgentCommand(t *testing.T) {
+	// 1. Create the private keys of two users, one of them encrypted
+	tempDir := t.TempDir()
+	keys := map[string]*ecdsa.PrivateKey{}
+	for _, userID := range []string{"alice", "bob"} {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		keys[userID] = key
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		require.NoError(t, err)
+		block := &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+		if userID == "bob" {
+			block, err = x509.EncryptPEMBlock(rand.Reader, block.Type, der, []byte("secret"), x509.PEMCipherAES256)
+			require.NoError(t, err)
+		}
+		require.NoError(t, os.WriteFile(path.Join(tempDir, userID+".key"), pem.EncodeToMemory(block), 0600))
+	}
+	t.Setenv(keyPassphraseEnv, "secret")
+
+	// 2. Run the agent command until the context is canceled
+	socketPath := path.Join(tempDir, "agent.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+	rootCmd := InitializeOrionCli()
+	rootCmd.SetArgs([]string{"agent", "-s", socketPath, "-k", "alice=" + path.Join(tempDir, "alice.key"), "-k", "bob=" + path.Join(tempDir, "bob.key")})
+	cmdErr := make(chan error, 1)
+	go func() {
+		cmdErr <- rootCmd.ExecuteContext(ctx)
+	}()
+
+	var client *signagent.Client
+	require.Eventually(t, func() bool {
+		var err error
+		client, err = signagent.Dial(socketPath)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	defer client.Close()
+
+	// 3. Sign through the agent with both identities
+	identities, err := client.Identities()
+	require.NoError(t, err)
+	require.Equal(t, []string{"alice", "bob"}, identities)
+	for userID, key := range keys {
+		signer, err := client.Signer(userID)
+		require.NoError(t, err)
+		signature, err := signer.Sign([]byte("message"))
+		require.NoError(t, err)
+		h := sha256.Sum256([]byte("message"))
+		require.True(t, ecdsa.VerifyASN1(&key.PublicKey, h[:], signature))
+	}
+
+	// 4. Stop the agent
+	cancel()
+	require.NoError(t, <-cmdErr)
+	_, err = os.Stat(socketPath)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestInvalidFlagsAgentCommand(t *testing.T) {
+	tests := []struct {
+		name           string
+		args           []string
+		expectedErrMsg string
+	}{
+		{
+			name:           "no socket",
+			args:           []string{"agent", "-k", "alice=alice.key"},
+			expectedErrMsg: "required flag(s) \"socket\" not set",
+		},
+		{
+			name:           "no key",
+			args:           []string{"agent", "-s", "agent.sock"},
+			expectedErrMsg: "required flag(s) \"key\" not set",
+		},
+		{
+			name:           "invalid key",
+			args:           []string{"agent", "-s", "agent.sock", "-k", "alice.key"},
+			expectedErrMsg: "invalid key [alice.key], expected <user-id>=<path-to-private-key>",
+		},
+		{
+			name:           "missing key file",
+			args:           []string{"agent", "-s", "agent.sock", "-k", "alice=/does/not/exist.key"},
+			expectedErrMsg: "failed to load the private key of user [alice]: cannot read user's private key from /does/not/exist.key: open /does/not/exist.key: no such file or directory",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rootCmd := InitializeOrionCli()
+			rootCmd.SetArgs(tt.args)
+			err := rootCmd.Execute()
+			require.EqualError(t, err, tt.expectedErrMsg)
+		})
+	}
+}
+
+func TestDescribeSignRequest(t *testing.T) {
+	digest := func(message string) string {
+		sum := sha256.Sum256([]byte(message))
+		return hex.EncodeToString(sum[:])
+	}
+
+	message := `{"must_sign_user_ids":["alice"],"tx_id":"tx1"}`
+	require.Equal(t, "Sign request of user [alice]\nSHA-256: "+digest(message)+"\n"+
+		"{\n  \"must_sign_user_ids\": [\n    \"alice\"\n  ],\n  \"tx_id\": \"tx1\"\n}",
+		describeSignRequest(&signagent.SignRequest{Identity: "alice", Message: []byte(message)}))
+
+	// the escape sequences of the client do not reach the terminal
+	message = "\x1b[2J\x1b]0;title\x07binary"
+	require.Equal(t, "Sign request of user [alice[1m]\nSHA-256: "+digest(message)+"\n(not a JSON payload)",
+		describeSignRequest(&signagent.SignRequest{Identity: "alice\x1b[1m", Message: []byte(message)}))
+
+	message = "{\"tx_id\":\"tx\u009b1\"}"
+	require.Equal(t, "Sign request of user [alice]\nSHA-256: "+digest(message)+"\n{\n  \"tx_id\": \"tx1\"\n}",
+		describeSignRequest(&signagent.SignRequest{Identity: "alice", Message: []byte(message)}))
+}
@@ -1,13 +1,18 @@
 package commands
 
 import (
+	"os"
+
 	"github.com/hyperledger-labs/orion-sdk-go/pkg/bcdb"
 	"github.com/hyperledger-labs/orion-sdk-go/pkg/config"
 	"github.com/hyperledger-labs/orion-server/pkg/logger"
 	"github.com/pkg/errors"
-	"github.com/spf13/viper"
 )
 
+// keyPassphraseEnv is the environment variable holding the passphrase of an encrypted user private key. If it is
+// not set, the passphrase is prompted for.
+const keyPassphraseEnv = "BCDBADMIN_KEY_PASSPHRASE"
+
 type cliConnectionConfig struct {
 	ConnectionConfig config.ConnectionConfig `yaml:"connection"`
 	SessionConfig    config.SessionConfig    `yaml:"session"`
@@ -27,6 +32,10 @@ func (c *cliConfigParams) CreateDbAndOpenSession() error {
 		return errors.Wrapf(err, "failed to read CLI connection configuration file")
 	}
 
+	if userConfig := c.cliConfig.SessionConfig.UserConfig; userConfig != nil && userConfig.PrivateKeyPassphrase == nil {
+		userConfig.PrivateKeyPassphrase = keyPassphrase()
+	}
+
 	c.db, err = bcdb.Create(&c.cliConfig.ConnectionConfig)
 	if err != nil {
 		return errors.Wrapf(err, "failed to instanciate a databse connection")
@@ -46,20 +55,20 @@ func (c *cliConnectionConfig) ReadAndConstructCliConnConfig(filePath string) err
 		return errors.New("path to the connection configuration file is empty")
 	}
 
-	v := viper.New()
-	v.SetConfigFile(filePath)
-
-	if err := v.ReadInConfig(); err != nil {
-		return errors.Wrapf(err, "error reading connection config file: %s", filePath)
+	conf, err := config.ReadConfig(filePath)
+	if err != nil {
+		return err
 	}
+	c.ConnectionConfig = conf.ConnectionConfig
+	c.SessionConfig = conf.SessionConfig
 
-	if err := v.UnmarshalExact(c); err != nil {
-		return errors.Wrapf(err, "unable to unmarshal connection config file: '%s' into struct", filePath)
+	level := c.ConnectionConfig.LogLevel
+	if level == "" {
+		level = "debug"
 	}
-
 	clientLogger, err := logger.New(
 		&logger.Config{
-			Level:         "debug",
+			Level:         level,
 			OutputPath:    []string{"stdout"},
 			ErrOutputPath: []string{"stderr"},
 			Encoding:      "console",
@@ -73,3 +82,12 @@ func (c *cliConnectionConfig) ReadAndConstructCliConnConfig(filePath string) err
 
 	return nil
 }
+
+// keyPassphrase returns the passphrase source of an encrypted user private key, which is the environment variable
+// BCDBADMIN_KEY_PASSPHRASE if set, and a prompt otherwise
+func keyPassphrase() config.PassphraseFunc {
+	if _, ok := os.LookupEnv(keyPassphraseEnv); ok {
+		return bcdb.PassphraseFromEnv(keyPassphraseEnv)
+	}
+	return bcdb.PassphraseFromPrompt("Enter the passphrase of the user private key: ")
+}
@@ -1,6 +1,9 @@
 package commands
 
 import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
 	"net/url"
 	"os"
 	"path"
@@ -372,3 +375,43 @@ func buildSharedClusterConfigAndWriteConfigToYaml(clusterConfig *types.ClusterCo
 	}
 	return nil
 }
+
+func TestGetConfigCommand_EncryptedPrivateKey(t *testing.T) {
+	// 1. Create crypto material, encrypt the admin private key and start server
+	tempDir, err := os.MkdirTemp(os.TempDir(), "Cli-Encrypted-Key-Test")
+	require.NoError(t, err)
+
+	testServer, _, _, err := util.SetupTestEnv(t, tempDir, uint32(6003))
+	require.NoError(t, err)
+	defer testServer.Stop()
+	util.StartTestServer(t, testServer)
+
+	keyPath := path.Join(tempDir, "crypto", "admin", "admin.key")
+	keyPEM, err := os.ReadFile(keyPath)
+	require.NoError(t, err)
+	keyBlock, _ := pem.Decode(keyPEM)
+	require.NotNil(t, keyBlock)
+	encryptedBlock, err := x509.EncryptPEMBlock(rand.Reader, keyBlock.Type, keyBlock.Bytes, []byte("secret"), x509.PEMCipherAES256)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(encryptedBlock), 0600))
+
+	getConfig := func() error {
+		createdDirName, err := os.MkdirTemp(os.TempDir(), "configTest")
+		require.NoError(t, err)
+		defer os.RemoveAll(createdDirName)
+
+		rootCmd := InitializeOrionCli()
+		rootCmd.SetArgs([]string{"config", "get", "-d", path.Join(tempDir, "config.yml"), "-c", createdDirName})
+		return rootCmd.Execute()
+	}
+
+	// 2. Get cluster config with the passphrase in the environment
+	t.Setenv(keyPassphraseEnv, "secret")
+	require.NoError(t, getConfig())
+
+	// 3. Get cluster config with a wrong passphrase
+	t.Setenv(keyPassphraseEnv, "wrong")
+	err = getConfig()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to decrypt the private key")
+}
@@ -19,5 +19,6 @@ func InitializeOrionCli() *cobra.Command {
 	cmd.AddCommand(adminCmd())
 	cmd.AddCommand(nodeCmd())
 	cmd.AddCommand(casCmd())
+	cmd.AddCommand(agentCmd())
 	return cmd
 }
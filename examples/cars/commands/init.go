@@ -135,7 +135,8 @@ func initDB(session bcdb.DBSession, lg *logger.SugarLogger) error {
 }
 
 func initUsers(demoDir string, session bcdb.DBSession, logger *logger.SugarLogger) error {
-	for _, role := range []string{"dmv", "dealer", "alice", "bob"} {
+	roles := []string{"dmv", "dealer", "alice", "bob"}
+	for _, role := range roles {
 		usersTx, err := session.UsersTx()
 		if err != nil {
 			return err
@@ -155,7 +156,9 @@ func initUsers(demoDir string, session bcdb.DBSession, logger *logger.SugarLogge
 				Privilege: &types.Privilege{
 					DbPermission: map[string]types.Privilege_Access{CarDBName: 1},
 				},
-			}, nil)
+			},
+			// all roles can read each other's certificate, in order to verify the signatures on a transfer
+			&types.AccessControl{ReadUsers: usersMap(roles...)})
 		if err != nil {
 			usersTx.Abort()
 			return err
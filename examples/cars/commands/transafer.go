@@ -182,6 +182,9 @@ func buyerValidateTransfer(buyerID, sellerID, dmvID, carReg string, buyerTx bcdb
 	if !hasSeller {
 		return errors.New("Car seller is not in signed-users")
 	}
+	if err := verifyTransferSignatures(buyerTx); err != nil {
+		return err
+	}
 
 	//validate the writes
 	if newCarRec.Owner != buyerID {
@@ -273,6 +276,9 @@ func dmvValidateTransfer(dmvID string, sessionDMV bcdb.DBSession, dmvTx bcdb.Loa
 	if !hasSeller {
 		return errors.New("Car seller is not in signed-users")
 	}
+	if err = verifyTransferSignatures(dmvTx); err != nil {
+		return err
+	}
 
 	//validate the writes
 	if newCarRec.CarRegistration != carRec.CarRegistration {
@@ -288,3 +294,18 @@ func dmvValidateTransfer(dmvID string, sessionDMV bcdb.DBSession, dmvTx bcdb.Loa
 	// validate the seller, buyer, car, are not on a black list, etc.
 	return nil
 }
+
+// verifyTransferSignatures makes sure that the existing signatures were really made by the signed users
+func verifyTransferSignatures(tx bcdb.LoadedDataTxContext) error {
+	res, err := tx.VerifySignatures()
+	if err != nil {
+		return errors.WithMessage(err, "error verifying signatures")
+	}
+	if invalid := res.InvalidUsers(); len(invalid) > 0 {
+		return errors.Errorf("invalid signatures of users: %v", invalid)
+	}
+	if unknown := res.UnknownUsers(); len(unknown) > 0 {
+		return errors.Errorf("signatures of unknown users: %v", unknown)
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package internal
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/config"
+)
+
+const (
+	DefaultFailureThreshold = 3
+	DefaultProbeInterval    = time.Second
+	DefaultSlowThreshold    = time.Second
+
+	// latencyWeight is the weight of the latest latency in the moving average of the latency of a replica
+	latencyWeight = 0.2
+)
+
+type CircuitState int32
+
+const (
+	CircuitState_CLOSED CircuitState = 0
+	CircuitState_OPEN   CircuitState = 1
+)
+
+var CircuitStateName = map[int32]string{
+	0: "CLOSED",
+	1: "OPEN",
+}
+
+func (s CircuitState) String() string {
+	return CircuitStateName[int32(s)]
+}
+
+// ReplicaHealthStatus is the health of a replica
+type ReplicaHealthStatus struct {
+	State               CircuitState
+	ConsecutiveFailures int
+	// Latency is the moving average of the latency of the responses of the replica
+	Latency time.Duration
+	// Score is between 0, for a replica with an open circuit, and 1, for a replica that responds fast and without
+	// failures
+	Score float64
+}
+
+// ReplicaHealth tracks the health of the replicas by their ID, so that it survives updates of the replica set.
+// A nil *ReplicaHealth is valid and reports all the replicas as healthy. ReplicaHealth is safe for concurrent use.
+type ReplicaHealth struct {
+	failureThreshold int
+	probeInterval    time.Duration
+	slowThreshold    time.Duration
+
+	lock     sync.Mutex
+	replicas map[string]*ReplicaHealthStatus
+}
+
+// NewReplicaHealth creates the health tracker of the given configuration, or returns nil if health tracking is
+// disabled
+func NewReplicaHealth(conf *config.ReplicaHealthConfig) *ReplicaHealth {
+	if conf == nil {
+		conf = &config.ReplicaHealthConfig{}
+	}
+	if conf.Disabled {
+		return nil
+	}
+
+	h := &ReplicaHealth{
+		failureThreshold: conf.FailureThreshold,
+		probeInterval:    conf.ProbeInterval,
+		slowThreshold:    conf.SlowThreshold,
+		replicas:         make(map[string]*ReplicaHealthStatus),
+	}
+	if h.failureThreshold <= 0 {
+		h.failureThreshold = DefaultFailureThreshold
+	}
+	if h.probeInterval <= 0 {
+		h.probeInterval = DefaultProbeInterval
+	}
+	if h.slowThreshold <= 0 {
+		h.slowThreshold = DefaultSlowThreshold
+	}
+	return h
+}
+
+// ProbeInterval returns the interval between two probes of a replica with an open circuit
+func (h *ReplicaHealth) ProbeInterval() time.Duration {
+	if h == nil {
+		return DefaultProbeInterval
+	}
+	return h.probeInterval
+}
+
+// RecordSuccess records a response of the replica, and returns true if it closed the circuit of the replica
+func (h *ReplicaHealth) RecordSuccess(replicaID string, latency time.Duration) bool {
+	if h == nil {
+		return false
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	status := h.statusLocked(replicaID)
+	closed := status.State == CircuitState_OPEN
+	status.State = CircuitState_CLOSED
+	status.ConsecutiveFailures = 0
+	if status.Latency == 0 {
+		status.Latency = latency
+	} else {
+		status.Latency = time.Duration(latencyWeight*float64(latency) + (1-latencyWeight)*float64(status.Latency))
+	}
+	h.scoreLocked(status)
+	return closed
+}
+
+// RecordFailure records a failure of the replica, i.e., a connection error or a service unavailable response, and
+// returns true if it opened the circuit of the replica
+func (h *ReplicaHealth) RecordFailure(replicaID string) bool {
+	if h == nil {
+		return false
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	status := h.statusLocked(replicaID)
+	status.ConsecutiveFailures++
+	opened := status.State == CircuitState_CLOSED && status.ConsecutiveFailures >= h.failureThreshold
+	if opened {
+		status.State = CircuitState_OPEN
+	}
+	h.scoreLocked(status)
+	return opened
+}
+
+// IsOpen returns true if the circuit of the replica is open
+func (h *ReplicaHealth) IsOpen(replicaID string) bool {
+	if h == nil {
+		return false
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	status, ok := h.replicas[replicaID]
+	return ok && status.State == CircuitState_OPEN
+}
+
+// Status returns the health of the replica
+func (h *ReplicaHealth) Status(replicaID string) ReplicaHealthStatus {
+	if h == nil {
+		return ReplicaHealthStatus{Score: 1}
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if status, ok := h.replicas[replicaID]; ok {
+		return *status
+	}
+	return ReplicaHealthStatus{Score: 1}
+}
+
+// Forget removes the health of the replica, e.g. when it left the cluster
+func (h *ReplicaHealth) Forget(replicaID string) {
+	if h == nil {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	delete(h.replicas, replicaID)
+}
+
+func (h *ReplicaHealth) statusLocked(replicaID string) *ReplicaHealthStatus {
+	status, ok := h.replicas[replicaID]
+	if !ok {
+		status = &ReplicaHealthStatus{Score: 1}
+		h.replicas[replicaID] = status
+	}
+	return status
+}
+
+// scoreLocked computes the score of the replica: every consecutive failure lowers it, and so does an average latency
+// above the slow threshold
+func (h *ReplicaHealth) scoreLocked(status *ReplicaHealthStatus) {
+	if status.State == CircuitState_OPEN {
+		status.Score = 0
+		return
+	}
+	status.Score = 1 / float64(1+status.ConsecutiveFailures)
+	if status.Latency > h.slowThreshold {
+		status.Score *= float64(h.slowThreshold) / float64(status.Latency)
+	}
+}
+
+// Available returns the replicas whose circuit is closed: the leader first, as only the leader commits transactions,
+// then the other replicas from the highest to the lowest score, the order of the replica set breaking ties. If the
+// circuits of all the replicas are open, all the replicas are returned, as there is no better choice than trying them.
+func (r ReplicaSet) Available(health *ReplicaHealth) ReplicaSet {
+	var available ReplicaSet
+	scores := make(map[string]float64)
+	for _, replica := range r {
+		if status := health.Status(replica.Id); status.State != CircuitState_OPEN {
+			available = append(available, replica)
+			scores[replica.Id] = status.Score
+		}
+	}
+	if len(available) == 0 {
+		return r
+	}
+	sort.SliceStable(available, func(i, j int) bool {
+		leaderI, leaderJ := available[i].Role == ReplicaRole_LEADER, available[j].Role == ReplicaRole_LEADER
+		if leaderI != leaderJ {
+			return leaderI
+		}
+		return scores[available[i].Id] > scores[available[j].Id]
+	})
+	return available
+}
+
+// Select returns the first available replica, i.e., the leader if its circuit is closed, else the replica with the
+// highest score, or the first replica if the circuits of all the replicas are open. It returns nil if the replica
+// set is empty.
+func (r ReplicaSet) Select(health *ReplicaHealth) *ReplicaWithRole {
+	available := r.Available(health)
+	if len(available) == 0 {
+		return nil
+	}
+	return available[0]
+}
+
+// FindByHost returns the replica whose URL has the given host, or nil if there is none
+func (r ReplicaSet) FindByHost(host string) *ReplicaWithRole {
+	for _, replica := range r {
+		if replica.URL.Host == host {
+			return replica
+		}
+	}
+	return nil
+}
+
+// Find returns the replica with the given ID, or nil if there is none
+func (r ReplicaSet) Find(replicaID string) *ReplicaWithRole {
+	for _, replica := range r {
+		if replica.Id == replicaID {
+			return replica
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/config"
+	"github.com/hyperledger-labs/orion-server/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplicaHealth(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		h := NewReplicaHealth(nil)
+		require.NotNil(t, h)
+		require.Equal(t, DefaultProbeInterval, h.ProbeInterval())
+
+		require.Nil(t, NewReplicaHealth(&config.ReplicaHealthConfig{Disabled: true}))
+	})
+
+	t.Run("circuit", func(t *testing.T) {
+		h := NewReplicaHealth(&config.ReplicaHealthConfig{FailureThreshold: 2, ProbeInterval: time.Millisecond})
+		require.Equal(t, time.Millisecond, h.ProbeInterval())
+		require.Equal(t, ReplicaHealthStatus{Score: 1}, h.Status("node-1"))
+
+		require.False(t, h.RecordFailure("node-1"))
+		require.False(t, h.IsOpen("node-1"))
+		require.Equal(t, 0.5, h.Status("node-1").Score)
+
+		// a success resets the consecutive failures
+		require.False(t, h.RecordSuccess("node-1", time.Millisecond))
+		require.False(t, h.RecordFailure("node-1"))
+		require.True(t, h.RecordFailure("node-1"))
+		require.True(t, h.IsOpen("node-1"))
+		require.Equal(t, ReplicaHealthStatus{State: CircuitState_OPEN, ConsecutiveFailures: 2, Latency: time.Millisecond}, h.Status("node-1"))
+		require.Equal(t, "OPEN", h.Status("node-1").State.String())
+
+		// the circuit opens once
+		require.False(t, h.RecordFailure("node-1"))
+		require.False(t, h.IsOpen("node-2"))
+
+		require.True(t, h.RecordSuccess("node-1", time.Millisecond))
+		require.False(t, h.IsOpen("node-1"))
+		require.Equal(t, ReplicaHealthStatus{State: CircuitState_CLOSED, Latency: time.Millisecond, Score: 1}, h.Status("node-1"))
+
+		h.Forget("node-1")
+		require.Equal(t, ReplicaHealthStatus{Score: 1}, h.Status("node-1"))
+	})
+
+	t.Run("latency", func(t *testing.T) {
+		h := NewReplicaHealth(&config.ReplicaHealthConfig{SlowThreshold: 100 * time.Millisecond})
+		h.RecordSuccess("node-1", 400*time.Millisecond)
+		require.Equal(t, 400*time.Millisecond, h.Status("node-1").Latency)
+		require.Equal(t, 0.25, h.Status("node-1").Score)
+
+		// moving average
+		h.RecordSuccess("node-1", 200*time.Millisecond)
+		require.Equal(t, 360*time.Millisecond, h.Status("node-1").Latency)
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		var h *ReplicaHealth
+		require.False(t, h.RecordFailure("node-1"))
+		require.False(t, h.RecordSuccess("node-1", time.Millisecond))
+		require.False(t, h.IsOpen("node-1"))
+		require.Equal(t, ReplicaHealthStatus{Score: 1}, h.Status("node-1"))
+		require.Equal(t, DefaultProbeInterval, h.ProbeInterval())
+		h.Forget("node-1")
+	})
+}
+
+func TestReplicaSet_Select(t *testing.T) {
+	replicas, err := ClusterStatusToReplicaSet(&types.GetClusterStatusResponse{
+		Nodes:  testNodes(3),
+		Leader: "node-2",
+		Active: []string{"node-1", "node-2", "node-3"},
+	}, false)
+	require.NoError(t, err)
+	h := NewReplicaHealth(&config.ReplicaHealthConfig{FailureThreshold: 1})
+
+	require.Nil(t, ReplicaSet(nil).Select(h))
+	require.Equal(t, "node-2", replicas.Select(nil).Id)
+	require.Equal(t, "node-2", replicas.Select(h).Id)
+	require.Len(t, replicas.Available(h), 3)
+
+	h.RecordFailure("node-2")
+	require.Equal(t, "node-1", replicas.Select(h).Id)
+	require.Len(t, replicas.Available(h), 2)
+
+	// when all the circuits are open, all the replicas are available
+	h.RecordFailure("node-1")
+	h.RecordFailure("node-3")
+	require.Equal(t, "node-2", replicas.Select(h).Id)
+	require.Len(t, replicas.Available(h), 3)
+
+	require.Equal(t, "node-3", replicas.Find("node-3").Id)
+	require.Nil(t, replicas.Find("node-4"))
+	require.Equal(t, "node-1", replicas.FindByHost("10.10.10.10:6001").Id)
+	require.Nil(t, replicas.FindByHost("10.10.10.10:7001"))
+}
+
+func TestReplicaSet_AvailableByScore(t *testing.T) {
+	replicas, err := ClusterStatusToReplicaSet(&types.GetClusterStatusResponse{
+		Nodes:  testNodes(3),
+		Leader: "node-2",
+		Active: []string{"node-1", "node-2", "node-3"},
+	}, false)
+	require.NoError(t, err)
+	h := NewReplicaHealth(&config.ReplicaHealthConfig{SlowThreshold: 100 * time.Millisecond})
+	ids := func(replicas ReplicaSet) []string {
+		var ids []string
+		for _, replica := range replicas {
+			ids = append(ids, replica.Id)
+		}
+		return ids
+	}
+
+	require.Equal(t, []string{"node-2", "node-1", "node-3"}, ids(replicas.Available(h)))
+
+	// the leader stays first, whatever its score
+	h.RecordFailure("node-2")
+	require.Equal(t, []string{"node-2", "node-1", "node-3"}, ids(replicas.Available(h)))
+
+	// a follower that failed, or that responds slowly, comes after the healthy followers
+	h.RecordFailure("node-1")
+	require.Equal(t, []string{"node-2", "node-3", "node-1"}, ids(replicas.Available(h)))
+	h.RecordSuccess("node-1", time.Millisecond)
+	h.RecordSuccess("node-3", time.Second)
+	require.Equal(t, []string{"node-2", "node-1", "node-3"}, ids(replicas.Available(h)))
+
+	// the follower with the highest score replaces the leader whose circuit opened
+	h.RecordFailure("node-2")
+	h.RecordFailure("node-2")
+	require.True(t, h.IsOpen("node-2"))
+	require.Equal(t, "node-1", replicas.Select(h).Id)
+}
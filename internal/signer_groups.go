@@ -0,0 +1,65 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package internal
+
+import (
+	"sort"
+	"strings"
+)
+
+// MinimalGroups removes the groups that are satisfied by some user, the duplicate groups, and the groups that contain
+// another group, as any user that satisfies the contained group satisfies the containing one as well. The remaining
+// groups are ordered by size, then lexicographically. Each group is expected to be sorted.
+func MinimalGroups(groups [][]string, satisfied func(userID string) bool) [][]string {
+	var candidates [][]string
+	for _, group := range groups {
+		isSatisfied := false
+		for _, userID := range group {
+			if satisfied(userID) {
+				isSatisfied = true
+				break
+			}
+		}
+		if !isSatisfied {
+			candidates = append(candidates, group)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if len(candidates[i]) != len(candidates[j]) {
+			return len(candidates[i]) < len(candidates[j])
+		}
+		return strings.Join(candidates[i], ",") < strings.Join(candidates[j], ",")
+	})
+
+	var minimal [][]string
+	for _, group := range candidates {
+		redundant := false
+		for _, m := range minimal {
+			if containsAll(group, m) {
+				redundant = true
+				break
+			}
+		}
+		if !redundant {
+			minimal = append(minimal, group)
+		}
+	}
+	return minimal
+}
+
+func containsAll(set, subset []string) bool {
+	for _, s := range subset {
+		found := false
+		for _, e := range set {
+			if e == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,55 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMinimalGroups(t *testing.T) {
+	none := func(string) bool { return false }
+
+	tests := []struct {
+		name      string
+		groups    [][]string
+		satisfied func(string) bool
+		expected  [][]string
+	}{
+		{
+			name:      "empty",
+			satisfied: none,
+		},
+		{
+			name:      "satisfied groups are removed",
+			groups:    [][]string{{"alice", "bob"}, {"carol", "dave"}},
+			satisfied: func(userID string) bool { return userID == "bob" },
+			expected:  [][]string{{"carol", "dave"}},
+		},
+		{
+			name:      "duplicate groups are removed",
+			groups:    [][]string{{"alice", "bob"}, {"alice", "bob"}},
+			satisfied: none,
+			expected:  [][]string{{"alice", "bob"}},
+		},
+		{
+			name:      "containing groups are removed, whatever their position",
+			groups:    [][]string{{"alice", "bob", "carol"}, {"bob", "carol"}, {"carol", "dave"}},
+			satisfied: none,
+			expected:  [][]string{{"bob", "carol"}, {"carol", "dave"}},
+		},
+		{
+			name:      "groups are ordered by size, then lexicographically",
+			groups:    [][]string{{"dave", "erin", "frank"}, {"carol", "dave"}, {"alice", "bob"}},
+			satisfied: none,
+			expected:  [][]string{{"alice", "bob"}, {"carol", "dave"}, {"dave", "erin", "frank"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, MinimalGroups(tt.groups, tt.satisfied))
+		})
+	}
+}
@@ -0,0 +1,440 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package test
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	sdkconfig "github.com/hyperledger-labs/orion-sdk-go/pkg/config"
+	"github.com/hyperledger-labs/orion-server/config"
+	"github.com/hyperledger-labs/orion-server/pkg/server"
+	"github.com/hyperledger-labs/orion-server/pkg/server/testutils"
+	"github.com/stretchr/testify/require"
+)
+
+// ClusterConfig configures an in-process Orion cluster. Zero values select the defaults.
+type ClusterConfig struct {
+	// Nodes is the number of nodes, 3 by default
+	Nodes int
+	// Users are the IDs of the users, in addition to the admin, whose crypto materials are generated. The users
+	// are not created in the cluster.
+	Users []string
+	// BlockTimeout is the block creation timeout, 100 milliseconds by default
+	BlockTimeout time.Duration
+	// MaxTxPerBlock is the maximal number of transactions in a block, 10 by default
+	MaxTxPerBlock uint32
+	// LeaderTimeout is how long to wait for the nodes to agree on a leader, 30 seconds by default
+	LeaderTimeout time.Duration
+}
+
+// AdminID is the ID of the admin of an in-process cluster
+const AdminID = "admin"
+
+// Cluster is an Orion cluster whose nodes run in the process of the test, on free ports and with generated crypto
+// materials. The replication traffic between the nodes goes through proxies, so that the cluster can be partitioned.
+type Cluster struct {
+	t         *testing.T
+	conf      *ClusterConfig
+	cryptoDir string
+
+	lock  sync.Mutex
+	nodes []*Node
+	// side holds the side of the partition of each node, by raft ID, nil if the cluster is not partitioned
+	side map[uint64]int
+}
+
+// Node is a node of an in-process cluster
+type Node struct {
+	ID     string
+	RaftID uint64
+	// Port is the port of the REST API of the node
+	Port uint32
+
+	config  *config.Configurations
+	server  *server.BCDBHTTPServer
+	proxy   *peerProxy
+	running bool
+}
+
+// URL returns the endpoint of the REST API of the node
+func (n *Node) URL() string {
+	return fmt.Sprintf("http://127.0.0.1:%d", n.Port)
+}
+
+// NewCluster creates a cluster, and starts all its nodes. The cluster is stopped at the end of the test.
+func NewCluster(t *testing.T, conf *ClusterConfig) *Cluster {
+	if conf == nil {
+		conf = &ClusterConfig{}
+	}
+	c := &ClusterConfig{
+		Nodes:         conf.Nodes,
+		Users:         conf.Users,
+		BlockTimeout:  conf.BlockTimeout,
+		MaxTxPerBlock: conf.MaxTxPerBlock,
+		LeaderTimeout: conf.LeaderTimeout,
+	}
+	if c.Nodes == 0 {
+		c.Nodes = 3
+	}
+	if c.BlockTimeout == 0 {
+		c.BlockTimeout = 100 * time.Millisecond
+	}
+	if c.MaxTxPerBlock == 0 {
+		c.MaxTxPerBlock = 10
+	}
+	if c.LeaderTimeout == 0 {
+		c.LeaderTimeout = 30 * time.Second
+	}
+
+	var nodeIDs []string
+	for i := 1; i <= c.Nodes; i++ {
+		nodeIDs = append(nodeIDs, fmt.Sprintf("node%d", i))
+	}
+	cluster := &Cluster{
+		t:         t,
+		conf:      c,
+		cryptoDir: testutils.GenerateTestCrypto(t, append(append([]string{AdminID}, nodeIDs...), c.Users...)),
+	}
+	t.Cleanup(cluster.stop)
+
+	sharedConfig := &config.SharedConfiguration{
+		Consensus: &config.ConsensusConf{
+			Algorithm: "raft",
+			RaftConfig: &config.RaftConf{
+				TickInterval:         "10ms",
+				ElectionTicks:        50,
+				HeartbeatTicks:       5,
+				MaxInflightBlocks:    50,
+				SnapshotIntervalSize: math.MaxInt64,
+			},
+		},
+		CAConfig: config.CAConfiguration{RootCACertsPath: []string{path.Join(cluster.cryptoDir, testutils.RootCAFileName+".pem")}},
+		Admin: config.AdminConf{
+			ID:              AdminID,
+			CertificatePath: path.Join(cluster.cryptoDir, AdminID+".pem"),
+		},
+	}
+
+	for i, nodeID := range nodeIDs {
+		node := &Node{ID: nodeID, RaftID: uint64(i + 1)}
+		var peerPort uint32
+		var err error
+		node.Port, err = GetFreePort()
+		require.NoError(t, err)
+		peerPort, err = GetFreePort()
+		require.NoError(t, err)
+		peerURL, err := url.Parse(fmt.Sprintf("http://127.0.0.1:%d", peerPort))
+		require.NoError(t, err)
+		node.proxy, err = newPeerProxy(node.RaftID, peerURL, cluster.blocked)
+		require.NoError(t, err)
+
+		dir := t.TempDir()
+		node.config = &config.Configurations{
+			LocalConfig: &config.LocalConfiguration{
+				Server: config.ServerConf{
+					Identity: config.IdentityConf{
+						ID:              nodeID,
+						CertificatePath: path.Join(cluster.cryptoDir, nodeID+".pem"),
+						KeyPath:         path.Join(cluster.cryptoDir, nodeID+".key"),
+					},
+					Network: config.NetworkConf{
+						Address: "127.0.0.1",
+						Port:    node.Port,
+					},
+					Database: config.DatabaseConf{
+						Name:            "leveldb",
+						LedgerDirectory: path.Join(dir, "ledger"),
+					},
+					QueueLength: config.QueueLengthConf{
+						Block:                     10,
+						Transaction:               10,
+						ReorderedTransactionBatch: 10,
+					},
+					QueryProcessing: config.QueryProcessingConf{
+						ResponseSizeLimitInBytes: 1 << 20,
+					},
+					LogLevel: "warn",
+				},
+				BlockCreation: config.BlockCreationConf{
+					MaxBlockSize:                1000000,
+					MaxTransactionCountPerBlock: c.MaxTxPerBlock,
+					BlockTimeout:                c.BlockTimeout,
+				},
+				Replication: config.ReplicationConf{
+					WALDir:  path.Join(dir, "raft", "wal"),
+					SnapDir: path.Join(dir, "raft", "snap"),
+					Network: config.NetworkConf{
+						Address: "127.0.0.1",
+						Port:    peerPort,
+					},
+					TLS: config.TLSConf{Enabled: false},
+				},
+				Bootstrap: config.BootstrapConf{},
+			},
+			SharedConfig: sharedConfig,
+		}
+
+		// the other nodes reach the node through its proxy
+		sharedConfig.Nodes = append(sharedConfig.Nodes, &config.NodeConf{
+			NodeID:          nodeID,
+			Host:            "127.0.0.1",
+			Port:            node.Port,
+			CertificatePath: path.Join(cluster.cryptoDir, nodeID+".pem"),
+		})
+		sharedConfig.Consensus.Members = append(sharedConfig.Consensus.Members, &config.PeerConf{
+			NodeId:   nodeID,
+			RaftId:   node.RaftID,
+			PeerHost: "127.0.0.1",
+			PeerPort: node.proxy.port(),
+		})
+		cluster.nodes = append(cluster.nodes, node)
+	}
+
+	for _, node := range cluster.nodes {
+		cluster.StartNode(node.ID)
+	}
+	cluster.WaitForLeader()
+
+	return cluster
+}
+
+// CryptoDir returns the directory of the crypto materials: the root CA certificate, and the certificate and private
+// key of each node and user, named after its ID
+func (c *Cluster) CryptoDir() string {
+	return c.cryptoDir
+}
+
+// ConnectionConfig returns the configuration to connect to the cluster, with the given nodes, or all the nodes if
+// none is given, as the bootstrap replica set
+func (c *Cluster) ConnectionConfig(nodeIDs ...string) *sdkconfig.ConnectionConfig {
+	if len(nodeIDs) == 0 {
+		for _, node := range c.Nodes() {
+			nodeIDs = append(nodeIDs, node.ID)
+		}
+	}
+
+	conf := &sdkconfig.ConnectionConfig{
+		RootCAs: []string{path.Join(c.cryptoDir, testutils.RootCAFileName+".pem")},
+	}
+	for _, nodeID := range nodeIDs {
+		conf.ReplicaSet = append(conf.ReplicaSet, &sdkconfig.Replica{
+			ID:       nodeID,
+			Endpoint: c.Node(nodeID).URL(),
+		})
+	}
+	return conf
+}
+
+// SessionConfig returns the configuration of a session of the given user
+func (c *Cluster) SessionConfig(userID string) *sdkconfig.SessionConfig {
+	return &sdkconfig.SessionConfig{
+		UserConfig: &sdkconfig.UserConfig{
+			UserID:         userID,
+			CertPath:       path.Join(c.cryptoDir, userID+".pem"),
+			PrivateKeyPath: path.Join(c.cryptoDir, userID+".key"),
+		},
+		TxTimeout:    20 * time.Second,
+		QueryTimeout: 20 * time.Second,
+	}
+}
+
+// Nodes returns the nodes of the cluster, ordered by raft ID
+func (c *Cluster) Nodes() []*Node {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return append([]*Node(nil), c.nodes...)
+}
+
+// Node returns the node of the given ID, and fails the test if it does not exist
+func (c *Cluster) Node(nodeID string) *Node {
+	for _, node := range c.Nodes() {
+		if node.ID == nodeID {
+			return node
+		}
+	}
+	require.FailNow(c.t, "node does not exist", "node ID: %s", nodeID)
+	return nil
+}
+
+// StartNode starts a stopped node, with the ledger it had when it was stopped
+func (c *Cluster) StartNode(nodeID string) {
+	node := c.Node(nodeID)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	require.False(c.t, node.running, "node %s is already running", nodeID)
+	s, err := server.New(node.config)
+	require.NoError(c.t, err)
+	require.NoError(c.t, s.Start())
+	node.server = s
+	node.running = true
+}
+
+// StopNode stops a running node
+func (c *Cluster) StopNode(nodeID string) {
+	node := c.Node(nodeID)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	require.True(c.t, node.running, "node %s is not running", nodeID)
+	require.NoError(c.t, node.server.Stop())
+	node.running = false
+}
+
+// RestartNode stops and starts a running node
+func (c *Cluster) RestartNode(nodeID string) {
+	c.StopNode(nodeID)
+	c.StartNode(nodeID)
+}
+
+// Partition cuts the replication links between the given nodes and the other nodes, until Heal is called. The
+// links among the given nodes, and among the other nodes, are kept. While the cluster is partitioned, the block
+// catch-up requests, whose sender is unknown, are dropped. The REST APIs of all the nodes remain reachable.
+func (c *Cluster) Partition(nodeIDs ...string) {
+	for _, nodeID := range nodeIDs {
+		c.Node(nodeID)
+	}
+
+	c.lock.Lock()
+	c.side = make(map[uint64]int)
+	for _, node := range c.nodes {
+		c.side[node.RaftID] = 0
+		for _, nodeID := range nodeIDs {
+			if node.ID == nodeID {
+				c.side[node.RaftID] = 1
+			}
+		}
+	}
+	c.lock.Unlock()
+
+	for _, node := range c.Nodes() {
+		node.proxy.cut()
+	}
+}
+
+// Heal restores the links cut by Partition
+func (c *Cluster) Heal() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.side = nil
+}
+
+func (c *Cluster) blocked(from, to uint64) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.side == nil {
+		return false
+	}
+	if from == 0 {
+		return true
+	}
+	return c.side[from] != c.side[to]
+}
+
+// Leader returns the node the given nodes, or all the running nodes if none is given, agree is the leader, or nil
+// if they do not agree on a leader
+func (c *Cluster) Leader(nodeIDs ...string) *Node {
+	var nodes []*Node
+	for _, node := range c.Nodes() {
+		if len(nodeIDs) == 0 {
+			nodes = append(nodes, node)
+			continue
+		}
+		for _, nodeID := range nodeIDs {
+			if node.ID == nodeID {
+				nodes = append(nodes, node)
+			}
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var leaderID uint64
+	for _, node := range nodes {
+		if !node.running {
+			if len(nodeIDs) == 0 {
+				continue
+			}
+			return nil
+		}
+		nodeLeaderID := node.RaftID
+		if err := node.server.IsLeader(); err != nil {
+			nodeLeaderID = err.GetLeaderID()
+		}
+		if nodeLeaderID == 0 || (leaderID != 0 && nodeLeaderID != leaderID) {
+			return nil
+		}
+		leaderID = nodeLeaderID
+	}
+
+	for _, node := range c.nodes {
+		if node.RaftID == leaderID && node.running {
+			return node
+		}
+	}
+	return nil
+}
+
+// WaitForLeader waits until the given nodes, or all the running nodes if none is given, agree on a leader, and
+// returns it
+func (c *Cluster) WaitForLeader(nodeIDs ...string) *Node {
+	var leader *Node
+	require.Eventually(c.t, func() bool {
+		leader = c.Leader(nodeIDs...)
+		return leader != nil
+	}, c.conf.LeaderTimeout, 50*time.Millisecond, "nodes %v do not agree on a leader", nodeIDs)
+	return leader
+}
+
+// ForceLeaderChange partitions the leader from the other nodes until they elect a new leader, heals the partition,
+// and returns the new leader once all the running nodes agree on it
+func (c *Cluster) ForceLeaderChange() *Node {
+	leader := c.WaitForLeader()
+
+	var others []string
+	for _, node := range c.Nodes() {
+		if node.ID != leader.ID && node.running {
+			others = append(others, node.ID)
+		}
+	}
+	c.Partition(leader.ID)
+	var newLeader *Node
+	require.Eventually(c.t, func() bool {
+		// the other nodes agree on the old leader until they detect the partition
+		newLeader = c.Leader(others...)
+		return newLeader != nil && newLeader.ID != leader.ID
+	}, c.conf.LeaderTimeout, 50*time.Millisecond, "nodes %v do not elect a new leader", others)
+	c.Heal()
+
+	require.Eventually(c.t, func() bool {
+		newLeader = c.Leader()
+		return newLeader != nil && newLeader.ID != leader.ID
+	}, c.conf.LeaderTimeout, 50*time.Millisecond, "nodes do not agree on a new leader")
+	return newLeader
+}
+
+func (c *Cluster) stop() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, node := range c.nodes {
+		if node.running {
+			node.server.Stop()
+			node.running = false
+		}
+		node.proxy.close()
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package test
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+// raftSenderHeader is the header in which the raft transport of a node sends its raft ID, in hexadecimal
+const raftSenderHeader = "X-Server-From"
+
+// peerProxy forwards the replication traffic sent to a node, i.e. the raft messages and the block catch-up requests,
+// and drops the traffic of the links the cluster partitions.
+type peerProxy struct {
+	raftID   uint64
+	listener net.Listener
+	server   *http.Server
+	// blocked tells whether the link between the sender, 0 if unknown, and the node of the proxy is partitioned
+	blocked func(from, to uint64) bool
+
+	lock   sync.Mutex
+	active map[*proxiedRequest]struct{}
+}
+
+type proxiedRequest struct {
+	from   uint64
+	cancel context.CancelFunc
+}
+
+func newPeerProxy(raftID uint64, target *url.URL, blocked func(from, to uint64) bool) (*peerProxy, error) {
+	listener, err := listenFree()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &peerProxy{
+		raftID:   raftID,
+		listener: listener,
+		blocked:  blocked,
+		active:   make(map[*proxiedRequest]struct{}),
+	}
+	reverseProxy := httputil.NewSingleHostReverseProxy(target)
+	// the raft messages are streamed, they must be flushed as soon as they are written
+	reverseProxy.FlushInterval = -1
+	// the requests it aborts to partition the links would be logged as errors
+	reverseProxy.ErrorLog = log.New(io.Discard, "", 0)
+	p.server = &http.Server{Handler: p.handler(reverseProxy)}
+	go p.server.Serve(listener)
+
+	return p, nil
+}
+
+// port returns the port the proxy listens on, which the other nodes use as the peer port of the node
+func (p *peerProxy) port() uint32 {
+	return uint32(p.listener.Addr().(*net.TCPAddr).Port)
+}
+
+func (p *peerProxy) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		from, err := strconv.ParseUint(r.Header.Get(raftSenderHeader), 16, 64)
+		if err != nil {
+			from = 0
+		}
+		if p.blocked(from, p.raftID) {
+			http.Error(w, "link partitioned", http.StatusServiceUnavailable)
+			return
+		}
+
+		ctx, cancel := context.WithCancel(r.Context())
+		req := &proxiedRequest{from: from, cancel: cancel}
+		p.lock.Lock()
+		p.active[req] = struct{}{}
+		p.lock.Unlock()
+		defer func() {
+			p.lock.Lock()
+			delete(p.active, req)
+			p.lock.Unlock()
+			cancel()
+		}()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// cut aborts the requests in flight over the links that are now partitioned, e.g. the long-lived raft streams
+func (p *peerProxy) cut() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for req := range p.active {
+		if p.blocked(req.from, p.raftID) {
+			req.cancel()
+		}
+	}
+}
+
+func (p *peerProxy) close() {
+	p.server.Close()
+}
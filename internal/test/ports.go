@@ -3,7 +3,10 @@
 
 package test
 
-import "sync"
+import (
+	"net"
+	"sync"
+)
 
 var portMutex sync.Mutex
 var nodePortBase uint32 = 32000
@@ -20,3 +23,32 @@ func GetPorts() (nodePort, peerPort uint32) {
 
 	return
 }
+
+// the ports GetPorts hands out, which GetFreePort must not return even if they are free yet
+const reservedPortsMin, reservedPortsMax = 32000, 34000
+
+// GetFreePort returns a port that is free on the loopback interface at the time of the call
+func GetFreePort() (uint32, error) {
+	l, err := listenFree()
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return uint32(l.Addr().(*net.TCPAddr).Port), nil
+}
+
+// listenFree listens on a port of the loopback interface the kernel picks, listening again if it picks a port
+// GetPorts hands out
+func listenFree() (net.Listener, error) {
+	for {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		port := l.Addr().(*net.TCPAddr).Port
+		if port < reservedPortsMin || port >= reservedPortsMax {
+			return l, nil
+		}
+		l.Close()
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package bcdbtest
+
+import (
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/bcdb"
+	"github.com/hyperledger-labs/orion-server/pkg/types"
+	"google.golang.org/protobuf/proto"
+)
+
+type userTxContext struct {
+	*txContext
+	userReads   []*types.UserRead
+	userWrites  []*types.UserWrite
+	userDeletes []*types.UserDelete
+}
+
+func (u *userTxContext) Commit(sync bool) (string, *types.TxReceiptResponseEnvelope, error) {
+	return u.commit(u.composeEnvelope, sync)
+}
+
+func (u *userTxContext) PutUser(user *types.User, acl *types.AccessControl) error {
+	if u.txSpent {
+		return bcdb.ErrTxSpent
+	}
+
+	u.userWrites = append(u.userWrites, &types.UserWrite{
+		User: user,
+		Acl:  acl,
+	})
+	return nil
+}
+
+func (u *userTxContext) GetUser(userID string) (*types.User, *types.Metadata, error) {
+	if u.txSpent {
+		return nil, nil, bcdb.ErrTxSpent
+	}
+
+	record, err := u.session.fake.getUser(u.session.userID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	u.userReads = append(u.userReads, &types.UserRead{
+		UserId:  userID,
+		Version: record.GetMetadata().GetVersion(),
+	})
+	return record.GetUser(), record.GetMetadata(), nil
+}
+
+func (u *userTxContext) RemoveUser(userID string) error {
+	if u.txSpent {
+		return bcdb.ErrTxSpent
+	}
+
+	u.userDeletes = append(u.userDeletes, &types.UserDelete{
+		UserId: userID,
+	})
+	return nil
+}
+
+func (u *userTxContext) composeEnvelope() (proto.Message, error) {
+	payload := &types.UserAdministrationTx{
+		UserId:      u.session.userID,
+		TxId:        u.txID,
+		UserReads:   u.userReads,
+		UserWrites:  u.userWrites,
+		UserDeletes: u.userDeletes,
+	}
+	return &types.UserAdministrationTxEnvelope{
+		Payload:   payload,
+		Signature: sign(u.session.userID, payload),
+	}, nil
+}
+
+type dbsTxContext struct {
+	*txContext
+	createdDBs map[string]*types.DBIndex
+	deletedDBs map[string]bool
+}
+
+func (d *dbsTxContext) Commit(sync bool) (string, *types.TxReceiptResponseEnvelope, error) {
+	return d.commit(d.composeEnvelope, sync)
+}
+
+func (d *dbsTxContext) CreateDB(dbName string, index map[string]types.IndexAttributeType) error {
+	if d.txSpent {
+		return bcdb.ErrTxSpent
+	}
+
+	d.createdDBs[dbName] = &types.DBIndex{
+		AttributeAndType: index,
+	}
+	return nil
+}
+
+func (d *dbsTxContext) DeleteDB(dbName string) error {
+	if d.txSpent {
+		return bcdb.ErrTxSpent
+	}
+
+	d.deletedDBs[dbName] = true
+	return nil
+}
+
+func (d *dbsTxContext) Exists(dbName string) (bool, error) {
+	if d.txSpent {
+		return false, bcdb.ErrTxSpent
+	}
+
+	f := d.session.fake
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.checkUserLocked(d.session.userID); err != nil {
+		return false, err
+	}
+	return f.dbs[dbName] != nil, nil
+}
+
+func (d *dbsTxContext) GetDBIndex(dbName string) (map[string]types.IndexAttributeType, error) {
+	if d.txSpent {
+		return nil, bcdb.ErrTxSpent
+	}
+
+	f := d.session.fake
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.checkUserLocked(d.session.userID); err != nil {
+		return nil, err
+	}
+	db := f.dbs[dbName]
+	if len(db.getIndex()) == 0 {
+		return nil, nil
+	}
+
+	index := make(map[string]types.IndexAttributeType)
+	for attr, attrType := range db.getIndex() {
+		index[attr] = attrType
+	}
+	return index, nil
+}
+
+func (d *dbsTxContext) composeEnvelope() (proto.Message, error) {
+	payload := &types.DBAdministrationTx{
+		UserId:   d.session.userID,
+		TxId:     d.txID,
+		DbsIndex: make(map[string]*types.DBIndex),
+	}
+	for _, dbName := range sortedKeys(d.createdDBs) {
+		payload.CreateDbs = append(payload.CreateDbs, dbName)
+		if index := d.createdDBs[dbName]; index != nil {
+			payload.DbsIndex[dbName] = index
+		}
+	}
+	payload.DeleteDbs = sortedKeys(d.deletedDBs)
+
+	return &types.DBAdministrationTxEnvelope{
+		Payload:   payload,
+		Signature: sign(d.session.userID, payload),
+	}, nil
+}
@@ -0,0 +1,360 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package bcdbtest
+
+import (
+	"context"
+	"sort"
+
+	"github.com/hyperledger-labs/orion-sdk-go/internal"
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/bcdb"
+	"github.com/hyperledger-labs/orion-server/pkg/types"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+)
+
+type dataTxContext struct {
+	*txContext
+	operations map[string]*dbOperations
+	txUsers    map[string]bool
+}
+
+type dbOperations struct {
+	// dataReads holds the values read, with a nil value and metadata for a key that does not exist
+	dataReads   map[string]*types.ValueWithMetadata
+	dataWrites  map[string]*types.DataWrite
+	dataDeletes map[string]*types.DataDelete
+	dataAsserts map[string]*types.Version
+}
+
+func (d *dataTxContext) ops(dbName string) *dbOperations {
+	ops, ok := d.operations[dbName]
+	if !ok {
+		ops = &dbOperations{
+			dataReads:   make(map[string]*types.ValueWithMetadata),
+			dataWrites:  make(map[string]*types.DataWrite),
+			dataDeletes: make(map[string]*types.DataDelete),
+			dataAsserts: make(map[string]*types.Version),
+		}
+		d.operations[dbName] = ops
+	}
+	return ops
+}
+
+func (d *dataTxContext) Commit(sync bool) (string, *types.TxReceiptResponseEnvelope, error) {
+	return d.commit(d.composeEnvelope, sync)
+}
+
+func (d *dataTxContext) Put(dbName, key string, value []byte, acl *types.AccessControl) error {
+	if d.txSpent {
+		return bcdb.ErrTxSpent
+	}
+
+	ops := d.ops(dbName)
+	delete(ops.dataDeletes, key)
+	ops.dataWrites[key] = &types.DataWrite{
+		Key:   key,
+		Value: value,
+		Acl:   acl,
+	}
+	return nil
+}
+
+func (d *dataTxContext) Get(dbName, key string) ([]byte, *types.Metadata, error) {
+	if d.txSpent {
+		return nil, nil, bcdb.ErrTxSpent
+	}
+
+	// like the client of the server, each key is read once, and the reads do not observe the writes of the transaction
+	if ops, ok := d.operations[dbName]; ok {
+		if _, ok := ops.dataAsserts[key]; ok {
+			return nil, nil, errors.Errorf("can not execute Get and AssertRead for the same key '%s' in the same transaction", key)
+		}
+		if value, ok := ops.dataReads[key]; ok {
+			return value.GetValue(), value.GetMetadata(), nil
+		}
+	}
+
+	value, err := d.session.fake.getData(d.session.userID, dbName, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if value == nil {
+		value = &types.ValueWithMetadata{}
+	}
+
+	d.ops(dbName).dataReads[key] = value
+	return value.GetValue(), value.GetMetadata(), nil
+}
+
+func (d *dataTxContext) Delete(dbName, key string) error {
+	if d.txSpent {
+		return bcdb.ErrTxSpent
+	}
+
+	ops := d.ops(dbName)
+	delete(ops.dataWrites, key)
+	ops.dataDeletes[key] = &types.DataDelete{
+		Key: key,
+	}
+	return nil
+}
+
+func (d *dataTxContext) AssertRead(dbName string, key string, version *types.Version) error {
+	if d.txSpent {
+		return bcdb.ErrTxSpent
+	}
+
+	if ops, ok := d.operations[dbName]; ok {
+		if _, ok := ops.dataReads[key]; ok {
+			return errors.Errorf("can not execute Get and AssertRead for the same key '%s' in the same transaction", key)
+		}
+		if currentVersion, ok := ops.dataAsserts[key]; ok {
+			if !proto.Equal(currentVersion, version) {
+				return errors.New("the received version is different from the existing version")
+			}
+			return nil
+		}
+	}
+
+	d.ops(dbName).dataAsserts[key] = version
+	return nil
+}
+
+func (d *dataTxContext) AddMustSignUser(userID string) {
+	d.txUsers[userID] = true
+}
+
+func (d *dataTxContext) SignConstructedTxEnvelopeAndCloseTx() (proto.Message, error) {
+	if d.txSpent {
+		return nil, bcdb.ErrTxSpent
+	}
+
+	txEnv, err := d.composeEnvelope()
+	if err != nil {
+		return nil, err
+	}
+	d.txEnvelope = txEnv
+	d.txSpent = true
+	return txEnv, nil
+}
+
+// RequiredSigners computes the users required by the write ACLs of the keys the transaction writes or deletes, as
+// committed in the fake
+func (d *dataTxContext) RequiredSigners(addToMustSignUsers bool) (*bcdb.SignerSets, error) {
+	if d.txSpent {
+		return nil, bcdb.ErrTxSpent
+	}
+
+	allOf := make(map[string]bool)
+	var anyOf [][]string
+	for _, dbName := range sortedKeys(d.operations) {
+		ops := d.operations[dbName]
+		keys := append(sortedKeys(ops.dataWrites), sortedKeys(ops.dataDeletes)...)
+		for _, key := range keys {
+			acl, err := d.currentACL(dbName, key, ops)
+			if err != nil {
+				return nil, err
+			}
+			if acl == nil {
+				continue
+			}
+
+			writers := sortedKeys(acl.GetReadWriteUsers())
+			switch {
+			case len(writers) == 0:
+				return nil, errors.Errorf("no user can write or delete the key [%s] in the database [%s]", key, dbName)
+			case len(writers) == 1 || acl.GetSignPolicyForWrite() == types.AccessControl_ALL:
+				for _, userID := range writers {
+					allOf[userID] = true
+				}
+			default:
+				anyOf = append(anyOf, writers)
+			}
+		}
+	}
+
+	signers := &bcdb.SignerSets{AllOf: sortedKeys(allOf)}
+	signers.AnyOf = internal.MinimalGroups(anyOf, func(userID string) bool {
+		return allOf[userID] || d.txUsers[userID]
+	})
+
+	if addToMustSignUsers {
+		for _, userID := range signers.Users() {
+			d.AddMustSignUser(userID)
+		}
+	}
+	return signers, nil
+}
+
+func (d *dataTxContext) currentACL(dbName, key string, ops *dbOperations) (*types.AccessControl, error) {
+	if value, ok := ops.dataReads[key]; ok {
+		return value.GetMetadata().GetAccessControl(), nil
+	}
+
+	value, err := d.session.fake.getData(d.session.userID, dbName, key)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to fetch the ACL of the key [%s] in the database [%s]", key, dbName)
+	}
+	return value.GetMetadata().GetAccessControl(), nil
+}
+
+// Validate returns the failure the fake would mark the transaction with if it was committed now by all its must-sign
+// users. Unlike the client of the server, it returns at most one failure, without the database and key it relates to.
+func (d *dataTxContext) Validate(ctx context.Context) ([]*bcdb.PredictedFailure, error) {
+	if d.txSpent {
+		return nil, bcdb.ErrTxSpent
+	}
+
+	payload := d.composePayload()
+	f := d.session.fake
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var signers []string
+	for _, userID := range payload.GetMustSignUserIds() {
+		if f.users[userID] != nil {
+			signers = append(signers, userID)
+		}
+	}
+	sort.Strings(signers)
+
+	valInfo := f.validateDataTxLocked(payload, signers)
+	if valInfo.GetFlag() == types.Flag_VALID {
+		return nil, nil
+	}
+	return []*bcdb.PredictedFailure{{Flag: valInfo.GetFlag(), Reason: valInfo.GetReasonIfInvalid()}}, nil
+}
+
+// composePayload composes the payload of the transaction, in a deterministic order. The initiating user is the first
+// must-sign user, as the server attributes the transaction to it.
+func (d *dataTxContext) composePayload() *types.DataTx {
+	payload := &types.DataTx{
+		MustSignUserIds: []string{d.session.userID},
+		TxId:            d.txID,
+	}
+	for _, userID := range sortedKeys(d.txUsers) {
+		if userID != d.session.userID {
+			payload.MustSignUserIds = append(payload.MustSignUserIds, userID)
+		}
+	}
+
+	for _, dbName := range sortedKeys(d.operations) {
+		ops := d.operations[dbName]
+		dbOp := &types.DBOperation{DbName: dbName}
+		for _, key := range sortedKeys(ops.dataWrites) {
+			dbOp.DataWrites = append(dbOp.DataWrites, ops.dataWrites[key])
+		}
+		for _, key := range sortedKeys(ops.dataDeletes) {
+			dbOp.DataDeletes = append(dbOp.DataDeletes, ops.dataDeletes[key])
+		}
+		for _, key := range sortedKeys(ops.dataReads) {
+			dbOp.DataReads = append(dbOp.DataReads, &types.DataRead{Key: key, Version: ops.dataReads[key].GetMetadata().GetVersion()})
+		}
+		for _, key := range sortedKeys(ops.dataAsserts) {
+			dbOp.DataReads = append(dbOp.DataReads, &types.DataRead{Key: key, Version: ops.dataAsserts[key]})
+		}
+		payload.DbOperations = append(payload.DbOperations, dbOp)
+	}
+
+	return payload
+}
+
+func (d *dataTxContext) composeEnvelope() (proto.Message, error) {
+	payload := d.composePayload()
+	return &types.DataTxEnvelope{
+		Payload:    payload,
+		Signatures: map[string][]byte{d.session.userID: sign(d.session.userID, payload)},
+	}, nil
+}
+
+type loadedDataTxContext struct {
+	*txContext
+	txEnv *types.DataTxEnvelope
+}
+
+// Commit adds the signature of the session user and commits the transaction
+func (d *loadedDataTxContext) Commit(sync bool) (string, *types.TxReceiptResponseEnvelope, error) {
+	return d.commit(d.composeEnvelope, sync)
+}
+
+func (d *loadedDataTxContext) CoSignTxEnvelopeAndCloseTx() (proto.Message, error) {
+	if d.txSpent {
+		return nil, bcdb.ErrTxSpent
+	}
+
+	txEnv, err := d.composeEnvelope()
+	if err != nil {
+		return nil, err
+	}
+	d.txEnvelope = txEnv
+	d.txSpent = true
+	return txEnv, nil
+}
+
+func (d *loadedDataTxContext) composeEnvelope() (proto.Message, error) {
+	d.txEnv.Signatures[d.session.userID] = sign(d.session.userID, d.txEnv.GetPayload())
+	return d.txEnv, nil
+}
+
+func (d *loadedDataTxContext) MustSignUsers() []string {
+	return d.txEnv.GetPayload().GetMustSignUserIds()
+}
+
+func (d *loadedDataTxContext) SignedUsers() []string {
+	return sortedKeys(d.txEnv.GetSignatures())
+}
+
+func (d *loadedDataTxContext) VerifySignatures() (*bcdb.SignaturesVerification, error) {
+	if d.txSpent {
+		return nil, bcdb.ErrTxSpent
+	}
+
+	f := d.session.fake
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	verification := &bcdb.SignaturesVerification{Users: make(map[string]*bcdb.UserSignatureVerification)}
+	for userID, signature := range d.txEnv.GetSignatures() {
+		status := &bcdb.UserSignatureVerification{UserID: userID, Status: bcdb.SignatureValid}
+		switch {
+		case f.users[userID] == nil:
+			status.Status = bcdb.SignatureUnknown
+		case string(signature) != string(sign(userID, d.txEnv.GetPayload())):
+			status.Status = bcdb.SignatureInvalid
+			status.Reason = "the signature does not match the payload"
+		}
+		verification.Users[userID] = status
+	}
+	for _, userID := range d.txEnv.GetPayload().GetMustSignUserIds() {
+		if _, ok := verification.Users[userID]; !ok {
+			verification.Users[userID] = &bcdb.UserSignatureVerification{UserID: userID, Status: bcdb.SignatureMissing}
+		}
+	}
+	return verification, nil
+}
+
+func (d *loadedDataTxContext) Reads() map[string][]*types.DataRead {
+	reads := make(map[string][]*types.DataRead)
+	for _, ops := range d.txEnv.GetPayload().GetDbOperations() {
+		reads[ops.GetDbName()] = ops.GetDataReads()
+	}
+	return reads
+}
+
+func (d *loadedDataTxContext) Writes() map[string][]*types.DataWrite {
+	writes := make(map[string][]*types.DataWrite)
+	for _, ops := range d.txEnv.GetPayload().GetDbOperations() {
+		writes[ops.GetDbName()] = ops.GetDataWrites()
+	}
+	return writes
+}
+
+func (d *loadedDataTxContext) Deletes() map[string][]*types.DataDelete {
+	deletes := make(map[string][]*types.DataDelete)
+	for _, ops := range d.txEnv.GetPayload().GetDbOperations() {
+		deletes[ops.GetDbName()] = ops.GetDataDeletes()
+	}
+	return deletes
+}
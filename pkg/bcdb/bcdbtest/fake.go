@@ -0,0 +1,702 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package bcdbtest provides an in-memory fake of an Orion cluster, to unit test applications written against the
+// interfaces of package bcdb without starting an Orion server.
+//
+// The fake implements bcdb.BCDB, and the sessions, transaction contexts, queries, provenance and ledger it creates.
+// It validates transactions the way the server does: the versions of the reads for MVCC, the database privileges of
+// the users, the ACLs of the keys with their sign policies, the signatures of the must-sign users, and the rules of
+// user and database administration. Each transaction is committed in a block of its own, so that the block numbers
+// and the versions a test observes are deterministic.
+//
+// Unlike the server, the fake does not use certificates. A session acts on behalf of the user ID of its
+// configuration, and a signature is a digest of the user ID and the transaction payload, which detects a payload
+// modified after it was signed but does not authenticate the user. Cryptographic proofs, cluster configuration
+// transactions and verified history are not supported, and the methods that need them return ErrNotSupported.
+package bcdbtest
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/bcdb"
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/config"
+	"github.com/hyperledger-labs/orion-server/pkg/types"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// DefaultDBName is the database every fake is created with
+	DefaultDBName = "bdb"
+	// DefaultAdmin is the admin user of a fake whose configuration has no admins
+	DefaultAdmin = "admin"
+)
+
+// ErrNotSupported is returned by the methods the fake does not implement
+var ErrNotSupported = errors.New("not supported by the bcdbtest fake")
+
+var (
+	validDBName = regexp.MustCompile(`^[0-9a-zA-Z_\-.]+$`)
+	systemDBs   = map[string]bool{"_config": true, "_users": true, "_dbs": true, "_metadata": true}
+)
+
+// Config holds the initial state of a Fake, which is committed in the genesis block
+type Config struct {
+	// Admins are the IDs of the admin users, DefaultAdmin if empty. As the fake does not support cluster
+	// configuration transactions, the admins cannot change.
+	Admins []string
+	// Users are the non-admin users
+	Users []*types.User
+	// DBs are the databases and their index definitions, in addition to DefaultDBName. A nil index definition
+	// denotes a database without an index.
+	DBs map[string]map[string]types.IndexAttributeType
+}
+
+// Fake is an in-memory fake of an Orion cluster. It is safe for concurrent use by multiple goroutines.
+type Fake struct {
+	lock       sync.Mutex // guards all the fields below
+	dbs        map[string]*database
+	users      map[string]*userRecord
+	blocks     []*block // blocks[i] holds block number i+1
+	receipts   map[string]*types.TxReceipt
+	provenance *provenance
+	txCount    uint64
+	// newBlock is closed and replaced whenever a block is committed
+	newBlock chan struct{}
+}
+
+type database struct {
+	index  map[string]types.IndexAttributeType
+	values map[string]*types.ValueWithMetadata
+}
+
+func (db *database) getIndex() map[string]types.IndexAttributeType {
+	if db == nil {
+		return nil
+	}
+	return db.index
+}
+
+type userRecord struct {
+	user     *types.User
+	metadata *types.Metadata
+}
+
+func (r *userRecord) GetUser() *types.User {
+	if r == nil {
+		return nil
+	}
+	return r.user
+}
+
+func (r *userRecord) GetMetadata() *types.Metadata {
+	if r == nil {
+		return nil
+	}
+	return r.metadata
+}
+
+type block struct {
+	header *types.BlockHeader
+	txID   string
+	txEnv  proto.Message
+}
+
+// New creates a fake with the initial state of the given configuration
+func New(conf *Config) (*Fake, error) {
+	if conf == nil {
+		conf = &Config{}
+	}
+
+	f := &Fake{
+		dbs:        map[string]*database{DefaultDBName: {values: make(map[string]*types.ValueWithMetadata)}},
+		users:      make(map[string]*userRecord),
+		receipts:   make(map[string]*types.TxReceipt),
+		provenance: newProvenance(),
+		newBlock:   make(chan struct{}),
+	}
+	genesisVersion := &types.Version{BlockNum: 1, TxNum: 0}
+
+	admins := conf.Admins
+	if len(admins) == 0 {
+		admins = []string{DefaultAdmin}
+	}
+	clusterConfig := &types.ClusterConfig{}
+	for _, adminID := range admins {
+		if adminID == "" {
+			return nil, errors.New("admin ID cannot be empty")
+		}
+		if _, ok := f.users[adminID]; ok {
+			return nil, errors.Errorf("admin [%s] is duplicated", adminID)
+		}
+		clusterConfig.Admins = append(clusterConfig.Admins, &types.Admin{Id: adminID})
+		f.users[adminID] = &userRecord{
+			user:     &types.User{Id: adminID, Privilege: &types.Privilege{Admin: true}},
+			metadata: &types.Metadata{Version: genesisVersion},
+		}
+	}
+
+	for dbName, index := range conf.DBs {
+		if !validDBName.MatchString(dbName) || systemDBs[dbName] || dbName == DefaultDBName {
+			return nil, errors.Errorf("database name [%s] is not valid", dbName)
+		}
+		f.dbs[dbName] = &database{index: index, values: make(map[string]*types.ValueWithMetadata)}
+	}
+
+	for _, user := range conf.Users {
+		switch {
+		case user.GetId() == "":
+			return nil, errors.New("user ID cannot be empty")
+		case user.GetPrivilege().GetAdmin():
+			return nil, errors.Errorf("user [%s] is marked as admin, admins are set by Config.Admins", user.GetId())
+		case f.users[user.GetId()] != nil:
+			return nil, errors.Errorf("user [%s] is duplicated", user.GetId())
+		}
+		for dbName := range user.GetPrivilege().GetDbPermission() {
+			if _, ok := f.dbs[dbName]; !ok {
+				return nil, errors.Errorf("database [%s] in the privileges of user [%s] does not exist", dbName, user.GetId())
+			}
+		}
+		f.users[user.GetId()] = &userRecord{
+			user:     proto.Clone(user).(*types.User),
+			metadata: &types.Metadata{Version: genesisVersion},
+		}
+	}
+
+	genesisTxID := f.nextTxID()
+	genesis := &types.ConfigTxEnvelope{
+		Payload: &types.ConfigTx{
+			UserId:    admins[0],
+			TxId:      genesisTxID,
+			NewConfig: clusterConfig,
+		},
+	}
+	f.appendBlock(genesisTxID, genesis, &types.ValidationInfo{Flag: types.Flag_VALID})
+
+	return f, nil
+}
+
+// Session opens a session of the user of the configuration, which must exist in the fake
+func (f *Fake) Session(conf *config.SessionConfig) (bcdb.DBSession, error) {
+	if conf == nil || conf.UserConfig == nil {
+		return nil, errors.New("user configuration is missing")
+	}
+	if err := f.checkUser(conf.UserConfig.UserID); err != nil {
+		return nil, err
+	}
+
+	return &session{fake: f, userID: conf.UserConfig.UserID}, nil
+}
+
+func (f *Fake) nextTxID() string {
+	f.txCount++
+	return fmt.Sprintf("tx%08d", f.txCount)
+}
+
+func (f *Fake) checkUser(userID string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.checkUserLocked(userID)
+}
+
+func (f *Fake) checkUserLocked(userID string) error {
+	if _, ok := f.users[userID]; !ok {
+		return errors.Errorf("the user [%s] does not exist", userID)
+	}
+	return nil
+}
+
+func (f *Fake) isAdminLocked(userID string) bool {
+	return f.users[userID].GetUser().GetPrivilege().GetAdmin()
+}
+
+// hasPrivilegeLocked returns true if the user is an admin, or has at least the given access to the database
+func (f *Fake) hasPrivilegeLocked(userID, dbName string, access types.Privilege_Access) bool {
+	record, ok := f.users[userID]
+	if !ok {
+		return false
+	}
+	if record.user.GetPrivilege().GetAdmin() {
+		return true
+	}
+	p, ok := record.user.GetPrivilege().GetDbPermission()[dbName]
+	return ok && p >= access
+}
+
+// commit validates the transaction and, unless the transaction is rejected before validation like the server rejects
+// a request, commits it in a new block, valid or not
+func (f *Fake) commit(txID string, txEnv proto.Message) (*types.TxReceipt, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if _, ok := f.receipts[txID]; ok {
+		return nil, errors.Errorf("failed to submit transaction, the transaction has a duplicate txID [%s]", txID)
+	}
+
+	var valInfo *types.ValidationInfo
+	switch env := txEnv.(type) {
+	case *types.DataTxEnvelope:
+		signers, err := f.checkDataTxSignaturesLocked(env)
+		if err != nil {
+			return nil, err
+		}
+		valInfo = f.validateDataTxLocked(env.GetPayload(), signers)
+	case *types.UserAdministrationTxEnvelope:
+		if err := f.checkSignatureLocked(env.GetPayload().GetUserId(), env.GetSignature(), env.GetPayload()); err != nil {
+			return nil, err
+		}
+		valInfo = f.validateUserTxLocked(env.GetPayload())
+	case *types.DBAdministrationTxEnvelope:
+		if err := f.checkSignatureLocked(env.GetPayload().GetUserId(), env.GetSignature(), env.GetPayload()); err != nil {
+			return nil, err
+		}
+		valInfo = f.validateDBsTxLocked(env.GetPayload())
+	default:
+		return nil, errors.Errorf("unexpected transaction envelope type %T", txEnv)
+	}
+
+	header := f.appendBlock(txID, txEnv, valInfo)
+	if valInfo.GetFlag() == types.Flag_VALID {
+		version := &types.Version{BlockNum: header.GetBaseHeader().GetNumber(), TxNum: 0}
+		switch env := txEnv.(type) {
+		case *types.DataTxEnvelope:
+			f.applyDataTxLocked(env.GetPayload(), version)
+		case *types.UserAdministrationTxEnvelope:
+			f.applyUserTxLocked(env.GetPayload(), version)
+		case *types.DBAdministrationTxEnvelope:
+			f.applyDBsTxLocked(env.GetPayload())
+		}
+	}
+
+	return f.receipts[txID], nil
+}
+
+// appendBlock commits a block with a single transaction, and returns its header
+func (f *Fake) appendBlock(txID string, txEnv proto.Message, valInfo *types.ValidationInfo) *types.BlockHeader {
+	number := uint64(len(f.blocks)) + 1
+	header := &types.BlockHeader{
+		BaseHeader:           &types.BlockHeaderBase{Number: number},
+		TxMerkleTreeRootHash: digest(txEnv),
+		ValidationInfo:       []*types.ValidationInfo{valInfo},
+	}
+	if number > 1 {
+		previous := f.blocks[number-2].header
+		header.BaseHeader.PreviousBaseHeaderHash = digest(previous.GetBaseHeader())
+		header.BaseHeader.LastCommittedBlockHash = digest(previous)
+		header.BaseHeader.LastCommittedBlockNum = number - 1
+	}
+
+	f.blocks = append(f.blocks, &block{header: header, txID: txID, txEnv: txEnv})
+	f.receipts[txID] = &types.TxReceipt{Header: header, TxIndex: 0}
+
+	close(f.newBlock)
+	f.newBlock = make(chan struct{})
+	return header
+}
+
+// checkDataTxSignaturesLocked rejects a data transaction not signed by all its must-sign users, and returns the users
+// with a valid signature
+func (f *Fake) checkDataTxSignaturesLocked(env *types.DataTxEnvelope) ([]string, error) {
+	payload := env.GetPayload()
+	if len(payload.GetMustSignUserIds()) == 0 {
+		return nil, errors.New("failed to submit transaction, missing UserID in transaction envelope payload")
+	}
+
+	var notSigned []string
+	for _, userID := range payload.GetMustSignUserIds() {
+		if _, ok := env.GetSignatures()[userID]; !ok {
+			notSigned = append(notSigned, userID)
+		}
+	}
+	if len(notSigned) > 0 {
+		sort.Strings(notSigned)
+		return nil, errors.Errorf("failed to submit transaction, users [%s] in the must sign list have not signed the transaction", strings.Join(notSigned, ","))
+	}
+	for _, userID := range payload.GetMustSignUserIds() {
+		if err := f.checkSignatureLocked(userID, env.GetSignatures()[userID], payload); err != nil {
+			return nil, err
+		}
+	}
+
+	var signers []string
+	for userID, signature := range env.GetSignatures() {
+		if f.checkSignatureLocked(userID, signature, payload) == nil {
+			signers = append(signers, userID)
+		}
+	}
+	sort.Strings(signers)
+	return signers, nil
+}
+
+func (f *Fake) checkSignatureLocked(userID string, signature []byte, payload proto.Message) error {
+	if err := f.checkUserLocked(userID); err != nil {
+		return errors.WithMessage(err, "failed to submit transaction, signature verification failed")
+	}
+	if string(signature) != string(sign(userID, payload)) {
+		return errors.Errorf("failed to submit transaction, signature verification failed for user [%s]", userID)
+	}
+	return nil
+}
+
+// sign returns the signature of the user on the payload, i.e., a digest of both
+func sign(userID string, payload proto.Message) []byte {
+	h := sha256.New()
+	h.Write([]byte(userID))
+	h.Write([]byte{0})
+	h.Write(digest(payload))
+	return h.Sum(nil)
+}
+
+func digest(m proto.Message) []byte {
+	bytes, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	if err != nil {
+		// marshaling a valid message does not fail
+		panic(err)
+	}
+	sum := sha256.Sum256(bytes)
+	return sum[:]
+}
+
+func invalid(flag types.Flag, reason string) *types.ValidationInfo {
+	return &types.ValidationInfo{Flag: flag, ReasonIfInvalid: reason}
+}
+
+var valid = &types.ValidationInfo{Flag: types.Flag_VALID}
+
+// validateDataTxLocked validates a data transaction, given the users with a valid signature, in the order of the
+// server validation
+func (f *Fake) validateDataTxLocked(tx *types.DataTx, signers []string) *types.ValidationInfo {
+	dbs := make(map[string]bool)
+	for _, ops := range tx.GetDbOperations() {
+		if dbs[ops.GetDbName()] {
+			return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "the database ["+ops.GetDbName()+"] occurs more than once in the operations. The database present in the operations should be unique")
+		}
+		dbs[ops.GetDbName()] = true
+	}
+
+	for _, ops := range tx.GetDbOperations() {
+		if r := f.validateDataOpsLocked(ops, signers); r.GetFlag() != types.Flag_VALID {
+			return r
+		}
+	}
+
+	for _, ops := range tx.GetDbOperations() {
+		db := f.dbs[ops.GetDbName()]
+		for _, d := range ops.GetDataDeletes() {
+			if _, ok := db.values[d.GetKey()]; !ok {
+				return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "the key ["+d.GetKey()+"] does not exist in the database and hence, it cannot be deleted")
+			}
+		}
+		for _, r := range ops.GetDataReads() {
+			if !proto.Equal(r.GetVersion(), db.values[r.GetKey()].GetMetadata().GetVersion()) {
+				return invalid(types.Flag_INVALID_MVCC_CONFLICT_WITH_COMMITTED_STATE, "mvcc conflict has occurred as the committed state for the key ["+r.GetKey()+"] in database ["+ops.GetDbName()+"] changed")
+			}
+		}
+	}
+
+	return valid
+}
+
+func (f *Fake) validateDataOpsLocked(ops *types.DBOperation, signers []string) *types.ValidationInfo {
+	dbName := ops.GetDbName()
+	switch {
+	case !validDBName.MatchString(dbName):
+		return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "the database name ["+dbName+"] is not valid")
+	case systemDBs[dbName]:
+		return invalid(types.Flag_INVALID_NO_PERMISSION, "the database ["+dbName+"] is a system database and no user can write to a system database via data transaction. Use appropriate transaction type to modify the system database")
+	case f.dbs[dbName] == nil:
+		return invalid(types.Flag_INVALID_DATABASE_DOES_NOT_EXIST, "the database ["+dbName+"] does not exist in the cluster")
+	}
+
+	var users []string
+	for _, userID := range signers {
+		if f.hasPrivilegeLocked(userID, dbName, types.Privilege_ReadWrite) {
+			users = append(users, userID)
+		}
+	}
+	if len(users) == 0 {
+		return invalid(types.Flag_INVALID_NO_PERMISSION, "none of the user in ["+strings.Join(signers, ", ")+"] has read-write permission on the database ["+dbName+"]")
+	}
+
+	writeKeys := make(map[string]bool)
+	for _, w := range ops.GetDataWrites() {
+		if w == nil {
+			return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "there is an empty entry in the write list")
+		}
+		for _, aclUsers := range []map[string]bool{w.GetAcl().GetReadUsers(), w.GetAcl().GetReadWriteUsers()} {
+			for _, userID := range sortedKeys(aclUsers) {
+				if f.users[userID] == nil {
+					return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "the user ["+userID+"] defined in the access control for the key ["+w.GetKey()+"] does not exist")
+				}
+			}
+		}
+		if writeKeys[w.GetKey()] {
+			return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "the key ["+w.GetKey()+"] is duplicated in the write list. The keys in the write list must be unique")
+		}
+		writeKeys[w.GetKey()] = true
+	}
+
+	deleteKeys := make(map[string]bool)
+	for _, d := range ops.GetDataDeletes() {
+		switch {
+		case d == nil:
+			return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "there is an empty entry in the delete list")
+		case deleteKeys[d.GetKey()]:
+			return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "the key ["+d.GetKey()+"] is duplicated in the delete list. The keys in the delete list must be unique")
+		case writeKeys[d.GetKey()]:
+			return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "the key ["+d.GetKey()+"] is being updated as well as deleted. Only one operation per key is allowed within a transaction")
+		}
+		deleteKeys[d.GetKey()] = true
+	}
+
+	db := f.dbs[dbName]
+	for _, r := range ops.GetDataReads() {
+		acl := db.values[r.GetKey()].GetMetadata().GetAccessControl()
+		if acl != nil && !anyUserIn(users, acl.GetReadUsers(), acl.GetReadWriteUsers()) {
+			return invalid(types.Flag_INVALID_NO_PERMISSION, "none of the user in ["+strings.Join(users, ",")+"] has a read permission on key ["+r.GetKey()+"] present in the database ["+dbName+"]")
+		}
+	}
+
+	var modifiedKeys []string
+	for _, w := range ops.GetDataWrites() {
+		modifiedKeys = append(modifiedKeys, w.GetKey())
+	}
+	for _, d := range ops.GetDataDeletes() {
+		modifiedKeys = append(modifiedKeys, d.GetKey())
+	}
+	for _, key := range modifiedKeys {
+		if r := validateWriteACL(users, dbName, key, db.values[key].GetMetadata().GetAccessControl()); r.GetFlag() != types.Flag_VALID {
+			return r
+		}
+	}
+
+	return valid
+}
+
+func validateWriteACL(users []string, dbName, key string, acl *types.AccessControl) *types.ValidationInfo {
+	if acl == nil {
+		return valid
+	}
+	if len(acl.GetReadWriteUsers()) == 0 {
+		return invalid(types.Flag_INVALID_NO_PERMISSION, "no user can write or delete the key ["+key+"]")
+	}
+
+	switch acl.GetSignPolicyForWrite() {
+	case types.AccessControl_ANY:
+		if !anyUserIn(users, acl.GetReadWriteUsers()) {
+			return invalid(types.Flag_INVALID_NO_PERMISSION, "none of the user in ["+strings.Join(users, ",")+"] has a write/delete permission on key ["+key+"] present in the database ["+dbName+"]")
+		}
+	case types.AccessControl_ALL:
+		writers := sortedKeys(acl.GetReadWriteUsers())
+		for _, writer := range writers {
+			if !containsString(users, writer) {
+				return invalid(types.Flag_INVALID_NO_PERMISSION, "not all required users in ["+strings.Join(writers, ",")+"] have signed the transaction to write/delete key ["+key+"] present in the database ["+dbName+"]")
+			}
+		}
+	}
+
+	return valid
+}
+
+func (f *Fake) applyDataTxLocked(tx *types.DataTx, version *types.Version) {
+	// the server attributes a data transaction to the first must-sign user, which is the initiating user
+	userID := tx.GetMustSignUserIds()[0]
+	f.provenance.txIDsBy[userID] = append(f.provenance.txIDsBy[userID], tx.GetTxId())
+
+	for _, ops := range tx.GetDbOperations() {
+		db := f.dbs[ops.GetDbName()]
+		for _, r := range ops.GetDataReads() {
+			f.provenance.addRead(userID, ops.GetDbName(), r.GetKey(), r.GetVersion())
+		}
+		for _, w := range ops.GetDataWrites() {
+			value := &types.ValueWithMetadata{
+				Value: w.GetValue(),
+				Metadata: &types.Metadata{
+					Version:       version,
+					AccessControl: w.GetAcl(),
+				},
+			}
+			db.values[w.GetKey()] = value
+			f.provenance.addWrite(userID, ops.GetDbName(), w.GetKey(), value)
+		}
+		for _, d := range ops.GetDataDeletes() {
+			delete(db.values, d.GetKey())
+		}
+	}
+}
+
+func (f *Fake) validateUserTxLocked(tx *types.UserAdministrationTx) *types.ValidationInfo {
+	if !f.isAdminLocked(tx.GetUserId()) {
+		return invalid(types.Flag_INVALID_NO_PERMISSION, "the user ["+tx.GetUserId()+"] has no privilege to perform user administrative operations")
+	}
+
+	writeUsers := make(map[string]bool)
+	for _, w := range tx.GetUserWrites() {
+		switch {
+		case w == nil:
+			return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "there is an empty entry in the write list")
+		case w.GetUser() == nil:
+			return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "there is an empty user entry in the write list")
+		case w.GetUser().GetId() == "":
+			return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "there is an user in the write list with an empty ID. A valid userID must be an non-empty string")
+		case w.GetUser().GetPrivilege().GetAdmin():
+			return invalid(types.Flag_INVALID_NO_PERMISSION, "the user ["+w.GetUser().GetId()+"] is marked as admin user. Only via a cluster configuration transaction, the ["+w.GetUser().GetId()+"] can be added as admin")
+		case len(w.GetAcl().GetReadWriteUsers()) > 0:
+			return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "adding users to Acl.ReadWriteUsers is not supported")
+		case writeUsers[w.GetUser().GetId()]:
+			return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "there are two users with the same userID ["+w.GetUser().GetId()+"] in the write list. The userIDs in the write list must be unique")
+		}
+		for _, dbName := range sortedKeys(w.GetUser().GetPrivilege().GetDbPermission()) {
+			if f.dbs[dbName] == nil {
+				return invalid(types.Flag_INVALID_DATABASE_DOES_NOT_EXIST, "the database ["+dbName+"] present in the db permission list does not exist in the cluster")
+			}
+		}
+		if f.isAdminLocked(w.GetUser().GetId()) {
+			return invalid(types.Flag_INVALID_NO_PERMISSION, "the user ["+w.GetUser().GetId()+"] is an admin user. Only via a cluster configuration transaction, the ["+w.GetUser().GetId()+"] can be modified")
+		}
+		writeUsers[w.GetUser().GetId()] = true
+	}
+
+	deleteUsers := make(map[string]bool)
+	for _, d := range tx.GetUserDeletes() {
+		switch {
+		case d == nil:
+			return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "there is an empty entry in the delete list")
+		case d.GetUserId() == "":
+			return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "there is an user in the delete list with an empty ID. A valid userID must be an non-empty string")
+		case deleteUsers[d.GetUserId()]:
+			return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "there are two users with the same userID ["+d.GetUserId()+"] in the delete list. The userIDs in the delete list must be unique")
+		case writeUsers[d.GetUserId()]:
+			return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "the user ["+d.GetUserId()+"] is present in both write and delete list. Only one operation per key is allowed within a transaction")
+		case f.users[d.GetUserId()] == nil:
+			return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "the user ["+d.GetUserId()+"] present in the delete list does not exist")
+		case f.isAdminLocked(d.GetUserId()):
+			return invalid(types.Flag_INVALID_NO_PERMISSION, "the user ["+d.GetUserId()+"] is an admin user. Only via a cluster configuration transaction, the ["+d.GetUserId()+"] can be deleted")
+		}
+		deleteUsers[d.GetUserId()] = true
+	}
+
+	for _, r := range tx.GetUserReads() {
+		if !proto.Equal(r.GetVersion(), f.users[r.GetUserId()].GetMetadata().GetVersion()) {
+			return invalid(types.Flag_INVALID_MVCC_CONFLICT_WITH_COMMITTED_STATE, "mvcc conflict has occurred as the committed state for the user ["+r.GetUserId()+"] has changed")
+		}
+	}
+
+	return valid
+}
+
+func (f *Fake) applyUserTxLocked(tx *types.UserAdministrationTx, version *types.Version) {
+	f.provenance.txIDsBy[tx.GetUserId()] = append(f.provenance.txIDsBy[tx.GetUserId()], tx.GetTxId())
+
+	for _, w := range tx.GetUserWrites() {
+		f.users[w.GetUser().GetId()] = &userRecord{
+			user:     w.GetUser(),
+			metadata: &types.Metadata{Version: version, AccessControl: w.GetAcl()},
+		}
+	}
+	for _, d := range tx.GetUserDeletes() {
+		delete(f.users, d.GetUserId())
+	}
+}
+
+func (f *Fake) validateDBsTxLocked(tx *types.DBAdministrationTx) *types.ValidationInfo {
+	if !f.isAdminLocked(tx.GetUserId()) {
+		return invalid(types.Flag_INVALID_NO_PERMISSION, "the user ["+tx.GetUserId()+"] has no privilege to perform database administrative operations")
+	}
+
+	created := make(map[string]bool)
+	for _, dbName := range tx.GetCreateDbs() {
+		switch {
+		case dbName == "":
+			return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "the name of the database to be created cannot be empty")
+		case !validDBName.MatchString(dbName):
+			return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "the database name ["+dbName+"] is not valid")
+		case systemDBs[dbName]:
+			return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "the database ["+dbName+"] is a system database which cannot be created as it exist by default")
+		case dbName == DefaultDBName:
+			return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "the database ["+dbName+"] is the system created default database for storing states and it cannot be created as it exist by default")
+		case f.dbs[dbName] != nil:
+			return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "the database ["+dbName+"] already exists in the cluster and hence, it cannot be created")
+		case created[dbName]:
+			return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "the database ["+dbName+"] is duplicated in the create list")
+		}
+		created[dbName] = true
+	}
+
+	deleted := make(map[string]bool)
+	for _, dbName := range tx.GetDeleteDbs() {
+		switch {
+		case dbName == "":
+			return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "the name of the database to be deleted cannot be empty")
+		case !validDBName.MatchString(dbName):
+			return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "the database name ["+dbName+"] is not valid")
+		case systemDBs[dbName]:
+			return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "the database ["+dbName+"] is a system database which cannot be deleted")
+		case dbName == DefaultDBName:
+			return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "the database ["+dbName+"] is the system created default database to store states and it cannot be deleted")
+		case f.dbs[dbName] == nil:
+			return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "the database ["+dbName+"] does not exist in the cluster and hence, it cannot be deleted")
+		case deleted[dbName]:
+			return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "the database ["+dbName+"] is duplicated in the delete list")
+		}
+		deleted[dbName] = true
+	}
+
+	for _, dbName := range sortedKeys(tx.GetDbsIndex()) {
+		if !created[dbName] && (f.dbs[dbName] == nil || deleted[dbName]) {
+			return invalid(types.Flag_INVALID_INCORRECT_ENTRIES, "index definion provided for database ["+dbName+"] cannot be processed as the database neither exists nor is in the create DB list")
+		}
+	}
+
+	return valid
+}
+
+func (f *Fake) applyDBsTxLocked(tx *types.DBAdministrationTx) {
+	f.provenance.txIDsBy[tx.GetUserId()] = append(f.provenance.txIDsBy[tx.GetUserId()], tx.GetTxId())
+
+	for _, dbName := range tx.GetCreateDbs() {
+		f.dbs[dbName] = &database{values: make(map[string]*types.ValueWithMetadata)}
+	}
+	for dbName, index := range tx.GetDbsIndex() {
+		f.dbs[dbName].index = index.GetAttributeAndType()
+	}
+	for _, dbName := range tx.GetDeleteDbs() {
+		delete(f.dbs, dbName)
+	}
+}
+
+func anyUserIn(users []string, sets ...map[string]bool) bool {
+	for _, userID := range users {
+		for _, set := range sets {
+			if set[userID] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsString(set []string, s string) bool {
+	for _, e := range set {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,367 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package bcdbtest
+
+import (
+	"testing"
+
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/bcdb"
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/config"
+	"github.com/hyperledger-labs/orion-server/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+var _ bcdb.BCDB = (*Fake)(nil)
+
+func newTestFake(t *testing.T) *Fake {
+	f, err := New(&Config{
+		Users: []*types.User{
+			{
+				Id: "alice",
+				Privilege: &types.Privilege{
+					DbPermission: map[string]types.Privilege_Access{DefaultDBName: types.Privilege_ReadWrite, "people": types.Privilege_ReadWrite},
+				},
+			},
+			{
+				Id: "bob",
+				Privilege: &types.Privilege{
+					DbPermission: map[string]types.Privilege_Access{DefaultDBName: types.Privilege_ReadWrite},
+				},
+			},
+			{
+				Id: "charlie",
+				Privilege: &types.Privilege{
+					DbPermission: map[string]types.Privilege_Access{DefaultDBName: types.Privilege_Read},
+				},
+			},
+		},
+		DBs: map[string]map[string]types.IndexAttributeType{
+			"people": {"name": types.IndexAttributeType_STRING, "age": types.IndexAttributeType_NUMBER},
+		},
+	})
+	require.NoError(t, err)
+	return f
+}
+
+func openSession(t *testing.T, f *Fake, userID string) bcdb.DBSession {
+	s, err := f.Session(&config.SessionConfig{UserConfig: &config.UserConfig{UserID: userID}})
+	require.NoError(t, err)
+	return s
+}
+
+func put(t *testing.T, s bcdb.DBSession, dbName, key, value string, acl *types.AccessControl) *types.TxReceipt {
+	tx, err := s.DataTx()
+	require.NoError(t, err)
+	require.NoError(t, tx.Put(dbName, key, []byte(value), acl))
+	_, receiptEnv, err := tx.Commit(true)
+	require.NoError(t, err)
+	return receiptEnv.GetResponse().GetReceipt()
+}
+
+func requireInvalid(t *testing.T, err error, flag types.Flag) {
+	require.Error(t, err)
+	require.IsType(t, &bcdb.ErrorTxValidation{}, err)
+	require.Equal(t, flag.String(), err.(*bcdb.ErrorTxValidation).Flag)
+}
+
+func TestFake_Session(t *testing.T) {
+	f := newTestFake(t)
+
+	_, err := f.Session(&config.SessionConfig{UserConfig: &config.UserConfig{UserID: "eve"}})
+	require.EqualError(t, err, "the user [eve] does not exist")
+
+	s := openSession(t, f, DefaultAdmin)
+	_, err = s.ConfigTx()
+	require.ErrorIs(t, err, ErrNotSupported)
+}
+
+func TestFake_DataTx(t *testing.T) {
+	f := newTestFake(t)
+	alice := openSession(t, f, "alice")
+	bob := openSession(t, f, "bob")
+	charlie := openSession(t, f, "charlie")
+
+	t.Run("put and get", func(t *testing.T) {
+		receipt := put(t, alice, DefaultDBName, "key1", "value1", nil)
+		require.Equal(t, uint64(2), receipt.GetHeader().GetBaseHeader().GetNumber())
+
+		tx, err := bob.DataTx()
+		require.NoError(t, err)
+		value, metadata, err := tx.Get(DefaultDBName, "key1")
+		require.NoError(t, err)
+		require.Equal(t, []byte("value1"), value)
+		require.Equal(t, &types.Version{BlockNum: 2, TxNum: 0}, metadata.GetVersion())
+
+		value, metadata, err = tx.Get(DefaultDBName, "missing")
+		require.NoError(t, err)
+		require.Nil(t, value)
+		require.Nil(t, metadata)
+		require.NoError(t, tx.Abort())
+	})
+
+	t.Run("mvcc conflict", func(t *testing.T) {
+		tx1, err := alice.DataTx()
+		require.NoError(t, err)
+		tx2, err := bob.DataTx()
+		require.NoError(t, err)
+		for _, tx := range []bcdb.DataTxContext{tx1, tx2} {
+			_, _, err = tx.Get(DefaultDBName, "key1")
+			require.NoError(t, err)
+			require.NoError(t, tx.Put(DefaultDBName, "key1", []byte("updated by "+tx.TxID()), nil))
+		}
+
+		_, _, err = tx1.Commit(true)
+		require.NoError(t, err)
+		_, _, err = tx2.Commit(true)
+		requireInvalid(t, err, types.Flag_INVALID_MVCC_CONFLICT_WITH_COMMITTED_STATE)
+	})
+
+	t.Run("db privilege", func(t *testing.T) {
+		tx, err := charlie.DataTx()
+		require.NoError(t, err)
+		require.NoError(t, tx.Put(DefaultDBName, "key2", []byte("value2"), nil))
+		_, _, err = tx.Commit(true)
+		requireInvalid(t, err, types.Flag_INVALID_NO_PERMISSION)
+
+		tx, err = bob.DataTx()
+		require.NoError(t, err)
+		_, _, err = tx.Get("people", "key")
+		require.EqualError(t, err, "the user [bob] has no permission to read from database [people]")
+	})
+
+	t.Run("acl", func(t *testing.T) {
+		put(t, alice, DefaultDBName, "private", "secret", &types.AccessControl{
+			ReadWriteUsers: map[string]bool{"alice": true},
+		})
+
+		tx, err := bob.DataTx()
+		require.NoError(t, err)
+		_, _, err = tx.Get(DefaultDBName, "private")
+		require.EqualError(t, err, "the user [bob] has no permission to read key [private] from database [bdb]")
+
+		tx, err = bob.DataTx()
+		require.NoError(t, err)
+		require.NoError(t, tx.Delete(DefaultDBName, "private"))
+		_, _, err = tx.Commit(true)
+		requireInvalid(t, err, types.Flag_INVALID_NO_PERMISSION)
+	})
+}
+
+func TestFake_MultiSign(t *testing.T) {
+	f := newTestFake(t)
+	alice := openSession(t, f, "alice")
+	bob := openSession(t, f, "bob")
+
+	put(t, alice, DefaultDBName, "shared", "v1", &types.AccessControl{
+		ReadWriteUsers:     map[string]bool{"alice": true, "bob": true},
+		SignPolicyForWrite: types.AccessControl_ALL,
+	})
+
+	tx, err := alice.DataTx()
+	require.NoError(t, err)
+	require.NoError(t, tx.Put(DefaultDBName, "shared", []byte("v2"), nil))
+	_, _, err = tx.Commit(true)
+	requireInvalid(t, err, types.Flag_INVALID_NO_PERMISSION)
+
+	tx, err = alice.DataTx()
+	require.NoError(t, err)
+	require.NoError(t, tx.Put(DefaultDBName, "shared", []byte("v2"), nil))
+	tx.AddMustSignUser("bob")
+	txEnv, err := tx.SignConstructedTxEnvelopeAndCloseTx()
+	require.NoError(t, err)
+
+	loaded, err := bob.LoadDataTx(txEnv.(*types.DataTxEnvelope))
+	require.NoError(t, err)
+	require.Equal(t, []string{"alice", "bob"}, loaded.MustSignUsers())
+	require.Equal(t, []string{"alice"}, loaded.SignedUsers())
+	_, _, err = loaded.Commit(true)
+	require.NoError(t, err)
+
+	getTx, err := bob.DataTx()
+	require.NoError(t, err)
+	value, _, err := getTx.Get(DefaultDBName, "shared")
+	require.NoError(t, err)
+	require.Equal(t, []byte("v2"), value)
+}
+
+func TestFake_Query(t *testing.T) {
+	f := newTestFake(t)
+	alice := openSession(t, f, "alice")
+
+	put(t, alice, "people", "p1", `{"name":"ann","age":25}`, nil)
+	put(t, alice, "people", "p2", `{"name":"ben","age":35}`, nil)
+	put(t, alice, "people", "p3", `{"name":"cid","age":45}`, nil)
+	put(t, alice, "people", "p4", `not json`, nil)
+
+	q, err := alice.Query()
+	require.NoError(t, err)
+
+	keysOf := func(kvs []*types.KVWithMetadata) []string {
+		var keys []string
+		for _, kv := range kvs {
+			keys = append(keys, kv.GetKey())
+		}
+		return keys
+	}
+
+	kvs, err := q.ExecuteJSONQuery("people", `{"selector":{"age":{"$gte":35}}}`)
+	require.NoError(t, err)
+	require.Equal(t, []string{"p2", "p3"}, keysOf(kvs))
+
+	kvs, err = q.ExecuteJSONQuery("people", `{"selector":{"$or":{"name":{"$eq":"ann"},"age":{"$gt":40}}}}`)
+	require.NoError(t, err)
+	require.Equal(t, []string{"p1", "p3"}, keysOf(kvs))
+
+	kvs, err = q.ExecuteJSONQuery("people", `{"selector":{"name":{"$neq":["ann","cid"]},"age":{"$lt":40}}}`)
+	require.NoError(t, err)
+	require.Equal(t, []string{"p2"}, keysOf(kvs))
+
+	_, err = q.ExecuteJSONQuery("people", `{"selector":{"city":{"$eq":"x"}}}`)
+	require.EqualError(t, err, "attribute [city] given in the query condition is not indexed")
+	_, err = q.ExecuteJSONQuery(DefaultDBName, `{"selector":{"age":{"$eq":1}}}`)
+	require.EqualError(t, err, "no index has been defined on the database bdb")
+
+	it, err := q.GetDataByRange("people", "p2", "p4", 0)
+	require.NoError(t, err)
+	var keys []string
+	for {
+		kv, ok, err := it.Next()
+		require.NoError(t, err)
+		if !ok {
+			break
+		}
+		keys = append(keys, kv.GetKey())
+	}
+	require.Equal(t, []string{"p2", "p3"}, keys)
+}
+
+func TestFake_Provenance(t *testing.T) {
+	f := newTestFake(t)
+	alice := openSession(t, f, "alice")
+	bob := openSession(t, f, "bob")
+	admin := openSession(t, f, DefaultAdmin)
+
+	put(t, alice, DefaultDBName, "key", "v1", nil)
+	put(t, bob, DefaultDBName, "key", "v2", nil)
+	tx, err := alice.DataTx()
+	require.NoError(t, err)
+	_, _, err = tx.Get(DefaultDBName, "key")
+	require.NoError(t, err)
+	require.NoError(t, tx.Put(DefaultDBName, "key", []byte("v3"), nil))
+	_, _, err = tx.Commit(true)
+	require.NoError(t, err)
+
+	p, err := admin.Provenance()
+	require.NoError(t, err)
+
+	history, err := p.GetHistoricalData(DefaultDBName, "key")
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	require.Equal(t, []byte("v1"), history[0].GetValue())
+
+	previous, err := p.GetPreviousHistoricalData(DefaultDBName, "key", &types.Version{BlockNum: 4})
+	require.NoError(t, err)
+	require.Len(t, previous, 2)
+	require.Equal(t, []byte("v2"), previous[0].GetValue())
+
+	writers, err := p.GetWriters(DefaultDBName, "key")
+	require.NoError(t, err)
+	require.Equal(t, []string{"alice", "bob"}, writers)
+	readers, err := p.GetReaders(DefaultDBName, "key")
+	require.NoError(t, err)
+	require.Equal(t, []string{"alice"}, readers)
+
+	it, err := p.GetHistoricalDataIterator(DefaultDBName, "key", &bcdb.ProvenanceQueryOptions{Limit: 2})
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, ok, err := it.Next()
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+	_, ok, err := it.Next()
+	require.NoError(t, err)
+	require.False(t, ok)
+	it, err = p.GetHistoricalDataIterator(DefaultDBName, "key", &bcdb.ProvenanceQueryOptions{ContinuationToken: it.ContinuationToken()})
+	require.NoError(t, err)
+	value, ok, err := it.Next()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, []byte("v3"), value.GetValue())
+	require.Empty(t, it.ContinuationToken())
+
+	p, err = bob.Provenance()
+	require.NoError(t, err)
+	txIDs, err := p.GetTxIDsSubmittedByUser("bob")
+	require.NoError(t, err)
+	require.Len(t, txIDs, 1)
+	_, err = p.GetTxIDsSubmittedByUser("alice")
+	require.EqualError(t, err, "The querier [bob] is neither an admin nor requesting operations performed by [alice]. Only an admin can query operations performed by other users.")
+	_, err = p.GetHistoricalData(DefaultDBName, "key")
+	require.EqualError(t, err, "The querier [bob] is not an admin. Only an admin can query historical data")
+}
+
+func TestFake_Ledger(t *testing.T) {
+	f := newTestFake(t)
+	alice := openSession(t, f, "alice")
+	bob := openSession(t, f, "bob")
+
+	l, err := alice.Ledger()
+	require.NoError(t, err)
+	delivery := l.NewBlockHeaderDeliveryService(&bcdb.BlockHeaderDeliveryConfig{StartBlockNumber: 2, Capacity: 10, IncludeTxIDs: true})
+	defer delivery.Stop()
+
+	receipt := put(t, alice, DefaultDBName, "key", "value", nil)
+
+	last, err := l.GetLastBlockHeader()
+	require.NoError(t, err)
+	require.Equal(t, receipt.GetHeader(), last)
+
+	tx, err := alice.DataTx()
+	require.NoError(t, err)
+	require.NoError(t, tx.Put(DefaultDBName, "key2", []byte("value"), nil))
+	txID, _, err := tx.Commit(false)
+	require.NoError(t, err)
+
+	receipt, err = l.GetTransactionReceipt(txID)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), receipt.GetHeader().GetBaseHeader().GetNumber())
+	_, err = l.GetTransactionReceipt("missing")
+	require.IsType(t, &bcdb.ErrorNotFound{}, err)
+
+	content, err := l.GetTxContent(3, 0)
+	require.NoError(t, err)
+	require.Equal(t, txID, content.GetDataTxEnvelope().GetPayload().GetTxId())
+	require.Equal(t, types.Flag_VALID, content.GetValidationInfo().GetFlag())
+
+	bobLedger, err := bob.Ledger()
+	require.NoError(t, err)
+	_, err = bobLedger.GetTxContent(3, 0)
+	require.EqualError(t, err, "user bob has no permission to access the tx")
+
+	for _, num := range []uint64{2, 3} {
+		header := delivery.Receive().(*types.AugmentedBlockHeader)
+		require.Equal(t, num, header.GetHeader().GetBaseHeader().GetNumber())
+		require.Len(t, header.GetTxIds(), 1)
+	}
+
+	_, err = l.GetDataProof(3, DefaultDBName, "key2", false)
+	require.ErrorIs(t, err, ErrNotSupported)
+}
+
+func TestFake_DeterministicBlocks(t *testing.T) {
+	lastHeader := func() *types.BlockHeader {
+		f := newTestFake(t)
+		alice := openSession(t, f, "alice")
+		put(t, alice, DefaultDBName, "key", "v1", nil)
+		put(t, alice, "people", "p1", `{"name":"ann","age":25}`, nil)
+
+		l, err := alice.Ledger()
+		require.NoError(t, err)
+		header, err := l.GetLastBlockHeader()
+		require.NoError(t, err)
+		return header
+	}
+
+	require.Equal(t, lastHeader(), lastHeader())
+}
@@ -0,0 +1,229 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package bcdbtest
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/bcdb"
+	"github.com/hyperledger-labs/orion-server/pkg/state"
+	"github.com/hyperledger-labs/orion-server/pkg/types"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+)
+
+type ledger struct {
+	session *session
+}
+
+// blockLocked returns the block of the given number, the caller must hold the lock of the fake
+func (l *ledger) blockLocked(blockNum uint64) (*block, error) {
+	if err := l.session.fake.checkUserLocked(l.session.userID); err != nil {
+		return nil, err
+	}
+	if blockNum == 0 || blockNum > uint64(len(l.session.fake.blocks)) {
+		return nil, &bcdb.ErrorNotFound{Message: fmt.Sprintf("block not found: %d", blockNum)}
+	}
+	return l.session.fake.blocks[blockNum-1], nil
+}
+
+func (l *ledger) GetBlockHeader(blockNum uint64) (*types.BlockHeader, error) {
+	l.session.fake.lock.Lock()
+	defer l.session.fake.lock.Unlock()
+
+	b, err := l.blockLocked(blockNum)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Clone(b.header).(*types.BlockHeader), nil
+}
+
+func (l *ledger) GetLastBlockHeader() (*types.BlockHeader, error) {
+	l.session.fake.lock.Lock()
+	defer l.session.fake.lock.Unlock()
+
+	b, err := l.blockLocked(uint64(len(l.session.fake.blocks)))
+	if err != nil {
+		return nil, err
+	}
+	return proto.Clone(b.header).(*types.BlockHeader), nil
+}
+
+// GetLedgerPath is not supported, as the blocks of the fake hold no skip list
+func (l *ledger) GetLedgerPath(startBlock, endBlock uint64) (*bcdb.LedgerPath, error) {
+	return nil, errors.WithMessage(ErrNotSupported, "GetLedgerPath")
+}
+
+// GetTransactionProof is not supported, as the blocks of the fake hold no Merkle tree of the transactions
+func (l *ledger) GetTransactionProof(blockNum uint64, txIndex int) (*bcdb.TxProof, error) {
+	return nil, errors.WithMessage(ErrNotSupported, "GetTransactionProof")
+}
+
+func (l *ledger) GetTransactionReceipt(txID string) (*types.TxReceipt, error) {
+	f := l.session.fake
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.checkUserLocked(l.session.userID); err != nil {
+		return nil, err
+	}
+	receipt, ok := f.receipts[txID]
+	if !ok {
+		return nil, &bcdb.ErrorNotFound{Message: fmt.Sprintf("txID not found: %s", txID)}
+	}
+	return proto.Clone(receipt).(*types.TxReceipt), nil
+}
+
+// GetDataProof is not supported, as the fake keeps no state trie
+func (l *ledger) GetDataProof(blockNum uint64, dbName, key string, isDeleted bool) (*state.Proof, error) {
+	return nil, errors.WithMessage(ErrNotSupported, "GetDataProof")
+}
+
+// GetFullTxProofAndVerify is not supported, as the fake keeps neither skip list nor Merkle tree
+func (l *ledger) GetFullTxProofAndVerify(txReceipt *types.TxReceipt, lastKnownBlockHeader *types.BlockHeader, tx proto.Message) (*bcdb.TxProof, *bcdb.LedgerPath, error) {
+	return nil, nil, errors.WithMessage(ErrNotSupported, "GetFullTxProofAndVerify")
+}
+
+// GetTxContent returns the transaction of the block, each block of the fake holding a single transaction. As with
+// the server, a data transaction is returned to its must-sign users and signers, and the other transactions to admins.
+func (l *ledger) GetTxContent(blockNum, txIndex uint64) (*types.GetTxResponse, error) {
+	f := l.session.fake
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	b, err := l.blockLocked(blockNum)
+	if err != nil {
+		return nil, err
+	}
+	if txIndex > 0 {
+		return nil, errors.Errorf("transaction index out of range: %d", txIndex)
+	}
+
+	var hasAccess bool
+	response := &types.GetTxResponse{
+		ValidationInfo: proto.Clone(b.header.GetValidationInfo()[txIndex]).(*types.ValidationInfo),
+		Version:        &types.Version{BlockNum: blockNum, TxNum: txIndex},
+	}
+	switch txEnv := proto.Clone(b.txEnv).(type) {
+	case *types.DataTxEnvelope:
+		_, signed := txEnv.GetSignatures()[l.session.userID]
+		hasAccess = signed || containsString(txEnv.GetPayload().GetMustSignUserIds(), l.session.userID)
+		response.TxEnvelope = &types.GetTxResponse_DataTxEnvelope{DataTxEnvelope: txEnv}
+	case *types.UserAdministrationTxEnvelope:
+		hasAccess = f.isAdminLocked(l.session.userID)
+		response.TxEnvelope = &types.GetTxResponse_UserAdministrationTxEnvelope{UserAdministrationTxEnvelope: txEnv}
+	case *types.DBAdministrationTxEnvelope:
+		hasAccess = f.isAdminLocked(l.session.userID)
+		response.TxEnvelope = &types.GetTxResponse_DbAdministrationTxEnvelope{DbAdministrationTxEnvelope: txEnv}
+	case *types.ConfigTxEnvelope:
+		hasAccess = f.isAdminLocked(l.session.userID)
+		response.TxEnvelope = &types.GetTxResponse_ConfigTxEnvelope{ConfigTxEnvelope: txEnv}
+	default:
+		return nil, errors.Errorf("unexpected transaction envelope in the block")
+	}
+
+	if !hasAccess {
+		return nil, errors.Errorf("user %s has no permission to access the tx", l.session.userID)
+	}
+	return response, nil
+}
+
+// NewBlockHeaderDeliveryService delivers the headers of the committed blocks from the start block number, waiting
+// for the blocks yet to be committed. The retry interval is ignored, as the fake notifies each committed block.
+func (l *ledger) NewBlockHeaderDeliveryService(conf *bcdb.BlockHeaderDeliveryConfig) bcdb.BlockHeaderDelivererService {
+	d := &blockHeaderDeliverer{
+		ledger:       l,
+		conf:         conf,
+		blockHeaders: make(chan interface{}, conf.Capacity),
+		stop:         make(chan struct{}),
+	}
+	go d.start()
+	return d
+}
+
+type blockHeaderDeliverer struct {
+	ledger       *ledger
+	conf         *bcdb.BlockHeaderDeliveryConfig
+	blockHeaders chan interface{}
+
+	stop chan struct{}
+	err  error
+	mu   sync.Mutex
+}
+
+func (d *blockHeaderDeliverer) start() {
+	defer close(d.blockHeaders)
+
+	f := d.ledger.session.fake
+	blockNum := d.conf.StartBlockNumber
+	if blockNum == 0 {
+		blockNum = 1
+	}
+	for {
+		f.lock.Lock()
+		if err := f.checkUserLocked(d.ledger.session.userID); err != nil {
+			f.lock.Unlock()
+			d.setError(err)
+			return
+		}
+		newBlock := f.newBlock
+		var b *block
+		if blockNum <= uint64(len(f.blocks)) {
+			b = f.blocks[blockNum-1]
+		}
+		f.lock.Unlock()
+
+		if b == nil {
+			select {
+			case <-newBlock:
+				continue
+			case <-d.stop:
+				return
+			}
+		}
+
+		var header interface{} = proto.Clone(b.header)
+		if d.conf.IncludeTxIDs {
+			header = &types.AugmentedBlockHeader{Header: header.(*types.BlockHeader), TxIds: []string{b.txID}}
+		}
+		select {
+		case d.blockHeaders <- header:
+			blockNum++
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+func (d *blockHeaderDeliverer) Receive() interface{} {
+	return <-d.blockHeaders
+}
+
+func (d *blockHeaderDeliverer) Stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	select {
+	case <-d.stop:
+		// already stopped
+		return
+	default:
+		close(d.stop)
+	}
+}
+
+func (d *blockHeaderDeliverer) Error() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.err
+}
+
+func (d *blockHeaderDeliverer) setError(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.err = err
+}
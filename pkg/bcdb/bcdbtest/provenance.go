@@ -0,0 +1,446 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package bcdbtest
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/bcdb"
+	"github.com/hyperledger-labs/orion-server/pkg/types"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+)
+
+// provenance holds the history of the committed transactions the provenance queries are answered from
+type provenance struct {
+	txIDsBy map[string][]string
+	// history, reads and writes are indexed by database, then by key
+	history map[string]map[string][]*types.ValueWithMetadata
+	readers map[string]map[string]map[string]bool
+	writers map[string]map[string]map[string]bool
+	// readBy and writtenBy are indexed by user, then by database
+	readBy    map[string]map[string][]*types.KVWithMetadata
+	writtenBy map[string]map[string][]*types.KVWithMetadata
+}
+
+func newProvenance() *provenance {
+	return &provenance{
+		txIDsBy:   make(map[string][]string),
+		history:   make(map[string]map[string][]*types.ValueWithMetadata),
+		readers:   make(map[string]map[string]map[string]bool),
+		writers:   make(map[string]map[string]map[string]bool),
+		readBy:    make(map[string]map[string][]*types.KVWithMetadata),
+		writtenBy: make(map[string]map[string][]*types.KVWithMetadata),
+	}
+}
+
+// addRead records the read of the given version of the key. The read of a key that did not exist is not recorded,
+// as there is no value to link it to.
+func (p *provenance) addRead(userID, dbName, key string, version *types.Version) {
+	value := p.valueAt(dbName, key, version)
+	if value == nil {
+		return
+	}
+
+	addUser(p.readers, dbName, key, userID)
+	addKV(p.readBy, userID, dbName, key, value)
+}
+
+// addWrite records the write of the value of the key
+func (p *provenance) addWrite(userID, dbName, key string, value *types.ValueWithMetadata) {
+	if p.history[dbName] == nil {
+		p.history[dbName] = make(map[string][]*types.ValueWithMetadata)
+	}
+	p.history[dbName][key] = append(p.history[dbName][key], value)
+
+	addUser(p.writers, dbName, key, userID)
+	addKV(p.writtenBy, userID, dbName, key, value)
+}
+
+func (p *provenance) valueAt(dbName, key string, version *types.Version) *types.ValueWithMetadata {
+	for _, value := range p.history[dbName][key] {
+		if proto.Equal(value.GetMetadata().GetVersion(), version) {
+			return value
+		}
+	}
+	return nil
+}
+
+func addUser(users map[string]map[string]map[string]bool, dbName, key, userID string) {
+	if users[dbName] == nil {
+		users[dbName] = make(map[string]map[string]bool)
+	}
+	if users[dbName][key] == nil {
+		users[dbName][key] = make(map[string]bool)
+	}
+	users[dbName][key][userID] = true
+}
+
+func addKV(kvs map[string]map[string][]*types.KVWithMetadata, userID, dbName, key string, value *types.ValueWithMetadata) {
+	if kvs[userID] == nil {
+		kvs[userID] = make(map[string][]*types.KVWithMetadata)
+	}
+	kvs[userID][dbName] = append(kvs[userID][dbName], &types.KVWithMetadata{
+		Key:      key,
+		Value:    value.GetValue(),
+		Metadata: value.GetMetadata(),
+	})
+}
+
+func cloneValues(values []*types.ValueWithMetadata) []*types.ValueWithMetadata {
+	var cloned []*types.ValueWithMetadata
+	for _, value := range values {
+		cloned = append(cloned, proto.Clone(value).(*types.ValueWithMetadata))
+	}
+	return cloned
+}
+
+// versionLess orders the versions by block number, then by transaction number
+func versionLess(a, b *types.Version) bool {
+	if a.GetBlockNum() != b.GetBlockNum() {
+		return a.GetBlockNum() < b.GetBlockNum()
+	}
+	return a.GetTxNum() < b.GetTxNum()
+}
+
+type provenanceQuerier struct {
+	session *session
+}
+
+// checkAdminLocked checks that the session user is an admin, as the server requires for the queries on a key
+func (p *provenanceQuerier) checkAdminLocked() error {
+	if err := p.session.fake.checkUserLocked(p.session.userID); err != nil {
+		return err
+	}
+	if !p.session.fake.isAdminLocked(p.session.userID) {
+		return errors.Errorf("The querier [%s] is not an admin. Only an admin can query historical data", p.session.userID)
+	}
+	return nil
+}
+
+// checkSelfOrAdminLocked checks that the session user is the given user or an admin, as the server requires for
+// the queries on a user
+func (p *provenanceQuerier) checkSelfOrAdminLocked(userID string) error {
+	if err := p.session.fake.checkUserLocked(p.session.userID); err != nil {
+		return err
+	}
+	if p.session.userID != userID && !p.session.fake.isAdminLocked(p.session.userID) {
+		return errors.Errorf("The querier [%s] is neither an admin nor requesting operations performed by [%s]. Only an admin can query operations performed by other users.", p.session.userID, userID)
+	}
+	return nil
+}
+
+func (p *provenanceQuerier) GetHistoricalData(dbName, key string) ([]*types.ValueWithMetadata, error) {
+	f := p.session.fake
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := p.checkAdminLocked(); err != nil {
+		return nil, err
+	}
+	return cloneValues(f.provenance.history[dbName][key]), nil
+}
+
+func (p *provenanceQuerier) GetHistoricalDataAt(dbName, key string, version *types.Version) (*types.ValueWithMetadata, error) {
+	f := p.session.fake
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := p.checkAdminLocked(); err != nil {
+		return nil, err
+	}
+	value := f.provenance.valueAt(dbName, key, version)
+	if value == nil {
+		return nil, nil
+	}
+	return proto.Clone(value).(*types.ValueWithMetadata), nil
+}
+
+// GetPreviousHistoricalData returns the values that precede the version, the most recent first
+func (p *provenanceQuerier) GetPreviousHistoricalData(dbName, key string, version *types.Version) ([]*types.ValueWithMetadata, error) {
+	f := p.session.fake
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := p.checkAdminLocked(); err != nil {
+		return nil, err
+	}
+	var values []*types.ValueWithMetadata
+	history := f.provenance.history[dbName][key]
+	for i := len(history) - 1; i >= 0; i-- {
+		if versionLess(history[i].GetMetadata().GetVersion(), version) {
+			values = append(values, history[i])
+		}
+	}
+	return cloneValues(values), nil
+}
+
+// GetNextHistoricalData returns the values that succeed the version, the oldest first
+func (p *provenanceQuerier) GetNextHistoricalData(dbName, key string, version *types.Version) ([]*types.ValueWithMetadata, error) {
+	f := p.session.fake
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := p.checkAdminLocked(); err != nil {
+		return nil, err
+	}
+	var values []*types.ValueWithMetadata
+	for _, value := range f.provenance.history[dbName][key] {
+		if versionLess(version, value.GetMetadata().GetVersion()) {
+			values = append(values, value)
+		}
+	}
+	return cloneValues(values), nil
+}
+
+func (p *provenanceQuerier) GetDataReadByUser(userID string) (map[string]*types.KVsWithMetadata, error) {
+	return p.userData(userID, func(prov *provenance) map[string]map[string][]*types.KVWithMetadata { return prov.readBy })
+}
+
+func (p *provenanceQuerier) GetDataWrittenByUser(userID string) (map[string]*types.KVsWithMetadata, error) {
+	return p.userData(userID, func(prov *provenance) map[string]map[string][]*types.KVWithMetadata { return prov.writtenBy })
+}
+
+func (p *provenanceQuerier) userData(userID string, byUser func(*provenance) map[string]map[string][]*types.KVWithMetadata) (map[string]*types.KVsWithMetadata, error) {
+	f := p.session.fake
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := p.checkSelfOrAdminLocked(userID); err != nil {
+		return nil, err
+	}
+	data := make(map[string]*types.KVsWithMetadata)
+	for dbName, kvs := range byUser(f.provenance)[userID] {
+		cloned := &types.KVsWithMetadata{}
+		for _, kv := range kvs {
+			cloned.KVs = append(cloned.KVs, proto.Clone(kv).(*types.KVWithMetadata))
+		}
+		data[dbName] = cloned
+	}
+	return data, nil
+}
+
+func (p *provenanceQuerier) GetReaders(dbName, key string) ([]string, error) {
+	return p.users(func(prov *provenance) map[string]bool { return prov.readers[dbName][key] })
+}
+
+func (p *provenanceQuerier) GetWriters(dbName, key string) ([]string, error) {
+	return p.users(func(prov *provenance) map[string]bool { return prov.writers[dbName][key] })
+}
+
+func (p *provenanceQuerier) users(ofKey func(*provenance) map[string]bool) ([]string, error) {
+	f := p.session.fake
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := p.checkAdminLocked(); err != nil {
+		return nil, err
+	}
+	return sortedKeys(ofKey(f.provenance)), nil
+}
+
+func (p *provenanceQuerier) GetTxIDsSubmittedByUser(userID string) ([]string, error) {
+	f := p.session.fake
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := p.checkSelfOrAdminLocked(userID); err != nil {
+		return nil, err
+	}
+	return append([]string(nil), f.provenance.txIDsBy[userID]...), nil
+}
+
+func (p *provenanceQuerier) GetHistoricalDataIterator(dbName, key string, opts *bcdb.ProvenanceQueryOptions) (bcdb.HistoricalDataIterator, error) {
+	if opts == nil {
+		opts = &bcdb.ProvenanceQueryOptions{}
+	}
+	if opts.DBName != "" || opts.KeyPrefix != "" {
+		return nil, errors.New("database name and key prefix filters are not applicable to the historical data query")
+	}
+	offset, err := parseOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := p.GetHistoricalData(dbName, key)
+	if err != nil {
+		return nil, err
+	}
+
+	it := &historicalDataIterator{}
+	for _, value := range values {
+		if inBlockRange(opts, value.GetMetadata().GetVersion()) {
+			it.values = append(it.values, value)
+		}
+	}
+	it.sliceIterator = newSliceIterator(len(it.values), offset, opts.Limit)
+	return it, nil
+}
+
+func (p *provenanceQuerier) GetDataReadByUserIterator(userID string, opts *bcdb.ProvenanceQueryOptions) (bcdb.UserDataIterator, error) {
+	return p.userDataIterator(userID, opts, p.GetDataReadByUser)
+}
+
+func (p *provenanceQuerier) GetDataWrittenByUserIterator(userID string, opts *bcdb.ProvenanceQueryOptions) (bcdb.UserDataIterator, error) {
+	return p.userDataIterator(userID, opts, p.GetDataWrittenByUser)
+}
+
+func (p *provenanceQuerier) userDataIterator(userID string, opts *bcdb.ProvenanceQueryOptions, query func(string) (map[string]*types.KVsWithMetadata, error)) (bcdb.UserDataIterator, error) {
+	if opts == nil {
+		opts = &bcdb.ProvenanceQueryOptions{}
+	}
+	offset, err := parseOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := query(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	it := &userDataIterator{}
+	for _, dbName := range sortedKeys(data) {
+		if opts.DBName != "" && dbName != opts.DBName {
+			continue
+		}
+		kvs := data[dbName].GetKVs()
+		sort.SliceStable(kvs, func(i, j int) bool {
+			if kvs[i].GetKey() != kvs[j].GetKey() {
+				return kvs[i].GetKey() < kvs[j].GetKey()
+			}
+			return versionLess(kvs[i].GetMetadata().GetVersion(), kvs[j].GetMetadata().GetVersion())
+		})
+		for _, kv := range kvs {
+			if strings.HasPrefix(kv.GetKey(), opts.KeyPrefix) && inBlockRange(opts, kv.GetMetadata().GetVersion()) {
+				it.dbNames = append(it.dbNames, dbName)
+				it.kvs = append(it.kvs, kv)
+			}
+		}
+	}
+	it.sliceIterator = newSliceIterator(len(it.kvs), offset, opts.Limit)
+	return it, nil
+}
+
+func (p *provenanceQuerier) GetTxIDsSubmittedByUserIterator(userID string, opts *bcdb.ProvenanceQueryOptions) (bcdb.TxIDIterator, error) {
+	if opts == nil {
+		opts = &bcdb.ProvenanceQueryOptions{}
+	}
+	if opts.StartBlock > 0 || opts.EndBlock > 0 || opts.DBName != "" || opts.KeyPrefix != "" {
+		return nil, errors.New("block range, database name and key prefix filters are not applicable to the tx IDs query")
+	}
+	offset, err := parseOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	txIDs, err := p.GetTxIDsSubmittedByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(txIDs)
+	return &txIDIterator{txIDs: txIDs, sliceIterator: newSliceIterator(len(txIDs), offset, opts.Limit)}, nil
+}
+
+// GetVerifiedHistory is not supported, as the fake keeps no state trie to prove the values against
+func (p *provenanceQuerier) GetVerifiedHistory(dbName, key string, anchor *types.BlockHeader) ([]*bcdb.VerifiedHistoricalValue, error) {
+	return nil, errors.WithMessage(ErrNotSupported, "GetVerifiedHistory")
+}
+
+// parseOptions validates the options and returns the offset of the first entry to return. The continuation tokens
+// of the fake are offsets in the result of the query, and are meant to be passed to the same query only.
+func parseOptions(opts *bcdb.ProvenanceQueryOptions) (int, error) {
+	if opts.EndBlock > 0 && opts.StartBlock > opts.EndBlock {
+		return 0, errors.Errorf("start block [%d] is greater than end block [%d]", opts.StartBlock, opts.EndBlock)
+	}
+	if opts.ContinuationToken == "" {
+		return 0, nil
+	}
+	offset, err := strconv.Atoi(opts.ContinuationToken)
+	if err != nil || offset < 0 {
+		return 0, errors.Errorf("malformed continuation token [%s]", opts.ContinuationToken)
+	}
+	return offset, nil
+}
+
+func inBlockRange(opts *bcdb.ProvenanceQueryOptions, version *types.Version) bool {
+	if opts.StartBlock > 0 && version.GetBlockNum() < opts.StartBlock {
+		return false
+	}
+	if opts.EndBlock > 0 && version.GetBlockNum() > opts.EndBlock {
+		return false
+	}
+	return true
+}
+
+// sliceIterator walks the indexes of a filtered query result, from an offset and up to a limit
+type sliceIterator struct {
+	length   int
+	current  int
+	limit    uint64
+	returned uint64
+}
+
+func newSliceIterator(length, offset int, limit uint64) *sliceIterator {
+	return &sliceIterator{length: length, current: offset, limit: limit}
+}
+
+// next returns the index of the next entry, or -1 if there are no more entries or the limit was reached
+func (s *sliceIterator) next() int {
+	if s.current >= s.length || (s.limit > 0 && s.returned >= s.limit) {
+		return -1
+	}
+	s.current++
+	s.returned++
+	return s.current - 1
+}
+
+func (s *sliceIterator) ContinuationToken() string {
+	if s.returned == 0 || s.current >= s.length {
+		return ""
+	}
+	return strconv.Itoa(s.current)
+}
+
+type historicalDataIterator struct {
+	*sliceIterator
+	values []*types.ValueWithMetadata
+}
+
+func (i *historicalDataIterator) Next() (*types.ValueWithMetadata, bool, error) {
+	idx := i.next()
+	if idx < 0 {
+		return nil, false, nil
+	}
+	return i.values[idx], true, nil
+}
+
+type userDataIterator struct {
+	*sliceIterator
+	dbNames []string
+	kvs     []*types.KVWithMetadata
+}
+
+func (i *userDataIterator) Next() (string, *types.KVWithMetadata, bool, error) {
+	idx := i.next()
+	if idx < 0 {
+		return "", nil, false, nil
+	}
+	return i.dbNames[idx], i.kvs[idx], true, nil
+}
+
+type txIDIterator struct {
+	*sliceIterator
+	txIDs []string
+}
+
+func (i *txIDIterator) Next() (string, bool, error) {
+	idx := i.next()
+	if idx < 0 {
+		return "", false, nil
+	}
+	return i.txIDs[idx], true, nil
+}
@@ -0,0 +1,386 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package bcdbtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/bcdb"
+	"github.com/hyperledger-labs/orion-server/pkg/constants"
+	"github.com/hyperledger-labs/orion-server/pkg/types"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+)
+
+// checkDataReadLocked checks that the user can read from the database
+func (f *Fake) checkDataReadLocked(userID, dbName string) error {
+	if err := f.checkUserLocked(userID); err != nil {
+		return err
+	}
+	switch {
+	case systemDBs[dbName]:
+		return errors.Errorf("no user can directly read from a system database [%s]", dbName)
+	case f.dbs[dbName] == nil:
+		return errors.Errorf("'%s' does not exist", dbName)
+	case !f.hasPrivilegeLocked(userID, dbName, types.Privilege_Read):
+		return errors.Errorf("the user [%s] has no permission to read from database [%s]", userID, dbName)
+	}
+	return nil
+}
+
+func canReadKey(userID string, value *types.ValueWithMetadata) bool {
+	acl := value.GetMetadata().GetAccessControl()
+	return acl == nil || acl.GetReadUsers()[userID] || acl.GetReadWriteUsers()[userID]
+}
+
+// getData returns a copy of the value of the key, or nil if the key does not exist
+func (f *Fake) getData(userID, dbName, key string) (*types.ValueWithMetadata, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.checkDataReadLocked(userID, dbName); err != nil {
+		return nil, err
+	}
+
+	value, ok := f.dbs[dbName].values[key]
+	if !ok {
+		return nil, nil
+	}
+	if !canReadKey(userID, value) {
+		return nil, errors.Errorf("the user [%s] has no permission to read key [%s] from database [%s]", userID, key, dbName)
+	}
+	return proto.Clone(value).(*types.ValueWithMetadata), nil
+}
+
+// getUser returns a copy of the record of the target user, with a nil user and metadata if it does not exist
+func (f *Fake) getUser(userID, targetUserID string) (*userRecord, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.checkUserLocked(userID); err != nil {
+		return nil, err
+	}
+
+	record, ok := f.users[targetUserID]
+	if !ok {
+		return &userRecord{}, nil
+	}
+	if !f.isAdminLocked(userID) && !record.metadata.GetAccessControl().GetReadUsers()[userID] {
+		return nil, errors.Errorf("the user [%s] has no permission to read info of user [%s]", userID, targetUserID)
+	}
+	return &userRecord{
+		user:     proto.Clone(record.user).(*types.User),
+		metadata: proto.Clone(record.metadata).(*types.Metadata),
+	}, nil
+}
+
+type queryExecutor struct {
+	session *session
+}
+
+// ExecuteJSONQuery executes the query on the indexed attributes of the JSON values of the database, and returns the
+// matching key-values the session user can read, ordered by key
+func (q *queryExecutor) ExecuteJSONQuery(dbName, query string) ([]*types.KVWithMetadata, error) {
+	f := q.session.fake
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.checkDataReadLocked(q.session.userID, dbName); err != nil {
+		return nil, err
+	}
+	db := f.dbs[dbName]
+
+	combination, conditions, err := parseJSONQuery(dbName, db.index, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var kvs []*types.KVWithMetadata
+	for _, key := range sortedKeys(db.values) {
+		value := db.values[key]
+		if !canReadKey(q.session.userID, value) || !matchJSONQuery(value.GetValue(), combination, conditions) {
+			continue
+		}
+		kvs = append(kvs, &types.KVWithMetadata{
+			Key:      key,
+			Value:    value.GetValue(),
+			Metadata: proto.Clone(value.GetMetadata()).(*types.Metadata),
+		})
+	}
+	return kvs, nil
+}
+
+// GetDataByRange returns an iterator over a snapshot of the key-values the session user can read in the range
+func (q *queryExecutor) GetDataByRange(dbName, startKey, endKey string, limit uint64) (bcdb.Iterator, error) {
+	f := q.session.fake
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.checkDataReadLocked(q.session.userID, dbName); err != nil {
+		return nil, err
+	}
+
+	var kvs []*types.KVWithMetadata
+	for _, key := range sortedKeys(f.dbs[dbName].values) {
+		if key < startKey || (endKey != "" && key >= endKey) {
+			continue
+		}
+		value := f.dbs[dbName].values[key]
+		if !canReadKey(q.session.userID, value) {
+			continue
+		}
+		if limit > 0 && uint64(len(kvs)) == limit {
+			break
+		}
+		kvs = append(kvs, &types.KVWithMetadata{
+			Key:      key,
+			Value:    value.GetValue(),
+			Metadata: proto.Clone(value.GetMetadata()).(*types.Metadata),
+		})
+	}
+	return &rangeIterator{kvs: kvs}, nil
+}
+
+type rangeIterator struct {
+	kvs []*types.KVWithMetadata
+}
+
+func (r *rangeIterator) Next() (*types.KVWithMetadata, bool, error) {
+	if len(r.kvs) == 0 {
+		return nil, false, nil
+	}
+	kv := r.kvs[0]
+	r.kvs = r.kvs[1:]
+	return kv, true, nil
+}
+
+// attributeCondition holds the conditions on an indexed attribute, by operator
+type attributeCondition struct {
+	attrType   types.IndexAttributeType
+	conditions map[string]interface{}
+}
+
+// parseJSONQuery parses the selector of the query, with the syntax and the restrictions of the server
+func parseJSONQuery(dbName string, index map[string]types.IndexAttributeType, query string) (string, map[string]*attributeCondition, error) {
+	var parsed map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewBufferString(query))
+	decoder.UseNumber()
+	if err := decoder.Decode(&parsed); err != nil {
+		return "", nil, errors.Wrap(err, "error decoding the query")
+	}
+
+	if _, ok := parsed[constants.QueryFieldSelector]; !ok {
+		return "", nil, errors.New("selector field is missing in the query")
+	}
+	selector, ok := parsed[constants.QueryFieldSelector].(map[string]interface{})
+	if !ok {
+		return "", nil, errors.New("query syntax error near " + constants.QueryFieldSelector)
+	}
+	if len(selector) == 0 {
+		return "", nil, errors.New("query conditions cannot be empty")
+	}
+
+	_, and := selector[constants.QueryOpAnd]
+	_, or := selector[constants.QueryOpOr]
+	combination := constants.QueryOpAnd
+	switch {
+	case and && or:
+		return "", nil, errors.New("there must be a single upper level combination operator")
+	case and || or:
+		if or {
+			combination = constants.QueryOpOr
+		}
+		if selector, ok = selector[combination].(map[string]interface{}); !ok {
+			return "", nil, errors.New("query syntax error near " + combination)
+		}
+	}
+
+	if len(index) == 0 {
+		return "", nil, errors.New("no index has been defined on the database " + dbName)
+	}
+
+	conditions := make(map[string]*attributeCondition)
+	for attr, c := range selector {
+		attrType, ok := index[attr]
+		if !ok {
+			return "", nil, errors.New("attribute [" + attr + "] given in the query condition is not indexed")
+		}
+		cond, ok := c.(map[string]interface{})
+		if !ok {
+			return "", nil, errors.New("query syntax error near the attribute [" + attr + "]")
+		}
+		if len(cond) == 0 {
+			return "", nil, errors.New("no condition provided for the attribute [" + attr + "]. All given attributes must have a condition")
+		}
+
+		attrCond := &attributeCondition{attrType: attrType, conditions: make(map[string]interface{})}
+		for opr, v := range cond {
+			switch opr {
+			case constants.QueryOpNotEqual:
+				values, ok := v.([]interface{})
+				if !ok {
+					return "", nil, errors.New("attribute [" + attr + "] is indexed but incorrect value type provided in the query")
+				}
+				var converted []interface{}
+				for _, value := range values {
+					c, err := attributeValue(value, attrType)
+					if err != nil {
+						return "", nil, errors.WithMessage(err, "attribute ["+attr+"] is indexed but incorrect value type provided in the query")
+					}
+					converted = append(converted, c)
+				}
+				attrCond.conditions[opr] = converted
+			case constants.QueryOpEqual, constants.QueryOpGreaterThan, constants.QueryOpGreaterThanOrEqual,
+				constants.QueryOpLesserThan, constants.QueryOpLesserThanOrEqual:
+				c, err := attributeValue(v, attrType)
+				if err != nil {
+					return "", nil, errors.WithMessage(err, "attribute ["+attr+"] is indexed but the value type provided in the query does not match the actual indexed type")
+				}
+				attrCond.conditions[opr] = c
+			default:
+				return "", nil, errors.New("invalid logical operator [" + opr + "] provided for the attribute [" + attr + "]")
+			}
+		}
+
+		_, eq := attrCond.conditions[constants.QueryOpEqual]
+		_, gt := attrCond.conditions[constants.QueryOpGreaterThan]
+		_, gte := attrCond.conditions[constants.QueryOpGreaterThanOrEqual]
+		_, lt := attrCond.conditions[constants.QueryOpLesserThan]
+		_, lte := attrCond.conditions[constants.QueryOpLesserThanOrEqual]
+		switch {
+		case eq && len(attrCond.conditions) > 1:
+			return "", nil, errors.New("query syntax error near attribute [" + attr + "]: with [" + constants.QueryOpEqual + "] condition, no other condition should be provided")
+		case gt && gte:
+			return "", nil, errors.New("query syntax error near attribute [" + attr + "]: use either [" + constants.QueryOpGreaterThan + "] or [" + constants.QueryOpGreaterThanOrEqual + "] but not both")
+		case lt && lte:
+			return "", nil, errors.New("query syntax error near attribute [" + attr + "]: use either [" + constants.QueryOpLesserThan + "] or [" + constants.QueryOpLesserThanOrEqual + "] but not both")
+		}
+		conditions[attr] = attrCond
+	}
+
+	return combination, conditions, nil
+}
+
+// attributeValue converts a JSON value to the comparable value of the attribute type: a string, an int64 or a bool
+func attributeValue(v interface{}, attrType types.IndexAttributeType) (interface{}, error) {
+	switch value := v.(type) {
+	case json.Number:
+		if attrType == types.IndexAttributeType_NUMBER {
+			return value.Int64()
+		}
+	case string:
+		if attrType == types.IndexAttributeType_STRING {
+			return value, nil
+		}
+	case bool:
+		if attrType == types.IndexAttributeType_BOOLEAN {
+			return value, nil
+		}
+	}
+	return nil, errors.Errorf("the value [%v] does not match the type [%s]", v, attrType)
+}
+
+// matchJSONQuery returns true if the value is a JSON document that satisfies the conditions. As with the server, an
+// attribute that is missing or has another type than the indexed one satisfies no condition.
+func matchJSONQuery(value []byte, combination string, conditions map[string]*attributeCondition) bool {
+	var doc map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewBuffer(value))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return false
+	}
+
+	attrs := make([]string, 0, len(conditions))
+	for attr := range conditions {
+		attrs = append(attrs, attr)
+	}
+	sort.Strings(attrs)
+
+	for _, attr := range attrs {
+		matched := matchAttribute(doc[attr], conditions[attr])
+		if combination == constants.QueryOpOr && matched {
+			return true
+		}
+		if combination == constants.QueryOpAnd && !matched {
+			return false
+		}
+	}
+	return combination == constants.QueryOpAnd
+}
+
+func matchAttribute(v interface{}, cond *attributeCondition) bool {
+	value, err := attributeValue(v, cond.attrType)
+	if err != nil {
+		return false
+	}
+
+	for opr, operand := range cond.conditions {
+		if opr == constants.QueryOpNotEqual {
+			for _, excluded := range operand.([]interface{}) {
+				if compareValues(value, excluded) == 0 {
+					return false
+				}
+			}
+			continue
+		}
+
+		c := compareValues(value, operand)
+		switch opr {
+		case constants.QueryOpEqual:
+			if c != 0 {
+				return false
+			}
+		case constants.QueryOpGreaterThan:
+			if c <= 0 {
+				return false
+			}
+		case constants.QueryOpGreaterThanOrEqual:
+			if c < 0 {
+				return false
+			}
+		case constants.QueryOpLesserThan:
+			if c >= 0 {
+				return false
+			}
+		case constants.QueryOpLesserThanOrEqual:
+			if c > 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// compareValues compares two values of the same attribute type, false being lesser than true
+func compareValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case int64:
+		b := b.(int64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+	case string:
+		b := b.(string)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+	case bool:
+		b := b.(bool)
+		switch {
+		case !a && b:
+			return -1
+		case a && !b:
+			return 1
+		}
+	}
+	return 0
+}
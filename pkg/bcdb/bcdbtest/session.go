@@ -0,0 +1,203 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package bcdbtest
+
+import (
+	"context"
+	"time"
+
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/bcdb"
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/config"
+	"github.com/hyperledger-labs/orion-server/pkg/types"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+)
+
+// ReplicaID is the ID of the single replica a session of the fake reports
+const ReplicaID = "bcdbtest"
+
+type session struct {
+	fake   *Fake
+	userID string
+}
+
+func (s *session) newTxContext(options ...bcdb.TxContextOption) (*txContext, error) {
+	txID, err := bcdb.TxIDFromOptions(options...)
+	if err != nil {
+		return nil, err
+	}
+	if txID == "" {
+		s.fake.lock.Lock()
+		txID = s.fake.nextTxID()
+		s.fake.lock.Unlock()
+	}
+
+	return &txContext{session: s, txID: txID}, nil
+}
+
+func (s *session) UsersTx() (bcdb.UsersTxContext, error) {
+	txCtx, err := s.newTxContext()
+	if err != nil {
+		return nil, err
+	}
+	return &userTxContext{txContext: txCtx}, nil
+}
+
+func (s *session) DataTx(options ...bcdb.TxContextOption) (bcdb.DataTxContext, error) {
+	txCtx, err := s.newTxContext(options...)
+	if err != nil {
+		return nil, err
+	}
+	return &dataTxContext{
+		txContext:  txCtx,
+		operations: make(map[string]*dbOperations),
+		txUsers:    map[string]bool{s.userID: true},
+	}, nil
+}
+
+func (s *session) LoadDataTx(txEnv *types.DataTxEnvelope) (bcdb.LoadedDataTxContext, error) {
+	switch {
+	case txEnv == nil:
+		return nil, errors.New("transaction envelope is nil")
+	case txEnv.GetPayload() == nil:
+		return nil, errors.New("payload in the transaction envelope is nil")
+	case len(txEnv.GetSignatures()) == 0:
+		return nil, errors.New("transaction envelope does not have a signature")
+	case txEnv.GetPayload().GetTxId() == "":
+		return nil, errors.New("transaction ID in the transaction envelope is empty")
+	case len(txEnv.GetPayload().GetMustSignUserIds()) == 0:
+		return nil, errors.New("no user ID in the transaction envelope")
+	}
+
+	return &loadedDataTxContext{
+		txContext: &txContext{session: s, txID: txEnv.GetPayload().GetTxId()},
+		txEnv:     proto.Clone(txEnv).(*types.DataTxEnvelope),
+	}, nil
+}
+
+func (s *session) DBsTx() (bcdb.DBsTxContext, error) {
+	txCtx, err := s.newTxContext()
+	if err != nil {
+		return nil, err
+	}
+	return &dbsTxContext{
+		txContext:  txCtx,
+		createdDBs: make(map[string]*types.DBIndex),
+		deletedDBs: make(map[string]bool),
+	}, nil
+}
+
+// ConfigTx is not supported, as the fake has no cluster configuration but its admins
+func (s *session) ConfigTx() (bcdb.ConfigTxContext, error) {
+	return nil, errors.WithMessage(ErrNotSupported, "ConfigTx")
+}
+
+func (s *session) Provenance() (bcdb.Provenance, error) {
+	return &provenanceQuerier{session: s}, nil
+}
+
+func (s *session) Ledger() (bcdb.Ledger, error) {
+	return &ledger{session: s}, nil
+}
+
+func (s *session) Query() (bcdb.Query, error) {
+	return &queryExecutor{session: s}, nil
+}
+
+// ReplicaSet returns the single replica of the fake
+func (s *session) ReplicaSet(refresh bool) ([]*config.Replica, error) {
+	return []*config.Replica{{ID: ReplicaID, Endpoint: "bcdbtest://" + ReplicaID}}, nil
+}
+
+// RotateCredentials accepts the configuration of the session user, whose credentials the fake does not use
+func (s *session) RotateCredentials(userConfig *config.UserConfig, clientTLS *config.ClientTLSConfig) error {
+	if userConfig == nil {
+		return errors.New("user configuration is nil")
+	}
+	if userConfig.UserID != s.userID {
+		return errors.Errorf("user ID [%s] differs from the session user ID [%s]", userConfig.UserID, s.userID)
+	}
+	return nil
+}
+
+// WatchCredentials returns at once, as the fake does not use credentials
+func (s *session) WatchCredentials(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return errors.Errorf("watch interval must be positive, got %s", interval)
+	}
+	return nil
+}
+
+// WatchCluster returns at once, as the single replica of the fake never changes
+func (s *session) WatchCluster(ctx context.Context, conf *bcdb.ClusterWatcherConfig) error {
+	if conf == nil {
+		return errors.New("cluster watcher configuration is nil")
+	}
+	if conf.Interval <= 0 {
+		return errors.Errorf("watch interval must be positive, got %s", conf.Interval)
+	}
+	return nil
+}
+
+// Close does nothing, as the fake session has no background work
+func (s *session) Close() {}
+
+// txContext holds the state common to all the transaction contexts
+type txContext struct {
+	session    *session
+	txID       string
+	txEnvelope proto.Message
+	txSpent    bool
+}
+
+func (t *txContext) TxID() string {
+	return t.txID
+}
+
+func (t *txContext) CommittedTxEnvelope() (proto.Message, error) {
+	if t.txEnvelope == nil {
+		return nil, bcdb.ErrTxNotFinalized
+	}
+	return t.txEnvelope, nil
+}
+
+func (t *txContext) Abort() error {
+	if t.txSpent {
+		return bcdb.ErrTxSpent
+	}
+	t.txSpent = true
+	return nil
+}
+
+// commit commits the envelope composed by the transaction context. As the fake commits a transaction at once, an
+// asynchronous commit differs from a synchronous one only by not returning the receipt and the validation error.
+func (t *txContext) commit(compose func() (proto.Message, error), sync bool) (string, *types.TxReceiptResponseEnvelope, error) {
+	if t.txSpent {
+		return "", nil, bcdb.ErrTxSpent
+	}
+
+	txEnv, err := compose()
+	if err != nil {
+		return "", nil, err
+	}
+	t.txEnvelope = txEnv
+
+	receipt, err := t.session.fake.commit(t.txID, txEnv)
+	if err != nil {
+		return t.txID, nil, err
+	}
+	t.txSpent = true
+	if !sync {
+		return t.txID, nil, nil
+	}
+
+	receiptEnv := &types.TxReceiptResponseEnvelope{
+		Response: &types.TxReceiptResponse{Receipt: receipt},
+	}
+	valInfo := receipt.GetHeader().GetValidationInfo()[receipt.GetTxIndex()]
+	if valInfo.GetFlag() != types.Flag_VALID {
+		return t.txID, receiptEnv, &bcdb.ErrorTxValidation{TxID: t.txID, Flag: valInfo.GetFlag().String(), Reason: valInfo.GetReasonIfInvalid()}
+	}
+	return t.txID, receiptEnv, nil
+}
@@ -0,0 +1,148 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package bcdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger-labs/orion-sdk-go/internal/test"
+	"github.com/stretchr/testify/require"
+)
+
+func openClusterSession(t *testing.T, c *test.Cluster, nodeIDs ...string) DBSession {
+	db, err := Create(c.ConnectionConfig(nodeIDs...))
+	require.NoError(t, err)
+	session, err := db.Session(c.SessionConfig(test.AdminID))
+	require.NoError(t, err)
+	return session
+}
+
+// requireLeaderFirst refreshes the replica set of the session until it puts the leader first, as the cluster status
+// reported by the nodes lags behind an election
+func requireLeaderFirst(t *testing.T, session DBSession, leader *test.Node) {
+	require.Eventually(t, func() bool {
+		replicas, err := session.ReplicaSet(true)
+		return err == nil && replicas[0].ID == leader.ID
+	}, 30*time.Second, 100*time.Millisecond)
+}
+
+func getKey(t *testing.T, session DBSession, key string) ([]byte, error) {
+	tx, err := session.DataTx()
+	require.NoError(t, err)
+	defer tx.Abort()
+
+	val, _, err := tx.Get("bdb", key)
+	return val, err
+}
+
+// Scenario:
+// - start a 3 node cluster, and open a session bootstrapped from a follower only
+// - commit: the replica set of the session puts the leader first
+// - force a leader change: the commit, sent to the old leader, is redirected to the new one
+// - stop the leader: the commit is retried until a new leader is elected
+// - restart the stopped node: it catches up with the transactions committed while it was down
+func TestClusterFailover_Commit(t *testing.T) {
+	c := test.NewCluster(t, nil)
+	leader := c.WaitForLeader()
+	var follower *test.Node
+	for _, node := range c.Nodes() {
+		if node.ID != leader.ID {
+			follower = node
+			break
+		}
+	}
+
+	session := openClusterSession(t, c, follower.ID)
+	replicas, err := session.ReplicaSet(false)
+	require.NoError(t, err)
+	require.Len(t, replicas, 3)
+	requireLeaderFirst(t, session, leader)
+	putKeySync(t, "bdb", "key1", "value1", test.AdminID, session)
+
+	newLeader := c.ForceLeaderChange()
+	require.NotEqual(t, leader.ID, newLeader.ID)
+	putKeySync(t, "bdb", "key2", "value2", test.AdminID, session)
+	requireLeaderFirst(t, session, newLeader)
+
+	c.StopNode(newLeader.ID)
+	putKeySync(t, "bdb", "key3", "value3", test.AdminID, session)
+	lastLeader := c.WaitForLeader()
+	require.NotEqual(t, newLeader.ID, lastLeader.ID)
+	requireLeaderFirst(t, session, lastLeader)
+
+	c.StartNode(newLeader.ID)
+	c.WaitForLeader()
+	restarted := openClusterSession(t, c, newLeader.ID)
+	require.Eventually(t, func() bool {
+		// the session queries the leader, the restarted node must agree on it and have caught up
+		val, err := getKey(t, restarted, "key3")
+		return err == nil && string(val) == "value3"
+	}, 30*time.Second, 100*time.Millisecond)
+}
+
+// Scenario:
+// - start a 3 node cluster, and open a session, which queries the leader
+// - stop the leader: the queries fail over to a follower once the circuit of the stopped leader opens
+// - stop a second node: there is no leader, the remaining node still serves queries
+// - partition the remaining node from a restarted node: there is still no leader
+// - heal the partition: a leader is elected and commits succeed again
+func TestClusterFailover_Query(t *testing.T) {
+	c := test.NewCluster(t, nil)
+	leader := c.WaitForLeader()
+
+	session := openClusterSession(t, c)
+	requireLeaderFirst(t, session, leader)
+	putKeySync(t, "bdb", "key1", "value1", test.AdminID, session)
+	val, err := getKey(t, session, "key1")
+	require.NoError(t, err)
+	require.Equal(t, []byte("value1"), val)
+
+	c.StopNode(leader.ID)
+	require.Eventually(t, func() bool {
+		val, err := getKey(t, session, "key1")
+		return err == nil && string(val) == "value1"
+	}, 30*time.Second, 100*time.Millisecond)
+
+	newLeader := c.WaitForLeader()
+	requireLeaderFirst(t, session, newLeader)
+	val, err = getKey(t, session, "key1")
+	require.NoError(t, err)
+	require.Equal(t, []byte("value1"), val)
+
+	var remaining *test.Node
+	for _, node := range c.Nodes() {
+		if node.ID != leader.ID && node.ID != newLeader.ID {
+			remaining = node
+		}
+	}
+	c.StopNode(newLeader.ID)
+	require.Eventually(t, func() bool {
+		return c.Leader() == nil
+	}, 30*time.Second, 50*time.Millisecond)
+
+	noLeaderSession := openClusterSession(t, c, remaining.ID)
+	require.Eventually(t, func() bool {
+		// the stopped nodes are reported active for a while, and may be queried before the remaining node
+		if _, err := noLeaderSession.ReplicaSet(true); err != nil {
+			return false
+		}
+		val, err := getKey(t, noLeaderSession, "key1")
+		return err == nil && string(val) == "value1"
+	}, 30*time.Second, 100*time.Millisecond)
+
+	c.Partition(remaining.ID)
+	c.StartNode(leader.ID)
+	require.Never(t, func() bool {
+		return c.Leader() != nil
+	}, time.Second, 50*time.Millisecond)
+
+	c.Heal()
+	lastLeader := c.WaitForLeader()
+	requireLeaderFirst(t, noLeaderSession, lastLeader)
+	putKeySync(t, "bdb", "key2", "value2", test.AdminID, noLeaderSession)
+	val, err = getKey(t, noLeaderSession, "key2")
+	require.NoError(t, err)
+	require.Equal(t, []byte("value2"), val)
+}
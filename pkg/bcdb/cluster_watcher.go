@@ -0,0 +1,120 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+package bcdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/config"
+	"github.com/hyperledger-labs/orion-server/pkg/types"
+	"github.com/pkg/errors"
+)
+
+// ClusterWatcherConfig configures the background refresh of the replica set of a session, and the callbacks invoked
+// when the cluster changes. All callbacks are optional, and are invoked sequentially from the watcher goroutine.
+type ClusterWatcherConfig struct {
+	// Interval between two consecutive polls of the cluster status, must be positive
+	Interval time.Duration
+	// OnLeaderChange is invoked when the leader of the cluster changes. An empty ID means the leader is unknown,
+	// e.g. during an election.
+	OnLeaderChange func(oldLeaderID, newLeaderID string)
+	// OnNodeAdded is invoked for every node that joined the cluster
+	OnNodeAdded func(replica *config.Replica)
+	// OnNodeRemoved is invoked for every node that left the cluster
+	OnNodeRemoved func(replica *config.Replica)
+	// OnConfigVersionChange is invoked when the version of the cluster configuration changes
+	OnConfigVersionChange func(oldVersion, newVersion *types.Version)
+	// OnError is invoked when the cluster status cannot be refreshed
+	OnError func(err error)
+}
+
+// clusterState is the part of the cluster status the watcher compares between polls
+type clusterState struct {
+	version  *types.Version
+	leader   string
+	replicas map[string]*config.Replica
+}
+
+// WatchCluster starts polling the cluster status every interval, until the context is done. Every poll refreshes
+// the replica set and the signature verifier of the session, as ReplicaSet(true) does, and invokes the callbacks
+// for the changes since the previous poll: first the config version change, then removed nodes, then added nodes,
+// and finally the leader change. A node whose URL changed is reported as removed and then added.
+func (d *dbSession) WatchCluster(ctx context.Context, conf *ClusterWatcherConfig) error {
+	if conf == nil {
+		return errors.New("cluster watcher configuration is nil")
+	}
+	if conf.Interval <= 0 {
+		return errors.Errorf("watch interval must be positive, got %s", conf.Interval)
+	}
+
+	state := d.currentClusterState()
+
+	go func() {
+		ticker := time.NewTicker(conf.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if _, err := d.ReplicaSet(true); err != nil {
+				d.logger.Warnf("cluster watcher failed to refresh the replica set, will retry, due to %s", err)
+				if conf.OnError != nil {
+					conf.OnError(err)
+				}
+				continue
+			}
+
+			newState := d.currentClusterState()
+			notifyClusterChanges(conf, state, newState)
+			state = newState
+		}
+	}()
+
+	return nil
+}
+
+// currentClusterState returns a snapshot of the cluster state last observed by the session
+func (d *dbSession) currentClusterState() *clusterState {
+	d.replicaSetLock.RLock()
+	defer d.replicaSetLock.RUnlock()
+
+	state := &clusterState{
+		version:  d.replicaSetVersion,
+		leader:   d.leader,
+		replicas: make(map[string]*config.Replica),
+	}
+	for _, replica := range d.replicaSet.ToConfigReplicaSet() {
+		state.replicas[replica.ID] = replica
+	}
+	return state
+}
+
+func notifyClusterChanges(conf *ClusterWatcherConfig, oldState, newState *clusterState) {
+	if conf.OnConfigVersionChange != nil && compareVersion(oldState.version, newState.version) != 0 {
+		conf.OnConfigVersionChange(oldState.version, newState.version)
+	}
+
+	for id, oldReplica := range oldState.replicas {
+		if newReplica, ok := newState.replicas[id]; !ok || newReplica.Endpoint != oldReplica.Endpoint {
+			if conf.OnNodeRemoved != nil {
+				conf.OnNodeRemoved(oldReplica)
+			}
+		}
+	}
+	for id, newReplica := range newState.replicas {
+		if oldReplica, ok := oldState.replicas[id]; !ok || newReplica.Endpoint != oldReplica.Endpoint {
+			if conf.OnNodeAdded != nil {
+				conf.OnNodeAdded(newReplica)
+			}
+		}
+	}
+
+	if conf.OnLeaderChange != nil && oldState.leader != newState.leader {
+		conf.OnLeaderChange(oldState.leader, newState.leader)
+	}
+}
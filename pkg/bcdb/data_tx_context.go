@@ -4,6 +4,10 @@
 package bcdb
 
 import (
+	"context"
+	"sort"
+
+	"github.com/hyperledger-labs/orion-sdk-go/internal"
 	"github.com/hyperledger-labs/orion-server/pkg/constants"
 	"github.com/hyperledger-labs/orion-server/pkg/cryptoservice"
 	"github.com/hyperledger-labs/orion-server/pkg/types"
@@ -31,7 +35,8 @@ type DataTxContext interface {
 	// AddMustSignUser can be used to add users whose signatures is required,
 	// on top of those mandates by the ACLs of the keys in the write-set of
 	// the transaction. The userID of the initiating client is always in
-	// the MustSignUserIDs set."
+	// the MustSignUserIDs set. RequiredSigners can be used to compute the
+	// users mandated by the ACLs."
 	AddMustSignUser(userID string)
 	// SignConstructedTxEnvelopeAndCloseTx returns a signed transaction envelope and
 	// also closes the transaction context. When a transaction requires
@@ -40,6 +45,70 @@ type DataTxContext interface {
 	// sign it and construct the envelope. The envelope must then be
 	// circulated among all the users that need to co-sign it."
 	SignConstructedTxEnvelopeAndCloseTx() (proto.Message, error)
+	// RequiredSigners computes the users whose signatures are required by the
+	// write ACLs of the keys written or deleted by the transaction. The ACL of
+	// a key is taken from the metadata fetched by Get, or fetched from the
+	// database otherwise. When addToMustSignUsers is true, the set of users
+	// returned by SignerSets.Users is added to the MustSignUserIDs set.
+	RequiredSigners(addToMustSignUsers bool) (*SignerSets, error)
+	// Validate dry-runs the validation of the transaction against the current
+	// state of the database, and returns the validation failures the server
+	// is predicted to mark the transaction with. It re-reads the keys read or
+	// asserted to detect stale reads, checks the database privileges of the
+	// must-sign users through their user records, evaluates the key ACLs and
+	// confirms that the target databases exist.
+	Validate(ctx context.Context) ([]*PredictedFailure, error)
+}
+
+// SignerSets holds the users whose signatures are required by the write ACLs
+// of the keys modified by a data transaction.
+type SignerSets struct {
+	// AllOf holds the users that must all sign the transaction, due to keys
+	// with the ALL sign policy for write, or with a single write user
+	AllOf []string
+	// AnyOf holds groups of users, such that at least one user of each group
+	// must sign the transaction, due to keys with the ANY sign policy for
+	// write. Groups that are already satisfied by AllOf or by the users in the
+	// MustSignUserIDs set are omitted.
+	AnyOf [][]string
+}
+
+// Users returns a small set of users that satisfies the requirements: all
+// the users in AllOf, and from the AnyOf groups, the users that satisfy the
+// largest number of the remaining groups, one after the other. This greedy
+// cover is not minimal in general: for the groups {a, c, x}, {a, c, y},
+// {a, z}, {b, c, u}, {b, c, v} and {b, w}, it returns a, b and c, while a
+// and b suffice.
+func (s *SignerSets) Users() []string {
+	users := append([]string{}, s.AllOf...)
+
+	remaining := s.AnyOf
+	for len(remaining) > 0 {
+		count := make(map[string]int)
+		for _, group := range remaining {
+			for _, u := range group {
+				count[u]++
+			}
+		}
+		best := ""
+		for u, c := range count {
+			if c > count[best] || (c == count[best] && u < best) {
+				best = u
+			}
+		}
+		users = append(users, best)
+
+		var next [][]string
+		for _, group := range remaining {
+			if !containsString(group, best) {
+				next = append(next, group)
+			}
+		}
+		remaining = next
+	}
+
+	sort.Strings(users)
+	return users
 }
 
 type dataTxContext struct {
@@ -99,26 +168,42 @@ func (d *dataTxContext) Get(dbName, key string) ([]byte, *types.Metadata, error)
 		}
 	}
 
+	// the cached response carries the version of the value, which the read records for MVCC
+	cacheKey := readCacheKey{kind: readCacheData, dbName: dbName, key: key}
+	cached, invalidations := d.readCache.get(cacheKey)
+	res, isCached := cached.(*types.GetDataResponse)
+	if !isCached {
+		var err error
+		res, err = d.getData(context.Background(), dbName, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		d.readCache.put(cacheKey, res, invalidations)
+	}
+
+	if !ok {
+		ops = newDBOperations()
+		d.operations[dbName] = ops
+	}
+
+	ops.dataReads[key] = res
+	return res.GetValue(), res.GetMetadata(), nil
+}
+
+func (d *dataTxContext) getData(ctx context.Context, dbName, key string) (*types.GetDataResponse, error) {
 	path := constants.URLForGetData(dbName, key)
 	resEnv := &types.GetDataResponseEnvelope{}
-	err := d.handleRequest(path, &types.GetDataQuery{
+	err := d.handleRequestWithContext(ctx, path, &types.GetDataQuery{
 		UserId: d.userID,
 		DbName: dbName,
 		Key:    key,
 	}, resEnv)
 	if err != nil {
 		d.logger.Errorf("failed to execute ledger data query path %s, due to %s", path, err)
-		return nil, nil, err
-	}
-
-	if !ok {
-		ops = newDBOperations()
-		d.operations[dbName] = ops
+		return nil, err
 	}
 
-	res := resEnv.GetResponse()
-	ops.dataReads[key] = res
-	return res.GetValue(), res.GetMetadata(), nil
+	return resEnv.GetResponse(), nil
 }
 
 // Delete value for key
@@ -175,6 +260,96 @@ func (d *dataTxContext) AddMustSignUser(userID string) {
 	d.txUsers[userID] = true
 }
 
+// RequiredSigners computes the users whose signatures are required by the
+// write ACLs of the keys written or deleted by the transaction, as the server
+// evaluates them: a key with no ACL can be modified by any user, a key with
+// the ANY sign policy requires the signature of one of its write users, and a
+// key with the ALL sign policy requires the signatures of all its write users.
+func (d *dataTxContext) RequiredSigners(addToMustSignUsers bool) (*SignerSets, error) {
+	if d.txSpent {
+		return nil, ErrTxSpent
+	}
+
+	allOf := make(map[string]bool)
+	var anyOf [][]string
+	for dbName, ops := range d.operations {
+		var keys []string
+		for key := range ops.dataWrites {
+			keys = append(keys, key)
+		}
+		for key := range ops.dataDeletes {
+			keys = append(keys, key)
+		}
+
+		for _, key := range keys {
+			acl, err := d.currentACL(dbName, key, ops)
+			if err != nil {
+				return nil, err
+			}
+			if acl == nil {
+				continue
+			}
+
+			var writers []string
+			for userID := range acl.GetReadWriteUsers() {
+				writers = append(writers, userID)
+			}
+			sort.Strings(writers)
+
+			switch {
+			case len(writers) == 0:
+				return nil, errors.Errorf("no user can write or delete the key [%s] in the database [%s]", key, dbName)
+			case len(writers) == 1 || acl.GetSignPolicyForWrite() == types.AccessControl_ALL:
+				for _, userID := range writers {
+					allOf[userID] = true
+				}
+			default:
+				anyOf = append(anyOf, writers)
+			}
+		}
+	}
+
+	signers := &SignerSets{}
+	for userID := range allOf {
+		signers.AllOf = append(signers.AllOf, userID)
+	}
+	sort.Strings(signers.AllOf)
+	signers.AnyOf = internal.MinimalGroups(anyOf, func(userID string) bool {
+		return allOf[userID] || d.txUsers[userID]
+	})
+
+	if addToMustSignUsers {
+		for _, userID := range signers.Users() {
+			d.AddMustSignUser(userID)
+		}
+	}
+
+	return signers, nil
+}
+
+// currentACL returns the ACL of the key as committed in the database, which is
+// the one the server evaluates, rather than the ACL set by the transaction.
+func (d *dataTxContext) currentACL(dbName, key string, ops *dbOperations) (*types.AccessControl, error) {
+	if res, ok := ops.dataReads[key]; ok {
+		return res.GetMetadata().GetAccessControl(), nil
+	}
+
+	res, err := d.getData(context.Background(), dbName, key)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to fetch the ACL of the key [%s] in the database [%s]", key, dbName)
+	}
+	return res.GetMetadata().GetAccessControl(), nil
+}
+
+func containsString(set []string, s string) bool {
+	for _, e := range set {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
 // SignConstructedTxEnvelopeAndCloseTx returns a signed transaction envelope and
 // also closes the transaction context. When the transaction requires signature from
 // multiple users, SignConstructedTxEnvelopeAndCloseTx can be used by
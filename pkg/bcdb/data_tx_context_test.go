@@ -4,15 +4,22 @@
 package bcdb
 
 import (
+	"context"
 	"encoding/pem"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
 	"path"
 	"sort"
 	"strconv"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
+	sdkconfig "github.com/hyperledger-labs/orion-sdk-go/pkg/config"
 	"github.com/hyperledger-labs/orion-server/pkg/server"
 	"github.com/hyperledger-labs/orion-server/pkg/server/testutils"
 	"github.com/hyperledger-labs/orion-server/pkg/types"
@@ -97,6 +104,98 @@ func TestDataContext_PutKey_WithTxID(t *testing.T) {
 	require.EqualError(t, err, "error while applying option: WithTxID: empty txID")
 }
 
+func TestDataContext_CommitAfterAmbiguousFailure(t *testing.T) {
+	clientCertTemDir := testutils.GenerateTestCrypto(t, []string{"admin", "server"})
+	testServer, _, _, err := SetupTestServer(t, clientCertTemDir)
+	defer testServer.Stop()
+	require.NoError(t, err)
+	StartTestServer(t, testServer)
+
+	bcdb, _ := connectAndOpenAdminSession(t, testServer, clientCertTemDir)
+
+	// the first submission of every transaction reaches the server, but its response is lost
+	var lock sync.Mutex
+	submissions := make(map[string]int)
+	lostResponse := func(info *sdkconfig.RequestInfo, req *http.Request, invoker sdkconfig.RequestInvoker) (*http.Response, error) {
+		if info.TxID == "" {
+			return invoker(req)
+		}
+		lock.Lock()
+		submissions[info.TxID]++
+		first := submissions[info.TxID] == 1
+		lock.Unlock()
+
+		resp, err := invoker(req)
+		if err != nil || !first {
+			return resp, err
+		}
+		resp.Body.Close()
+		return nil, &url.Error{Op: "Post", URL: req.URL.String(), Err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}}
+	}
+	session, err := bcdb.Session(&sdkconfig.SessionConfig{
+		UserConfig: &sdkconfig.UserConfig{
+			UserID:         "admin",
+			CertPath:       path.Join(clientCertTemDir, "admin.pem"),
+			PrivateKeyPath: path.Join(clientCertTemDir, "admin.key"),
+		},
+		TxTimeout:    20 * time.Second,
+		Interceptors: []sdkconfig.RequestInterceptor{lostResponse},
+	})
+	require.NoError(t, err)
+
+	t.Run("sync commit returns the receipt", func(t *testing.T) {
+		tx, err := session.DataTx()
+		require.NoError(t, err)
+		require.NoError(t, tx.Put("bdb", "key1", []byte("value1"), nil))
+		txID, receiptEnv, err := tx.Commit(true)
+		require.NoError(t, err)
+		receipt := receiptEnv.GetResponse().GetReceipt()
+		require.Equal(t, types.Flag_VALID, receipt.GetHeader().GetValidationInfo()[receipt.GetTxIndex()].GetFlag())
+
+		// the transaction was submitted once
+		lock.Lock()
+		require.Equal(t, 1, submissions[txID])
+		lock.Unlock()
+
+		_, _, err = tx.Commit(true)
+		require.Equal(t, ErrTxSpent, err)
+	})
+
+	t.Run("async commit is not rejected as a duplicate", func(t *testing.T) {
+		tx, err := session.DataTx()
+		require.NoError(t, err)
+		require.NoError(t, tx.Put("bdb", "key2", []byte("value2"), nil))
+		txID, _, err := tx.Commit(false)
+		require.NoError(t, err)
+		require.Equal(t, tx.TxID(), txID)
+
+		lock.Lock()
+		require.LessOrEqual(t, submissions[txID], 2)
+		lock.Unlock()
+
+		ledger, err := session.Ledger()
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			receipt, err := ledger.GetTransactionReceipt(txID)
+			return err == nil && receipt != nil
+		}, 10*time.Second, 100*time.Millisecond)
+	})
+
+	t.Run("a reused txID is still rejected", func(t *testing.T) {
+		tx, err := session.DataTx(WithTxID("reused-TxID"))
+		require.NoError(t, err)
+		require.NoError(t, tx.Put("bdb", "key3", []byte("value3"), nil))
+		_, _, err = tx.Commit(true)
+		require.NoError(t, err)
+
+		tx, err = session.DataTx(WithTxID("reused-TxID"))
+		require.NoError(t, err)
+		require.NoError(t, tx.Put("bdb", "key3", []byte("value4"), nil))
+		_, _, err = tx.Commit(true)
+		require.EqualError(t, err, "failed to submit transaction, server returned: status: 400 Bad Request, message: the transaction has a duplicate txID [reused-TxID]")
+	})
+}
+
 func TestDataContext_GetNonExistKey(t *testing.T) {
 	clientCertTemDir := testutils.GenerateTestCrypto(t, []string{"admin", "alice", "server"})
 	testServer, _, _, err := SetupTestServer(t, clientCertTemDir)
@@ -718,6 +817,222 @@ func TestDataContext_ConstructEnvelopeForMultiSign(t *testing.T) {
 	require.NotNil(t, dataTxEnv.Signatures["alice"])
 }
 
+func TestDataContext_RequiredSigners(t *testing.T) {
+	clientCertTemDir := testutils.GenerateTestCrypto(t, []string{"admin", "alice", "bob", "carol", "dave", "server"})
+	testServer, _, _, err := SetupTestServer(t, clientCertTemDir)
+	defer testServer.Stop()
+	require.NoError(t, err)
+	StartTestServer(t, testServer)
+
+	bcdb, adminSession := connectAndOpenAdminSession(t, testServer, clientCertTemDir)
+	dbPerm := map[string]types.Privilege_Access{
+		"bdb": 1,
+	}
+	sessions := make(map[string]DBSession)
+	for _, user := range []string{"alice", "bob", "carol", "dave"} {
+		pemUserCert, err := ioutil.ReadFile(path.Join(clientCertTemDir, user+".pem"))
+		require.NoError(t, err)
+		addUser(t, user, adminSession, pemUserCert, dbPerm)
+		sessions[user] = openUserSession(t, bcdb, user, clientCertTemDir)
+	}
+
+	tx, err := sessions["alice"].DataTx()
+	require.NoError(t, err)
+	require.NoError(t, tx.Put("bdb", "key1", []byte("value1"), &types.AccessControl{
+		ReadWriteUsers:     map[string]bool{"alice": true, "bob": true},
+		SignPolicyForWrite: types.AccessControl_ALL,
+	}))
+	require.NoError(t, tx.Put("bdb", "key2", []byte("value2"), &types.AccessControl{
+		ReadUsers:      map[string]bool{"alice": true},
+		ReadWriteUsers: map[string]bool{"carol": true, "dave": true},
+	}))
+	require.NoError(t, tx.Put("bdb", "key3", []byte("value3"), &types.AccessControl{
+		ReadUsers:      map[string]bool{"alice": true},
+		ReadWriteUsers: map[string]bool{"bob": true, "carol": true, "dave": true},
+	}))
+	_, receiptEnv, err := tx.Commit(true)
+	require.NoError(t, err)
+	require.NotNil(t, receiptEnv)
+
+	tx, err = sessions["alice"].DataTx()
+	require.NoError(t, err)
+	_, meta, err := tx.Get("bdb", "key1")
+	require.NoError(t, err)
+	require.NotNil(t, meta.GetAccessControl())
+	require.NoError(t, tx.Put("bdb", "key1", []byte("value1-new"), meta.GetAccessControl()))
+	require.NoError(t, tx.Put("bdb", "key2", []byte("value2-new"), nil))
+	require.NoError(t, tx.Delete("bdb", "key3"))
+	require.NoError(t, tx.Put("bdb", "key4", []byte("value4"), nil))
+
+	signers, err := tx.RequiredSigners(false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"alice", "bob"}, signers.AllOf)
+	require.Equal(t, [][]string{{"carol", "dave"}}, signers.AnyOf)
+	require.Equal(t, []string{"alice", "bob", "carol"}, signers.Users())
+
+	_, err = tx.RequiredSigners(true)
+	require.NoError(t, err)
+	txEnv, err := tx.SignConstructedTxEnvelopeAndCloseTx()
+	require.NoError(t, err)
+	dataTxEnv := txEnv.(*types.DataTxEnvelope)
+	require.ElementsMatch(t, []string{"alice", "bob", "carol"}, dataTxEnv.Payload.MustSignUserIds)
+	_, err = tx.RequiredSigners(false)
+	require.EqualError(t, err, ErrTxSpent.Error())
+
+	var coSignedEnvs []*types.DataTxEnvelope
+	for _, user := range []string{"bob", "carol"} {
+		loadedTx, err := sessions[user].LoadDataTx(proto.Clone(dataTxEnv).(*types.DataTxEnvelope))
+		require.NoError(t, err)
+		env, err := loadedTx.CoSignTxEnvelopeAndCloseTx()
+		require.NoError(t, err)
+		coSignedEnvs = append(coSignedEnvs, env.(*types.DataTxEnvelope))
+	}
+	merged, missing, err := MergeSignatures(coSignedEnvs...)
+	require.NoError(t, err)
+	require.Empty(t, missing)
+
+	loadedTx, err := sessions["bob"].LoadDataTx(merged)
+	require.NoError(t, err)
+	_, receiptEnv, err = loadedTx.Commit(true)
+	require.NoError(t, err)
+	receipt := receiptEnv.GetResponse().GetReceipt()
+	require.Equal(t, types.Flag_VALID, receipt.GetHeader().GetValidationInfo()[receipt.GetTxIndex()].GetFlag())
+}
+
+func TestDataContext_Validate(t *testing.T) {
+	clientCertTemDir := testutils.GenerateTestCrypto(t, []string{"admin", "alice", "bob", "carol", "server"})
+	testServer, _, _, err := SetupTestServer(t, clientCertTemDir)
+	defer testServer.Stop()
+	require.NoError(t, err)
+	StartTestServer(t, testServer)
+
+	bcdb, adminSession := connectAndOpenAdminSession(t, testServer, clientCertTemDir)
+	userRecordACL := &types.AccessControl{
+		ReadUsers: map[string]bool{"alice": true, "bob": true, "carol": true},
+	}
+	sessions := make(map[string]DBSession)
+	for user, perm := range map[string]types.Privilege_Access{"alice": types.Privilege_ReadWrite, "bob": types.Privilege_ReadWrite, "carol": types.Privilege_Read} {
+		pemUserCert, err := ioutil.ReadFile(path.Join(clientCertTemDir, user+".pem"))
+		require.NoError(t, err)
+		require.NoError(t, addUserWithAcl(t, user, adminSession, pemUserCert, map[string]types.Privilege_Access{"bdb": perm}, userRecordACL))
+		sessions[user] = openUserSession(t, bcdb, user, clientCertTemDir)
+	}
+
+	tx, err := sessions["alice"].DataTx()
+	require.NoError(t, err)
+	require.NoError(t, tx.Put("bdb", "key1", []byte("value1"), nil))
+	require.NoError(t, tx.Put("bdb", "key2", []byte("value2"), &types.AccessControl{
+		ReadWriteUsers:     map[string]bool{"alice": true, "bob": true},
+		SignPolicyForWrite: types.AccessControl_ALL,
+	}))
+	_, _, err = tx.Commit(true)
+	require.NoError(t, err)
+
+	flagsOf := func(failures []*PredictedFailure) []types.Flag {
+		var flags []types.Flag
+		for _, f := range failures {
+			flags = append(flags, f.Flag)
+		}
+		return flags
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		tx, err := sessions["alice"].DataTx()
+		require.NoError(t, err)
+		_, _, err = tx.Get("bdb", "key1")
+		require.NoError(t, err)
+		require.NoError(t, tx.Put("bdb", "key1", []byte("value1-new"), nil))
+		require.NoError(t, tx.AssertRead("bdb", "key3", nil))
+
+		failures, err := tx.Validate(context.Background())
+		require.NoError(t, err)
+		require.Empty(t, failures)
+		require.NoError(t, tx.Abort())
+	})
+
+	t.Run("stale read", func(t *testing.T) {
+		tx, err := sessions["alice"].DataTx()
+		require.NoError(t, err)
+		_, _, err = tx.Get("bdb", "key1")
+		require.NoError(t, err)
+		require.NoError(t, tx.Put("bdb", "key1", []byte("value1-stale"), nil))
+		putKeySync(t, "bdb", "key1", "value1-committed", "alice", sessions["alice"])
+
+		failures, err := tx.Validate(context.Background())
+		require.NoError(t, err)
+		require.Len(t, failures, 1)
+		require.Equal(t, &PredictedFailure{
+			Flag:   types.Flag_INVALID_MVCC_CONFLICT_WITH_COMMITTED_STATE,
+			DbName: "bdb",
+			Key:    "key1",
+			Reason: "mvcc conflict has occurred as the committed state for the key [key1] in database [bdb] changed",
+		}, failures[0])
+
+		_, _, err = tx.Commit(true)
+		require.Error(t, err)
+		require.Equal(t, types.Flag_INVALID_MVCC_CONFLICT_WITH_COMMITTED_STATE.String(), err.(*ErrorTxValidation).Flag)
+	})
+
+	t.Run("signature required by ACL", func(t *testing.T) {
+		tx, err := sessions["alice"].DataTx()
+		require.NoError(t, err)
+		require.NoError(t, tx.Put("bdb", "key2", []byte("value2-new"), nil))
+
+		failures, err := tx.Validate(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, []types.Flag{types.Flag_INVALID_NO_PERMISSION}, flagsOf(failures))
+		require.Equal(t, "key2", failures[0].Key)
+
+		tx.AddMustSignUser("bob")
+		failures, err = tx.Validate(context.Background())
+		require.NoError(t, err)
+		require.Empty(t, failures)
+		require.NoError(t, tx.Abort())
+	})
+
+	t.Run("incorrect entries and missing databases", func(t *testing.T) {
+		tx, err := sessions["alice"].DataTx()
+		require.NoError(t, err)
+		require.NoError(t, tx.Delete("bdb", "key3"))
+		require.NoError(t, tx.Put("bdb", "key4", []byte("value4"), &types.AccessControl{
+			ReadUsers: map[string]bool{"eve": true},
+		}))
+		require.NoError(t, tx.Put("db1", "key1", []byte("value1"), nil))
+		require.NoError(t, tx.Put("_users", "key1", []byte("value1"), nil))
+		tx.AddMustSignUser("eve")
+
+		failures, err := tx.Validate(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, []types.Flag{
+			types.Flag_INVALID_UNAUTHORISED,
+			types.Flag_INVALID_NO_PERMISSION,
+			types.Flag_INVALID_INCORRECT_ENTRIES,
+			types.Flag_INVALID_INCORRECT_ENTRIES,
+			types.Flag_INVALID_DATABASE_DOES_NOT_EXIST,
+		}, flagsOf(failures))
+		require.Equal(t, "_users", failures[1].DbName)
+		require.Equal(t, "key4", failures[2].Key)
+		require.Equal(t, "key3", failures[3].Key)
+		require.Equal(t, "db1", failures[4].DbName)
+
+		require.NoError(t, tx.Abort())
+		_, err = tx.Validate(context.Background())
+		require.EqualError(t, err, ErrTxSpent.Error())
+	})
+
+	t.Run("no database privilege", func(t *testing.T) {
+		tx, err := sessions["carol"].DataTx()
+		require.NoError(t, err)
+		require.NoError(t, tx.Put("bdb", "key5", []byte("value5"), nil))
+
+		failures, err := tx.Validate(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, []types.Flag{types.Flag_INVALID_NO_PERMISSION}, flagsOf(failures))
+		require.Equal(t, "none of the must sign users has read-write permission on the database [bdb]", failures[0].Reason)
+		require.NoError(t, tx.Abort())
+	})
+}
+
 func connectAndOpenAdminSession(t *testing.T, testServer *server.BCDBHTTPServer, cryptoDir string) (BCDB, DBSession) {
 	serverPort, err := testServer.Port()
 	require.NoError(t, err)
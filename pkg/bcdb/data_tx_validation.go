@@ -0,0 +1,374 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package bcdb
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger-labs/orion-server/pkg/constants"
+	"github.com/hyperledger-labs/orion-server/pkg/types"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	validDBName = regexp.MustCompile(`^[0-9a-zA-Z_\-\.]+$`)
+	systemDBs   = map[string]bool{
+		"_users":    true,
+		"_dbs":      true,
+		"_config":   true,
+		"_metadata": true,
+	}
+)
+
+// PredictedFailure is a validation failure the server is expected to mark the
+// transaction with, if it was committed in the current state of the database.
+type PredictedFailure struct {
+	// Flag is the validation flag the server would set
+	Flag types.Flag
+	// DbName is the database the failure relates to, if any
+	DbName string
+	// Key is the key the failure relates to, if any
+	Key string
+	// Reason describes the failure
+	Reason string
+}
+
+// Validate dry-runs the server validation of the transaction, as far as it can
+// be done by the client, and returns the predicted validation failures. An
+// empty result predicts a valid transaction. Note that, unlike the server, the
+// validation does not stop at the first failure.
+//
+// The validation re-reads the current version of every key read or asserted by
+// the transaction to detect stale reads, confirms that the target databases
+// exist, checks the database privileges of the must-sign users through their
+// user records, and evaluates the ACLs of the keys read, written and deleted.
+// Hence, the session user needs read access to the user records of the other
+// must-sign users, and to the keys the transaction operates on. Conflicts with
+// other transactions of the same block cannot be predicted.
+func (d *dataTxContext) Validate(ctx context.Context) ([]*PredictedFailure, error) {
+	if d.txSpent {
+		return nil, ErrTxSpent
+	}
+
+	v := &dataTxValidator{
+		ctx:  ctx,
+		tx:   d,
+		data: make(map[string]*types.GetDataResponse),
+	}
+	if err := v.validate(); err != nil {
+		return nil, err
+	}
+	return v.failures, nil
+}
+
+type dataTxValidator struct {
+	ctx      context.Context
+	tx       *dataTxContext
+	users    map[string]*types.User
+	data     map[string]*types.GetDataResponse
+	failures []*PredictedFailure
+}
+
+func (v *dataTxValidator) fail(flag types.Flag, dbName, key, reason string) {
+	v.failures = append(v.failures, &PredictedFailure{
+		Flag:   flag,
+		DbName: dbName,
+		Key:    key,
+		Reason: reason,
+	})
+}
+
+func (v *dataTxValidator) validate() error {
+	var userIDs []string
+	for userID := range v.tx.txUsers {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Strings(userIDs)
+
+	v.users = make(map[string]*types.User)
+	for _, userID := range userIDs {
+		user, err := v.getUser(userID)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			v.fail(types.Flag_INVALID_UNAUTHORISED, "", "",
+				"the must sign user ["+userID+"] does not exist and hence, its signature cannot be valid")
+			continue
+		}
+		v.users[userID] = user
+	}
+
+	var dbNames []string
+	for dbName := range v.tx.operations {
+		dbNames = append(dbNames, dbName)
+	}
+	sort.Strings(dbNames)
+
+	for _, dbName := range dbNames {
+		if err := v.validateDBOperations(dbName, v.tx.operations[dbName]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *dataTxValidator) validateDBOperations(dbName string, ops *dbOperations) error {
+	switch {
+	case !validDBName.MatchString(dbName):
+		v.fail(types.Flag_INVALID_INCORRECT_ENTRIES, dbName, "", "the database name ["+dbName+"] is not valid")
+		return nil
+	case systemDBs[dbName]:
+		v.fail(types.Flag_INVALID_NO_PERMISSION, dbName, "", "the database ["+dbName+"] is a system database and no user can write to a system database via data transaction")
+		return nil
+	}
+
+	exist, err := v.dbExists(dbName)
+	if err != nil {
+		return err
+	}
+	if !exist {
+		v.fail(types.Flag_INVALID_DATABASE_DOES_NOT_EXIST, dbName, "", "the database ["+dbName+"] does not exist in the cluster")
+		return nil
+	}
+
+	// as the server does, only the must-sign users with read-write privilege on the database are considered
+	var usersWithDBAccess []string
+	for userID, user := range v.users {
+		if user.GetPrivilege().GetAdmin() || user.GetPrivilege().GetDbPermission()[dbName] == types.Privilege_ReadWrite {
+			usersWithDBAccess = append(usersWithDBAccess, userID)
+		}
+	}
+	sort.Strings(usersWithDBAccess)
+	if len(usersWithDBAccess) == 0 {
+		v.fail(types.Flag_INVALID_NO_PERMISSION, dbName, "", "none of the must sign users has read-write permission on the database ["+dbName+"]")
+		return nil
+	}
+
+	if err = v.validateWriteACLDefinitions(dbName, ops); err != nil {
+		return err
+	}
+	if err = v.validateReads(dbName, ops, usersWithDBAccess); err != nil {
+		return err
+	}
+
+	var modifiedKeys []string
+	for key := range ops.dataWrites {
+		modifiedKeys = append(modifiedKeys, key)
+	}
+	for key := range ops.dataDeletes {
+		modifiedKeys = append(modifiedKeys, key)
+	}
+	sort.Strings(modifiedKeys)
+
+	for _, key := range modifiedKeys {
+		res, err := v.getData(dbName, key)
+		if err != nil {
+			return err
+		}
+		if _, ok := ops.dataDeletes[key]; ok && res.GetValue() == nil && res.GetMetadata() == nil {
+			v.fail(types.Flag_INVALID_INCORRECT_ENTRIES, dbName, key, "the key ["+key+"] does not exist in the database and hence, it cannot be deleted")
+			continue
+		}
+		if reason := evaluateWriteACL(res.GetMetadata().GetAccessControl(), usersWithDBAccess); reason != "" {
+			v.fail(types.Flag_INVALID_NO_PERMISSION, dbName, key, reason)
+		}
+	}
+	return nil
+}
+
+// validateWriteACLDefinitions checks that the users in the ACLs set by the transaction exist
+func (v *dataTxValidator) validateWriteACLDefinitions(dbName string, ops *dbOperations) error {
+	var keys []string
+	for key := range ops.dataWrites {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		acl := ops.dataWrites[key].GetAcl()
+		var aclUsers []string
+		for userID := range acl.GetReadUsers() {
+			aclUsers = append(aclUsers, userID)
+		}
+		for userID := range acl.GetReadWriteUsers() {
+			if !acl.GetReadUsers()[userID] {
+				aclUsers = append(aclUsers, userID)
+			}
+		}
+		sort.Strings(aclUsers)
+
+		for _, userID := range aclUsers {
+			exist, err := v.userExists(userID)
+			if err != nil {
+				return err
+			}
+			if !exist {
+				v.fail(types.Flag_INVALID_INCORRECT_ENTRIES, dbName, key, "the user ["+userID+"] defined in the access control for the key ["+key+"] does not exist")
+			}
+		}
+	}
+	return nil
+}
+
+// validateReads re-reads every key read or asserted by the transaction, to detect stale reads and missing read
+// permissions
+func (v *dataTxValidator) validateReads(dbName string, ops *dbOperations, usersWithDBAccess []string) error {
+	readVersions := make(map[string]*types.Version)
+	for key, res := range ops.dataReads {
+		readVersions[key] = res.GetMetadata().GetVersion()
+	}
+	for key, version := range ops.dataAsserts {
+		readVersions[key] = version
+	}
+
+	var keys []string
+	for key := range readVersions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		res, err := v.getData(dbName, key)
+		if err != nil {
+			return err
+		}
+		if !proto.Equal(readVersions[key], res.GetMetadata().GetVersion()) {
+			v.fail(types.Flag_INVALID_MVCC_CONFLICT_WITH_COMMITTED_STATE, dbName, key,
+				"mvcc conflict has occurred as the committed state for the key ["+key+"] in database ["+dbName+"] changed")
+			continue
+		}
+
+		acl := res.GetMetadata().GetAccessControl()
+		if acl == nil {
+			continue
+		}
+		hasPerm := false
+		for _, userID := range usersWithDBAccess {
+			if acl.ReadUsers[userID] || acl.ReadWriteUsers[userID] {
+				hasPerm = true
+				break
+			}
+		}
+		if !hasPerm {
+			v.fail(types.Flag_INVALID_NO_PERMISSION, dbName, key,
+				"none of the user in ["+strings.Join(usersWithDBAccess, ",")+"] has a read permission on key ["+key+"] present in the database ["+dbName+"]")
+		}
+	}
+	return nil
+}
+
+// evaluateWriteACL evaluates the committed ACL of a key the way the server does, and returns the reason the
+// given users cannot write or delete the key, or an empty string if they can
+func evaluateWriteACL(acl *types.AccessControl, userIDs []string) string {
+	if acl == nil {
+		return ""
+	}
+	if len(acl.ReadWriteUsers) == 0 {
+		return "no user can write or delete the key"
+	}
+
+	signed := make(map[string]bool)
+	for _, userID := range userIDs {
+		signed[userID] = true
+	}
+
+	var writers []string
+	hasAny := false
+	hasAll := true
+	for userID := range acl.ReadWriteUsers {
+		writers = append(writers, userID)
+		if signed[userID] {
+			hasAny = true
+		} else {
+			hasAll = false
+		}
+	}
+	sort.Strings(writers)
+
+	switch acl.SignPolicyForWrite {
+	case types.AccessControl_ANY:
+		if !hasAny {
+			return "none of the user in [" + strings.Join(userIDs, ",") + "] has a write/delete permission on the key"
+		}
+	case types.AccessControl_ALL:
+		if !hasAll {
+			return "not all required users in [" + strings.Join(writers, ",") + "] have signed the transaction to write/delete the key"
+		}
+	}
+	return ""
+}
+
+func (v *dataTxValidator) getData(dbName, key string) (*types.GetDataResponse, error) {
+	cacheKey := dbName + "/" + key
+	if res, ok := v.data[cacheKey]; ok {
+		return res, nil
+	}
+
+	res, err := v.tx.getData(v.ctx, dbName, key)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to read the key [%s] in the database [%s]", key, dbName)
+	}
+	v.data[cacheKey] = res
+	return res, nil
+}
+
+// getUser returns the user record, or nil if the user does not exist
+func (v *dataTxValidator) getUser(userID string) (*types.User, error) {
+	if user, ok := v.users[userID]; ok {
+		return user, nil
+	}
+
+	path := constants.URLForGetUser(userID)
+	resEnv := &types.GetUserResponseEnvelope{}
+	err := v.tx.handleRequestWithContext(
+		v.ctx,
+		path,
+		&types.GetUserQuery{
+			UserId:       v.tx.userID,
+			TargetUserId: userID,
+		}, resEnv,
+	)
+	if err != nil {
+		v.tx.logger.Errorf("failed to execute user query, Path = %s, due to %s", path, err)
+		return nil, errors.WithMessagef(err, "failed to fetch the record of user [%s]", userID)
+	}
+	return resEnv.GetResponse().GetUser(), nil
+}
+
+// userExists returns true if the user exists, even if the session user has no read access to its record, as the
+// server checks the existence of the user before the access
+func (v *dataTxValidator) userExists(userID string) (bool, error) {
+	user, err := v.getUser(userID)
+	if err != nil {
+		if httpError, ok := errors.Cause(err).(*httpError); ok && httpError.statusCode == http.StatusForbidden {
+			return true, nil
+		}
+		return false, err
+	}
+	return user != nil, nil
+}
+
+func (v *dataTxValidator) dbExists(dbName string) (bool, error) {
+	path := constants.URLForGetDBStatus(dbName)
+	resEnv := &types.GetDBStatusResponseEnvelope{}
+	err := v.tx.handleRequestWithContext(
+		v.ctx,
+		path,
+		&types.GetDBStatusQuery{
+			UserId: v.tx.userID,
+			DbName: dbName,
+		}, resEnv,
+	)
+	if err != nil {
+		v.tx.logger.Errorf("failed to execute database status query, path = %s, due to %s", path, err)
+		return false, err
+	}
+	return resEnv.GetResponse().GetExist(), nil
+}
@@ -4,12 +4,14 @@
 package bcdb
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/pem"
 	"io/ioutil"
 	"net/url"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/hyperledger-labs/orion-sdk-go/internal"
 	"github.com/hyperledger-labs/orion-sdk-go/pkg/config"
@@ -29,7 +31,9 @@ type BCDB interface {
 	Session(config *config.SessionConfig) (DBSession, error)
 }
 
-// DBSession captures user's session
+// DBSession captures user's session.
+// A DBSession is safe for concurrent use by multiple goroutines. The transaction contexts, and the provenance,
+// ledger and query handlers it creates are not; each should be used by a single goroutine.
 type DBSession interface {
 	UsersTx() (UsersTxContext, error)
 	DataTx(options ...TxContextOption) (DataTxContext, error)
@@ -43,6 +47,23 @@ type DBSession interface {
 	// also query the cluster for the most recent replica set before returning.
 	// Note that when a DBSession is first created, it queries the cluster for the most recent replica set.
 	ReplicaSet(refresh bool) ([]*config.Replica, error)
+	// RotateCredentials atomically replaces the signer and certificate of the session user, and the client TLS
+	// certificate, with the ones of the given configurations. Transactions and queries created before the rotation
+	// keep the old credentials, new ones use the new credentials. The user ID cannot change, and a nil clientTLS
+	// keeps the current client TLS certificate.
+	RotateCredentials(userConfig *config.UserConfig, clientTLS *config.ClientTLSConfig) error
+	// WatchCredentials polls the credential files of the session every interval, and rotates the credentials when
+	// any of them changes, until the context is done. A rotation that fails, e.g. because the certificate was updated
+	// but the private key was not yet, is retried at the next poll.
+	WatchCredentials(ctx context.Context, interval time.Duration) error
+	// WatchCluster refreshes the replica set of the session in the background, at the interval of the given
+	// configuration, until the context is done. The callbacks of the configuration are invoked when the leader
+	// changes, when nodes are added or removed, and when the cluster config version changes.
+	WatchCluster(ctx context.Context, conf *ClusterWatcherConfig) error
+	// Close stops the background work of the session, i.e., the probes of the replicas with an open circuit, and
+	// closes its idle connections. The session must not be used after it is closed. Closing a session of a
+	// SessionManager has no effect, the background work of its sessions stops when the manager is closed.
+	Close()
 }
 
 var ErrTxSpent = errors.New("transaction committed or aborted")
@@ -53,6 +74,9 @@ type TxContext interface {
 	// Sync option returns tx id and tx receipt envelope and
 	// in case of error, commitTimeout error is one of possible errors to return.
 	// Async returns tx id, always nil as tx receipt or error
+	// Failed submissions are retried. When a submission fails after it may have reached the server, e.g. because the
+	// connection was reset, the receipt of the transaction is looked up before it is resubmitted, and a resubmission
+	// rejected with a duplicate txID waits for the outcome of the earlier one.
 	Commit(sync bool) (string, *types.TxReceiptResponseEnvelope, error)
 	// Abort cancel submission and abandon all changes
 	// within given transaction context
@@ -118,6 +142,29 @@ type Provenance interface {
 	GetWriters(dbName, key string) ([]string, error)
 	// GetTxIDsSubmittedByUser IDs of all tx submitted by user
 	GetTxIDsSubmittedByUser(userID string) ([]string, error)
+	// GetHistoricalDataIterator returns an iterator over the historical values of specific db and key, ordered by
+	// version, filtered by block range, limited and resumed by the given options. The whole history of the key is
+	// loaded from the server when the iterator is created, the limit does not bound the size of the response.
+	GetHistoricalDataIterator(dbName, key string, opts *ProvenanceQueryOptions) (HistoricalDataIterator, error)
+	// GetDataReadByUserIterator returns an iterator over the user reads, ordered by database, key and version,
+	// filtered by block range, database and key prefix, limited and resumed by the given options. All the reads of
+	// the user are loaded from the server when the iterator is created, the limit does not bound the size of the
+	// response.
+	GetDataReadByUserIterator(userID string, opts *ProvenanceQueryOptions) (UserDataIterator, error)
+	// GetDataWrittenByUserIterator returns an iterator over the user writes, ordered by database, key and version,
+	// filtered by block range, database and key prefix, limited and resumed by the given options. All the writes of
+	// the user are loaded from the server when the iterator is created, the limit does not bound the size of the
+	// response.
+	GetDataWrittenByUserIterator(userID string, opts *ProvenanceQueryOptions) (UserDataIterator, error)
+	// GetTxIDsSubmittedByUserIterator returns an iterator over the IDs of the tx submitted by user, ordered by ID,
+	// limited and resumed by the given options. All the IDs are loaded from the server when the iterator is
+	// created, the limit does not bound the size of the response.
+	GetTxIDsSubmittedByUserIterator(userID string, opts *ProvenanceQueryOptions) (TxIDIterator, error)
+	// GetVerifiedHistory returns all historical writes and deletions of specific db and key, each one along with
+	// a state proof verified against the block that produced it, and each block header verified against the
+	// trusted anchor block header. The anchor must be at or after the last block of the history, e.g. the last
+	// block header, as entries committed after the anchor cannot be verified against it
+	GetVerifiedHistory(dbName, key string, anchor *types.BlockHeader) ([]*VerifiedHistoricalValue, error)
 }
 
 type RangeQueryResponse struct {
@@ -175,12 +222,21 @@ type Signer interface {
 }
 
 // Create prepares connection context to work with BCDB instance
-// loads root CA certificates
+// loads root CA certificates. The connection configuration is validated first,
+// and all its problems are reported at once by a *config.ValidationError.
 func Create(connectionConfig *config.ConnectionConfig) (BCDB, error) {
+	if err := connectionConfig.Validate(); err != nil {
+		return nil, err
+	}
+
 	dbLogger := connectionConfig.Logger
 	if dbLogger == nil {
+		level := connectionConfig.LogLevel
+		if level == "" {
+			level = "info"
+		}
 		c := &logger.Config{
-			Level:         "info",
+			Level:         level,
 			OutputPath:    []string{"stdout"},
 			ErrOutputPath: []string{"stderr"},
 			Encoding:      "console",
@@ -220,12 +276,12 @@ func Create(connectionConfig *config.ConnectionConfig) (BCDB, error) {
 		if connectionConfig.TLSConfig.Enabled {
 			if replicaURL.Scheme != "https" {
 				dbLogger.Errorf("configuration error, tls in use, but url is %s", uri.Endpoint)
-				return nil, errors.Wrapf(err, "configuration error, tls in use, but url is %s", uri.Endpoint)
+				return nil, errors.Errorf("configuration error, tls in use, but url is %s", uri.Endpoint)
 			}
 		} else {
 			if replicaURL.Scheme != "http" {
 				dbLogger.Errorf("configuration error, tls disabled, but url is %s", uri.Endpoint)
-				return nil, errors.Wrapf(err, "configuration error, tls disabled, but url is  %s", uri.Endpoint)
+				return nil, errors.Errorf("configuration error, tls disabled, but url is %s", uri.Endpoint)
 			}
 		}
 	}
@@ -233,6 +289,7 @@ func Create(connectionConfig *config.ConnectionConfig) (BCDB, error) {
 	db := &bDB{
 		bootstrapReplicaMap: urls,
 		rootCAs:             rootCACerts,
+		health:              internal.NewReplicaHealth(&connectionConfig.ReplicaHealth),
 		logger:              dbLogger,
 	}
 
@@ -243,6 +300,9 @@ func Create(connectionConfig *config.ConnectionConfig) (BCDB, error) {
 			return nil, err
 		}
 		tlsIntermediateCAs, err := loadCACertificates(connectionConfig.TLSConfig.CaConfig.IntermediateCACertsPath, dbLogger)
+		if err != nil {
+			return nil, err
+		}
 		tlsCACertCollection, err := certificateauthority.NewCACertCollection(tlsRootCAs, tlsIntermediateCAs)
 		if err != nil {
 			dbLogger.Errorf("failed to create CACertCollection, due to %s", err)
@@ -252,9 +312,6 @@ func Create(connectionConfig *config.ConnectionConfig) (BCDB, error) {
 			dbLogger.Errorf("verification of CA certs collection is failed, due to %s", err)
 			return nil, err
 		}
-		if err != nil {
-			return nil, err
-		}
 		db.tlsRootCAs = tlsCACertCollection
 		db.tlsEnabled = true
 		db.tlsClientAuthRequire = connectionConfig.TLSConfig.ClientAuthRequired
@@ -270,90 +327,126 @@ type bDB struct {
 	tlsEnabled           bool
 	tlsRootCAs           *certificateauthority.CACertCollection
 	tlsClientAuthRequire bool
+	health               *internal.ReplicaHealth // shared by all the sessions, nil if health tracking is disabled
 	logger               *logger.SugarLogger
 }
 
-// Session parses the session configuration and opens a user session to the Orion cluster.
+// Session validates the session configuration and opens a user session to the Orion cluster.
 // When a session is created, the cluster is queried for the latest cluster status using the BCDB existing replica set.
 // The returned cluster status is used to update the replica set of the session and the BCDB instance.
 func (b *bDB) Session(cfg *config.SessionConfig) (DBSession, error) {
-	signer, err := crypto.NewSigner(&crypto.SignerOptions{
-		KeyFilePath: cfg.UserConfig.PrivateKeyPath,
-	})
-	if err != nil {
-		b.logger.Errorf("cannot create signer with user's private key, from %s, due to %s",
-			cfg.UserConfig.PrivateKeyPath, err)
-		return nil, errors.Wrap(err, "cannot create signer with user's private key")
+	if err := cfg.Validate(); err != nil {
+		return nil, err
 	}
 
-	certBytes, err := ioutil.ReadFile(cfg.UserConfig.CertPath)
+	clientTlsConfig, err := b.clientTLSConfig(&cfg.ClientTLS)
 	if err != nil {
-		b.logger.Errorf("cannot read user's certificate with user's private key, from %s, due to %s",
-			cfg.UserConfig.CertPath, err)
-		return nil, errors.Wrap(err, "cannot read user's certificate with user's private key")
+		return nil, err
 	}
-
-	session := &dbSession{
-		userID:       cfg.UserConfig.UserID,
-		signer:       signer,
-		userCert:     certBytes,
-		rootCAs:      b.rootCAs,
-		tlsEnabled:   b.tlsEnabled,
-		tlsRootCAs:   b.tlsRootCAs,
-		txTimeout:    cfg.TxTimeout,
-		queryTimeout: cfg.QueryTimeout,
-		logger:       b.logger,
+	session, err := b.newSession(cfg, clientTlsConfig)
+	if err != nil {
+		return nil, err
 	}
-
-	for id, url := range b.bootstrapReplicaMap {
-		session.replicaSet = append(session.replicaSet, &internal.ReplicaWithRole{
-			Id:   id,
-			URL:  url,
-			Role: internal.ReplicaRole_UNKNOWN,
-		})
+	session.replicaSetView = &replicaSetView{
+		replicaSet: b.bootstrapReplicaSet(),
+		health:     b.health,
 	}
+	session.stop = make(chan struct{})
+	session.replicaSetView.stop = session.stop
 
-	if b.tlsEnabled {
-		clientTlsConfig := &tls.Config{
-			RootCAs:    b.tlsRootCAs.GetCertPool(),
-			ClientCAs:  b.tlsRootCAs.GetCertPool(),
-			MinVersion: tls.VersionTLS12,
-		}
-		if b.tlsClientAuthRequire {
-			clientKeyBytes, err := os.ReadFile(cfg.ClientTLS.ClientKeyPath)
-			if err != nil {
-				b.logger.Errorf("cannot read user's tls certificate, from %s, due to %s",
-					cfg.ClientTLS.ClientKeyPath, err)
-				return nil, errors.Wrap(err, "cannot read user's tls certificate")
-			}
-			clientCertBytes, err := os.ReadFile(cfg.ClientTLS.ClientCertificatePath)
-			if err != nil {
-				b.logger.Errorf("cannot read user's tls private key, from %s, due to %s",
-					cfg.ClientTLS.ClientCertificatePath, err)
-				return nil, errors.Wrap(err, "cannot read user's tls private key")
-			}
-			clientKeyPair, err := tls.X509KeyPair(clientCertBytes, clientKeyBytes)
-			if err != nil {
-				b.logger.Error("cannot create x509 key pair", err)
-				return nil, errors.Wrap(err, "cannot create x509 key pair")
-			}
-			clientTlsConfig.Certificates = []tls.Certificate{clientKeyPair}
-			session.clientAuthRequired = true
-		}
-		session.clientTlsConfig = clientTlsConfig
-	}
-	httpClient := newHTTPClient(session.tlsEnabled, session.clientTlsConfig, nil)
+	httpClient := newInterceptingHttpClient(session.httpClient(nil), session.interceptors)
 	err = session.updateReplicaSetAndVerifier(httpClient, session.tlsEnabled)
 	if err != nil {
 		b.logger.Errorf("cannot update the replica set and signature verifier, error: %s", err)
 		return nil, errors.Wrap(err, "cannot update the replica set and signature verifier")
 	}
 
-	b.updateReplicaMap(session.replicaSet.ToReplicaMap())
+	replicaSet, _ := session.currentReplicaSet()
+	b.updateReplicaMap(replicaSet.ToReplicaMap())
 
 	return session, nil
 }
 
+// newSession creates a session of the user with the given client TLS configuration, without a replica set
+func (b *bDB) newSession(cfg *config.SessionConfig, clientTlsConfig *tls.Config) (*dbSession, error) {
+	signer, err := loadUserSigner(cfg.UserConfig, b.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	certBytes, err := loadUserCertificate(cfg.UserConfig, b.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics, err := newSessionMetrics(cfg.MetricsRegisterer)
+	if err != nil {
+		b.logger.Errorf("cannot create the session metrics, due to %s", err)
+		return nil, err
+	}
+
+	userConfig := *cfg.UserConfig
+	session := &dbSession{
+		userID:             cfg.UserConfig.UserID,
+		signer:             signer,
+		userCert:           certBytes,
+		userConfig:         &userConfig,
+		clientTLS:          cfg.ClientTLS,
+		rootCAs:            b.rootCAs,
+		tlsEnabled:         b.tlsEnabled,
+		tlsRootCAs:         b.tlsRootCAs,
+		clientAuthRequired: b.tlsEnabled && b.tlsClientAuthRequire,
+		clientTlsConfig:    clientTlsConfig,
+		transport:          newHTTPTransport(b.tlsEnabled, clientTlsConfig),
+		txTimeout:          cfg.TxTimeout,
+		queryTimeout:       cfg.QueryTimeout,
+		logger:             b.logger,
+		metrics:            metrics,
+		interceptors:       append([]config.RequestInterceptor(nil), cfg.Interceptors...),
+		wrapTransport:      cfg.WrapTransport,
+	}
+	session.readCache = newReadCache(cfg.ReadCache, session)
+	return session, nil
+}
+
+// clientTLSConfig returns the TLS configuration to connect to the cluster, with the client TLS key pair if the
+// cluster requires client TLS authentication, or nil if TLS is disabled
+func (b *bDB) clientTLSConfig(clientTLS *config.ClientTLSConfig) (*tls.Config, error) {
+	if !b.tlsEnabled {
+		return nil, nil
+	}
+
+	clientTlsConfig := &tls.Config{
+		RootCAs:    b.tlsRootCAs.GetCertPool(),
+		ClientCAs:  b.tlsRootCAs.GetCertPool(),
+		MinVersion: tls.VersionTLS12,
+	}
+	if b.tlsClientAuthRequire {
+		clientKeyPair, err := loadClientKeyPair(clientTLS, b.logger)
+		if err != nil {
+			return nil, err
+		}
+		clientTlsConfig.Certificates = []tls.Certificate{clientKeyPair}
+	}
+	return clientTlsConfig, nil
+}
+
+// bootstrapReplicaSet returns the replica set of the BCDB instance, with unknown roles
+func (b *bDB) bootstrapReplicaSet() internal.ReplicaSet {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var replicaSet internal.ReplicaSet
+	for id, url := range b.bootstrapReplicaMap {
+		replicaSet = append(replicaSet, &internal.ReplicaWithRole{
+			Id:   id,
+			URL:  url,
+			Role: internal.ReplicaRole_UNKNOWN,
+		})
+	}
+	return replicaSet
+}
+
 func (b *bDB) updateReplicaMap(replicaMap map[string]*url.URL) {
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
@@ -370,7 +463,65 @@ func loadCACertificates(certPaths []string, dbLogger *logger.SugarLogger) ([][]b
 			return nil, errors.Wrap(err, "failed to read root CA certificate")
 		}
 		asn1Data, _ := pem.Decode(rootCABytes)
+		if asn1Data == nil {
+			dbLogger.Errorf("failed to decode root CA certificate %s, no PEM data found", rootCAPath)
+			return nil, errors.Errorf("failed to decode root CA certificate %s, no PEM data found", rootCAPath)
+		}
 		rootCAs = append(rootCAs, asn1Data.Bytes)
 	}
 	return rootCAs, nil
 }
+
+// loadUserSigner returns the signer set in the user configuration, or creates a
+// signer over the private key file, which is decrypted if it is encrypted
+func loadUserSigner(userConfig *config.UserConfig, logger *logger.SugarLogger) (Signer, error) {
+	if userConfig.Signer != nil {
+		return userConfig.Signer, nil
+	}
+
+	signer, err := NewEncryptedFileSigner(userConfig.UserID, userConfig.PrivateKeyPath, userConfig.PrivateKeyPassphrase)
+	if err != nil {
+		logger.Errorf("cannot create signer with user's private key, from %s, due to %s",
+			userConfig.PrivateKeyPath, err)
+		return nil, errors.WithMessage(err, "cannot create signer with user's private key")
+	}
+	return signer, nil
+}
+
+// loadUserCertificate returns the PEM encoded certificate set in the user
+// configuration, or reads it from the certificate file
+func loadUserCertificate(userConfig *config.UserConfig, logger *logger.SugarLogger) ([]byte, error) {
+	if len(userConfig.Cert) > 0 {
+		return userConfig.Cert, nil
+	}
+
+	certBytes, err := ioutil.ReadFile(userConfig.CertPath)
+	if err != nil {
+		logger.Errorf("cannot read user's certificate with user's private key, from %s, due to %s",
+			userConfig.CertPath, err)
+		return nil, errors.Wrap(err, "cannot read user's certificate with user's private key")
+	}
+	return certBytes, nil
+}
+
+// loadClientKeyPair reads the client TLS certificate and private key files
+func loadClientKeyPair(clientTLS *config.ClientTLSConfig, logger *logger.SugarLogger) (tls.Certificate, error) {
+	clientKeyBytes, err := os.ReadFile(clientTLS.ClientKeyPath)
+	if err != nil {
+		logger.Errorf("cannot read user's tls certificate, from %s, due to %s",
+			clientTLS.ClientKeyPath, err)
+		return tls.Certificate{}, errors.Wrap(err, "cannot read user's tls certificate")
+	}
+	clientCertBytes, err := os.ReadFile(clientTLS.ClientCertificatePath)
+	if err != nil {
+		logger.Errorf("cannot read user's tls private key, from %s, due to %s",
+			clientTLS.ClientCertificatePath, err)
+		return tls.Certificate{}, errors.Wrap(err, "cannot read user's tls private key")
+	}
+	clientKeyPair, err := tls.X509KeyPair(clientCertBytes, clientKeyBytes)
+	if err != nil {
+		logger.Error("cannot create x509 key pair", err)
+		return tls.Certificate{}, errors.Wrap(err, "cannot create x509 key pair")
+	}
+	return clientKeyPair, nil
+}
@@ -4,13 +4,34 @@
 package bcdb
 
 import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
 	"path"
+	"strings"
 	"testing"
+	"time"
 
 	sdkconfig "github.com/hyperledger-labs/orion-sdk-go/pkg/config"
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/signagent"
+	"github.com/hyperledger-labs/orion-server/pkg/crypto"
 	"github.com/hyperledger-labs/orion-server/pkg/server/testutils"
+	"github.com/hyperledger-labs/orion-server/pkg/types"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/pbkdf2"
 )
 
 func TestCreate(t *testing.T) {
@@ -36,7 +57,7 @@ func TestCreate(t *testing.T) {
 			Logger: createTestLogger(t),
 		})
 
-		require.EqualError(t, err, fmt.Sprintf("failed to read root CA certificate: open %s: no such file or directory", wrongCAPath))
+		require.EqualError(t, err, fmt.Sprintf("invalid connection configuration: root CA certificate [%s] cannot be read: open %s: no such file or directory", wrongCAPath, wrongCAPath))
 		require.Nil(t, bcdb)
 	})
 
@@ -54,7 +75,7 @@ func TestCreate(t *testing.T) {
 		})
 
 		require.Error(t, err)
-		require.Contains(t, err.Error(), "failed to create CACertCollection: certificate is missing the CA property, SN:")
+		require.EqualError(t, err, fmt.Sprintf("invalid connection configuration: root CA certificate [%s] is not a CA certificate", wrongCAFile))
 		require.Nil(t, bcdb)
 	})
 
@@ -78,4 +99,295 @@ func TestCreate(t *testing.T) {
 		require.Nil(t, bcdb)
 	})
 
+	t.Run("log level", func(t *testing.T) {
+		conf := &sdkconfig.ConnectionConfig{
+			RootCAs: []string{path.Join(clientCertTemDir, testutils.RootCAFileName+".pem")},
+			ReplicaSet: []*sdkconfig.Replica{
+				{
+					ID:       "testNode1",
+					Endpoint: fmt.Sprintf("http://127.0.0.1:%s", serverPort),
+				},
+			},
+			LogLevel: "debug",
+		}
+		bcdb, err := Create(conf)
+		require.NoError(t, err)
+		require.True(t, bcdb.(*bDB).logger.IsDebug())
+
+		conf.LogLevel = "verbose"
+		bcdb, err = Create(conf)
+		require.Error(t, err)
+		require.Nil(t, bcdb)
+	})
+}
+
+func TestSession_SignerSources(t *testing.T) {
+	clientCertTemDir := testutils.GenerateTestCrypto(t, []string{"admin", "alice", "server"})
+	testServer, _, _, err := SetupTestServer(t, clientCertTemDir)
+	defer testServer.Stop()
+	require.NoError(t, err)
+	bcdb, _, _ := startServerConnectOpenAdminCreateUserAndUserSession(t, testServer, clientCertTemDir, "alice")
+
+	certPEM, err := ioutil.ReadFile(path.Join(clientCertTemDir, "alice.pem"))
+	require.NoError(t, err)
+	keyPEM, err := ioutil.ReadFile(path.Join(clientCertTemDir, "alice.key"))
+	require.NoError(t, err)
+
+	commitWith := func(t *testing.T, userConfig *sdkconfig.UserConfig) {
+		session, err := bcdb.Session(&sdkconfig.SessionConfig{
+			UserConfig: userConfig,
+			TxTimeout:  time.Second * 20,
+		})
+		require.NoError(t, err)
+		tx, err := session.DataTx()
+		require.NoError(t, err)
+		require.NoError(t, tx.Put("bdb", "key", []byte("value"), nil))
+		_, receiptEnv, err := tx.Commit(true)
+		require.NoError(t, err)
+		require.Equal(t, types.Flag_VALID, receiptEnv.GetResponse().GetReceipt().GetHeader().GetValidationInfo()[receiptEnv.GetResponse().GetReceipt().GetTxIndex()].GetFlag())
+	}
+
+	t.Run("PEM bytes", func(t *testing.T) {
+		signer, err := NewPEMSigner("alice", keyPEM)
+		require.NoError(t, err)
+		require.Equal(t, "alice", signer.Identity())
+		commitWith(t, &sdkconfig.UserConfig{
+			UserID: "alice",
+			Cert:   certPEM,
+			Signer: signer,
+		})
+	})
+
+	t.Run("in-memory key", func(t *testing.T) {
+		block, _ := pem.Decode(keyPEM)
+		require.NotNil(t, block)
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		}
+		require.NoError(t, err)
+		signer, err := NewKeySigner("alice", key.(*ecdsa.PrivateKey))
+		require.NoError(t, err)
+
+		certBlock, _ := pem.Decode(certPEM)
+		cert, err := x509.ParseCertificate(certBlock.Bytes)
+		require.NoError(t, err)
+
+		commitWith(t, &sdkconfig.UserConfig{
+			UserID: "alice",
+			Cert:   CertFromX509(cert),
+			Signer: signer,
+		})
+	})
+
+	t.Run("environment variables", func(t *testing.T) {
+		t.Setenv("ALICE_KEY", strings.ReplaceAll(string(keyPEM), "\n", `\n`))
+		t.Setenv("ALICE_CERT", string(certPEM))
+		signer, err := NewEnvSigner("alice", "ALICE_KEY")
+		require.NoError(t, err)
+		cert, err := CertFromEnv("ALICE_CERT")
+		require.NoError(t, err)
+		commitWith(t, &sdkconfig.UserConfig{
+			UserID: "alice",
+			Cert:   cert,
+			Signer: signer,
+		})
+
+		_, err = NewEnvSigner("alice", "ALICE_MISSING_KEY")
+		require.EqualError(t, err, "environment variable ALICE_MISSING_KEY is not set")
+	})
+
+	t.Run("mixed file and signer", func(t *testing.T) {
+		signer, err := NewFileSigner("alice", path.Join(clientCertTemDir, "alice.key"))
+		require.NoError(t, err)
+		commitWith(t, &sdkconfig.UserConfig{
+			UserID:   "alice",
+			CertPath: path.Join(clientCertTemDir, "alice.pem"),
+			Signer:   signer,
+		})
+	})
+
+	t.Run("signing agent", func(t *testing.T) {
+		keySigner, err := NewPEMSigner("alice", keyPEM)
+		require.NoError(t, err)
+		agent, err := signagent.NewAgent(&signagent.AgentConfig{
+			Signers: []crypto.Signer{keySigner},
+			Logger:  createTestLogger(t),
+		})
+		require.NoError(t, err)
+		socketPath := path.Join(t.TempDir(), "agent.sock")
+		listener, err := net.Listen("unix", socketPath)
+		require.NoError(t, err)
+		go agent.Serve(listener)
+		defer agent.Close()
+
+		client, err := signagent.Dial(socketPath)
+		require.NoError(t, err)
+		defer client.Close()
+		signer, err := client.Signer("alice")
+		require.NoError(t, err)
+
+		commitWith(t, &sdkconfig.UserConfig{
+			UserID: "alice",
+			Cert:   certPEM,
+			Signer: signer,
+		})
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		_, err := NewPEMSigner("alice", certPEM)
+		require.EqualError(t, err, "cannot load user's private key: failed to find private key block in pem file")
+	})
+}
+
+func TestSession_EncryptedPrivateKey(t *testing.T) {
+	clientCertTemDir := testutils.GenerateTestCrypto(t, []string{"admin", "alice", "server"})
+	testServer, _, _, err := SetupTestServer(t, clientCertTemDir)
+	defer testServer.Stop()
+	require.NoError(t, err)
+	bcdb, _, _ := startServerConnectOpenAdminCreateUserAndUserSession(t, testServer, clientCertTemDir, "alice")
+
+	keyPEM, err := ioutil.ReadFile(path.Join(clientCertTemDir, "alice.key"))
+	require.NoError(t, err)
+	keyBlock, _ := pem.Decode(keyPEM)
+	require.NotNil(t, keyBlock)
+	key, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		key, err = x509.ParseECPrivateKey(keyBlock.Bytes)
+	}
+	require.NoError(t, err)
+	pkcs8Key, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	pkcs8KeyPath := path.Join(clientCertTemDir, "alice-pkcs8.key")
+	require.NoError(t, ioutil.WriteFile(pkcs8KeyPath, encryptPKCS8(t, pkcs8Key, []byte("secret")), 0600))
+	legacyBlock, err := x509.EncryptPEMBlock(rand.Reader, keyBlock.Type, keyBlock.Bytes, []byte("secret"), x509.PEMCipherAES256)
+	require.NoError(t, err)
+	legacyKeyPath := path.Join(clientCertTemDir, "alice-legacy.key")
+	require.NoError(t, ioutil.WriteFile(legacyKeyPath, pem.EncodeToMemory(legacyBlock), 0600))
+
+	openSession := func(keyPath string, passphrase sdkconfig.PassphraseFunc) (DBSession, error) {
+		return bcdb.Session(&sdkconfig.SessionConfig{
+			UserConfig: &sdkconfig.UserConfig{
+				UserID:               "alice",
+				CertPath:             path.Join(clientCertTemDir, "alice.pem"),
+				PrivateKeyPath:       keyPath,
+				PrivateKeyPassphrase: passphrase,
+			},
+			TxTimeout: time.Second * 20,
+		})
+	}
+	secret := func() ([]byte, error) {
+		return []byte("secret"), nil
+	}
+
+	for _, keyPath := range []string{pkcs8KeyPath, legacyKeyPath} {
+		session, err := openSession(keyPath, secret)
+		require.NoError(t, err)
+		tx, err := session.DataTx()
+		require.NoError(t, err)
+		require.NoError(t, tx.Put("bdb", "key", []byte("value"), nil))
+		_, receiptEnv, err := tx.Commit(true)
+		require.NoError(t, err)
+		require.Equal(t, types.Flag_VALID, receiptEnv.GetResponse().GetReceipt().GetHeader().GetValidationInfo()[receiptEnv.GetResponse().GetReceipt().GetTxIndex()].GetFlag())
+	}
+
+	t.Run("passphrase from env", func(t *testing.T) {
+		t.Setenv("ALICE_KEY_PASSPHRASE", "secret")
+		_, err := openSession(pkcs8KeyPath, PassphraseFromEnv("ALICE_KEY_PASSPHRASE"))
+		require.NoError(t, err)
+
+		_, err = openSession(pkcs8KeyPath, PassphraseFromEnv("ALICE_MISSING_PASSPHRASE"))
+		require.EqualError(t, err, "cannot create signer with user's private key: cannot load user's private key: "+
+			"failed to obtain the private key passphrase: environment variable ALICE_MISSING_PASSPHRASE is not set")
+	})
+
+	t.Run("wrong passphrase", func(t *testing.T) {
+		wrong := func() ([]byte, error) {
+			return []byte("wrong"), nil
+		}
+		_, err := openSession(pkcs8KeyPath, wrong)
+		require.EqualError(t, err, "cannot create signer with user's private key: cannot load user's private key: "+
+			"failed to decrypt the private key: incorrect passphrase")
+		_, err = openSession(legacyKeyPath, wrong)
+		require.EqualError(t, err, "cannot create signer with user's private key: cannot load user's private key: "+
+			"failed to decrypt the private key: x509: decryption password incorrect")
+	})
+
+	t.Run("no passphrase", func(t *testing.T) {
+		_, err := openSession(pkcs8KeyPath, nil)
+		require.EqualError(t, err, "cannot create signer with user's private key: cannot load user's private key: "+
+			"private key is encrypted, but no passphrase is provided")
+	})
+
+	t.Run("unencrypted key ignores passphrase", func(t *testing.T) {
+		_, err := openSession(path.Join(clientCertTemDir, "alice.key"), func() ([]byte, error) {
+			return nil, errors.New("must not be called")
+		})
+		require.NoError(t, err)
+	})
+}
+
+// encryptPKCS8 encrypts a PKCS#8 private key the way `openssl pkcs8 -topk8` does by default, with PBES2, PBKDF2
+// with HMAC-SHA256 and AES-256-CBC
+func encryptPKCS8(t *testing.T, der, pass []byte) []byte {
+	salt := make([]byte, 8)
+	iv := make([]byte, aes.BlockSize)
+	_, err := rand.Read(salt)
+	require.NoError(t, err)
+	_, err = rand.Read(iv)
+	require.NoError(t, err)
+
+	block, err := aes.NewCipher(pbkdf2.Key(pass, salt, 2048, 32, sha256.New))
+	require.NoError(t, err)
+	padLen := aes.BlockSize - len(der)%aes.BlockSize
+	data := append(append([]byte{}, der...), bytes.Repeat([]byte{byte(padLen)}, padLen)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+
+	marshal := func(v interface{}) asn1.RawValue {
+		b, err := asn1.Marshal(v)
+		require.NoError(t, err)
+		return asn1.RawValue{FullBytes: b}
+	}
+	keyInfo, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		EncryptionAlgorithm: pkix.AlgorithmIdentifier{
+			Algorithm: oidPBES2,
+			Parameters: marshal(pbes2Params{
+				KeyDerivationFunc: pkix.AlgorithmIdentifier{
+					Algorithm: oidPBKDF2,
+					Parameters: marshal(pbkdf2Params{
+						Salt:           salt,
+						IterationCount: 2048,
+						PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+					}),
+				},
+				EncryptionScheme: pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: marshal(iv)},
+			}),
+		},
+		EncryptedData: data,
+	})
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: keyInfo})
+}
+
+func TestReadPassphrase(t *testing.T) {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	defer r.Close()
+
+	_, err = w.WriteString("secret\r\nnext input")
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	pass, err := readPassphrase(r)
+	require.NoError(t, err)
+	require.Equal(t, []byte("secret"), pass)
+
+	// the input that follows the passphrase is left unread
+	rest, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "next input", string(rest))
+
+	_, err = readPassphrase(r)
+	require.Equal(t, io.EOF, err)
 }
@@ -95,27 +95,34 @@ func (d *dbsTxContext) GetDBIndex(dbName string) (map[string]types.IndexAttribut
 		return nil, ErrTxSpent
 	}
 
-	path := constants.URLForGetDBIndex(dbName)
-	resEnv := &types.GetDBIndexResponseEnvelope{}
-	err := d.handleRequest(
-		path,
-		&types.GetDBIndexQuery{
-			UserId: d.userID,
-			DbName: dbName,
-		},
-		resEnv,
-	)
-	if err != nil {
-		d.logger.Errorf("failed to execute database index query, path = %s, due to %s", path, err)
-		return nil, err
+	cacheKey := readCacheKey{kind: readCacheDBIndex, dbName: dbName}
+	cached, invalidations := d.readCache.get(cacheKey)
+	res, isCached := cached.(*types.GetDBIndexResponse)
+	if !isCached {
+		path := constants.URLForGetDBIndex(dbName)
+		resEnv := &types.GetDBIndexResponseEnvelope{}
+		err := d.handleRequest(
+			path,
+			&types.GetDBIndexQuery{
+				UserId: d.userID,
+				DbName: dbName,
+			},
+			resEnv,
+		)
+		if err != nil {
+			d.logger.Errorf("failed to execute database index query, path = %s, due to %s", path, err)
+			return nil, err
+		}
+		res = resEnv.GetResponse()
+		d.readCache.put(cacheKey, res, invalidations)
 	}
 
-	if resEnv.GetResponse().GetIndex() == "" {
+	if res.GetIndex() == "" {
 		return nil, nil
 	}
 
 	index := map[string]types.IndexAttributeType{}
-	if err = json.Unmarshal([]byte(resEnv.GetResponse().GetIndex()), &index); err != nil {
+	if err := json.Unmarshal([]byte(res.GetIndex()), &index); err != nil {
 		return nil, err
 	}
 
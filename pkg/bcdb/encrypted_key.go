@@ -0,0 +1,208 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+package bcdb
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"hash"
+
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/config"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+var (
+	oidPBES2  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA224 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 8}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+)
+
+// encryptedPrivateKeyInfo is the PKCS#8 encrypted private key, RFC 5958
+type encryptedPrivateKeyInfo struct {
+	EncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedData       []byte
+}
+
+// pbes2Params are the parameters of the PBES2 encryption scheme, RFC 8018
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// pbkdf2Params are the parameters of the PBKDF2 key derivation function, RFC 8018
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// isEncryptedPrivateKey returns true if the first private key in the PEM data is encrypted
+func isEncryptedPrivateKey(keyPEM []byte) bool {
+	block := config.PrivateKeyBlock(keyPEM)
+	return block != nil && (block.Type == "ENCRYPTED PRIVATE KEY" || x509.IsEncryptedPEMBlock(block))
+}
+
+// decryptPrivateKey decrypts the first private key in the PEM data, either an encrypted PKCS#8 key or a legacy
+// encrypted PEM key, and returns it PEM encoded. The passphrase is requested only when the key is encrypted; an
+// unencrypted key is returned as is.
+func decryptPrivateKey(keyPEM []byte, passphrase config.PassphraseFunc) ([]byte, error) {
+	if !isEncryptedPrivateKey(keyPEM) {
+		return keyPEM, nil
+	}
+	if passphrase == nil {
+		return nil, errors.New("private key is encrypted, but no passphrase is provided")
+	}
+	pass, err := passphrase()
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to obtain the private key passphrase")
+	}
+
+	block := config.PrivateKeyBlock(keyPEM)
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		der, err := decryptPKCS8(block.Bytes, pass)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	}
+
+	// Legacy encrypted PEM keys, RFC 1423, are insecure by design: the key is derived from the passphrase with a
+	// single MD5 round, and the ciphertext has no integrity check, hence a wrong passphrase is not always detected
+	// and a tampered key file may decrypt to garbage. They are still produced by common tools, e.g.
+	// `openssl ec -aes256`, and are accepted for compatibility only; PKCS#8 keys should be preferred.
+	der, err := x509.DecryptPEMBlock(block, pass)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt the private key")
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der}), nil
+}
+
+// decryptPKCS8 decrypts a PKCS#8 private key encrypted with PBES2, PBKDF2 and a CBC cipher, which is the
+// encryption OpenSSL uses by default, and returns the DER encoded PKCS#8 private key
+func decryptPKCS8(der, pass []byte) ([]byte, error) {
+	keyInfo := &encryptedPrivateKeyInfo{}
+	if _, err := asn1.Unmarshal(der, keyInfo); err != nil {
+		return nil, errors.Wrap(err, "failed to parse the encrypted private key")
+	}
+	if !keyInfo.EncryptionAlgorithm.Algorithm.Equal(oidPBES2) {
+		return nil, errors.Errorf("unsupported private key encryption algorithm %s, only PBES2 is supported", keyInfo.EncryptionAlgorithm.Algorithm)
+	}
+
+	params := &pbes2Params{}
+	if _, err := asn1.Unmarshal(keyInfo.EncryptionAlgorithm.Parameters.FullBytes, params); err != nil {
+		return nil, errors.Wrap(err, "failed to parse the PBES2 parameters")
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, errors.Errorf("unsupported key derivation function %s, only PBKDF2 is supported", params.KeyDerivationFunc.Algorithm)
+	}
+	kdfParams := &pbkdf2Params{}
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, kdfParams); err != nil {
+		return nil, errors.Wrap(err, "failed to parse the PBKDF2 parameters")
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, errors.Wrap(err, "failed to parse the encryption scheme IV")
+	}
+	newCipher, keyLen, err := cbcCipher(params.EncryptionScheme.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if kdfParams.KeyLength != 0 && kdfParams.KeyLength != keyLen {
+		return nil, errors.Errorf("key length %d does not match the encryption scheme %s", kdfParams.KeyLength, params.EncryptionScheme.Algorithm)
+	}
+
+	key, err := deriveKey(pass, kdfParams, keyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := newCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	data := keyInfo.EncryptedData
+	if len(iv) != block.BlockSize() || len(data) == 0 || len(data)%block.BlockSize() != 0 {
+		return nil, errors.New("failed to decrypt the private key: malformed encrypted data")
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+
+	padLen := int(plain[len(plain)-1])
+	if padLen == 0 || padLen > block.BlockSize() {
+		return nil, errors.New("failed to decrypt the private key: incorrect passphrase")
+	}
+	for _, b := range plain[len(plain)-padLen:] {
+		if int(b) != padLen {
+			return nil, errors.New("failed to decrypt the private key: incorrect passphrase")
+		}
+	}
+	plain = plain[:len(plain)-padLen]
+
+	if _, err := x509.ParsePKCS8PrivateKey(plain); err != nil {
+		return nil, errors.New("failed to decrypt the private key: incorrect passphrase")
+	}
+	return plain, nil
+}
+
+func pbkdf2PRF(oid asn1.ObjectIdentifier) (func() hash.Hash, error) {
+	switch {
+	case len(oid) == 0, oid.Equal(oidHMACWithSHA1):
+		return sha1.New, nil
+	case oid.Equal(oidHMACWithSHA224):
+		return sha256.New224, nil
+	case oid.Equal(oidHMACWithSHA256):
+		return sha256.New, nil
+	case oid.Equal(oidHMACWithSHA384):
+		return sha512.New384, nil
+	case oid.Equal(oidHMACWithSHA512):
+		return sha512.New, nil
+	default:
+		return nil, errors.Errorf("unsupported PBKDF2 pseudorandom function %s", oid)
+	}
+}
+
+func cbcCipher(oid asn1.ObjectIdentifier) (func([]byte) (cipher.Block, error), int, error) {
+	switch {
+	case oid.Equal(oidAES128CBC):
+		return aes.NewCipher, 16, nil
+	case oid.Equal(oidAES192CBC):
+		return aes.NewCipher, 24, nil
+	case oid.Equal(oidAES256CBC):
+		return aes.NewCipher, 32, nil
+	case oid.Equal(oidDESEDE3CBC):
+		return des.NewTripleDESCipher, 24, nil
+	default:
+		return nil, 0, errors.Errorf("unsupported encryption scheme %s", oid)
+	}
+}
+
+// deriveKey derives a key of the given length from the passphrase with PBKDF2, RFC 8018, section 5.2
+func deriveKey(pass []byte, params *pbkdf2Params, keyLen int) ([]byte, error) {
+	prf, err := pbkdf2PRF(params.PRF.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if params.IterationCount < 1 {
+		return nil, errors.Errorf("invalid PBKDF2 iteration count %d", params.IterationCount)
+	}
+	return pbkdf2.Key(pass, params.Salt, params.IterationCount, keyLen, prf), nil
+}
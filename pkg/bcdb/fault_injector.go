@@ -0,0 +1,371 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+package bcdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/config"
+	"github.com/hyperledger-labs/orion-server/pkg/types"
+	"github.com/pkg/errors"
+)
+
+// FaultKind is a fault a FaultInjector injects into the exchanges with the cluster
+type FaultKind int
+
+const (
+	// FaultLatency delays the request by the latency of the rule, then sends it
+	FaultLatency FaultKind = iota
+	// FaultRefuseConnection fails the request without sending it, as if the replica refused the connection
+	FaultRefuseConnection
+	// FaultDropConnection sends the request, and fails it as if the connection was reset before the response was
+	// received, so that the caller cannot tell whether the replica processed it
+	FaultDropConnection
+	// FaultServiceUnavailable answers the request with 503 Service Unavailable without sending it, as a replica does
+	// when the cluster has no leader
+	FaultServiceUnavailable
+	// FaultAccepted sends the request, and answers it with 202 Accepted, as a replica does when a synchronous commit
+	// times out
+	FaultAccepted
+	// FaultCorruptBody sends the request, and truncates the body of the response
+	FaultCorruptBody
+	// FaultTamperSignature sends the request, and alters the signature of the response
+	FaultTamperSignature
+	// FaultRedirect answers the request with 307 Temporary Redirect to the redirect endpoint of the rule without
+	// sending it, as a replica does when it redirects a transaction to the leader. Only the RoundTripper of the
+	// injector injects it, so that the HTTP client of the session follows the redirect as it follows the ones of a
+	// replica.
+	FaultRedirect
+)
+
+func (k FaultKind) String() string {
+	switch k {
+	case FaultLatency:
+		return "latency"
+	case FaultRefuseConnection:
+		return "refuse-connection"
+	case FaultDropConnection:
+		return "drop-connection"
+	case FaultServiceUnavailable:
+		return "service-unavailable"
+	case FaultAccepted:
+		return "accepted"
+	case FaultCorruptBody:
+		return "corrupt-body"
+	case FaultTamperSignature:
+		return "tamper-signature"
+	case FaultRedirect:
+		return "redirect"
+	default:
+		return fmt.Sprintf("FaultKind(%d)", int(k))
+	}
+}
+
+// FaultRule tells which requests a FaultInjector injects a fault into, and which fault
+type FaultRule struct {
+	// Kind is the fault to inject
+	Kind FaultKind
+	// Replica, if not empty, scopes the rule to the requests sent to the replica with this ID, or with this host and
+	// port in the endpoint, e.g. `127.0.0.1:6001`
+	Replica string
+	// Endpoint, if not empty, scopes the rule to the requests whose path starts with it, e.g. `/data/tx`
+	Endpoint string
+	// Method, if not empty, scopes the rule to the requests with this HTTP method
+	Method string
+	// Probability is the probability to inject the fault into a request in scope, 1 if zero
+	Probability float64
+	// Times is the maximal number of requests the fault is injected into, unlimited if zero
+	Times int
+	// Latency is the delay of FaultLatency
+	Latency time.Duration
+	// RedirectTo is the endpoint of the replica FaultRedirect redirects the requests to, e.g. `http://127.0.0.1:6002`
+	RedirectTo string
+}
+
+func (r *FaultRule) matches(replica, method string, u *url.URL) bool {
+	if r.Replica != "" && r.Replica != replica && r.Replica != u.Host {
+		return false
+	}
+	// the redirected request keeps the replica of the original one, it must not be redirected again
+	if r.Kind == FaultRedirect {
+		if target, err := url.Parse(r.RedirectTo); err == nil && target.Host == u.Host {
+			return false
+		}
+	}
+	if r.Endpoint != "" && !strings.HasPrefix(u.Path, r.Endpoint) {
+		return false
+	}
+	return r.Method == "" || r.Method == method
+}
+
+// FaultInjector injects faults into the exchanges of a session with the cluster, according to its rules, to test
+// how an application, and the retries and verifications of the SDK, behave under partial failures. The first rule,
+// in the order they were added, that is in scope of a request and draws its probability, is applied to it.
+//
+// The draws are pseudo-random, from a fixed seed, so that a test that sends the same sequence of requests gets the
+// same faults.
+type FaultInjector struct {
+	lock     sync.Mutex
+	rules    []*FaultRule
+	injected map[*FaultRule]int
+	random   *rand.Rand
+}
+
+// NewFaultInjector creates a fault injector with the given rules
+func NewFaultInjector(rules ...*FaultRule) *FaultInjector {
+	return &FaultInjector{
+		rules:    rules,
+		injected: make(map[*FaultRule]int),
+		random:   rand.New(rand.NewSource(1)),
+	}
+}
+
+// AddRule adds a rule, applied after the rules already added
+func (f *FaultInjector) AddRule(rule *FaultRule) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.rules = append(f.rules, rule)
+}
+
+// ClearRules removes all the rules, so that the requests are sent unaltered
+func (f *FaultInjector) ClearRules() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.rules = nil
+}
+
+// Injected returns the number of requests the fault of the given rule was injected into
+func (f *FaultInjector) Injected(rule *FaultRule) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.injected[rule]
+}
+
+// HttpClient returns an HttpClient that injects the faults into the requests it sends with the given client
+func (f *FaultInjector) HttpClient(httpClient HttpClient) HttpClient {
+	return &faultyHttpClient{
+		httpClient: httpClient,
+		injector:   f,
+	}
+}
+
+// Interceptor returns an interceptor that injects the faults, but FaultRedirect, into the requests of a session, to
+// be added to the interceptors of its configuration
+func (f *FaultInjector) Interceptor() config.RequestInterceptor {
+	return func(info *config.RequestInfo, req *http.Request, invoker config.RequestInvoker) (*http.Response, error) {
+		return f.inject(req.Context(), info.Replica, req.Method, req.URL.String(), func() (*http.Response, error) {
+			return invoker(req)
+		})
+	}
+}
+
+// RoundTripper returns an http.RoundTripper that injects FaultRedirect into the requests it sends with the given
+// transport, to wrap the transport of a session, see config.SessionConfig.WrapTransport
+func (f *FaultInjector) RoundTripper(transport http.RoundTripper) http.RoundTripper {
+	return &faultyRoundTripper{
+		transport: transport,
+		injector:  f,
+	}
+}
+
+// inject sends the request with the given function, unless the fault of the rule it applies prevents it, and
+// injects the fault into the exchange
+func (f *FaultInjector) inject(ctx context.Context, replica, method, endpoint string, send func() (*http.Response, error)) (*http.Response, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	rule := f.applicableRule(replica, method, u, false)
+	if rule == nil {
+		return send()
+	}
+
+	switch rule.Kind {
+	case FaultLatency:
+		select {
+		case <-time.After(rule.Latency):
+		case <-ctx.Done():
+			return nil, &url.Error{Op: urlErrorOp(method), URL: endpoint, Err: ctx.Err()}
+		}
+		return send()
+	case FaultRefuseConnection:
+		return nil, &url.Error{
+			Op:  urlErrorOp(method),
+			URL: endpoint,
+			Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED},
+		}
+	case FaultDropConnection:
+		resp, err := send()
+		if err != nil {
+			return nil, err
+		}
+		discardBody(resp)
+		return nil, &url.Error{
+			Op:  urlErrorOp(method),
+			URL: endpoint,
+			Err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET},
+		}
+	case FaultServiceUnavailable:
+		return errorResponse(http.StatusServiceUnavailable, "Cluster leader unavailable"), nil
+	case FaultAccepted:
+		resp, err := send()
+		if err != nil {
+			return nil, err
+		}
+		discardBody(resp)
+		return errorResponse(http.StatusAccepted, "Transaction processing timeout"), nil
+	case FaultCorruptBody:
+		resp, err := send()
+		return alterBody(resp, err, func(body []byte) []byte {
+			return body[:len(body)/2]
+		})
+	case FaultTamperSignature:
+		resp, err := send()
+		return alterBody(resp, err, tamperSignature)
+	default:
+		return nil, errors.Errorf("unknown fault [%s]", rule.Kind)
+	}
+}
+
+// applicableRule returns the rule to apply to the request, among the FaultRedirect rules if redirect is true, and
+// among the other rules otherwise
+func (f *FaultInjector) applicableRule(replica, method string, u *url.URL, redirect bool) *FaultRule {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for _, rule := range f.rules {
+		if (rule.Kind == FaultRedirect) != redirect || !rule.matches(replica, method, u) {
+			continue
+		}
+		if rule.Times > 0 && f.injected[rule] >= rule.Times {
+			continue
+		}
+		if rule.Probability > 0 && f.random.Float64() >= rule.Probability {
+			continue
+		}
+		f.injected[rule]++
+		return rule
+	}
+	return nil
+}
+
+type faultyHttpClient struct {
+	httpClient HttpClient
+	injector   *FaultInjector
+}
+
+func (c *faultyHttpClient) Do(req *http.Request) (*http.Response, error) {
+	return c.injector.Interceptor()(requestInfo(req.Context()), req, c.httpClient.Do)
+}
+
+type faultyRoundTripper struct {
+	transport http.RoundTripper
+	injector  *FaultInjector
+}
+
+func (t *faultyRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	rule := t.injector.applicableRule(requestInfo(req.Context()).Replica, req.Method, req.URL, true)
+	if rule == nil {
+		return t.transport.RoundTrip(req)
+	}
+
+	// a round tripper closes the body of the request, even if it does not send it
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	target, err := url.Parse(rule.RedirectTo)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error while parsing the redirect endpoint [%s]", rule.RedirectTo)
+	}
+	location := target.ResolveReference(&url.URL{Path: req.URL.Path, RawQuery: req.URL.RawQuery})
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", http.StatusTemporaryRedirect, http.StatusText(http.StatusTemporaryRedirect)),
+		StatusCode: http.StatusTemporaryRedirect,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Location": []string{location.String()}},
+		Body:       http.NoBody,
+		Request:    req,
+	}, nil
+}
+
+// urlErrorOp returns the operation an http client reports in the errors of a request with the given method
+func urlErrorOp(method string) string {
+	return method[:1] + strings.ToLower(method[1:])
+}
+
+func errorResponse(statusCode int, errMsg string) *http.Response {
+	body, _ := json.Marshal(&types.HttpResponseErr{ErrMsg: errMsg})
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+}
+
+func discardBody(resp *http.Response) {
+	if resp != nil && resp.Body != nil {
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+}
+
+// alterBody replaces the body of the response, if the request succeeded, by the given function of it
+func alterBody(resp *http.Response, err error, alter func([]byte) []byte) (*http.Response, error) {
+	if err != nil || resp == nil || resp.Body == nil {
+		return resp, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	body = alter(body)
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Del("Content-Length")
+	return resp, nil
+}
+
+// tamperSignature flips the last byte of the signature of a response envelope, and returns the body unaltered if it
+// has no signature
+func tamperSignature(body []byte) []byte {
+	envelope := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return body
+	}
+	var encoded string
+	if err := json.Unmarshal(envelope["signature"], &encoded); err != nil {
+		return body
+	}
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(signature) == 0 {
+		return body
+	}
+	signature[len(signature)-1] ^= 0xff
+	envelope["signature"], _ = json.Marshal(base64.StdEncoding.EncodeToString(signature))
+	tampered, err := json.Marshal(envelope)
+	if err != nil {
+		return body
+	}
+	return tampered
+}
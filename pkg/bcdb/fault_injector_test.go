@@ -0,0 +1,330 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package bcdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sdkconfig "github.com/hyperledger-labs/orion-sdk-go/pkg/config"
+	"github.com/hyperledger-labs/orion-server/pkg/constants"
+	"github.com/hyperledger-labs/orion-server/pkg/server/testutils"
+	"github.com/hyperledger-labs/orion-server/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaultInjector_HttpClient(t *testing.T) {
+	var sent []string
+	base := httpClientFunc(func(req *http.Request) (*http.Response, error) {
+		body := ""
+		if req.Body != nil {
+			content, err := ioutil.ReadAll(req.Body)
+			require.NoError(t, err)
+			body = string(content)
+		}
+		sent = append(sent, req.Method+" "+req.URL.String()+" "+body)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       ioutil.NopCloser(strings.NewReader(`{"response":{"value":"dmFsdWUx"},"signature":"c2lnbmF0dXJl"}`)),
+		}, nil
+	})
+	readBody := func(resp *http.Response) string {
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+	do := func(client HttpClient, ctx context.Context, method, endpoint string) (*http.Response, error) {
+		body := ""
+		if method == http.MethodPost {
+			body = "tx"
+		}
+		req, err := http.NewRequestWithContext(ctx, method, endpoint, strings.NewReader(body))
+		require.NoError(t, err)
+		return client.Do(req)
+	}
+	node1Ctx := withRequestInfo(context.Background(), &sdkconfig.RequestInfo{Operation: operationGet, Replica: "node1", Attempt: 1})
+
+	t.Run("scope", func(t *testing.T) {
+		sent = nil
+		byReplica := &FaultRule{Kind: FaultServiceUnavailable, Replica: "node1"}
+		byHost := &FaultRule{Kind: FaultServiceUnavailable, Replica: "node2:6001"}
+		byEndpoint := &FaultRule{Kind: FaultServiceUnavailable, Endpoint: constants.PostDataTx, Method: http.MethodPost}
+		client := NewFaultInjector(byReplica, byHost, byEndpoint).HttpClient(base)
+
+		for _, request := range []struct {
+			ctx      context.Context
+			method   string
+			endpoint string
+			status   int
+		}{
+			{ctx: node1Ctx, method: http.MethodGet, endpoint: "http://node1:6001/data/bdb/key1", status: http.StatusServiceUnavailable},
+			{ctx: context.Background(), method: http.MethodGet, endpoint: "http://node1:6001/data/bdb/key1", status: http.StatusOK},
+			{ctx: context.Background(), method: http.MethodGet, endpoint: "http://node2:6001/data/bdb/key1", status: http.StatusServiceUnavailable},
+			{ctx: context.Background(), method: http.MethodPost, endpoint: "http://node3:6001/data/tx", status: http.StatusServiceUnavailable},
+			{ctx: context.Background(), method: http.MethodGet, endpoint: "http://node3:6001/data/tx", status: http.StatusOK},
+			{ctx: context.Background(), method: http.MethodPost, endpoint: "http://node3:6001/user/tx", status: http.StatusOK},
+		} {
+			resp, err := do(client, request.ctx, request.method, request.endpoint)
+			require.NoError(t, err)
+			require.Equal(t, request.status, resp.StatusCode, "%s %s", request.method, request.endpoint)
+		}
+		require.Equal(t, []string{
+			"GET http://node1:6001/data/bdb/key1 ",
+			"GET http://node3:6001/data/tx ",
+			"POST http://node3:6001/user/tx tx",
+		}, sent)
+	})
+
+	t.Run("times and probability", func(t *testing.T) {
+		twice := &FaultRule{Kind: FaultServiceUnavailable, Times: 2}
+		injector := NewFaultInjector(twice)
+		client := injector.HttpClient(base)
+		var statuses []int
+		for i := 0; i < 3; i++ {
+			resp, err := do(client, context.Background(), http.MethodGet, "http://node1:6001/data/bdb/key1")
+			require.NoError(t, err)
+			statuses = append(statuses, resp.StatusCode)
+		}
+		require.Equal(t, []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK}, statuses)
+		require.Equal(t, 2, injector.Injected(twice))
+
+		half := &FaultRule{Kind: FaultServiceUnavailable, Probability: 0.5}
+		injector.AddRule(half)
+		for i := 0; i < 1000; i++ {
+			_, err := do(client, context.Background(), http.MethodGet, "http://node1:6001/data/bdb/key1")
+			require.NoError(t, err)
+		}
+		require.InDelta(t, 500, injector.Injected(half), 100)
+
+		injector.ClearRules()
+		resp, err := do(client, context.Background(), http.MethodGet, "http://node1:6001/data/bdb/key1")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("latency", func(t *testing.T) {
+		client := NewFaultInjector(&FaultRule{Kind: FaultLatency, Latency: 200 * time.Millisecond}).HttpClient(base)
+		start := time.Now()
+		resp, err := do(client, context.Background(), http.MethodGet, "http://node1:6001/data/bdb/key1")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err = do(client, ctx, http.MethodGet, "http://node1:6001/data/bdb/key1")
+		require.Error(t, err)
+		require.True(t, isAmbiguousSubmitError(err))
+		require.True(t, err.(*url.Error).Timeout())
+	})
+
+	t.Run("connection faults", func(t *testing.T) {
+		sent = nil
+		client := NewFaultInjector(&FaultRule{Kind: FaultRefuseConnection}).HttpClient(base)
+		_, err := do(client, context.Background(), http.MethodPost, "http://node1:6001/data/tx")
+		require.EqualError(t, err, `Post "http://node1:6001/data/tx": dial tcp: connection refused`)
+		require.Empty(t, sent)
+
+		client = NewFaultInjector(&FaultRule{Kind: FaultDropConnection}).HttpClient(base)
+		_, err = do(client, context.Background(), http.MethodPost, "http://node1:6001/data/tx")
+		require.EqualError(t, err, `Post "http://node1:6001/data/tx": read tcp: connection reset by peer`)
+		require.True(t, isAmbiguousSubmitError(err))
+		require.Len(t, sent, 1)
+	})
+
+	t.Run("status faults", func(t *testing.T) {
+		sent = nil
+		client := NewFaultInjector(&FaultRule{Kind: FaultServiceUnavailable}).HttpClient(base)
+		resp, err := do(client, context.Background(), http.MethodPost, "http://node1:6001/data/tx")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		require.Equal(t, `{"error":"Cluster leader unavailable"}`, readBody(resp))
+		require.Empty(t, sent)
+
+		client = NewFaultInjector(&FaultRule{Kind: FaultAccepted}).HttpClient(base)
+		resp, err = do(client, context.Background(), http.MethodPost, "http://node1:6001/data/tx")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusAccepted, resp.StatusCode)
+		require.Equal(t, `{"error":"Transaction processing timeout"}`, readBody(resp))
+		require.Len(t, sent, 1)
+	})
+
+	t.Run("body faults", func(t *testing.T) {
+		client := NewFaultInjector(&FaultRule{Kind: FaultCorruptBody}).HttpClient(base)
+		resp, err := do(client, context.Background(), http.MethodGet, "http://node1:6001/data/bdb/key1")
+		require.NoError(t, err)
+		require.Equal(t, `{"response":{"value":"dmFsdWUx`, readBody(resp))
+
+		client = NewFaultInjector(&FaultRule{Kind: FaultTamperSignature}).HttpClient(base)
+		resp, err = do(client, context.Background(), http.MethodGet, "http://node1:6001/data/bdb/key1")
+		require.NoError(t, err)
+		envelope := make(map[string]json.RawMessage)
+		require.NoError(t, json.Unmarshal([]byte(readBody(resp)), &envelope))
+		require.JSONEq(t, `{"value":"dmFsdWUx"}`, string(envelope["response"]))
+		require.NotEqual(t, `"c2lnbmF0dXJl"`, string(envelope["signature"]))
+	})
+
+	t.Run("redirect", func(t *testing.T) {
+		sent = nil
+		redirect := &FaultRule{Kind: FaultRedirect, RedirectTo: "http://node2:6002"}
+		injector := NewFaultInjector(redirect)
+
+		// the redirect is left to the round tripper
+		_, err := do(injector.HttpClient(base), node1Ctx, http.MethodPost, "http://node1:6001/data/tx")
+		require.NoError(t, err)
+		require.Equal(t, []string{"POST http://node1:6001/data/tx tx"}, sent)
+		require.Equal(t, 0, injector.Injected(redirect))
+
+		sent = nil
+		var redirected []string
+		client := &http.Client{
+			Transport: injector.RoundTripper(base),
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				redirected = append(redirected, via[len(via)-1].URL.String()+" -> "+req.URL.String())
+				return nil
+			},
+		}
+		resp, err := do(client, node1Ctx, http.MethodPost, "http://node1:6001/data/tx?a=b")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, []string{"http://node1:6001/data/tx?a=b -> http://node2:6002/data/tx?a=b"}, redirected)
+		require.Equal(t, []string{"POST http://node2:6002/data/tx?a=b tx"}, sent)
+		require.Equal(t, 1, injector.Injected(redirect))
+	})
+}
+
+func TestFaultInjector_Session(t *testing.T) {
+	clientCertTemDir := testutils.GenerateTestCrypto(t, []string{"admin", "server"})
+	testServer, _, _, err := SetupTestServer(t, clientCertTemDir)
+	require.NoError(t, err)
+	StartTestServer(t, testServer)
+	defer testServer.Stop()
+
+	serverPort, err := testServer.Port()
+	require.NoError(t, err)
+
+	injector := NewFaultInjector()
+	var clusterStatusQueries atomic.Int32
+	counter := func(info *sdkconfig.RequestInfo, req *http.Request, invoker sdkconfig.RequestInvoker) (*http.Response, error) {
+		if strings.HasPrefix(req.URL.Path, constants.GetClusterStatus) {
+			clusterStatusQueries.Add(1)
+		}
+		return invoker(req)
+	}
+	session, err := createDBInstance(t, clientCertTemDir, serverPort).Session(&sdkconfig.SessionConfig{
+		UserConfig: &sdkconfig.UserConfig{
+			UserID:         "admin",
+			CertPath:       path.Join(clientCertTemDir, "admin.pem"),
+			PrivateKeyPath: path.Join(clientCertTemDir, "admin.key"),
+		},
+		TxTimeout:     20 * time.Second,
+		Interceptors:  []sdkconfig.RequestInterceptor{counter, injector.Interceptor()},
+		WrapTransport: injector.RoundTripper,
+	})
+	require.NoError(t, err)
+
+	commit := func(key string) (*types.TxReceiptResponseEnvelope, error) {
+		tx, err := session.DataTx()
+		require.NoError(t, err)
+		require.NoError(t, tx.Put("bdb", key, []byte("value-"+key), nil))
+		_, receiptEnv, err := tx.Commit(true)
+		return receiptEnv, err
+	}
+	get := func(key string) ([]byte, error) {
+		tx, err := session.DataTx()
+		require.NoError(t, err)
+		defer tx.Abort()
+		val, _, err := tx.Get("bdb", key)
+		return val, err
+	}
+
+	t.Run("unavailable leader", func(t *testing.T) {
+		unavailable := &FaultRule{Kind: FaultServiceUnavailable, Endpoint: constants.PostDataTx, Times: 2}
+		injector.AddRule(unavailable)
+		defer injector.ClearRules()
+
+		receiptEnv, err := commit("key1")
+		require.NoError(t, err)
+		require.NotNil(t, receiptEnv.GetResponse().GetReceipt())
+		require.Equal(t, 2, injector.Injected(unavailable))
+	})
+
+	t.Run("dropped connection", func(t *testing.T) {
+		dropped := &FaultRule{Kind: FaultDropConnection, Endpoint: constants.PostDataTx, Times: 1}
+		injector.AddRule(dropped)
+		defer injector.ClearRules()
+
+		// the transaction reached the server, the session finds its receipt
+		receiptEnv, err := commit("key2")
+		require.NoError(t, err)
+		require.NotNil(t, receiptEnv.GetResponse().GetReceipt())
+		require.Equal(t, 1, injector.Injected(dropped))
+	})
+
+	t.Run("commit timeout", func(t *testing.T) {
+		injector.AddRule(&FaultRule{Kind: FaultAccepted, Endpoint: constants.PostDataTx, Times: 1})
+		defer injector.ClearRules()
+
+		_, err := commit("key3")
+		require.Error(t, err)
+		require.IsType(t, &ServerTimeout{}, err)
+	})
+
+	t.Run("tampered and corrupted responses", func(t *testing.T) {
+		injector.AddRule(&FaultRule{Kind: FaultTamperSignature, Endpoint: "/data/bdb", Method: http.MethodGet, Times: 1})
+		injector.AddRule(&FaultRule{Kind: FaultCorruptBody, Endpoint: "/data/bdb", Method: http.MethodGet, Times: 1})
+		defer injector.ClearRules()
+
+		_, err := get("key1")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "signature")
+
+		_, err = get("key1")
+		require.Error(t, err)
+
+		val, err := get("key1")
+		require.NoError(t, err)
+		require.Equal(t, []byte("value-key1"), val)
+	})
+
+	t.Run("redirect to the leader", func(t *testing.T) {
+		// the replica is reached through another host name, so that the redirect is not to itself
+		redirect := &FaultRule{Kind: FaultRedirect, Endpoint: constants.PostDataTx, RedirectTo: fmt.Sprintf("http://localhost:%s", serverPort), Times: 1}
+		injector.AddRule(redirect)
+		defer injector.ClearRules()
+
+		receiptEnv, err := commit("key4")
+		require.NoError(t, err)
+		require.NotNil(t, receiptEnv.GetResponse().GetReceipt())
+		require.Equal(t, 1, injector.Injected(redirect))
+		require.True(t, session.(*dbSession).updateReplicaSetFlag.Load())
+
+		// the next transaction refreshes the replica set, as the session was redirected
+		queries := clusterStatusQueries.Load()
+		tx, err := session.DataTx()
+		require.NoError(t, err)
+		require.NoError(t, tx.Abort())
+		require.Equal(t, queries+1, clusterStatusQueries.Load())
+		require.False(t, session.(*dbSession).updateReplicaSetFlag.Load())
+	})
+
+	t.Run("redirect to an unreachable replica", func(t *testing.T) {
+		injector.AddRule(&FaultRule{Kind: FaultRedirect, Endpoint: "/data/bdb", RedirectTo: "http://127.0.0.1:1"})
+		defer injector.ClearRules()
+
+		_, err := get("key1")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "connection refused")
+	})
+}
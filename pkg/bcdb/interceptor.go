@@ -0,0 +1,59 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+package bcdb
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/config"
+)
+
+const operationClusterStatus = "cluster_status"
+
+type requestInfoKey struct{}
+
+// withRequestInfo returns a context that carries the description of the request sent with it, for the interceptors
+func withRequestInfo(ctx context.Context, info *config.RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// requestInfo returns the description of the request sent with the context, or that of a first attempt if it has none
+func requestInfo(ctx context.Context) *config.RequestInfo {
+	if info, ok := ctx.Value(requestInfoKey{}).(*config.RequestInfo); ok {
+		return info
+	}
+	return &config.RequestInfo{Attempt: 1}
+}
+
+// interceptingHttpClient runs the interceptors of the session around every request it sends
+type interceptingHttpClient struct {
+	httpClient   HttpClient
+	interceptors []config.RequestInterceptor
+}
+
+// newInterceptingHttpClient returns an HttpClient that runs the interceptors around the requests of the given
+// client, or the given client if there are no interceptors
+func newInterceptingHttpClient(httpClient HttpClient, interceptors []config.RequestInterceptor) HttpClient {
+	if len(interceptors) == 0 {
+		return httpClient
+	}
+	return &interceptingHttpClient{
+		httpClient:   httpClient,
+		interceptors: interceptors,
+	}
+}
+
+func (c *interceptingHttpClient) Do(req *http.Request) (*http.Response, error) {
+	info := requestInfo(req.Context())
+	invoker := c.httpClient.Do
+	for i := len(c.interceptors) - 1; i >= 0; i-- {
+		interceptor, next := c.interceptors[i], invoker
+		invoker = func(req *http.Request) (*http.Response, error) {
+			// every interceptor gets its own copy, so that one cannot change what the others see
+			infoCopy := *info
+			return interceptor(&infoCopy, req, next)
+		}
+	}
+	return invoker(req)
+}
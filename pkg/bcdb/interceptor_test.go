@@ -0,0 +1,175 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package bcdb
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	sdkconfig "github.com/hyperledger-labs/orion-sdk-go/pkg/config"
+	"github.com/hyperledger-labs/orion-server/pkg/server/testutils"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+type httpClientFunc func(req *http.Request) (*http.Response, error)
+
+func (f httpClientFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func (f httpClientFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestInterceptingHttpClient(t *testing.T) {
+	var calls []string
+	base := httpClientFunc(func(req *http.Request) (*http.Response, error) {
+		calls = append(calls, "base:"+req.Header.Get("X-Request-Id"))
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	})
+	recording := func(name string) sdkconfig.RequestInterceptor {
+		return func(info *sdkconfig.RequestInfo, req *http.Request, invoker sdkconfig.RequestInvoker) (*http.Response, error) {
+			calls = append(calls, name+":"+info.Operation)
+			info.Operation = "changed"
+			return invoker(req)
+		}
+	}
+	requestID := func(info *sdkconfig.RequestInfo, req *http.Request, invoker sdkconfig.RequestInvoker) (*http.Response, error) {
+		req.Header.Set("X-Request-Id", info.TxID+"-"+info.Replica)
+		return invoker(req)
+	}
+
+	t.Run("no interceptors", func(t *testing.T) {
+		_, intercepting := newInterceptingHttpClient(base, nil).(*interceptingHttpClient)
+		require.False(t, intercepting)
+	})
+
+	t.Run("chain", func(t *testing.T) {
+		calls = nil
+		client := newInterceptingHttpClient(base, []sdkconfig.RequestInterceptor{recording("first"), recording("second"), requestID})
+
+		ctx := withRequestInfo(context.Background(), &sdkconfig.RequestInfo{
+			Operation: operationCommitSync,
+			TxID:      "tx1",
+			UserID:    "alice",
+			Replica:   "node1",
+			Attempt:   2,
+		})
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost:8888", nil)
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, []string{"first:commit_sync", "second:commit_sync", "base:tx1-node1"}, calls)
+	})
+
+	t.Run("request without info", func(t *testing.T) {
+		var got *sdkconfig.RequestInfo
+		client := newInterceptingHttpClient(base, []sdkconfig.RequestInterceptor{
+			func(info *sdkconfig.RequestInfo, req *http.Request, invoker sdkconfig.RequestInvoker) (*http.Response, error) {
+				got = info
+				return invoker(req)
+			},
+		})
+		req, err := http.NewRequest(http.MethodGet, "http://localhost:8888", nil)
+		require.NoError(t, err)
+		_, err = client.Do(req)
+		require.NoError(t, err)
+		require.Equal(t, &sdkconfig.RequestInfo{Attempt: 1}, got)
+	})
+
+	t.Run("interceptor fails the request", func(t *testing.T) {
+		calls = nil
+		client := newInterceptingHttpClient(base, []sdkconfig.RequestInterceptor{
+			func(info *sdkconfig.RequestInfo, req *http.Request, invoker sdkconfig.RequestInvoker) (*http.Response, error) {
+				return nil, errors.New("request denied")
+			},
+			recording("second"),
+		})
+		req, err := http.NewRequest(http.MethodGet, "http://localhost:8888", nil)
+		require.NoError(t, err)
+		_, err = client.Do(req)
+		require.EqualError(t, err, "request denied")
+		require.Empty(t, calls)
+	})
+}
+
+func TestSessionInterceptors(t *testing.T) {
+	clientCertTemDir := testutils.GenerateTestCrypto(t, []string{"admin", "server"})
+	testServer, _, _, err := SetupTestServer(t, clientCertTemDir)
+	defer testServer.Stop()
+	require.NoError(t, err)
+	StartTestServer(t, testServer)
+
+	serverPort, err := testServer.Port()
+	require.NoError(t, err)
+	bcdb := createDBInstance(t, clientCertTemDir, serverPort)
+
+	var lock sync.Mutex
+	var infos []sdkconfig.RequestInfo
+	var statusCodes []int
+	recorder := func(info *sdkconfig.RequestInfo, req *http.Request, invoker sdkconfig.RequestInvoker) (*http.Response, error) {
+		req.Header.Set("X-Request-Id", info.Operation+"-"+info.TxID)
+		resp, err := invoker(req)
+		lock.Lock()
+		defer lock.Unlock()
+		infos = append(infos, *info)
+		if err == nil {
+			statusCodes = append(statusCodes, resp.StatusCode)
+		}
+		return resp, err
+	}
+	denyLedger := func(info *sdkconfig.RequestInfo, req *http.Request, invoker sdkconfig.RequestInvoker) (*http.Response, error) {
+		if info.Operation == operationLedger {
+			return nil, errors.New("ledger requests are denied")
+		}
+		return invoker(req)
+	}
+
+	session, err := bcdb.Session(&sdkconfig.SessionConfig{
+		UserConfig: &sdkconfig.UserConfig{
+			UserID:         "admin",
+			CertPath:       path.Join(clientCertTemDir, "admin.pem"),
+			PrivateKeyPath: path.Join(clientCertTemDir, "admin.key"),
+		},
+		TxTimeout:    20 * time.Second,
+		Interceptors: []sdkconfig.RequestInterceptor{recorder, denyLedger},
+	})
+	require.NoError(t, err)
+
+	lock.Lock()
+	require.Equal(t, []sdkconfig.RequestInfo{
+		{Operation: operationClusterStatus, UserID: "admin", Replica: "testNode1", Attempt: 1},
+	}, infos)
+	infos, statusCodes = nil, nil
+	lock.Unlock()
+
+	_, txID, _ := putKeySync(t, "bdb", "key1", "value1", "admin", session)
+
+	tx, err := session.DataTx()
+	require.NoError(t, err)
+	val, _, err := tx.Get("bdb", "key1")
+	require.NoError(t, err)
+	require.Equal(t, []byte("value1"), val)
+	require.NoError(t, tx.Abort())
+
+	ledger, err := session.Ledger()
+	require.NoError(t, err)
+	_, err = ledger.GetLastBlockHeader()
+	require.EqualError(t, err, "ledger requests are denied")
+
+	lock.Lock()
+	defer lock.Unlock()
+	require.Equal(t, []sdkconfig.RequestInfo{
+		{Operation: operationCommitSync, TxID: txID, UserID: "admin", Replica: "testNode1", Attempt: 1},
+		{Operation: operationGet, UserID: "admin", Replica: "testNode1", Attempt: 1},
+		{Operation: operationLedger, UserID: "admin", Replica: "testNode1", Attempt: 1},
+	}, infos)
+	require.Equal(t, []int{http.StatusOK, http.StatusOK}, statusCodes)
+}
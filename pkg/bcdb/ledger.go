@@ -184,8 +184,13 @@ func (l *ledger) GetDataProof(blockNum uint64, dbName, key string, isDeleted boo
 		}, resEnv,
 	)
 	if err != nil {
-		l.logger.Errorf("failed to execute state proof query %s, due to %s", path, err)
-		return nil, err
+		httpError, ok := err.(*httpError)
+		if !ok || httpError.statusCode != http.StatusNotFound {
+			l.logger.Errorf("failed to execute state proof query %s, due to %s", path, err)
+			return nil, err
+		} else {
+			return nil, &ErrorNotFound{err.Error()}
+		}
 	}
 
 	return state.NewProof(resEnv.GetResponse().GetPath()), nil
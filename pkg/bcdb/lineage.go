@@ -0,0 +1,350 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+package bcdb
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/hyperledger-labs/orion-server/pkg/types"
+	"github.com/pkg/errors"
+)
+
+// LineageNodeType is the type of node in the lineage graph, following the W3C PROV core types.
+type LineageNodeType string
+
+const (
+	// LineageNodeValue is a version of a key, i.e., a PROV entity
+	LineageNodeValue LineageNodeType = "value"
+	// LineageNodeTx is a transaction, i.e., a PROV activity
+	LineageNodeTx LineageNodeType = "tx"
+	// LineageNodeUser is a user who signed a transaction, i.e., a PROV agent
+	LineageNodeUser LineageNodeType = "user"
+)
+
+// LineageEdgeType is the type of relation between two nodes in the lineage graph, following the W3C PROV relations.
+type LineageEdgeType string
+
+const (
+	// LineageEdgeGeneratedBy connects a value to the transaction that wrote it
+	LineageEdgeGeneratedBy LineageEdgeType = "wasGeneratedBy"
+	// LineageEdgeInvalidatedBy connects a value to the transaction that deleted it
+	LineageEdgeInvalidatedBy LineageEdgeType = "wasInvalidatedBy"
+	// LineageEdgeUsed connects a transaction to a value it read
+	LineageEdgeUsed LineageEdgeType = "used"
+	// LineageEdgeAssociatedWith connects a transaction to a user who signed it
+	LineageEdgeAssociatedWith LineageEdgeType = "wasAssociatedWith"
+	// LineageEdgeRevisionOf connects a value to the previous value of the same key
+	LineageEdgeRevisionOf LineageEdgeType = "wasRevisionOf"
+)
+
+// LineageNode is a node in the lineage graph
+type LineageNode struct {
+	ID         string            `json:"id"`
+	Type       LineageNodeType   `json:"type"`
+	Label      string            `json:"label"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// LineageEdge is a directed edge in the lineage graph
+type LineageEdge struct {
+	From string          `json:"from"`
+	To   string          `json:"to"`
+	Type LineageEdgeType `json:"type"`
+}
+
+// LineageGraph explains how a value came to be: the transactions that wrote it and the values these
+// transactions read, recursively, together with the users who signed these transactions.
+type LineageGraph struct {
+	// Root is the ID of the value node the lineage was built from
+	Root  string         `json:"root"`
+	Nodes []*LineageNode `json:"nodes"`
+	Edges []*LineageEdge `json:"edges"`
+
+	nodes map[string]*LineageNode
+	edges map[LineageEdge]bool
+}
+
+// Node returns the node with the given ID, or nil if it does not exist
+func (g *LineageGraph) Node(id string) *LineageNode {
+	if g.nodes == nil {
+		// the graph was not built by the SDK, e.g. it was unmarshalled from JSON, hence it has no index yet
+		g.nodes = make(map[string]*LineageNode, len(g.Nodes))
+		for _, n := range g.Nodes {
+			g.nodes[n.ID] = n
+		}
+	}
+	return g.nodes[id]
+}
+
+func (g *LineageGraph) addNode(id string, nodeType LineageNodeType, label string) *LineageNode {
+	if n, ok := g.nodes[id]; ok {
+		return n
+	}
+	n := &LineageNode{
+		ID:         id,
+		Type:       nodeType,
+		Label:      label,
+		Attributes: map[string]string{},
+	}
+	g.nodes[id] = n
+	g.Nodes = append(g.Nodes, n)
+	return n
+}
+
+func (g *LineageGraph) addEdge(from, to string, edgeType LineageEdgeType) {
+	e := LineageEdge{From: from, To: to, Type: edgeType}
+	if g.edges[e] {
+		return
+	}
+	g.edges[e] = true
+	g.Edges = append(g.Edges, &e)
+}
+
+// LineageBuilder builds the lineage graph of a value by walking backwards through the
+// historical data of keys and the content of the transactions that wrote them.
+//
+// Historical data can be queried only by admins, while the content of a data transaction can be fetched only by
+// the users who signed it, hence the provenance and the ledger may be obtained from sessions of different users.
+// Parts of the graph that cannot be accessed are marked with an "access" attribute set to "denied".
+type LineageBuilder struct {
+	provenance Provenance
+	ledger     Ledger
+	maxDepth   int
+}
+
+// NewLineageBuilder creates a lineage builder which walks up to maxDepth transactions backwards
+// from the value the lineage is built for.
+func NewLineageBuilder(provenance Provenance, ledger Ledger, maxDepth int) (*LineageBuilder, error) {
+	if provenance == nil || ledger == nil {
+		return nil, errors.New("provenance and ledger must be provided")
+	}
+	if maxDepth < 1 {
+		return nil, errors.Errorf("max depth must be greater than 0, got %d", maxDepth)
+	}
+
+	return &LineageBuilder{
+		provenance: provenance,
+		ledger:     ledger,
+		maxDepth:   maxDepth,
+	}, nil
+}
+
+type lineageValue struct {
+	dbName  string
+	key     string
+	version *types.Version
+	depth   int
+}
+
+type lineageWalk struct {
+	*LineageBuilder
+	graph    *LineageGraph
+	queue    []*lineageValue
+	expanded map[string]bool
+	txs      map[string]string
+	history  map[string][]*types.ValueWithMetadata
+}
+
+// Build returns the lineage graph of the given version of the key
+func (b *LineageBuilder) Build(dbName, key string, version *types.Version) (*LineageGraph, error) {
+	if version == nil {
+		return nil, errors.New("version must be provided")
+	}
+
+	w := &lineageWalk{
+		LineageBuilder: b,
+		graph: &LineageGraph{
+			nodes: map[string]*LineageNode{},
+			edges: map[LineageEdge]bool{},
+		},
+		expanded: map[string]bool{},
+		txs:      map[string]string{},
+		history:  map[string][]*types.ValueWithMetadata{},
+	}
+	w.graph.Root = w.addValue(dbName, key, version, 0)
+
+	// breadth first, so that every value is expanded at its minimal depth
+	for len(w.queue) > 0 {
+		v := w.queue[0]
+		w.queue = w.queue[1:]
+		if err := w.expandValue(v); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(w.graph.Nodes, func(i, j int) bool { return w.graph.Nodes[i].ID < w.graph.Nodes[j].ID })
+	sort.SliceStable(w.graph.Edges, func(i, j int) bool {
+		if w.graph.Edges[i].From != w.graph.Edges[j].From {
+			return w.graph.Edges[i].From < w.graph.Edges[j].From
+		}
+		if w.graph.Edges[i].To != w.graph.Edges[j].To {
+			return w.graph.Edges[i].To < w.graph.Edges[j].To
+		}
+		return w.graph.Edges[i].Type < w.graph.Edges[j].Type
+	})
+
+	return w.graph, nil
+}
+
+func lineageValueID(dbName, key string, version *types.Version) string {
+	if version == nil {
+		return fmt.Sprintf("value:%s/%s@none", dbName, key)
+	}
+	return fmt.Sprintf("value:%s/%s@%d:%d", dbName, key, version.GetBlockNum(), version.GetTxNum())
+}
+
+// addValue adds a value node and schedules its expansion
+func (w *lineageWalk) addValue(dbName, key string, version *types.Version, depth int) string {
+	id := lineageValueID(dbName, key, version)
+	n := w.graph.addNode(id, LineageNodeValue, dbName+"/"+key)
+	n.Attributes["db"] = dbName
+	n.Attributes["key"] = key
+	if version != nil {
+		n.Attributes["block"] = strconv.FormatUint(version.GetBlockNum(), 10)
+		n.Attributes["txIndex"] = strconv.FormatUint(version.GetTxNum(), 10)
+		n.Label = fmt.Sprintf("%s/%s@%d:%d", dbName, key, version.GetBlockNum(), version.GetTxNum())
+	} else {
+		n.Attributes["exists"] = "false"
+	}
+
+	if version != nil && depth < w.maxDepth && !w.expanded[id] {
+		w.expanded[id] = true
+		w.queue = append(w.queue, &lineageValue{dbName: dbName, key: key, version: version, depth: depth})
+	}
+	return id
+}
+
+func (w *lineageWalk) expandValue(v *lineageValue) error {
+	id := lineageValueID(v.dbName, v.key, v.version)
+
+	history, err := w.keyHistory(v.dbName, v.key)
+	switch {
+	case isForbidden(err):
+		w.graph.nodes[id].Attributes["history"] = "denied"
+	case err != nil:
+		return err
+	default:
+		var previous *types.ValueWithMetadata
+		for _, h := range history {
+			hVersion := h.GetMetadata().GetVersion()
+			switch c := compareVersion(hVersion, v.version); {
+			case c == 0:
+				setLineageValue(w.graph.nodes[id], h.GetValue())
+			case c < 0 && (previous == nil || compareVersion(hVersion, previous.GetMetadata().GetVersion()) > 0):
+				previous = h
+			}
+		}
+		if previous != nil {
+			prevID := w.addValue(v.dbName, v.key, previous.GetMetadata().GetVersion(), v.depth+1)
+			setLineageValue(w.graph.nodes[prevID], previous.GetValue())
+			w.graph.addEdge(id, prevID, LineageEdgeRevisionOf)
+		}
+	}
+
+	txNodeID, err := w.addTx(v.version.GetBlockNum(), v.version.GetTxNum(), v.depth+1)
+	if err != nil {
+		return err
+	}
+	w.graph.addEdge(id, txNodeID, LineageEdgeGeneratedBy)
+	return nil
+}
+
+func (w *lineageWalk) keyHistory(dbName, key string) ([]*types.ValueWithMetadata, error) {
+	cacheKey := dbName + "/" + key
+	if h, ok := w.history[cacheKey]; ok {
+		return h, nil
+	}
+	h, err := w.provenance.GetHistoricalData(dbName, key)
+	if err != nil {
+		return nil, err
+	}
+	w.history[cacheKey] = h
+	return h, nil
+}
+
+// addTx adds the transaction at the given block and index, the users who signed it,
+// the values it wrote and deleted, and the values it read, which are scheduled for expansion
+func (w *lineageWalk) addTx(blockNum, txIndex uint64, depth int) (string, error) {
+	position := fmt.Sprintf("%d:%d", blockNum, txIndex)
+	if id, ok := w.txs[position]; ok {
+		return id, nil
+	}
+
+	txRes, err := w.ledger.GetTxContent(blockNum, txIndex)
+	if err != nil && !isForbidden(err) {
+		return "", errors.WithMessagef(err, "failed to fetch the content of tx at block %d, index %d", blockNum, txIndex)
+	}
+
+	env := txRes.GetDataTxEnvelope()
+	if env == nil {
+		id := "tx:" + position
+		n := w.graph.addNode(id, LineageNodeTx, "tx@"+position)
+		n.Attributes["block"] = strconv.FormatUint(blockNum, 10)
+		n.Attributes["txIndex"] = strconv.FormatUint(txIndex, 10)
+		if err != nil {
+			n.Attributes["access"] = "denied"
+		} else {
+			n.Attributes["txType"] = "non-data"
+		}
+		w.txs[position] = id
+		return id, nil
+	}
+
+	txID := env.GetPayload().GetTxId()
+	id := "tx:" + txID
+	w.txs[position] = id
+	n := w.graph.addNode(id, LineageNodeTx, txID)
+	n.Attributes["txID"] = txID
+	n.Attributes["block"] = strconv.FormatUint(blockNum, 10)
+	n.Attributes["txIndex"] = strconv.FormatUint(txIndex, 10)
+	n.Attributes["flag"] = txRes.GetValidationInfo().GetFlag().String()
+
+	var signers []string
+	for userID := range env.GetSignatures() {
+		signers = append(signers, userID)
+	}
+	sort.Strings(signers)
+	for _, userID := range signers {
+		userNodeID := "user:" + userID
+		w.graph.addNode(userNodeID, LineageNodeUser, userID)
+		w.graph.addEdge(id, userNodeID, LineageEdgeAssociatedWith)
+	}
+
+	txVersion := &types.Version{BlockNum: blockNum, TxNum: txIndex}
+	for _, ops := range env.GetPayload().GetDbOperations() {
+		for _, write := range ops.GetDataWrites() {
+			writeID := w.addValue(ops.GetDbName(), write.GetKey(), txVersion, w.maxDepth)
+			setLineageValue(w.graph.nodes[writeID], write.GetValue())
+			w.graph.addEdge(writeID, id, LineageEdgeGeneratedBy)
+		}
+		for _, del := range ops.GetDataDeletes() {
+			deleteID := w.addValue(ops.GetDbName(), del.GetKey(), txVersion, w.maxDepth)
+			w.graph.nodes[deleteID].Attributes["deleted"] = "true"
+			w.graph.addEdge(deleteID, id, LineageEdgeInvalidatedBy)
+		}
+		for _, read := range ops.GetDataReads() {
+			readID := w.addValue(ops.GetDbName(), read.GetKey(), read.GetVersion(), depth)
+			w.graph.addEdge(id, readID, LineageEdgeUsed)
+		}
+	}
+
+	return id, nil
+}
+
+func setLineageValue(n *LineageNode, value []byte) {
+	if utf8.Valid(value) {
+		n.Attributes["value"] = string(value)
+	} else {
+		n.Attributes["value"] = fmt.Sprintf("%x", value)
+		n.Attributes["valueEncoding"] = "hex"
+	}
+}
+
+func isForbidden(err error) bool {
+	httpErr, ok := errors.Cause(err).(*httpError)
+	return ok && httpErr.statusCode == http.StatusForbidden
+}
@@ -0,0 +1,135 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+package bcdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// provNamespace is the namespace used for the identifiers and the attributes in the PROV-JSON export
+const provNamespace = "https://github.com/hyperledger-labs/orion-server/provenance#"
+
+// ToDOT exports the lineage graph in the Graphviz DOT language. Values are drawn as ellipses,
+// transactions as boxes and users as houses, as customary for PROV graphs.
+func (g *LineageGraph) ToDOT() []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString("digraph lineage {\n")
+	buf.WriteString("\trankdir=BT;\n")
+
+	for _, n := range g.Nodes {
+		shape := "ellipse"
+		switch n.Type {
+		case LineageNodeTx:
+			shape = "box"
+		case LineageNodeUser:
+			shape = "house"
+		}
+
+		label := n.Label
+		if v, ok := n.Attributes["value"]; ok && n.Attributes["valueEncoding"] == "" {
+			label += "\n" + v
+		}
+		if n.Attributes["deleted"] == "true" {
+			label += "\n(deleted)"
+		}
+
+		style := ""
+		if n.ID == g.Root {
+			style = ", style=bold"
+		}
+		fmt.Fprintf(buf, "\t%s [label=%s, shape=%s%s];\n", strconv.Quote(n.ID), strconv.Quote(label), shape, style)
+	}
+
+	for _, e := range g.Edges {
+		fmt.Fprintf(buf, "\t%s -> %s [label=%s];\n", strconv.Quote(e.From), strconv.Quote(e.To), strconv.Quote(string(e.Type)))
+	}
+
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+// ToJSON exports the lineage graph as JSON, with a list of nodes and a list of edges.
+func (g *LineageGraph) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(g, "", "  ")
+}
+
+// ToPROVJSON exports the lineage graph in the W3C PROV-JSON format, see https://www.w3.org/Submission/prov-json/.
+// Values are exported as entities, transactions as activities and users as agents.
+func (g *LineageGraph) ToPROVJSON() ([]byte, error) {
+	doc := map[string]map[string]interface{}{
+		"prefix": {
+			"orion": provNamespace,
+		},
+	}
+	add := func(section, id string, record map[string]interface{}) {
+		if _, ok := doc[section]; !ok {
+			doc[section] = map[string]interface{}{}
+		}
+		doc[section][id] = record
+	}
+
+	for _, n := range g.Nodes {
+		record := map[string]interface{}{
+			"prov:label": n.Label,
+		}
+		keys := make([]string, 0, len(n.Attributes))
+		for k := range n.Attributes {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			record["orion:"+k] = n.Attributes[k]
+		}
+
+		switch n.Type {
+		case LineageNodeValue:
+			add("entity", provID(n.ID), record)
+		case LineageNodeTx:
+			add("activity", provID(n.ID), record)
+		case LineageNodeUser:
+			add("agent", provID(n.ID), record)
+		}
+	}
+
+	for i, e := range g.Edges {
+		relationID := fmt.Sprintf("_:r%d", i)
+		switch e.Type {
+		case LineageEdgeGeneratedBy:
+			add("wasGeneratedBy", relationID, map[string]interface{}{
+				"prov:entity":   provID(e.From),
+				"prov:activity": provID(e.To),
+			})
+		case LineageEdgeInvalidatedBy:
+			add("wasInvalidatedBy", relationID, map[string]interface{}{
+				"prov:entity":   provID(e.From),
+				"prov:activity": provID(e.To),
+			})
+		case LineageEdgeUsed:
+			add("used", relationID, map[string]interface{}{
+				"prov:activity": provID(e.From),
+				"prov:entity":   provID(e.To),
+			})
+		case LineageEdgeAssociatedWith:
+			add("wasAssociatedWith", relationID, map[string]interface{}{
+				"prov:activity": provID(e.From),
+				"prov:agent":    provID(e.To),
+			})
+		case LineageEdgeRevisionOf:
+			add("wasDerivedFrom", relationID, map[string]interface{}{
+				"prov:generatedEntity": provID(e.From),
+				"prov:usedEntity":      provID(e.To),
+				"prov:type":            "prov:Revision",
+			})
+		}
+	}
+
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+func provID(nodeID string) string {
+	return "orion:" + nodeID
+}
@@ -0,0 +1,139 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+package bcdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger-labs/orion-server/pkg/server/testutils"
+	"github.com/hyperledger-labs/orion-server/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLineageBuilder(t *testing.T) {
+	clientCertTemDir := testutils.GenerateTestCrypto(t, []string{"admin", "alice", "server"})
+	testServer, _, _, err := SetupTestServerWithParams(t, clientCertTemDir, time.Second, 1, false, false)
+	defer testServer.Stop()
+	require.NoError(t, err)
+	_, adminSession, aliceSession := startServerConnectOpenAdminCreateUserAndUserSession(t, testServer, clientCertTemDir, "alice")
+
+	acl := &types.AccessControl{
+		ReadUsers:      map[string]bool{"alice": true},
+		ReadWriteUsers: map[string]bool{"alice": true},
+	}
+	readAndWrite := func(readKey, writeKey, value string) *types.TxReceipt {
+		tx, err := aliceSession.DataTx()
+		require.NoError(t, err)
+		if readKey != "" {
+			_, _, err = tx.Get("bdb", readKey)
+			require.NoError(t, err)
+		}
+		require.NoError(t, tx.Put("bdb", writeKey, []byte(value), acl))
+		_, receiptEnv, err := tx.Commit(true)
+		require.NoError(t, err)
+		return receiptEnv.GetResponse().GetReceipt()
+	}
+
+	r1 := readAndWrite("", "a", "a1")
+	r2 := readAndWrite("a", "b", "b1")
+	r3 := readAndWrite("b", "b", "b2")
+	versionOf := func(r *types.TxReceipt) *types.Version {
+		return &types.Version{BlockNum: r.GetHeader().GetBaseHeader().GetNumber(), TxNum: r.GetTxIndex()}
+	}
+
+	p, err := adminSession.Provenance()
+	require.NoError(t, err)
+	l, err := aliceSession.Ledger()
+	require.NoError(t, err)
+
+	_, err = NewLineageBuilder(p, l, 0)
+	require.EqualError(t, err, "max depth must be greater than 0, got 0")
+
+	rootID := lineageValueID("bdb", "b", versionOf(r3))
+	b1ID := lineageValueID("bdb", "b", versionOf(r2))
+	a1ID := lineageValueID("bdb", "a", versionOf(r1))
+
+	t.Run("depth 1", func(t *testing.T) {
+		builder, err := NewLineageBuilder(p, l, 1)
+		require.NoError(t, err)
+		g, err := builder.Build("bdb", "b", versionOf(r3))
+		require.NoError(t, err)
+
+		require.Equal(t, rootID, g.Root)
+		require.Equal(t, "b2", g.Node(rootID).Attributes["value"])
+		require.Equal(t, "b1", g.Node(b1ID).Attributes["value"])
+		require.Nil(t, g.Node(a1ID))
+
+		tx3 := g.Node("tx:" + txIDOfReceipt(t, l, r3))
+		require.NotNil(t, tx3)
+		require.Equal(t, types.Flag_VALID.String(), tx3.Attributes["flag"])
+		require.Contains(t, g.Edges, &LineageEdge{From: rootID, To: tx3.ID, Type: LineageEdgeGeneratedBy})
+		require.Contains(t, g.Edges, &LineageEdge{From: rootID, To: b1ID, Type: LineageEdgeRevisionOf})
+		require.Contains(t, g.Edges, &LineageEdge{From: tx3.ID, To: b1ID, Type: LineageEdgeUsed})
+		require.Contains(t, g.Edges, &LineageEdge{From: tx3.ID, To: "user:alice", Type: LineageEdgeAssociatedWith})
+	})
+
+	t.Run("full depth and exports", func(t *testing.T) {
+		builder, err := NewLineageBuilder(p, l, 5)
+		require.NoError(t, err)
+		g, err := builder.Build("bdb", "b", versionOf(r3))
+		require.NoError(t, err)
+
+		require.Equal(t, "a1", g.Node(a1ID).Attributes["value"])
+		tx1ID := "tx:" + txIDOfReceipt(t, l, r1)
+		tx2ID := "tx:" + txIDOfReceipt(t, l, r2)
+		require.Contains(t, g.Edges, &LineageEdge{From: b1ID, To: tx2ID, Type: LineageEdgeGeneratedBy})
+		require.Contains(t, g.Edges, &LineageEdge{From: tx2ID, To: a1ID, Type: LineageEdgeUsed})
+		require.Contains(t, g.Edges, &LineageEdge{From: a1ID, To: tx1ID, Type: LineageEdgeGeneratedBy})
+		require.Len(t, g.Nodes, 7) // 3 values, 3 txs, 1 user
+
+		dot := string(g.ToDOT())
+		require.Contains(t, dot, "digraph lineage {")
+		require.Contains(t, dot, `"`+tx2ID+`" -> "`+a1ID+`" [label="used"];`)
+
+		jsonBytes, err := g.ToJSON()
+		require.NoError(t, err)
+		exported := &LineageGraph{}
+		require.NoError(t, json.Unmarshal(jsonBytes, exported))
+		require.Equal(t, g.Root, exported.Root)
+		require.Equal(t, "b2", exported.Node(rootID).Attributes["value"])
+		require.Nil(t, exported.Node(a1ID+"-missing"))
+		reexported, err := exported.ToJSON()
+		require.NoError(t, err)
+		require.Equal(t, jsonBytes, reexported)
+
+		provBytes, err := g.ToPROVJSON()
+		require.NoError(t, err)
+		prov := map[string]map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(provBytes, &prov))
+		require.Equal(t, provNamespace, prov["prefix"]["orion"])
+		require.Len(t, prov["entity"], 3)
+		require.Len(t, prov["activity"], 3)
+		require.Len(t, prov["agent"], 1)
+		require.Len(t, prov["used"], 2)
+		require.Len(t, prov["wasDerivedFrom"], 1)
+		require.Equal(t, "a1", prov["entity"]["orion:"+a1ID].(map[string]interface{})["orion:value"])
+	})
+
+	t.Run("tx content denied", func(t *testing.T) {
+		adminLedger, err := adminSession.Ledger()
+		require.NoError(t, err)
+		builder, err := NewLineageBuilder(p, adminLedger, 5)
+		require.NoError(t, err)
+		g, err := builder.Build("bdb", "b", versionOf(r3))
+		require.NoError(t, err)
+
+		txNode := g.Node(fmt.Sprintf("tx:%d:%d", r3.GetHeader().GetBaseHeader().GetNumber(), r3.GetTxIndex()))
+		require.NotNil(t, txNode)
+		require.Equal(t, "denied", txNode.Attributes["access"])
+	})
+}
+
+func txIDOfReceipt(t *testing.T, l Ledger, r *types.TxReceipt) string {
+	res, err := l.GetTxContent(r.GetHeader().GetBaseHeader().GetNumber(), r.GetTxIndex())
+	require.NoError(t, err)
+	return res.GetDataTxEnvelope().GetPayload().GetTxId()
+}
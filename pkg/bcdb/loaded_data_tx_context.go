@@ -30,8 +30,10 @@ type LoadedDataTxContext interface {
 	MustSignUsers() []string
 	// SignedUsers returns all users who have signed the transaction envelope
 	SignedUsers() []string
-	// VerifySignatures verifies the existing signature on the loaded data transactions
-	VerifySignatures() error
+	// VerifySignatures verifies the existing signatures on the loaded data transaction with the certificates of
+	// the signers, and returns a per-user result that shows which signatures are valid, missing, invalid or
+	// belong to unknown users. The session user needs read access to the user records of the signers.
+	VerifySignatures() (*SignaturesVerification, error)
 	// Reads return all read operations performed by the load data transaction on
 	// different databases
 	Reads() map[string][]*types.DataRead
@@ -139,9 +141,13 @@ func (d *loadedDataTxContext) Deletes() map[string][]*types.DataDelete {
 	return deletes
 }
 
-func (d *loadedDataTxContext) VerifySignatures() error {
-	// TODO issue 174
-	return nil
+// VerifySignatures verifies the existing signatures on the loaded data transaction
+func (d *loadedDataTxContext) VerifySignatures() (*SignaturesVerification, error) {
+	if d.txSpent {
+		return nil, ErrTxSpent
+	}
+
+	return d.verifyTxSignatures(d.txEnv)
 }
 
 func (d *loadedDataTxContext) composeEnvelope(_ string) (proto.Message, error) {
@@ -278,3 +278,179 @@ func TestLoadedDataContext_ErrorCase(t *testing.T) {
 	require.EqualError(t, err, "no user ID in the transaction envelope")
 	require.Nil(t, loadedTxCtx)
 }
+
+func TestLoadedDataContext_VerifySignatures(t *testing.T) {
+	clientCertTemDir := testutils.GenerateTestCrypto(t, []string{"admin", "alice", "bob", "carol", "server"})
+	testServer, _, _, err := SetupTestServer(t, clientCertTemDir)
+	defer testServer.Stop()
+	require.NoError(t, err)
+	StartTestServer(t, testServer)
+
+	bcdb, adminSession := connectAndOpenAdminSession(t, testServer, clientCertTemDir)
+	createDB(t, "db1", adminSession)
+	dbPerm := map[string]types.Privilege_Access{"db1": 1}
+	for _, user := range []struct {
+		id  string
+		acl *types.AccessControl
+	}{
+		{"alice", &types.AccessControl{ReadUsers: map[string]bool{"bob": true}}},
+		{"bob", &types.AccessControl{ReadUsers: map[string]bool{"alice": true}}},
+		{"carol", nil},
+	} {
+		cert, err := ioutil.ReadFile(path.Join(clientCertTemDir, user.id+".pem"))
+		require.NoError(t, err)
+		require.NoError(t, addUserWithAcl(t, user.id, adminSession, cert, dbPerm, user.acl))
+	}
+
+	aliceSession := openUserSession(t, bcdb, "alice", clientCertTemDir)
+	bobSession := openUserSession(t, bcdb, "bob", clientCertTemDir)
+	carolSession := openUserSession(t, bcdb, "carol", clientCertTemDir)
+
+	tx, err := aliceSession.DataTx()
+	require.NoError(t, err)
+	require.NoError(t, tx.Put("db1", "key1", []byte("value1"), nil))
+	tx.AddMustSignUser("bob")
+	txEnv, err := tx.SignConstructedTxEnvelopeAndCloseTx()
+	require.NoError(t, err)
+	aliceSignedEnv := txEnv.(*types.DataTxEnvelope)
+
+	verify := func(session DBSession, env *types.DataTxEnvelope) (*SignaturesVerification, error) {
+		loadedTx, err := session.LoadDataTx(proto.Clone(env).(*types.DataTxEnvelope))
+		require.NoError(t, err)
+		return loadedTx.VerifySignatures()
+	}
+
+	t.Run("signature missing", func(t *testing.T) {
+		res, err := verify(bobSession, aliceSignedEnv)
+		require.NoError(t, err)
+		require.False(t, res.Valid())
+		require.Equal(t, []string{"alice"}, res.ValidUsers())
+		require.Equal(t, []string{"bob"}, res.MissingUsers())
+		require.Empty(t, res.InvalidUsers())
+		require.Empty(t, res.UnknownUsers())
+	})
+
+	t.Run("all signatures valid", func(t *testing.T) {
+		loadedTx, err := bobSession.LoadDataTx(proto.Clone(aliceSignedEnv).(*types.DataTxEnvelope))
+		require.NoError(t, err)
+		coSignedEnv, err := loadedTx.CoSignTxEnvelopeAndCloseTx()
+		require.NoError(t, err)
+		_, err = loadedTx.VerifySignatures()
+		require.EqualError(t, err, ErrTxSpent.Error())
+
+		res, err := verify(bobSession, coSignedEnv.(*types.DataTxEnvelope))
+		require.NoError(t, err)
+		require.True(t, res.Valid())
+		require.Equal(t, []string{"alice", "bob"}, res.ValidUsers())
+
+		res, err = verify(aliceSession, coSignedEnv.(*types.DataTxEnvelope))
+		require.NoError(t, err)
+		require.True(t, res.Valid())
+	})
+
+	t.Run("signature invalid", func(t *testing.T) {
+		env := proto.Clone(aliceSignedEnv).(*types.DataTxEnvelope)
+		env.Payload.DbOperations[0].DataWrites[0].Value = []byte("value2")
+		res, err := verify(bobSession, env)
+		require.NoError(t, err)
+		require.False(t, res.Valid())
+		require.Equal(t, []string{"alice"}, res.InvalidUsers())
+		require.Contains(t, res.Users["alice"].Reason, "verification failure")
+	})
+
+	t.Run("signer unknown", func(t *testing.T) {
+		env := proto.Clone(aliceSignedEnv).(*types.DataTxEnvelope)
+		env.Signatures["dave"] = []byte("signature")
+		res, err := verify(bobSession, env)
+		require.NoError(t, err)
+		require.Equal(t, []string{"dave"}, res.UnknownUsers())
+		require.Equal(t, []string{"alice"}, res.ValidUsers())
+	})
+
+	t.Run("no read access to signer", func(t *testing.T) {
+		loadedTx, err := carolSession.LoadDataTx(proto.Clone(aliceSignedEnv).(*types.DataTxEnvelope))
+		require.NoError(t, err)
+		coSignedEnv, err := loadedTx.CoSignTxEnvelopeAndCloseTx()
+		require.NoError(t, err)
+
+		res, err := verify(bobSession, coSignedEnv.(*types.DataTxEnvelope))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to fetch the certificate of user [carol]")
+		require.Nil(t, res)
+	})
+}
+
+func TestLoadedDataContext_MergeSignatures(t *testing.T) {
+	clientCertTemDir := testutils.GenerateTestCrypto(t, []string{"admin", "alice", "bob", "carol", "server"})
+	testServer, _, _, err := SetupTestServer(t, clientCertTemDir)
+	defer testServer.Stop()
+	require.NoError(t, err)
+	StartTestServer(t, testServer)
+
+	bcdb, adminSession := connectAndOpenAdminSession(t, testServer, clientCertTemDir)
+	createDB(t, "db1", adminSession)
+	dbPerm := map[string]types.Privilege_Access{"db1": 1}
+	sessions := make(map[string]DBSession)
+	for _, user := range []string{"alice", "bob", "carol"} {
+		cert, err := ioutil.ReadFile(path.Join(clientCertTemDir, user+".pem"))
+		require.NoError(t, err)
+		addUser(t, user, adminSession, cert, dbPerm)
+		sessions[user] = openUserSession(t, bcdb, user, clientCertTemDir)
+	}
+
+	tx, err := sessions["alice"].DataTx()
+	require.NoError(t, err)
+	require.NoError(t, tx.Put("db1", "key1", []byte("value1"), nil))
+	tx.AddMustSignUser("bob")
+	tx.AddMustSignUser("carol")
+	txEnv, err := tx.SignConstructedTxEnvelopeAndCloseTx()
+	require.NoError(t, err)
+	constructedEnv := txEnv.(*types.DataTxEnvelope)
+
+	coSign := func(userID string) *types.DataTxEnvelope {
+		loadedTx, err := sessions[userID].LoadDataTx(proto.Clone(constructedEnv).(*types.DataTxEnvelope))
+		require.NoError(t, err)
+		env, err := loadedTx.CoSignTxEnvelopeAndCloseTx()
+		require.NoError(t, err)
+		return env.(*types.DataTxEnvelope)
+	}
+	bobEnv := coSign("bob")
+	carolEnv := coSign("carol")
+
+	t.Run("signatures missing", func(t *testing.T) {
+		merged, missing, err := MergeSignatures(constructedEnv, bobEnv)
+		require.NoError(t, err)
+		require.Equal(t, []string{"carol"}, missing)
+		require.Len(t, merged.Signatures, 2)
+	})
+
+	t.Run("payload differs", func(t *testing.T) {
+		env := proto.Clone(carolEnv).(*types.DataTxEnvelope)
+		env.Payload.DbOperations[0].DataWrites[0].Value = []byte("value2")
+		merged, missing, err := MergeSignatures(bobEnv, env)
+		require.EqualError(t, err, "payload of the transaction envelope [1] differs from the payload of the transaction envelope [0]")
+		require.Nil(t, merged)
+		require.Nil(t, missing)
+
+		merged, missing, err = MergeSignatures()
+		require.EqualError(t, err, "no transaction envelopes to merge")
+		require.Nil(t, merged)
+		require.Nil(t, missing)
+	})
+
+	t.Run("merge and commit", func(t *testing.T) {
+		merged, missing, err := MergeSignatures(bobEnv, carolEnv)
+		require.NoError(t, err)
+		require.Empty(t, missing)
+		require.Equal(t, bobEnv.Signatures["alice"], merged.Signatures["alice"])
+		require.Equal(t, bobEnv.Signatures["bob"], merged.Signatures["bob"])
+		require.Equal(t, carolEnv.Signatures["carol"], merged.Signatures["carol"])
+
+		loadedTx, err := sessions["alice"].LoadDataTx(merged)
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"alice", "bob", "carol"}, loadedTx.SignedUsers())
+		_, receiptEnv, err := loadedTx.Commit(true)
+		require.NoError(t, err)
+		require.Equal(t, types.Flag_VALID, receiptEnv.GetResponse().GetReceipt().GetHeader().GetValidationInfo()[receiptEnv.GetResponse().GetReceipt().GetTxIndex()].GetFlag())
+	})
+}
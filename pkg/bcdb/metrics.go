@@ -0,0 +1,199 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+package bcdb
+
+import (
+	"time"
+
+	"github.com/hyperledger-labs/orion-server/pkg/types"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	metricsNamespace = "orion"
+	metricsSubsystem = "sdk"
+)
+
+// The operations whose latency is observed
+const (
+	operationCommitSync  = "commit_sync"
+	operationCommitAsync = "commit_async"
+	operationGet         = "get"
+	operationJSONQuery   = "json_query"
+	operationRangePage   = "range_page"
+	operationProvenance  = "provenance"
+	operationLedger      = "ledger"
+	operationOther       = "other"
+)
+
+// sessionMetrics are the client side metrics of the sessions. A nil *sessionMetrics is valid and records nothing, so
+// that sessions without a metrics registerer need no checks.
+type sessionMetrics struct {
+	operationDuration     *prometheus.HistogramVec
+	commitRetries         prometheus.Counter
+	replicaFailovers      prometheus.Counter
+	verificationFailures  prometheus.Counter
+	committedTransactions *prometheus.CounterVec
+	inFlightTransactions  *prometheus.GaugeVec
+}
+
+// newSessionMetrics creates the metrics and registers them with the registerer. Metrics that are already registered,
+// e.g. by another session over the same registerer, are shared. A nil registerer disables the metrics.
+func newSessionMetrics(registerer prometheus.Registerer) (*sessionMetrics, error) {
+	if registerer == nil {
+		return nil, nil
+	}
+
+	m := &sessionMetrics{
+		operationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "operation_duration_seconds",
+			Help:      "The latency of the operations sent to the cluster, by operation and status.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 16),
+		}, []string{"operation", "status"}),
+		commitRetries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "commit_retries_total",
+			Help:      "The number of times a transaction submission was retried.",
+		}),
+		replicaFailovers: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "replica_failovers_total",
+			Help:      "The number of times a transaction submission was retried with another replica.",
+		}),
+		verificationFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "signature_verification_failures_total",
+			Help:      "The number of responses whose signature failed verification.",
+		}),
+		committedTransactions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "committed_transactions_total",
+			Help:      "The number of transactions committed synchronously, by validation flag.",
+		}, []string{"flag"}),
+		inFlightTransactions: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "transactions_in_flight",
+			Help:      "The number of transactions being committed, by operation.",
+		}, []string{"operation"}),
+	}
+
+	var err error
+	if m.operationDuration, err = registerCollector(registerer, m.operationDuration); err != nil {
+		return nil, err
+	}
+	if m.commitRetries, err = registerCollector(registerer, m.commitRetries); err != nil {
+		return nil, err
+	}
+	if m.replicaFailovers, err = registerCollector(registerer, m.replicaFailovers); err != nil {
+		return nil, err
+	}
+	if m.verificationFailures, err = registerCollector(registerer, m.verificationFailures); err != nil {
+		return nil, err
+	}
+	if m.committedTransactions, err = registerCollector(registerer, m.committedTransactions); err != nil {
+		return nil, err
+	}
+	if m.inFlightTransactions, err = registerCollector(registerer, m.inFlightTransactions); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// registerCollector registers the collector, or returns the collector already registered in its place
+func registerCollector[C prometheus.Collector](registerer prometheus.Registerer, collector C) (C, error) {
+	err := registerer.Register(collector)
+	if err == nil {
+		return collector, nil
+	}
+	if alreadyRegistered, ok := err.(prometheus.AlreadyRegisteredError); ok {
+		if existing, ok := alreadyRegistered.ExistingCollector.(C); ok {
+			return existing, nil
+		}
+	}
+	return collector, errors.Wrap(err, "failed to register the session metrics")
+}
+
+// observeOperation records the latency of an operation that started at start
+func (m *sessionMetrics) observeOperation(operation string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	m.operationDuration.WithLabelValues(operation, status).Observe(time.Since(start).Seconds())
+}
+
+func (m *sessionMetrics) commitRetried(failover bool) {
+	if m == nil {
+		return
+	}
+	m.commitRetries.Inc()
+	if failover {
+		m.replicaFailovers.Inc()
+	}
+}
+
+func (m *sessionMetrics) verificationFailed() {
+	if m == nil {
+		return
+	}
+	m.verificationFailures.Inc()
+}
+
+func (m *sessionMetrics) transactionCommitted(flag types.Flag) {
+	if m == nil {
+		return
+	}
+	m.committedTransactions.WithLabelValues(flag.String()).Inc()
+}
+
+// transactionStarted increments the in-flight transactions of the operation, and returns the function that
+// decrements them
+func (m *sessionMetrics) transactionStarted(operation string) func() {
+	if m == nil {
+		return func() {}
+	}
+	gauge := m.inFlightTransactions.WithLabelValues(operation)
+	gauge.Inc()
+	return gauge.Dec
+}
+
+// queryOperation returns the operation of a query by the type of its response
+func queryOperation(res proto.Message) string {
+	switch res.(type) {
+	case *types.GetDataResponseEnvelope:
+		return operationGet
+	case *types.DataQueryResponseEnvelope:
+		return operationJSONQuery
+	case *types.GetDataRangeResponseEnvelope:
+		return operationRangePage
+	case *types.GetHistoricalDataResponseEnvelope,
+		*types.GetDataReadersResponseEnvelope,
+		*types.GetDataWritersResponseEnvelope,
+		*types.GetDataProvenanceResponseEnvelope,
+		*types.GetTxIDsSubmittedByResponseEnvelope:
+		return operationProvenance
+	case *types.GetBlockResponseEnvelope,
+		*types.GetAugmentedBlockHeaderResponseEnvelope,
+		*types.GetLedgerPathResponseEnvelope,
+		*types.GetTxProofResponseEnvelope,
+		*types.GetDataProofResponseEnvelope,
+		*types.TxReceiptResponseEnvelope,
+		*types.GetTxResponseEnvelope,
+		*types.GetConfigBlockResponseEnvelope:
+		return operationLedger
+	default:
+		return operationOther
+	}
+}
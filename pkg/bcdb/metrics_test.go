@@ -0,0 +1,189 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package bcdb
+
+import (
+	"net/url"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/hyperledger-labs/orion-sdk-go/internal"
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/bcdb/mocks"
+	sdkconfig "github.com/hyperledger-labs/orion-sdk-go/pkg/config"
+	"github.com/hyperledger-labs/orion-server/pkg/server/testutils"
+	"github.com/hyperledger-labs/orion-server/pkg/types"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionMetrics(t *testing.T) {
+	clientCertTemDir := testutils.GenerateTestCrypto(t, []string{"admin", "server"})
+	testServer, _, _, err := SetupTestServer(t, clientCertTemDir)
+	defer testServer.Stop()
+	require.NoError(t, err)
+	StartTestServer(t, testServer)
+
+	serverPort, err := testServer.Port()
+	require.NoError(t, err)
+	bcdb := createDBInstance(t, clientCertTemDir, serverPort)
+
+	registry := prometheus.NewRegistry()
+	sessionConfig := &sdkconfig.SessionConfig{
+		UserConfig: &sdkconfig.UserConfig{
+			UserID:         "admin",
+			CertPath:       path.Join(clientCertTemDir, "admin.pem"),
+			PrivateKeyPath: path.Join(clientCertTemDir, "admin.key"),
+		},
+		TxTimeout:         20 * time.Second,
+		MetricsRegisterer: registry,
+	}
+	session, err := bcdb.Session(sessionConfig)
+	require.NoError(t, err)
+	metrics := session.(*dbSession).metrics
+	require.NotNil(t, metrics)
+
+	putKeySync(t, "bdb", "key1", "value1", "admin", session)
+	putKeySync(t, "bdb", "key2", "value2", "admin", session)
+
+	tx, err := session.DataTx()
+	require.NoError(t, err)
+	require.NoError(t, tx.Put("bdb", "key3", []byte("value3"), nil))
+	_, _, err = tx.Commit(false)
+	require.NoError(t, err)
+
+	tx, err = session.DataTx()
+	require.NoError(t, err)
+	_, _, err = tx.Get("bdb", "key1")
+	require.NoError(t, err)
+	require.NoError(t, tx.Abort())
+
+	query, err := session.Query()
+	require.NoError(t, err)
+	_, err = query.GetDataByRange("bdb", "key1", "key3", 0)
+	require.NoError(t, err)
+
+	provenance, err := session.Provenance()
+	require.NoError(t, err)
+	_, err = provenance.GetHistoricalData("bdb", "key1")
+	require.NoError(t, err)
+
+	ledger, err := session.Ledger()
+	require.NoError(t, err)
+	_, err = ledger.GetLastBlockHeader()
+	require.NoError(t, err)
+
+	observations := func(operation, status string) uint64 {
+		m := &dto.Metric{}
+		require.NoError(t, metrics.operationDuration.WithLabelValues(operation, status).(prometheus.Metric).Write(m))
+		return m.GetHistogram().GetSampleCount()
+	}
+	require.Equal(t, uint64(2), observations(operationCommitSync, "ok"))
+	require.Equal(t, uint64(1), observations(operationCommitAsync, "ok"))
+	require.Equal(t, uint64(1), observations(operationGet, "ok"))
+	require.Equal(t, uint64(1), observations(operationRangePage, "ok"))
+	require.Equal(t, uint64(1), observations(operationProvenance, "ok"))
+	require.Equal(t, uint64(1), observations(operationLedger, "ok"))
+
+	require.Equal(t, float64(2), testutil.ToFloat64(metrics.committedTransactions.WithLabelValues(types.Flag_VALID.String())))
+	require.Equal(t, float64(0), testutil.ToFloat64(metrics.inFlightTransactions.WithLabelValues(operationCommitSync)))
+	require.Equal(t, float64(0), testutil.ToFloat64(metrics.inFlightTransactions.WithLabelValues(operationCommitAsync)))
+	require.Equal(t, float64(0), testutil.ToFloat64(metrics.commitRetries))
+	require.Equal(t, float64(0), testutil.ToFloat64(metrics.verificationFailures))
+
+	// a transaction that fails validation
+	tx, err = session.DataTx()
+	require.NoError(t, err)
+	require.NoError(t, tx.Put("non-existing-db", "key", []byte("value"), nil))
+	_, _, err = tx.Commit(true)
+	require.Error(t, err)
+	require.IsType(t, &ErrorTxValidation{}, err)
+	require.Equal(t, float64(1), testutil.ToFloat64(metrics.committedTransactions.WithLabelValues(types.Flag_INVALID_DATABASE_DOES_NOT_EXIST.String())))
+	require.Equal(t, uint64(1), observations(operationCommitSync, "error"))
+
+	t.Run("sessions share the metrics of a registerer", func(t *testing.T) {
+		otherSession, err := bcdb.Session(sessionConfig)
+		require.NoError(t, err)
+		otherMetrics := otherSession.(*dbSession).metrics
+		require.Same(t, metrics.operationDuration, otherMetrics.operationDuration)
+		require.Same(t, metrics.inFlightTransactions, otherMetrics.inFlightTransactions)
+
+		putKeySync(t, "bdb", "key4", "value4", "admin", otherSession)
+		require.Equal(t, float64(3), testutil.ToFloat64(metrics.committedTransactions.WithLabelValues(types.Flag_VALID.String())))
+	})
+
+	t.Run("no registerer", func(t *testing.T) {
+		noMetricsConfig := *sessionConfig
+		noMetricsConfig.MetricsRegisterer = nil
+		otherSession, err := bcdb.Session(&noMetricsConfig)
+		require.NoError(t, err)
+		require.Nil(t, otherSession.(*dbSession).metrics)
+		putKeySync(t, "bdb", "key5", "value5", "admin", otherSession)
+	})
+
+	t.Run("conflicting registration", func(t *testing.T) {
+		conflictingRegistry := prometheus.NewRegistry()
+		conflictingRegistry.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "orion_sdk_commit_retries_total",
+			Help: "A conflicting metric.",
+		}))
+		conflictingConfig := *sessionConfig
+		conflictingConfig.MetricsRegisterer = conflictingRegistry
+		_, err := bcdb.Session(&conflictingConfig)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to register the session metrics")
+	})
+}
+
+func TestSessionMetrics_VerificationFailure(t *testing.T) {
+	signer := &mocks.Signer{}
+	signer.On("Sign", mock.Anything).Return([]byte{1}, nil)
+	verifierFails := &mocks.SignatureVerifier{}
+	verifierFails.On("Verify", mock.Anything, mock.Anything, mock.Anything).Return(errors.New("bad-mock-signature"))
+	logger := createTestLogger(t)
+
+	metrics, err := newSessionMetrics(prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	restClient := NewRestClient("testUser", &mockHttpClient{
+		process: asyncSubmit,
+		resp:    okResponseAsync(),
+	}, signer)
+	tx := &dataTxContext{
+		commonTxContext: &commonTxContext{
+			userID:   "testUser",
+			signer:   signer,
+			userCert: []byte{1, 2, 3},
+			replicaSet: []*internal.ReplicaWithRole{
+				{Id: "node1", URL: &url.URL{Path: "http://localhost:8888"}, Role: internal.ReplicaRole_LEADER},
+			},
+			verifier:   verifierFails,
+			restClient: restClient,
+			logger:     logger,
+			metrics:    metrics,
+			dbSession:  createDBSession(signer, verifierFails, logger, restClient, 0, 0),
+		},
+		operations: make(map[string]*dbOperations),
+	}
+
+	_, _, err = tx.Commit(false)
+	require.EqualError(t, err, "signature verification failed nodeID node1, due to bad-mock-signature")
+	require.Equal(t, float64(1), testutil.ToFloat64(metrics.verificationFailures))
+	require.Equal(t, float64(0), testutil.ToFloat64(metrics.inFlightTransactions.WithLabelValues(operationCommitAsync)))
+}
+
+func TestQueryOperation(t *testing.T) {
+	require.Equal(t, operationGet, queryOperation(&types.GetDataResponseEnvelope{}))
+	require.Equal(t, operationJSONQuery, queryOperation(&types.DataQueryResponseEnvelope{}))
+	require.Equal(t, operationRangePage, queryOperation(&types.GetDataRangeResponseEnvelope{}))
+	require.Equal(t, operationProvenance, queryOperation(&types.GetHistoricalDataResponseEnvelope{}))
+	require.Equal(t, operationProvenance, queryOperation(&types.GetTxIDsSubmittedByResponseEnvelope{}))
+	require.Equal(t, operationLedger, queryOperation(&types.GetAugmentedBlockHeaderResponseEnvelope{}))
+	require.Equal(t, operationLedger, queryOperation(&types.TxReceiptResponseEnvelope{}))
+	require.Equal(t, operationOther, queryOperation(&types.GetUserResponseEnvelope{}))
+}
@@ -0,0 +1,68 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+package bcdb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/config"
+	"github.com/pkg/errors"
+	"golang.org/x/term"
+)
+
+// PassphraseFromEnv returns a passphrase function that reads the passphrase from an environment variable
+func PassphraseFromEnv(envVar string) config.PassphraseFunc {
+	return func() ([]byte, error) {
+		value, ok := os.LookupEnv(envVar)
+		if !ok {
+			return nil, errors.Errorf("environment variable %s is not set", envVar)
+		}
+		return []byte(value), nil
+	}
+}
+
+// PassphraseFromPrompt returns a passphrase function that prints the prompt to the standard error and reads the
+// passphrase from the standard input. When the standard input is a terminal, the passphrase is not echoed.
+func PassphraseFromPrompt(prompt string) config.PassphraseFunc {
+	return func() ([]byte, error) {
+		fmt.Fprint(os.Stderr, prompt)
+		defer fmt.Fprintln(os.Stderr)
+
+		pass, err := readPassphrase(os.Stdin)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the passphrase")
+		}
+		return pass, nil
+	}
+}
+
+// readPassphrase reads a single line, without echo if the file is a terminal. Otherwise, the line is read one byte
+// at a time, so that the input that follows it is left unread.
+func readPassphrase(f *os.File) ([]byte, error) {
+	if fd := int(f.Fd()); term.IsTerminal(fd) {
+		return term.ReadPassword(fd)
+	}
+
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := f.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+			continue
+		}
+		if err == io.EOF && len(line) > 0 {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return bytes.TrimRight(line, "\r"), nil
+}
@@ -0,0 +1,413 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+package bcdb
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger-labs/orion-server/pkg/types"
+	"github.com/pkg/errors"
+)
+
+// ProvenanceQueryOptions holds the filters, the limit and the continuation token of
+// the provenance iterators. The server returns the complete result of a provenance
+// query, hence nothing is paged on the server side: each iterator fetches the complete
+// result, sorts it, and the filters, the limit and the continuation token are applied
+// by the SDK while iterating over the sorted result. The limit bounds the number of
+// entries returned by an iterator, not the size of the server response.
+type ProvenanceQueryOptions struct {
+	// StartBlock, when greater than 0, skips all entries committed before the given block number.
+	StartBlock uint64
+	// EndBlock, when greater than 0, skips all entries committed after the given block number.
+	EndBlock uint64
+	// DBName, when not empty, returns only entries of the given database.
+	// Applicable only to the data read by and the data written by user queries.
+	DBName string
+	// KeyPrefix, when not empty, returns only entries whose key starts with the given prefix.
+	// Applicable only to the data read by and the data written by user queries.
+	KeyPrefix string
+	// Limit denotes the maximal number of entries returned by the iterator, 0 denotes no limit.
+	Limit uint64
+	// ContinuationToken, when not empty, resumes the iteration right after the last entry
+	// returned by a previous iterator of the same query. See ContinuationToken() of the iterators.
+	ContinuationToken string
+}
+
+// HistoricalDataIterator iterates over the historical values of a key, ordered by version.
+type HistoricalDataIterator interface {
+	// Next returns the next value. If there are no more values, or the limit was reached,
+	// it would return a nil value and a false value.
+	Next() (*types.ValueWithMetadata, bool, error)
+	// ContinuationToken returns a token that can be passed in ProvenanceQueryOptions to resume
+	// the iteration after the last returned value. An empty token denotes that there are no more values.
+	ContinuationToken() string
+}
+
+// UserDataIterator iterates over the keys read or written by a user, ordered by database, key and version.
+type UserDataIterator interface {
+	// Next returns the name of the database and the next key-value. If there are no more key-values, or
+	// the limit was reached, it would return an empty database name, a nil value and a false value.
+	Next() (string, *types.KVWithMetadata, bool, error)
+	// ContinuationToken returns a token that can be passed in ProvenanceQueryOptions to resume
+	// the iteration after the last returned key-value. An empty token denotes that there are no more key-values.
+	ContinuationToken() string
+}
+
+// TxIDIterator iterates over transaction IDs, in lexicographic order.
+type TxIDIterator interface {
+	// Next returns the next transaction ID. If there are no more IDs, or the limit was reached,
+	// it would return an empty ID and a false value.
+	Next() (string, bool, error)
+	// ContinuationToken returns a token that can be passed in ProvenanceQueryOptions to resume
+	// the iteration after the last returned ID. An empty token denotes that there are no more IDs.
+	ContinuationToken() string
+}
+
+const (
+	historicalDataQuery = "history"
+	dataReadByQuery     = "read-by"
+	dataWrittenByQuery  = "written-by"
+	txIDsByQuery        = "tx-ids"
+)
+
+// provenancePosition identifies an entry in the result of a provenance query, and is
+// serialized as the continuation token.
+type provenancePosition struct {
+	Query    string `json:"query"`
+	DBName   string `json:"db,omitempty"`
+	Key      string `json:"key,omitempty"`
+	BlockNum uint64 `json:"block,omitempty"`
+	TxNum    uint64 `json:"tx,omitempty"`
+	TxID     string `json:"txid,omitempty"`
+}
+
+func (p *provenancePosition) less(other *provenancePosition) bool {
+	if p.DBName != other.DBName {
+		return p.DBName < other.DBName
+	}
+	if p.Key != other.Key {
+		return p.Key < other.Key
+	}
+	if p.BlockNum != other.BlockNum {
+		return p.BlockNum < other.BlockNum
+	}
+	return p.TxNum < other.TxNum
+}
+
+func (p *provenancePosition) encode() string {
+	bytes, err := json.Marshal(p)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+func decodeProvenancePosition(token, query string) (*provenancePosition, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	bytes, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errors.Wrap(err, "malformed continuation token")
+	}
+	pos := &provenancePosition{}
+	if err = json.Unmarshal(bytes, pos); err != nil {
+		return nil, errors.Wrap(err, "malformed continuation token")
+	}
+	if pos.Query != query {
+		return nil, errors.Errorf("continuation token of query [%s] used with query [%s]", pos.Query, query)
+	}
+	return pos, nil
+}
+
+type provenanceEntry struct {
+	pos   *provenancePosition
+	value *types.ValueWithMetadata
+	kv    *types.KVWithMetadata
+}
+
+// provenanceIterator streams over a sorted provenance result and applies the
+// filters and the limit given in the ProvenanceQueryOptions.
+type provenanceIterator struct {
+	entries  []*provenanceEntry
+	opts     *ProvenanceQueryOptions
+	current  int
+	returned uint64
+	last     *provenancePosition
+}
+
+func (i *provenanceIterator) match(e *provenanceEntry) bool {
+	if i.opts.StartBlock > 0 && e.pos.BlockNum < i.opts.StartBlock {
+		return false
+	}
+	if i.opts.EndBlock > 0 && e.pos.BlockNum > i.opts.EndBlock {
+		return false
+	}
+	if i.opts.DBName != "" && e.pos.DBName != i.opts.DBName {
+		return false
+	}
+	if i.opts.KeyPrefix != "" && !strings.HasPrefix(e.pos.Key, i.opts.KeyPrefix) {
+		return false
+	}
+	return true
+}
+
+func (i *provenanceIterator) nextMatch() int {
+	for idx := i.current; idx < len(i.entries); idx++ {
+		if i.match(i.entries[idx]) {
+			return idx
+		}
+	}
+	return -1
+}
+
+func (i *provenanceIterator) next() *provenanceEntry {
+	if i.opts.Limit > 0 && i.returned >= i.opts.Limit {
+		return nil
+	}
+
+	idx := i.nextMatch()
+	if idx < 0 {
+		i.current = len(i.entries)
+		return nil
+	}
+
+	e := i.entries[idx]
+	i.current = idx + 1
+	i.returned++
+	i.last = e.pos
+	return e
+}
+
+func (i *provenanceIterator) continuationToken() string {
+	if i.nextMatch() < 0 || i.last == nil {
+		return ""
+	}
+	return i.last.encode()
+}
+
+func newProvenanceIterator(entries []*provenanceEntry, opts *ProvenanceQueryOptions, resumeAfter *provenancePosition) *provenanceIterator {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].pos.less(entries[j].pos)
+	})
+
+	start := 0
+	if resumeAfter != nil {
+		start = sort.Search(len(entries), func(i int) bool {
+			return resumeAfter.less(entries[i].pos)
+		})
+	}
+
+	return &provenanceIterator{
+		entries: entries,
+		opts:    opts,
+		current: start,
+		last:    resumeAfter,
+	}
+}
+
+func validateProvenanceQueryOptions(opts *ProvenanceQueryOptions, query string) (*provenancePosition, error) {
+	if opts.EndBlock > 0 && opts.StartBlock > opts.EndBlock {
+		return nil, errors.Errorf("start block [%d] is greater than end block [%d]", opts.StartBlock, opts.EndBlock)
+	}
+
+	switch query {
+	case historicalDataQuery:
+		if opts.DBName != "" || opts.KeyPrefix != "" {
+			return nil, errors.New("database name and key prefix filters are not applicable to the historical data query")
+		}
+	case txIDsByQuery:
+		if opts.StartBlock > 0 || opts.EndBlock > 0 || opts.DBName != "" || opts.KeyPrefix != "" {
+			return nil, errors.New("block range, database name and key prefix filters are not applicable to the tx IDs query")
+		}
+	}
+
+	return decodeProvenancePosition(opts.ContinuationToken, query)
+}
+
+type historicalDataIterator struct {
+	*provenanceIterator
+}
+
+func (i *historicalDataIterator) Next() (*types.ValueWithMetadata, bool, error) {
+	e := i.next()
+	if e == nil {
+		return nil, false, nil
+	}
+	return e.value, true, nil
+}
+
+func (i *historicalDataIterator) ContinuationToken() string {
+	return i.continuationToken()
+}
+
+type userDataIterator struct {
+	*provenanceIterator
+}
+
+func (i *userDataIterator) Next() (string, *types.KVWithMetadata, bool, error) {
+	e := i.next()
+	if e == nil {
+		return "", nil, false, nil
+	}
+	return e.pos.DBName, e.kv, true, nil
+}
+
+func (i *userDataIterator) ContinuationToken() string {
+	return i.continuationToken()
+}
+
+type txIDIterator struct {
+	txIDs    []string
+	limit    uint64
+	current  int
+	returned uint64
+}
+
+func (i *txIDIterator) Next() (string, bool, error) {
+	if i.current >= len(i.txIDs) || (i.limit > 0 && i.returned >= i.limit) {
+		return "", false, nil
+	}
+
+	txID := i.txIDs[i.current]
+	i.current++
+	i.returned++
+	return txID, true, nil
+}
+
+func (i *txIDIterator) ContinuationToken() string {
+	if i.current == 0 || i.current >= len(i.txIDs) {
+		return ""
+	}
+	pos := &provenancePosition{
+		Query: txIDsByQuery,
+		TxID:  i.txIDs[i.current-1],
+	}
+	return pos.encode()
+}
+
+// GetHistoricalDataIterator loads the whole history of the key from the server, then iterates
+// over the result on the client side
+func (p *provenance) GetHistoricalDataIterator(dbName, key string, opts *ProvenanceQueryOptions) (HistoricalDataIterator, error) {
+	if opts == nil {
+		opts = &ProvenanceQueryOptions{}
+	}
+	resumeAfter, err := validateProvenanceQueryOptions(opts, historicalDataQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := p.GetHistoricalData(dbName, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*provenanceEntry
+	for _, v := range values {
+		entries = append(entries, &provenanceEntry{
+			pos: &provenancePosition{
+				Query:    historicalDataQuery,
+				BlockNum: v.GetMetadata().GetVersion().GetBlockNum(),
+				TxNum:    v.GetMetadata().GetVersion().GetTxNum(),
+			},
+			value: v,
+		})
+	}
+
+	return &historicalDataIterator{newProvenanceIterator(entries, opts, resumeAfter)}, nil
+}
+
+// GetDataReadByUserIterator loads all the reads of the user from the server, then iterates
+// over the result on the client side
+func (p *provenance) GetDataReadByUserIterator(userID string, opts *ProvenanceQueryOptions) (UserDataIterator, error) {
+	if opts == nil {
+		opts = &ProvenanceQueryOptions{}
+	}
+	resumeAfter, err := validateProvenanceQueryOptions(opts, dataReadByQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	dbKVs, err := p.GetDataReadByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &userDataIterator{newProvenanceIterator(userDataEntries(dbKVs, dataReadByQuery), opts, resumeAfter)}, nil
+}
+
+// GetDataWrittenByUserIterator loads all the writes of the user from the server, then iterates
+// over the result on the client side
+func (p *provenance) GetDataWrittenByUserIterator(userID string, opts *ProvenanceQueryOptions) (UserDataIterator, error) {
+	if opts == nil {
+		opts = &ProvenanceQueryOptions{}
+	}
+	resumeAfter, err := validateProvenanceQueryOptions(opts, dataWrittenByQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	dbKVs, err := p.GetDataWrittenByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &userDataIterator{newProvenanceIterator(userDataEntries(dbKVs, dataWrittenByQuery), opts, resumeAfter)}, nil
+}
+
+// GetTxIDsSubmittedByUserIterator loads the IDs of all the transactions of the user from the server, then iterates
+// over the result on the client side
+func (p *provenance) GetTxIDsSubmittedByUserIterator(userID string, opts *ProvenanceQueryOptions) (TxIDIterator, error) {
+	if opts == nil {
+		opts = &ProvenanceQueryOptions{}
+	}
+	resumeAfter, err := validateProvenanceQueryOptions(opts, txIDsByQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	txIDs, err := p.GetTxIDsSubmittedByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the IDs are sorted, so that the continuation token, the last returned ID, resumes the
+	// iteration at the same place whatever the order of the server result
+	txIDs = append([]string(nil), txIDs...)
+	sort.Strings(txIDs)
+	start := 0
+	if resumeAfter != nil {
+		start = sort.Search(len(txIDs), func(i int) bool {
+			return txIDs[i] > resumeAfter.TxID
+		})
+	}
+
+	return &txIDIterator{
+		txIDs:   txIDs,
+		limit:   opts.Limit,
+		current: start,
+	}, nil
+}
+
+func userDataEntries(dbKVs map[string]*types.KVsWithMetadata, query string) []*provenanceEntry {
+	var entries []*provenanceEntry
+	for dbName, kvs := range dbKVs {
+		for _, kv := range kvs.GetKVs() {
+			entries = append(entries, &provenanceEntry{
+				pos: &provenancePosition{
+					Query:    query,
+					DBName:   dbName,
+					Key:      kv.GetKey(),
+					BlockNum: kv.GetMetadata().GetVersion().GetBlockNum(),
+					TxNum:    kv.GetMetadata().GetVersion().GetTxNum(),
+				},
+				kv: kv,
+			})
+		}
+	}
+	return entries
+}
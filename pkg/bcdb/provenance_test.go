@@ -3,16 +3,24 @@
 package bcdb
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"path"
+	"sort"
+	"sync"
 	"testing"
 	"time"
 
+	sdkconfig "github.com/hyperledger-labs/orion-sdk-go/pkg/config"
+	"github.com/hyperledger-labs/orion-server/pkg/constants"
+	"github.com/hyperledger-labs/orion-server/pkg/marshal"
 	"github.com/hyperledger-labs/orion-server/pkg/server/testutils"
 	"github.com/hyperledger-labs/orion-server/pkg/types"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -576,3 +584,299 @@ func runUpdateTx(t *testing.T, user string, userSession DBSession, readKey strin
 	require.NotNil(t, receiptEnv)
 	return receiptEnv.GetResponse().GetReceipt()
 }
+
+func TestProvenanceIterators(t *testing.T) {
+	clientCertTemDir := testutils.GenerateTestCrypto(t, []string{"admin", "alice", "server"})
+	testServer, _, _, err := SetupTestServerWithParams(t, clientCertTemDir, time.Second, 1, false, false)
+	defer testServer.Stop()
+	require.NoError(t, err)
+	_, adminSession, aliceSession := startServerConnectOpenAdminCreateUserAndUserSession(t, testServer, clientCertTemDir, "alice")
+
+	var receipts []*types.TxReceipt
+	for i := 0; i < 4; i++ {
+		receipt, _, _ := putKeySync(t, "bdb", "key0", fmt.Sprintf("value%d", i), "alice", aliceSession)
+		receipts = append(receipts, receipt)
+	}
+	putKeySync(t, "bdb", "other1", "value", "alice", aliceSession)
+
+	p, err := adminSession.Provenance()
+	require.NoError(t, err)
+
+	collectHistory := func(it HistoricalDataIterator) []string {
+		var values []string
+		for {
+			v, ok, err := it.Next()
+			require.NoError(t, err)
+			if !ok {
+				return values
+			}
+			values = append(values, string(v.GetValue()))
+		}
+	}
+
+	t.Run("history with limit and continuation token", func(t *testing.T) {
+		it, err := p.GetHistoricalDataIterator("bdb", "key0", &ProvenanceQueryOptions{Limit: 3})
+		require.NoError(t, err)
+		require.Equal(t, []string{"value0", "value1", "value2"}, collectHistory(it))
+		token := it.ContinuationToken()
+		require.NotEmpty(t, token)
+
+		it, err = p.GetHistoricalDataIterator("bdb", "key0", &ProvenanceQueryOptions{Limit: 3, ContinuationToken: token})
+		require.NoError(t, err)
+		require.Equal(t, []string{"value3"}, collectHistory(it))
+		require.Empty(t, it.ContinuationToken())
+	})
+
+	t.Run("history with block range", func(t *testing.T) {
+		it, err := p.GetHistoricalDataIterator("bdb", "key0", &ProvenanceQueryOptions{
+			StartBlock: receipts[1].GetHeader().GetBaseHeader().GetNumber(),
+			EndBlock:   receipts[2].GetHeader().GetBaseHeader().GetNumber(),
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{"value1", "value2"}, collectHistory(it))
+	})
+
+	t.Run("written by user with filters and pagination", func(t *testing.T) {
+		collect := func(opts *ProvenanceQueryOptions) ([]string, string) {
+			it, err := p.GetDataWrittenByUserIterator("alice", opts)
+			require.NoError(t, err)
+			var keys []string
+			for {
+				db, kv, ok, err := it.Next()
+				require.NoError(t, err)
+				if !ok {
+					return keys, it.ContinuationToken()
+				}
+				require.Equal(t, "bdb", db)
+				keys = append(keys, kv.GetKey())
+			}
+		}
+
+		all, token := collect(nil)
+		require.Contains(t, all, "key0")
+		require.Contains(t, all, "other1")
+		require.Empty(t, token)
+
+		prefixed, _ := collect(&ProvenanceQueryOptions{KeyPrefix: "key"})
+		require.NotEmpty(t, prefixed)
+		for _, k := range prefixed {
+			require.Equal(t, "key0", k)
+		}
+
+		otherDB, _ := collect(&ProvenanceQueryOptions{DBName: "otherdb"})
+		require.Empty(t, otherDB)
+
+		var paged []string
+		opts := &ProvenanceQueryOptions{Limit: 1}
+		for {
+			keys, token := collect(opts)
+			paged = append(paged, keys...)
+			if token == "" {
+				break
+			}
+			opts.ContinuationToken = token
+		}
+		require.Equal(t, all, paged)
+	})
+
+	t.Run("tx IDs with pagination", func(t *testing.T) {
+		expected, err := p.GetTxIDsSubmittedByUser("alice")
+		require.NoError(t, err)
+		require.Len(t, expected, 5)
+		sort.Strings(expected)
+
+		var paged []string
+		opts := &ProvenanceQueryOptions{Limit: 2}
+		for {
+			it, err := p.GetTxIDsSubmittedByUserIterator("alice", opts)
+			require.NoError(t, err)
+			for {
+				txID, ok, err := it.Next()
+				require.NoError(t, err)
+				if !ok {
+					break
+				}
+				paged = append(paged, txID)
+			}
+			if opts.ContinuationToken = it.ContinuationToken(); opts.ContinuationToken == "" {
+				break
+			}
+		}
+		require.Equal(t, expected, paged)
+	})
+
+	t.Run("bad options", func(t *testing.T) {
+		_, err := p.GetHistoricalDataIterator("bdb", "key0", &ProvenanceQueryOptions{StartBlock: 5, EndBlock: 4})
+		require.EqualError(t, err, "start block [5] is greater than end block [4]")
+
+		_, err = p.GetHistoricalDataIterator("bdb", "key0", &ProvenanceQueryOptions{KeyPrefix: "key"})
+		require.EqualError(t, err, "database name and key prefix filters are not applicable to the historical data query")
+
+		_, err = p.GetTxIDsSubmittedByUserIterator("alice", &ProvenanceQueryOptions{StartBlock: 1})
+		require.EqualError(t, err, "block range, database name and key prefix filters are not applicable to the tx IDs query")
+
+		it, err := p.GetHistoricalDataIterator("bdb", "key0", &ProvenanceQueryOptions{Limit: 1})
+		require.NoError(t, err)
+		_, _, err = it.Next()
+		require.NoError(t, err)
+		_, err = p.GetDataWrittenByUserIterator("alice", &ProvenanceQueryOptions{ContinuationToken: it.ContinuationToken()})
+		require.EqualError(t, err, "continuation token of query [history] used with query [written-by]")
+
+		_, err = p.GetHistoricalDataIterator("bdb", "key0", &ProvenanceQueryOptions{ContinuationToken: "%%%"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "malformed continuation token")
+	})
+}
+
+func TestGetVerifiedHistory(t *testing.T) {
+	clientCertTemDir := testutils.GenerateTestCrypto(t, []string{"admin", "alice", "server"})
+	testServer, _, _, err := SetupTestServerWithParams(t, clientCertTemDir, time.Second, 1, false, false)
+	defer testServer.Stop()
+	require.NoError(t, err)
+	db, adminSession, aliceSession := startServerConnectOpenAdminCreateUserAndUserSession(t, testServer, clientCertTemDir, "alice")
+
+	deleteKeySync := func(key string) *types.TxReceipt {
+		tx, err := aliceSession.DataTx()
+		require.NoError(t, err)
+		require.NoError(t, tx.Delete("bdb", key))
+		_, receiptEnv, err := tx.Commit(true)
+		require.NoError(t, err)
+		return receiptEnv.GetResponse().GetReceipt()
+	}
+
+	r1, _, _ := putKeySync(t, "bdb", "key1", "value1", "alice", aliceSession)
+	r2, _, _ := putKeySync(t, "bdb", "key1", "value2", "alice", aliceSession)
+	putKeySync(t, "bdb", "other", "value", "alice", aliceSession)
+	rDel1 := deleteKeySync("key1")
+	putKeySync(t, "bdb", "other", "value", "alice", aliceSession)
+	r3, _, _ := putKeySync(t, "bdb", "key1", "value3", "alice", aliceSession)
+	putKeySync(t, "bdb", "other", "value", "alice", aliceSession)
+	putKeySync(t, "bdb", "other", "value", "alice", aliceSession)
+	rDel2 := deleteKeySync("key1")
+	putKeySync(t, "bdb", "other", "value", "alice", aliceSession)
+
+	p, err := adminSession.Provenance()
+	require.NoError(t, err)
+	l, err := adminSession.Ledger()
+	require.NoError(t, err)
+	lastHeader, err := l.GetLastBlockHeader()
+	require.NoError(t, err)
+
+	blockOf := func(r *types.TxReceipt) uint64 {
+		return r.GetHeader().GetBaseHeader().GetNumber()
+	}
+	expected := []struct {
+		value     string
+		isDeleted bool
+		block     uint64
+	}{
+		{"value1", false, blockOf(r1)},
+		{"value2", false, blockOf(r2)},
+		{"value2", true, blockOf(rDel1)},
+		{"value3", false, blockOf(r3)},
+		{"value3", true, blockOf(rDel2)},
+	}
+
+	requireHistory := func(t *testing.T, history []*VerifiedHistoricalValue) {
+		require.Len(t, history, len(expected))
+		for i, e := range expected {
+			require.Equal(t, e.value, string(history[i].Value.GetValue()))
+			require.Equal(t, e.isDeleted, history[i].IsDeleted)
+			require.Equal(t, e.block, history[i].BlockHeader.GetBaseHeader().GetNumber())
+			require.NotNil(t, history[i].Proof)
+		}
+	}
+
+	t.Run("last block anchor", func(t *testing.T) {
+		history, err := p.GetVerifiedHistory("bdb", "key1", lastHeader)
+		require.NoError(t, err)
+		requireHistory(t, history)
+	})
+
+	t.Run("forged header newer than the anchor", func(t *testing.T) {
+		// a compromised replica, holding the key of the node, forges the state root of the newest header of
+		// every ledger path going up from an older anchor
+		nodeSigner, err := NewFileSigner("testNode1", path.Join(clientCertTemDir, "server.key"))
+		require.NoError(t, err)
+		var lock sync.Mutex
+		forged := 0
+		forgeNewerHeader := func(info *sdkconfig.RequestInfo, req *http.Request, invoker sdkconfig.RequestInvoker) (*http.Response, error) {
+			resp, err := invoker(req)
+			if err != nil || resp.StatusCode != http.StatusOK || req.URL.Path != constants.GetPath {
+				return resp, err
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			resEnv := &types.GetLedgerPathResponseEnvelope{}
+			require.NoError(t, protojson.Unmarshal(body, resEnv))
+			headers := resEnv.GetResponse().GetBlockHeaders()
+			if len(headers) > 1 {
+				headers[0].StateMerkleTreeRootHash = []byte("forged state root")
+				payload, err := marshal.DefaultMarshaller().Marshal(resEnv.GetResponse())
+				require.NoError(t, err)
+				resEnv.Signature, err = nodeSigner.Sign(payload)
+				require.NoError(t, err)
+				body, err = protojson.Marshal(resEnv)
+				require.NoError(t, err)
+				lock.Lock()
+				forged++
+				lock.Unlock()
+			}
+			resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+			return resp, nil
+		}
+
+		session, err := db.Session(&sdkconfig.SessionConfig{
+			UserConfig: &sdkconfig.UserConfig{
+				UserID:         "admin",
+				CertPath:       path.Join(clientCertTemDir, "admin.pem"),
+				PrivateKeyPath: path.Join(clientCertTemDir, "admin.key"),
+			},
+			TxTimeout:    20 * time.Second,
+			Interceptors: []sdkconfig.RequestInterceptor{forgeNewerHeader},
+		})
+		require.NoError(t, err)
+		forgingProvenance, err := session.Provenance()
+		require.NoError(t, err)
+
+		anchor, err := l.GetBlockHeader(blockOf(r2))
+		require.NoError(t, err)
+		history, err := forgingProvenance.GetVerifiedHistory("bdb", "key1", anchor)
+		require.EqualError(t, err, fmt.Sprintf("verification failed: block %d is after the trusted anchor block %d", blockOf(rDel1), blockOf(r2)))
+		require.IsType(t, &ProofVerificationError{}, err)
+		require.Nil(t, history)
+
+		genesisHeader, err := l.GetBlockHeader(GenesisBlockNumber)
+		require.NoError(t, err)
+		history, err = forgingProvenance.GetVerifiedHistory("bdb", "key1", genesisHeader)
+		require.EqualError(t, err, fmt.Sprintf("verification failed: block %d is after the trusted anchor block %d", blockOf(r1), GenesisBlockNumber))
+		require.Nil(t, history)
+
+		lock.Lock()
+		require.Equal(t, 0, forged, "no ledger path going up from the anchor is requested")
+		lock.Unlock()
+
+		// a ledger path going up from the anchor is only pinned at its older end, hence it accepts the forged header
+		forgingLedger, err := session.Ledger()
+		require.NoError(t, err)
+		ledgerPath, err := forgingLedger.GetLedgerPath(blockOf(r2), blockOf(rDel1))
+		require.NoError(t, err)
+		ok, err := ledgerPath.Verify(anchor, nil)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, []byte("forged state root"), ledgerPath.Path[0].GetStateMerkleTreeRootHash())
+	})
+
+	t.Run("untrusted anchor", func(t *testing.T) {
+		anchor := proto.Clone(lastHeader).(*types.BlockHeader)
+		anchor.SkipchainHashes = [][]byte{[]byte("hash")}
+		history, err := p.GetVerifiedHistory("bdb", "key1", anchor)
+		require.Error(t, err)
+		require.IsType(t, &ProofVerificationError{}, err)
+		require.Nil(t, history)
+
+		history, err = p.GetVerifiedHistory("bdb", "key1", nil)
+		require.EqualError(t, err, "trusted anchor block header is required")
+		require.Nil(t, history)
+	})
+}
@@ -0,0 +1,283 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+package bcdb
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger-labs/orion-server/pkg/constants"
+	"github.com/hyperledger-labs/orion-server/pkg/state"
+	"github.com/hyperledger-labs/orion-server/pkg/types"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+)
+
+// VerifiedHistoricalValue is a single entry of a verified history of a key. An entry records either the write of
+// a value, or the deletion of a previously written value.
+type VerifiedHistoricalValue struct {
+	// Value is the historical value along with its metadata. For a deletion, it is the value that was deleted
+	// and its metadata holds the version of the write that produced it.
+	Value *types.ValueWithMetadata
+	// IsDeleted is true when the entry records the deletion of Value
+	IsDeleted bool
+	// BlockHeader is the header of the block that produced the entry, verified against the trusted anchor
+	BlockHeader *types.BlockHeader
+	// Proof is the state proof of the entry, verified against the state root of BlockHeader. Proof is nil
+	// when the entry was superseded by a later transaction of the same block, as the state trie only captures
+	// the state at the end of each block.
+	Proof *state.Proof
+}
+
+// GetVerifiedHistory returns the history of a key, ordered by block number, in which each write and each deletion
+// is checked with a state proof against the state root of the block that produced it, and each such block header
+// is checked with a ledger path against the trusted anchor block header. As the ledger skip list only lets trust
+// flow from a newer header back to older ones, the anchor must be at or after the last block of the history, e.g.
+// the last block header; an entry committed after the anchor fails the verification.
+func (p *provenance) GetVerifiedHistory(dbName, key string, anchor *types.BlockHeader) ([]*VerifiedHistoricalValue, error) {
+	if anchor == nil {
+		return nil, errors.New("trusted anchor block header is required")
+	}
+
+	values, err := p.GetHistoricalData(dbName, key)
+	if err != nil {
+		return nil, err
+	}
+	deletedValues, err := p.getHistoricalDeletedData(dbName, key)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+		return compareVersion(values[i].GetMetadata().GetVersion(), values[j].GetMetadata().GetVersion()) < 0
+	})
+	deleted := make(map[string]bool)
+	for _, v := range deletedValues {
+		deleted[versionKey(v.GetMetadata().GetVersion())] = true
+	}
+
+	l := &ledger{p.commonTxContext}
+	lastHeader, err := l.GetLastBlockHeader()
+	if err != nil {
+		return nil, err
+	}
+	lastBlock := lastHeader.GetBaseHeader().GetNumber()
+	if anchorNum := anchor.GetBaseHeader().GetNumber(); anchorNum < lastBlock {
+		// blocks after the anchor cannot be verified, hence deletions are only searched up to the anchor
+		lastBlock = anchorNum
+	}
+	v := &historyVerifier{
+		ledger:    l,
+		dbName:    dbName,
+		key:       key,
+		anchor:    anchor,
+		lastBlock: lastBlock,
+		headers:   make(map[uint64]*types.BlockHeader),
+	}
+
+	var history []*VerifiedHistoricalValue
+	for i, value := range values {
+		writeBlock := value.GetMetadata().GetVersion().GetBlockNum()
+		nextWriteBlock := uint64(0)
+		if i+1 < len(values) {
+			nextWriteBlock = values[i+1].GetMetadata().GetVersion().GetBlockNum()
+		}
+		// the header of the write is verified first, so that a write after the anchor is reported as such
+		if _, err = v.verifiedHeader(writeBlock); err != nil {
+			return nil, err
+		}
+
+		var deletion *VerifiedHistoricalValue
+		if deleted[versionKey(value.GetMetadata().GetVersion())] {
+			if deletion, err = v.verifyDeletion(value, writeBlock, nextWriteBlock); err != nil {
+				return nil, err
+			}
+		}
+
+		superseded := writeBlock == nextWriteBlock ||
+			(deletion != nil && deletion.BlockHeader.GetBaseHeader().GetNumber() == writeBlock)
+		write, err := v.verifyWrite(value, writeBlock, superseded)
+		if err != nil {
+			return nil, err
+		}
+
+		history = append(history, write)
+		if deletion != nil {
+			history = append(history, deletion)
+		}
+	}
+
+	return history, nil
+}
+
+func (p *provenance) getHistoricalDeletedData(dbName, key string) ([]*types.ValueWithMetadata, error) {
+	path := constants.URLForGetHistoricalDeletedData(dbName, key)
+	resEnv := &types.GetHistoricalDataResponseEnvelope{}
+	err := p.handleRequest(
+		path,
+		&types.GetHistoricalDataQuery{
+			UserId:      p.userID,
+			DbName:      dbName,
+			Key:         key,
+			OnlyDeletes: true,
+		}, resEnv,
+	)
+	if err != nil {
+		p.logger.Errorf("failed to execute historical deleted data query %s, due to %s", path, err)
+		return nil, err
+	}
+	return resEnv.GetResponse().GetValues(), nil
+}
+
+// historyVerifier verifies the entries of the history of a single key, caching the block headers it has
+// already verified against the trusted anchor
+type historyVerifier struct {
+	ledger    *ledger
+	dbName    string
+	key       string
+	anchor    *types.BlockHeader
+	lastBlock uint64 // the last block searched for deletions, not after the anchor
+	headers   map[uint64]*types.BlockHeader
+}
+
+func (v *historyVerifier) verifyWrite(value *types.ValueWithMetadata, blockNum uint64, superseded bool) (*VerifiedHistoricalValue, error) {
+	header, err := v.verifiedHeader(blockNum)
+	if err != nil {
+		return nil, err
+	}
+	entry := &VerifiedHistoricalValue{
+		Value:       value,
+		BlockHeader: header,
+	}
+	if superseded {
+		return entry, nil
+	}
+
+	if entry.Proof, err = v.ledger.GetDataProof(blockNum, v.dbName, v.key, false); err != nil {
+		return nil, err
+	}
+	if err = v.verifyProof(entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// verifyDeletion finds the block that deleted the value, that is the first block after the write in which the
+// state trie holds the key as deleted, and verifies the deletion proof. As the key stays deleted until the next
+// write, the search is a binary search over the blocks between the write and the next write.
+func (v *historyVerifier) verifyDeletion(value *types.ValueWithMetadata, writeBlock, nextWriteBlock uint64) (*VerifiedHistoricalValue, error) {
+	low, high := writeBlock, v.lastBlock
+	if nextWriteBlock != 0 {
+		high = nextWriteBlock - 1
+	}
+
+	var deleteBlock uint64
+	var proof *state.Proof
+	for low <= high {
+		mid := low + (high-low)/2
+		p, err := v.ledger.GetDataProof(mid, v.dbName, v.key, true)
+		if err != nil {
+			if _, ok := err.(*ErrorNotFound); !ok {
+				return nil, err
+			}
+			low = mid + 1
+			continue
+		}
+		deleteBlock, proof = mid, p
+		high = mid - 1
+	}
+
+	if proof == nil {
+		// the key was deleted and written again by transactions of the same block
+		if nextWriteBlock == 0 {
+			return nil, &ProofVerificationError{fmt.Sprintf("no deletion of db %s, key %s found in blocks [%d, %d]", v.dbName, v.key, writeBlock, v.lastBlock)}
+		}
+		deleteBlock = nextWriteBlock
+	}
+
+	header, err := v.verifiedHeader(deleteBlock)
+	if err != nil {
+		return nil, err
+	}
+	entry := &VerifiedHistoricalValue{
+		Value:       value,
+		IsDeleted:   true,
+		BlockHeader: header,
+		Proof:       proof,
+	}
+	if proof == nil {
+		return entry, nil
+	}
+	if err = v.verifyProof(entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (v *historyVerifier) verifyProof(entry *VerifiedHistoricalValue) error {
+	valueHash, err := CalculateValueHash(v.dbName, v.key, entry.Value.GetValue())
+	if err != nil {
+		return err
+	}
+	ok, err := entry.Proof.Verify(valueHash, entry.BlockHeader.GetStateMerkleTreeRootHash(), entry.IsDeleted)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		version := entry.Value.GetMetadata().GetVersion()
+		return &ProofVerificationError{fmt.Sprintf("verification failed: state proof of db %s, key %s, version (%d, %d), isDeleted %t in block %d",
+			v.dbName, v.key, version.GetBlockNum(), version.GetTxNum(), entry.IsDeleted, entry.BlockHeader.GetBaseHeader().GetNumber())}
+	}
+	return nil
+}
+
+// verifiedHeader returns the header of the given block, after verifying the ledger path from the trusted anchor down
+// to it. A block after the anchor is rejected: a ledger path from the anchor up to it would only pin its older end,
+// leaving its newer end, the header of the block, unauthenticated.
+func (v *historyVerifier) verifiedHeader(blockNum uint64) (*types.BlockHeader, error) {
+	if header, ok := v.headers[blockNum]; ok {
+		return header, nil
+	}
+
+	anchorNum := v.anchor.GetBaseHeader().GetNumber()
+	var header *types.BlockHeader
+	switch {
+	case blockNum == anchorNum:
+		h, err := v.ledger.GetBlockHeader(blockNum)
+		if err != nil {
+			return nil, err
+		}
+		if !proto.Equal(h, v.anchor) {
+			return nil, &ProofVerificationError{fmt.Sprintf("verification failed: block %d is not same as the trusted anchor", blockNum)}
+		}
+		header = h
+	case blockNum < anchorNum:
+		path, err := v.ledger.GetLedgerPath(blockNum, anchorNum)
+		if err != nil {
+			return nil, err
+		}
+		if ok, err := path.Verify(nil, v.anchor); err != nil || !ok {
+			return nil, ledgerPathError(blockNum, anchorNum, err)
+		}
+		header = path.Path[len(path.Path)-1]
+	default:
+		return nil, &ProofVerificationError{fmt.Sprintf("verification failed: block %d is after the trusted anchor block %d", blockNum, anchorNum)}
+	}
+
+	if header.GetBaseHeader().GetNumber() != blockNum {
+		return nil, &ProofVerificationError{fmt.Sprintf("verification failed: ledger path ends at block %d instead of block %d", header.GetBaseHeader().GetNumber(), blockNum)}
+	}
+	v.headers[blockNum] = header
+	return header, nil
+}
+
+func ledgerPathError(begin, end uint64, err error) error {
+	if err != nil {
+		return err
+	}
+	return &ProofVerificationError{fmt.Sprintf("verification failed: ledger path between blocks %d and %d", begin, end)}
+}
+
+func versionKey(version *types.Version) string {
+	return fmt.Sprintf("%d:%d", version.GetBlockNum(), version.GetTxNum())
+}
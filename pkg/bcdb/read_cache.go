@@ -0,0 +1,415 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+package bcdb
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/config"
+	"github.com/hyperledger-labs/orion-server/pkg/logger"
+	"github.com/hyperledger-labs/orion-server/pkg/types"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultReadCacheMaxEntries   = 1024
+	defaultReadCacheMaxStaleness = 5 * time.Second
+	defaultReadCachePollInterval = 100 * time.Millisecond
+)
+
+type readCacheKind int
+
+const (
+	readCacheData readCacheKind = iota
+	readCacheUser
+	readCacheDBIndex
+)
+
+// readCacheKey identifies a cached read: the key in a database, the ID of a user, or the database of an index
+type readCacheKey struct {
+	kind   readCacheKind
+	dbName string
+	key    string
+}
+
+type readCacheEntry struct {
+	// response is the verified response of the read, which carries the version of the value
+	response  proto.Message
+	fetchedAt time.Time
+}
+
+type readCacheState int
+
+const (
+	readCacheIdle readCacheState = iota
+	readCacheStarting
+	readCacheFollowing
+)
+
+// readCache caches the verified reads of a session. Entries are cached only while the cache follows the ledger, so
+// that every transaction committed after an entry was read invalidates it. The cache starts following the ledger on
+// first use, and stops after it was not used for the max staleness, as by then all its entries expired.
+//
+// The cache records the keys written by the transactions the session submits, and matches them against the
+// transaction IDs of each block header, so that a transaction of the session invalidates the keys it writes without
+// reading its content. The content of a transaction of any other session is read from the ledger, and invalidates
+// the keys it writes. As the content of a data transaction is only readable to its signers, and the one of an
+// administration transaction to the admins, a transaction the session user may not read invalidates all the entries.
+//
+// A nil *readCache is valid and caches nothing.
+type readCache struct {
+	maxEntries   int
+	maxStaleness time.Duration
+	pollInterval time.Duration
+	session      *dbSession
+	logger       *logger.SugarLogger
+
+	lock     sync.Mutex // guards all the fields below
+	state    readCacheState
+	entries  map[readCacheKey]*readCacheEntry
+	lastUsed time.Time
+	// invalidations counts the invalidations, so that a read that was in flight during an invalidation is not cached
+	invalidations uint64
+	// submitted holds the keys written by the transactions the session submitted, by transaction ID, until the
+	// cache follows their block
+	submitted map[string]*submittedWrites
+}
+
+// submittedWrites are the keys written by a transaction of the session, as returned by writtenReadCacheKeys
+type submittedWrites struct {
+	keys        []readCacheKey
+	deletedDBs  map[string]bool
+	all         bool
+	submittedAt time.Time
+}
+
+func newReadCache(conf *config.ReadCacheConfig, session *dbSession) *readCache {
+	if conf == nil {
+		return nil
+	}
+
+	c := &readCache{
+		maxEntries:   conf.MaxEntries,
+		maxStaleness: conf.MaxStaleness,
+		pollInterval: conf.PollInterval,
+		session:      session,
+		logger:       session.logger,
+		entries:      make(map[readCacheKey]*readCacheEntry),
+		submitted:    make(map[string]*submittedWrites),
+	}
+	if c.maxEntries <= 0 {
+		c.maxEntries = defaultReadCacheMaxEntries
+	}
+	if c.maxStaleness <= 0 {
+		c.maxStaleness = defaultReadCacheMaxStaleness
+	}
+	if c.pollInterval <= 0 {
+		c.pollInterval = defaultReadCachePollInterval
+	}
+	return c
+}
+
+// get returns a copy of the cached response of the read, or nil, along with the invalidation count to pass to put
+func (c *readCache) get(key readCacheKey) (proto.Message, uint64) {
+	if c == nil {
+		return nil, 0
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	c.lastUsed = now
+	if c.state == readCacheIdle {
+		c.state = readCacheStarting
+		go c.follow()
+		return nil, c.invalidations
+	}
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, c.invalidations
+	}
+	if now.Sub(entry.fetchedAt) > c.maxStaleness {
+		delete(c.entries, key)
+		return nil, c.invalidations
+	}
+	return proto.Clone(entry.response), c.invalidations
+}
+
+// put caches the response of a read, unless an invalidation occurred since the read was requested
+func (c *readCache) put(key readCacheKey, response proto.Message, invalidations uint64) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.state != readCacheFollowing || invalidations != c.invalidations {
+		return
+	}
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		c.evictOldestLocked()
+	}
+	c.entries[key] = &readCacheEntry{
+		response:  proto.Clone(response),
+		fetchedAt: time.Now(),
+	}
+}
+
+// invalidateTx removes the entries written by the transaction the session submitted, and records them, so that they
+// are removed again once the cache follows the block of the transaction
+func (c *readCache) invalidateTx(txID string, txEnv proto.Message) {
+	if c == nil || txEnv == nil {
+		return
+	}
+
+	keys, deletedDBs, all := writtenReadCacheKeys(txEnv)
+	writes := &submittedWrites{
+		keys:        keys,
+		deletedDBs:  deletedDBs,
+		all:         all,
+		submittedAt: time.Now(),
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.invalidations++
+	c.removeWritesLocked(writes)
+
+	// a transaction whose record is evicted invalidates all the entries once the cache follows its block
+	if _, ok := c.submitted[txID]; !ok && len(c.submitted) >= c.maxEntries {
+		c.evictOldestSubmittedLocked()
+	}
+	c.submitted[txID] = writes
+}
+
+func (c *readCache) removeWritesLocked(writes *submittedWrites) {
+	if writes.all {
+		c.entries = make(map[readCacheKey]*readCacheEntry)
+		return
+	}
+	for _, key := range writes.keys {
+		delete(c.entries, key)
+	}
+	if len(writes.deletedDBs) > 0 {
+		for key := range c.entries {
+			if key.kind == readCacheData && writes.deletedDBs[key.dbName] {
+				delete(c.entries, key)
+			}
+		}
+	}
+}
+
+func (c *readCache) evictOldestSubmittedLocked() {
+	var oldestTxID string
+	var oldest *submittedWrites
+	for txID, writes := range c.submitted {
+		if oldest == nil || writes.submittedAt.Before(oldest.submittedAt) {
+			oldestTxID, oldest = txID, writes
+		}
+	}
+	delete(c.submitted, oldestTxID)
+}
+
+func (c *readCache) evictOldestLocked() {
+	var oldestKey readCacheKey
+	var oldest *readCacheEntry
+	for key, entry := range c.entries {
+		if oldest == nil || entry.fetchedAt.Before(oldest.fetchedAt) {
+			oldestKey, oldest = key, entry
+		}
+	}
+	delete(c.entries, oldestKey)
+}
+
+// follow delivers the headers of the blocks committed after the cache started, and invalidates the entries written by
+// their valid transactions, until the cache is not used for the max staleness or the delivery fails
+func (c *readCache) follow() {
+	defer c.stop()
+
+	ledger, err := c.session.Ledger()
+	if err != nil {
+		c.logger.Warnf("read cache cannot follow the ledger, due to %s", err)
+		return
+	}
+	lastHeader, err := ledger.GetLastBlockHeader()
+	if err != nil {
+		c.logger.Warnf("read cache cannot follow the ledger, due to %s", err)
+		return
+	}
+
+	deliverer := ledger.NewBlockHeaderDeliveryService(&BlockHeaderDeliveryConfig{
+		StartBlockNumber: lastHeader.GetBaseHeader().GetNumber() + 1,
+		RetryInterval:    c.pollInterval,
+		Capacity:         16,
+		IncludeTxIDs:     true,
+	})
+
+	// the reads that were in flight while the cache started are not cached, as they may precede the start block
+	c.lock.Lock()
+	c.state = readCacheFollowing
+	c.invalidations++
+	c.lock.Unlock()
+
+	done := make(chan struct{})
+	defer close(done)
+	go c.stopWhenIdle(deliverer, done)
+
+	for {
+		header, ok := deliverer.Receive().(*types.AugmentedBlockHeader)
+		if !ok || header == nil {
+			break
+		}
+		c.invalidateBlock(ledger, header)
+	}
+	if err := deliverer.Error(); err != nil {
+		c.logger.Warnf("read cache stopped following the ledger, due to %s", err)
+	}
+}
+
+// invalidateBlock invalidates the entries written by the valid transactions of the block: the keys written by the
+// transactions of the session, and the keys written by the transactions of other sessions, whose content is read from
+// the ledger. A transaction whose content cannot be read, e.g. because the session user is not allowed to, invalidates
+// all the entries.
+func (c *readCache) invalidateBlock(ledger Ledger, header *types.AugmentedBlockHeader) {
+	blockNum := header.GetHeader().GetBaseHeader().GetNumber()
+	validationInfo := header.GetHeader().GetValidationInfo()
+
+	// the reads in flight are not cached from now on, as they may precede the block, while the content of the
+	// transactions of other sessions is read without holding the lock
+	var foreignTxs []int
+	c.lock.Lock()
+	for txIndex, txID := range header.GetTxIds() {
+		writes, ok := c.submitted[txID]
+		delete(c.submitted, txID)
+		if txIndex < len(validationInfo) && validationInfo[txIndex].GetFlag() != types.Flag_VALID {
+			continue
+		}
+		c.invalidations++
+		if !ok {
+			foreignTxs = append(foreignTxs, txIndex)
+			continue
+		}
+		c.removeWritesLocked(writes)
+	}
+	c.lock.Unlock()
+
+	var foreignWrites []*submittedWrites
+	for _, txIndex := range foreignTxs {
+		txEnv, err := readCacheTxContent(ledger, blockNum, uint64(txIndex))
+		if err != nil {
+			if !isForbidden(err) {
+				c.logger.Warnf("read cache cannot read transaction %d of block %d, invalidates all the entries, due to %s",
+					txIndex, blockNum, err)
+			}
+			foreignWrites = []*submittedWrites{{all: true}}
+			break
+		}
+		keys, deletedDBs, all := writtenReadCacheKeys(txEnv)
+		foreignWrites = append(foreignWrites, &submittedWrites{keys: keys, deletedDBs: deletedDBs, all: all})
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, writes := range foreignWrites {
+		c.removeWritesLocked(writes)
+	}
+}
+
+// readCacheTxContent reads the envelope of a transaction from the ledger
+func readCacheTxContent(ledger Ledger, blockNum, txIndex uint64) (proto.Message, error) {
+	txContent, err := ledger.GetTxContent(blockNum, txIndex)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case txContent.GetDataTxEnvelope() != nil:
+		return txContent.GetDataTxEnvelope(), nil
+	case txContent.GetUserAdministrationTxEnvelope() != nil:
+		return txContent.GetUserAdministrationTxEnvelope(), nil
+	case txContent.GetDbAdministrationTxEnvelope() != nil:
+		return txContent.GetDbAdministrationTxEnvelope(), nil
+	case txContent.GetConfigTxEnvelope() != nil:
+		return txContent.GetConfigTxEnvelope(), nil
+	default:
+		return nil, errors.Errorf("transaction %d of block %d has no envelope", txIndex, blockNum)
+	}
+}
+
+func (c *readCache) stopWhenIdle(deliverer BlockHeaderDelivererService, done chan struct{}) {
+	ticker := time.NewTicker(c.maxStaleness / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			c.lock.Lock()
+			idle := now.Sub(c.lastUsed) > c.maxStaleness
+			c.lock.Unlock()
+			if idle {
+				deliverer.Stop()
+				return
+			}
+		}
+	}
+}
+
+// stop clears the cache, which starts following the ledger again on next use
+func (c *readCache) stop() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.state = readCacheIdle
+	c.invalidations++
+	c.entries = make(map[readCacheKey]*readCacheEntry)
+	// a transaction submitted before the cache starts following the ledger again is then treated as one of another
+	// session, which invalidates all the entries
+	c.submitted = make(map[string]*submittedWrites)
+}
+
+// writtenReadCacheKeys returns the keys of the reads a transaction writes, the databases it deletes, or true if it
+// may write any of them, e.g. a config transaction that updates the admins
+func writtenReadCacheKeys(txEnv proto.Message) ([]readCacheKey, map[string]bool, bool) {
+	var keys []readCacheKey
+	var deletedDBs map[string]bool
+
+	switch env := txEnv.(type) {
+	case *types.DataTxEnvelope:
+		for _, ops := range env.GetPayload().GetDbOperations() {
+			for _, write := range ops.GetDataWrites() {
+				keys = append(keys, readCacheKey{kind: readCacheData, dbName: ops.GetDbName(), key: write.GetKey()})
+			}
+			for _, del := range ops.GetDataDeletes() {
+				keys = append(keys, readCacheKey{kind: readCacheData, dbName: ops.GetDbName(), key: del.GetKey()})
+			}
+		}
+	case *types.UserAdministrationTxEnvelope:
+		for _, write := range env.GetPayload().GetUserWrites() {
+			keys = append(keys, readCacheKey{kind: readCacheUser, key: write.GetUser().GetId()})
+		}
+		for _, del := range env.GetPayload().GetUserDeletes() {
+			keys = append(keys, readCacheKey{kind: readCacheUser, key: del.GetUserId()})
+		}
+	case *types.DBAdministrationTxEnvelope:
+		for _, dbName := range env.GetPayload().GetCreateDbs() {
+			keys = append(keys, readCacheKey{kind: readCacheDBIndex, dbName: dbName})
+		}
+		for dbName := range env.GetPayload().GetDbsIndex() {
+			keys = append(keys, readCacheKey{kind: readCacheDBIndex, dbName: dbName})
+		}
+		deletedDBs = make(map[string]bool)
+		for _, dbName := range env.GetPayload().GetDeleteDbs() {
+			keys = append(keys, readCacheKey{kind: readCacheDBIndex, dbName: dbName})
+			deletedDBs[dbName] = true
+		}
+	default:
+		return nil, nil, true
+	}
+
+	return keys, deletedDBs, false
+}
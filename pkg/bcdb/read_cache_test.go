@@ -0,0 +1,249 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package bcdb
+
+import (
+	"encoding/pem"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sdkconfig "github.com/hyperledger-labs/orion-sdk-go/pkg/config"
+	"github.com/hyperledger-labs/orion-server/pkg/constants"
+	"github.com/hyperledger-labs/orion-server/pkg/server/testutils"
+	"github.com/hyperledger-labs/orion-server/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadCache(t *testing.T) {
+	clientCertTemDir := testutils.GenerateTestCrypto(t, []string{"admin", "alice", "server"})
+	testServer, _, _, err := SetupTestServer(t, clientCertTemDir)
+	defer testServer.Stop()
+	require.NoError(t, err)
+	StartTestServer(t, testServer)
+
+	serverPort, err := testServer.Port()
+	require.NoError(t, err)
+	bcdb := createDBInstance(t, clientCertTemDir, serverPort)
+
+	var dataReads, userReads atomic.Int32
+	counter := func(info *sdkconfig.RequestInfo, req *http.Request, invoker sdkconfig.RequestInvoker) (*http.Response, error) {
+		if req.Method == http.MethodGet && strings.HasPrefix(req.URL.Path, "/data/") {
+			dataReads.Add(1)
+		}
+		if req.Method == http.MethodGet && strings.HasPrefix(req.URL.Path, "/user/") {
+			userReads.Add(1)
+		}
+		return invoker(req)
+	}
+
+	session, err := bcdb.Session(&sdkconfig.SessionConfig{
+		UserConfig: &sdkconfig.UserConfig{
+			UserID:         "admin",
+			CertPath:       path.Join(clientCertTemDir, "admin.pem"),
+			PrivateKeyPath: path.Join(clientCertTemDir, "admin.key"),
+		},
+		TxTimeout:    20 * time.Second,
+		Interceptors: []sdkconfig.RequestInterceptor{counter},
+		ReadCache: &sdkconfig.ReadCacheConfig{
+			MaxStaleness: 2 * time.Second,
+			PollInterval: 50 * time.Millisecond,
+		},
+	})
+	require.NoError(t, err)
+	otherSession := openUserSession(t, bcdb, "admin", clientCertTemDir)
+
+	receipt, _, _ := putKeySync(t, "bdb", "key1", "value1", "admin", session)
+
+	get := func(key string) ([]byte, *types.Metadata) {
+		tx, err := session.DataTx()
+		require.NoError(t, err)
+		val, meta, err := tx.Get("bdb", key)
+		require.NoError(t, err)
+		require.NoError(t, tx.Abort())
+		return val, meta
+	}
+
+	requireCached := func(key string) {
+		require.Eventually(t, func() bool {
+			get(key)
+			reads := dataReads.Load()
+			get(key)
+			return dataReads.Load() == reads
+		}, 10*time.Second, 100*time.Millisecond)
+	}
+
+	// the first read starts following the ledger, the reads are cached once the cache follows it
+	requireCached("key1")
+
+	reads := dataReads.Load()
+	val, meta := get("key1")
+	require.Equal(t, []byte("value1"), val)
+	require.Equal(t, receipt.GetHeader().GetBaseHeader().GetNumber(), meta.GetVersion().GetBlockNum())
+	require.Equal(t, reads, dataReads.Load())
+
+	// a transaction of another session invalidates the entry once the cache follows its block
+	receipt, _, _ = putKeySync(t, "bdb", "key1", "value2", "admin", otherSession)
+	require.Eventually(t, func() bool {
+		val, _ := get("key1")
+		return string(val) == "value2"
+	}, 10*time.Second, 100*time.Millisecond)
+
+	// a cached read records the version of the value, so that the transaction passes MVCC validation
+	requireCached("key1")
+	tx, err := session.DataTx()
+	require.NoError(t, err)
+	val, meta, err = tx.Get("bdb", "key1")
+	require.NoError(t, err)
+	require.Equal(t, []byte("value2"), val)
+	require.Equal(t, receipt.GetHeader().GetBaseHeader().GetNumber(), meta.GetVersion().GetBlockNum())
+	require.NoError(t, tx.Put("bdb", "key1", []byte("value3"), nil))
+	_, _, err = tx.Commit(true)
+	require.NoError(t, err)
+
+	// a commit of the session invalidates the entries it writes at once
+	val, _ = get("key1")
+	require.Equal(t, []byte("value3"), val)
+
+	// user reads are cached and invalidated the same way
+	pemUserCert, err := os.ReadFile(path.Join(clientCertTemDir, "alice.pem"))
+	require.NoError(t, err)
+	certBlock, _ := pem.Decode(pemUserCert)
+	alice := &types.User{Id: "alice", Certificate: certBlock.Bytes}
+	userTx, err := session.UsersTx()
+	require.NoError(t, err)
+	require.NoError(t, userTx.PutUser(alice, nil))
+	_, _, err = userTx.Commit(true)
+	require.NoError(t, err)
+
+	getUser := func() *types.User {
+		tx, err := session.UsersTx()
+		require.NoError(t, err)
+		user, _, err := tx.GetUser("alice")
+		require.NoError(t, err)
+		require.NoError(t, tx.Abort())
+		return user
+	}
+	require.Eventually(t, func() bool {
+		getUser()
+		reads := userReads.Load()
+		getUser()
+		return userReads.Load() == reads
+	}, 10*time.Second, 100*time.Millisecond)
+
+	otherUserTx, err := otherSession.UsersTx()
+	require.NoError(t, err)
+	require.NoError(t, otherUserTx.PutUser(&types.User{
+		Id:          "alice",
+		Certificate: certBlock.Bytes,
+		Privilege:   &types.Privilege{DbPermission: map[string]types.Privilege_Access{"bdb": types.Privilege_Read}},
+	}, nil))
+	_, _, err = otherUserTx.Commit(true)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return getUser().GetPrivilege().GetDbPermission()["bdb"] == types.Privilege_Read
+	}, 10*time.Second, 100*time.Millisecond)
+
+	// entries are not served beyond the max staleness, and the cache stops following the ledger when idle
+	requireCached("key1")
+	reads = dataReads.Load()
+	time.Sleep(3 * time.Second)
+	get("key1")
+	require.Equal(t, reads+1, dataReads.Load())
+}
+
+func TestReadCache_NonAdmin(t *testing.T) {
+	clientCertTemDir := testutils.GenerateTestCrypto(t, []string{"admin", "alice", "server"})
+	testServer, _, _, err := SetupTestServer(t, clientCertTemDir)
+	defer testServer.Stop()
+	require.NoError(t, err)
+	StartTestServer(t, testServer)
+
+	serverPort, err := testServer.Port()
+	require.NoError(t, err)
+	bcdb := createDBInstance(t, clientCertTemDir, serverPort)
+	adminSession := openUserSession(t, bcdb, "admin", clientCertTemDir)
+	pemUserCert, err := os.ReadFile(path.Join(clientCertTemDir, "alice.pem"))
+	require.NoError(t, err)
+	addUser(t, "alice", adminSession, pemUserCert, map[string]types.Privilege_Access{"bdb": types.Privilege_ReadWrite})
+
+	var dataReads, txContentReads atomic.Int32
+	counter := func(info *sdkconfig.RequestInfo, req *http.Request, invoker sdkconfig.RequestInvoker) (*http.Response, error) {
+		if req.Method == http.MethodGet && strings.HasPrefix(req.URL.Path, "/data/") {
+			dataReads.Add(1)
+		}
+		if strings.HasPrefix(req.URL.Path, constants.GetTxContentPrefix) {
+			txContentReads.Add(1)
+		}
+		return invoker(req)
+	}
+
+	session, err := bcdb.Session(&sdkconfig.SessionConfig{
+		UserConfig: &sdkconfig.UserConfig{
+			UserID:         "alice",
+			CertPath:       path.Join(clientCertTemDir, "alice.pem"),
+			PrivateKeyPath: path.Join(clientCertTemDir, "alice.key"),
+		},
+		TxTimeout:    20 * time.Second,
+		Interceptors: []sdkconfig.RequestInterceptor{counter},
+		ReadCache: &sdkconfig.ReadCacheConfig{
+			MaxStaleness: 5 * time.Second,
+			PollInterval: 50 * time.Millisecond,
+		},
+	})
+	require.NoError(t, err)
+
+	putKeySync(t, "bdb", "key1", "value1", "alice", session)
+
+	get := func(key string) []byte {
+		tx, err := session.DataTx()
+		require.NoError(t, err)
+		val, _, err := tx.Get("bdb", key)
+		require.NoError(t, err)
+		require.NoError(t, tx.Abort())
+		return val
+	}
+	requireCached := func(key string) {
+		require.Eventually(t, func() bool {
+			get(key)
+			reads := dataReads.Load()
+			get(key)
+			return dataReads.Load() == reads
+		}, 10*time.Second, 100*time.Millisecond)
+	}
+
+	requireCached("key1")
+
+	// a transaction of the session only invalidates the keys it writes, once the cache follows its block
+	putKeySync(t, "bdb", "key2", "value2", "alice", session)
+	time.Sleep(500 * time.Millisecond)
+	reads := dataReads.Load()
+	require.Equal(t, []byte("value1"), get("key1"))
+	require.Equal(t, reads, dataReads.Load())
+
+	// a transaction of another session of alice is read from the ledger, and only invalidates the keys it writes
+	otherSession := openUserSession(t, bcdb, "alice", clientCertTemDir)
+	requireCached("key2")
+	putKeySync(t, "bdb", "key2", "value4", "alice", otherSession)
+	require.Eventually(t, func() bool {
+		return string(get("key2")) == "value4"
+	}, 10*time.Second, 100*time.Millisecond)
+	require.Positive(t, txContentReads.Load())
+	reads = dataReads.Load()
+	require.Equal(t, []byte("value1"), get("key1"))
+	require.Equal(t, reads, dataReads.Load())
+
+	// a transaction of another session, whose content alice cannot read, invalidates all the entries
+	putKeySync(t, "bdb", "key3", "value3", "admin", adminSession)
+	require.Eventually(t, func() bool {
+		reads := dataReads.Load()
+		get("key1")
+		return dataReads.Load() == reads+1
+	}, 10*time.Second, 100*time.Millisecond)
+	require.Equal(t, []byte("value1"), get("key1"))
+}
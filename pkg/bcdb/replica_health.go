@@ -0,0 +1,102 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+package bcdb
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/hyperledger-labs/orion-sdk-go/internal"
+	"github.com/pkg/errors"
+)
+
+// recordReplicaResult records the result of a request sent to the replica in the health of the replicas. A request
+// that was redirected, e.g. from a follower to the leader, is accounted to the replica it was redirected to. The
+// replica is probed in the background if its circuit opened.
+func (d *dbSession) recordReplicaResult(replicaSet internal.ReplicaSet, replica *internal.ReplicaWithRole, latency time.Duration, response *http.Response, err error) {
+	if d.health == nil {
+		return
+	}
+
+	var urlErr *url.Error
+	switch {
+	case errors.As(err, &urlErr):
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		if parsedURL, parseErr := url.Parse(urlErr.URL); parseErr == nil {
+			if target := replicaSet.FindByHost(parsedURL.Host); target != nil {
+				replica = target
+			}
+		}
+	case err != nil || response == nil:
+		// not a failure of the replica, e.g. an interceptor rejected the request
+		return
+	case response.Request != nil && response.Request.URL != nil:
+		if target := replicaSet.FindByHost(response.Request.URL.Host); target != nil {
+			replica = target
+		}
+	}
+
+	if err == nil && response.StatusCode != http.StatusServiceUnavailable {
+		if d.health.RecordSuccess(replica.Id, latency) {
+			d.logger.Infof("closed the circuit of replica %s", replica.Id)
+		}
+		return
+	}
+
+	if d.health.RecordFailure(replica.Id) {
+		d.logger.Warnf("opened the circuit of replica %s, after %d consecutive failures", replica.Id, d.health.Status(replica.Id).ConsecutiveFailures)
+		go d.probeReplica(replica.Id)
+	}
+}
+
+// probeReplica sends a cluster status request to a replica with an open circuit every probe interval, until the
+// replica responds and its circuit is closed, the replica leaves the cluster, or the session is closed. A probe that
+// gets no response within the probe interval fails.
+func (d *dbSession) probeReplica(replicaID string) {
+	interval := d.health.ProbeInterval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-d.replicaSetView.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			d.logger.Debugf("stopped probing replica %s, as the session is closed", replicaID)
+			return
+		case <-ticker.C:
+		}
+
+		if !d.health.IsOpen(replicaID) {
+			return
+		}
+
+		replicaSet, _ := d.currentReplicaSet()
+		replica := replicaSet.Find(replicaID)
+		if replica == nil {
+			d.logger.Debugf("stopped probing replica %s, as it left the cluster", replicaID)
+			d.health.Forget(replicaID)
+			return
+		}
+
+		// the result of the probe is recorded by getClusterStatusFrom, and closes the circuit on success
+		httpClient := newInterceptingHttpClient(d.httpClient(nil), d.interceptors)
+		probeCtx, cancelProbe := context.WithTimeout(ctx, interval)
+		if _, err := d.getClusterStatusFrom(probeCtx, replica.Id, replica.URL, httpClient); err != nil {
+			d.logger.Debugf("probe of replica %s failed, due to %s", replicaID, err)
+		}
+		cancelProbe()
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package bcdb
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/hyperledger-labs/orion-sdk-go/internal"
+	"github.com/hyperledger-labs/orion-sdk-go/internal/test"
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/bcdb/mocks"
+	sdkconfig "github.com/hyperledger-labs/orion-sdk-go/pkg/config"
+	"github.com/hyperledger-labs/orion-server/pkg/server/testutils"
+	"github.com/hyperledger-labs/orion-server/test/setup"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplicaHealth_CircuitBreaking(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cluster-test")
+	require.NoError(t, err)
+
+	nPort, pPort := test.GetPorts()
+	setupConfig := &setup.Config{
+		NumberOfServers:     3,
+		TestDirAbsolutePath: dir,
+		BDBBinaryPath:       "../../bin/bdb",
+		CmdTimeout:          10 * time.Second,
+		BaseNodePort:        nPort,
+		BasePeerPort:        pPort,
+	}
+	c, err := setup.NewCluster(setupConfig)
+	require.NoError(t, err)
+	defer c.ShutdownAndCleanup()
+
+	require.NoError(t, c.Start())
+	originalLeader := -1
+	require.Eventually(t, func() bool {
+		originalLeader = c.AgreedLeader(t, 0, 1, 2)
+		return originalLeader >= 0
+	}, 30*time.Second, 100*time.Millisecond)
+
+	connConfig := &sdkconfig.ConnectionConfig{
+		RootCAs: []string{path.Join(setupConfig.TestDirAbsolutePath, "ca", testutils.RootCAFileName+".pem")},
+		ReplicaSet: []*sdkconfig.Replica{
+			{
+				ID:       c.Servers[0].ID(),
+				Endpoint: c.Servers[0].URL(),
+			},
+		},
+		ReplicaHealth: sdkconfig.ReplicaHealthConfig{
+			FailureThreshold: 2,
+			ProbeInterval:    100 * time.Millisecond,
+		},
+	}
+	bcdb, err := Create(connConfig)
+	require.NoError(t, err)
+	health := bcdb.(*bDB).health
+	session := openUserSession(t, bcdb, "admin", path.Join(setupConfig.TestDirAbsolutePath, "users"))
+	leaderID := c.Servers[originalLeader].ID()
+
+	sessionImpl := session.(*dbSession)
+	replicaSet, _ := sessionImpl.currentReplicaSet()
+	require.Len(t, replicaSet, 3)
+	require.Equal(t, leaderID, replicaSet[0].Id)
+	require.Equal(t, float64(1), health.Status(leaderID).Score)
+
+	ledger, err := session.Ledger()
+	require.NoError(t, err)
+	_, err = ledger.GetLastBlockHeader()
+	require.NoError(t, err)
+
+	// shut down the leader and wait for a new leader
+	require.NoError(t, c.ShutdownServer(c.Servers[originalLeader]))
+	require.Eventually(t, func() bool {
+		newLeader := c.AgreedLeader(t, (originalLeader+1)%3, (originalLeader+2)%3)
+		return newLeader >= 0 && newLeader != originalLeader
+	}, 30*time.Second, 100*time.Millisecond)
+
+	// the queries to the leader fail until its circuit opens, then they are sent to a follower
+	_, err = ledger.GetLastBlockHeader()
+	require.Error(t, err)
+	require.False(t, health.IsOpen(leaderID))
+	_, err = ledger.GetLastBlockHeader()
+	require.Error(t, err)
+	require.True(t, health.IsOpen(leaderID))
+	require.Zero(t, health.Status(leaderID).Score)
+
+	_, err = ledger.GetLastBlockHeader()
+	require.NoError(t, err)
+
+	// a transaction created with the stale replica set skips the old leader, and is redirected to the new leader
+	putKeySync(t, "bdb", "key1", "value1", "admin", session)
+
+	// the replica set is refreshed without querying the old leader
+	_, err = session.ReplicaSet(true)
+	require.NoError(t, err)
+	require.True(t, health.IsOpen(leaderID))
+	replicaSet, _ = sessionImpl.currentReplicaSet()
+	require.Equal(t, internal.ReplicaRole_UNKNOWN, replicaSet[2].Role)
+
+	// the probes close the circuit once the old leader is back
+	require.NoError(t, c.StartServer(c.Servers[originalLeader]))
+	require.Eventually(t, func() bool {
+		return !health.IsOpen(leaderID)
+	}, 30*time.Second, 100*time.Millisecond)
+	require.Zero(t, health.Status(leaderID).ConsecutiveFailures)
+}
+
+func TestReplicaHealth_ProbeStopsOnClose(t *testing.T) {
+	// the replica accepts the probes, and never responds
+	requests := make(chan struct{}, 10)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- struct{}{}
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	signer := &mocks.Signer{}
+	signer.On("Sign", mock.Anything).Return([]byte{1}, nil)
+	health := internal.NewReplicaHealth(&sdkconfig.ReplicaHealthConfig{
+		FailureThreshold: 1,
+		ProbeInterval:    50 * time.Millisecond,
+	})
+	stop := make(chan struct{})
+	session := &dbSession{
+		replicaSetView: &replicaSetView{
+			replicaSet: internal.ReplicaSet{{Id: "node1", URL: serverURL, Role: internal.ReplicaRole_LEADER}},
+			health:     health,
+			stop:       stop,
+		},
+		userID:    "testUser",
+		signer:    signer,
+		transport: newHTTPTransport(false, nil),
+		logger:    createTestLogger(t),
+		stop:      stop,
+	}
+	health.RecordFailure("node1")
+	require.True(t, health.IsOpen("node1"))
+
+	probed := make(chan struct{})
+	go func() {
+		session.probeReplica("node1")
+		close(probed)
+	}()
+
+	// a probe that gets no response fails after the probe interval, and the next probe is sent
+	for i := 0; i < 2; i++ {
+		select {
+		case <-requests:
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "the replica was not probed")
+		}
+	}
+	require.True(t, health.IsOpen("node1"))
+
+	session.Close()
+	select {
+	case <-probed:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "the probe did not stop when the session was closed")
+	}
+}
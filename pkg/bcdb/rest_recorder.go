@@ -0,0 +1,217 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+package bcdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/config"
+	"github.com/pkg/errors"
+)
+
+const restExchangeFilePrefix = "exchange-"
+
+// restExchange is a request sent to the cluster and its response, as saved in a fixture file
+type restExchange struct {
+	Method       string      `json:"method"`
+	Endpoint     string      `json:"endpoint"`
+	RequestBody  string      `json:"request_body,omitempty"`
+	StatusCode   int         `json:"status_code"`
+	Header       http.Header `json:"header,omitempty"`
+	ResponseBody string      `json:"response_body,omitempty"`
+}
+
+// key identifies the exchanges that are replayed for the same request. The scheme and host of the endpoint are not
+// part of the key, so that fixtures recorded against a cluster can be replayed whatever the address of its replicas.
+func (e *restExchange) key() string {
+	return e.Method + " " + requestURI(e.Endpoint)
+}
+
+func requestURI(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return endpoint
+	}
+	return u.RequestURI()
+}
+
+// RestRecorder saves every exchange with the cluster, i.e., the endpoint, method and body of the request, and the
+// status, headers and signed body of the response, to a fixture directory, one file per exchange. A RestReplayer
+// serves the saved responses without a server.
+type RestRecorder struct {
+	dir   string
+	lock  sync.Mutex
+	count int
+}
+
+// NewRestRecorder creates a recorder that saves the exchanges to the given directory, which is created if it does
+// not exist. The fixtures of an earlier recording in the directory are removed.
+func NewRestRecorder(dir string) (*RestRecorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "error while creating the fixture directory [%s]", dir)
+	}
+	fixtures, err := filepath.Glob(filepath.Join(dir, restExchangeFilePrefix+"*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, fixture := range fixtures {
+		if err = os.Remove(fixture); err != nil {
+			return nil, errors.Wrapf(err, "error while removing the fixture [%s]", fixture)
+		}
+	}
+
+	return &RestRecorder{dir: dir}, nil
+}
+
+// Interceptor returns an interceptor that records the exchanges of a session, to be added to the interceptors of its
+// configuration. It should be the last interceptor of the session, so that it records the requests as they are sent.
+func (r *RestRecorder) Interceptor() config.RequestInterceptor {
+	return func(info *config.RequestInfo, req *http.Request, invoker config.RequestInvoker) (*http.Response, error) {
+		body, err := readRequestBody(req)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := invoker(req)
+		if err != nil {
+			return nil, err
+		}
+		return resp, r.record(req.Method, req.URL.String(), body, resp)
+	}
+}
+
+// record saves the exchange, and replaces the body of the response it reads with a copy
+func (r *RestRecorder) record(method, endpoint string, requestBody []byte, resp *http.Response) error {
+	var responseBody []byte
+	if resp.Body != nil {
+		var err error
+		responseBody, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		resp.Body = ioutil.NopCloser(bytes.NewReader(responseBody))
+	}
+
+	exchange, err := json.MarshalIndent(&restExchange{
+		Method:       method,
+		Endpoint:     endpoint,
+		RequestBody:  string(requestBody),
+		StatusCode:   resp.StatusCode,
+		Header:       resp.Header,
+		ResponseBody: string(responseBody),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.count++
+	fixture := filepath.Join(r.dir, fmt.Sprintf("%s%06d.json", restExchangeFilePrefix, r.count))
+	if err = ioutil.WriteFile(fixture, exchange, 0644); err != nil {
+		return errors.Wrapf(err, "error while writing the fixture [%s]", fixture)
+	}
+	return nil
+}
+
+// RestReplayer serves the responses saved by a RestRecorder. The responses to the same method and endpoint are
+// served in the order they were recorded, and the last one is served again once they are exhausted, e.g. to polling
+// requests. The request bodies are not compared, as they hold signatures and random transaction IDs, so that an
+// application replays its fixtures as long as it sends the same sequence of requests to each endpoint.
+//
+// The responses are the signed responses of the recorded cluster, which the session verifies as it does the
+// responses of a server. The session must hence be configured with the certificates of the recording, and transaction
+// IDs that appear in endpoints, e.g. of receipt queries, must be the recorded ones, see WithTxID.
+type RestReplayer struct {
+	lock      sync.Mutex
+	exchanges map[string][]*restExchange
+	served    map[string]int
+}
+
+// NewRestReplayer loads the fixtures saved by a RestRecorder in the given directory
+func NewRestReplayer(dir string) (*RestReplayer, error) {
+	fixtures, err := filepath.Glob(filepath.Join(dir, restExchangeFilePrefix+"*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(fixtures) == 0 {
+		return nil, errors.Errorf("no fixture found in [%s]", dir)
+	}
+	sort.Strings(fixtures)
+
+	r := &RestReplayer{
+		exchanges: make(map[string][]*restExchange),
+		served:    make(map[string]int),
+	}
+	for _, fixture := range fixtures {
+		data, err := ioutil.ReadFile(fixture)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error while reading the fixture [%s]", fixture)
+		}
+		exchange := &restExchange{}
+		if err = json.Unmarshal(data, exchange); err != nil {
+			return nil, errors.Wrapf(err, "error while parsing the fixture [%s]", fixture)
+		}
+		r.exchanges[exchange.key()] = append(r.exchanges[exchange.key()], exchange)
+	}
+
+	return r, nil
+}
+
+// Interceptor returns an interceptor that serves the recorded responses to the requests of a session, without
+// invoking the interceptors that follow it. It should be the last interceptor of the session.
+func (r *RestReplayer) Interceptor() config.RequestInterceptor {
+	return func(info *config.RequestInfo, req *http.Request, invoker config.RequestInvoker) (*http.Response, error) {
+		return r.replay(req.Method, req.URL.String())
+	}
+}
+
+func (r *RestReplayer) replay(method, endpoint string) (*http.Response, error) {
+	key := method + " " + requestURI(endpoint)
+
+	r.lock.Lock()
+	exchanges := r.exchanges[key]
+	if len(exchanges) == 0 {
+		r.lock.Unlock()
+		return nil, errors.Errorf("no recorded exchange for request [%s]", key)
+	}
+	exchange := exchanges[len(exchanges)-1]
+	if served := r.served[key]; served < len(exchanges) {
+		exchange = exchanges[served]
+		r.served[key] = served + 1
+	}
+	r.lock.Unlock()
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", exchange.StatusCode, http.StatusText(exchange.StatusCode)),
+		StatusCode:    exchange.StatusCode,
+		Header:        exchange.Header.Clone(),
+		Body:          ioutil.NopCloser(strings.NewReader(exchange.ResponseBody)),
+		ContentLength: int64(len(exchange.ResponseBody)),
+	}, nil
+}
+
+// readRequestBody reads the body of the request, and replaces it with a copy
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
@@ -0,0 +1,195 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package bcdb
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	sdkconfig "github.com/hyperledger-labs/orion-sdk-go/pkg/config"
+	"github.com/hyperledger-labs/orion-server/pkg/server/testutils"
+	"github.com/hyperledger-labs/orion-server/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestRecorder_Interceptor(t *testing.T) {
+	dir := t.TempDir()
+	recorder, err := NewRestRecorder(dir)
+	require.NoError(t, err)
+
+	var count int
+	httpClient := httpClientFunc(func(req *http.Request) (*http.Response, error) {
+		count++
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       ioutil.NopCloser(strings.NewReader(req.URL.Path + "-" + string(rune('0'+count)))),
+		}, nil
+	})
+	// the interceptors wrap the http client of the rest client, as they wrap the one of a session
+	intercepted := func(interceptor sdkconfig.RequestInterceptor, httpClient HttpClient) RestClient {
+		return NewRestClient("alice", httpClientFunc(func(req *http.Request) (*http.Response, error) {
+			return interceptor(requestInfo(req.Context()), req, httpClient.Do)
+		}), nil)
+	}
+	restClient := intercepted(recorder.Interceptor(), httpClient)
+
+	readBody := func(resp *http.Response) string {
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	resp, err := restClient.Query(context.Background(), "http://node1:6001/data/bdb/key1", http.MethodGet, nil, []byte("sig"))
+	require.NoError(t, err)
+	require.Equal(t, "/data/bdb/key1-1", readBody(resp))
+	resp, err = restClient.Query(context.Background(), "http://node1:6001/data/bdb/key1", http.MethodGet, nil, []byte("sig"))
+	require.NoError(t, err)
+	require.Equal(t, "/data/bdb/key1-2", readBody(resp))
+	resp, err = restClient.Submit(context.Background(), "http://node1:6001/data/tx", &types.DataTxEnvelope{}, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "/data/tx-3", readBody(resp))
+
+	replayer, err := NewRestReplayer(dir)
+	require.NoError(t, err)
+	restClient = intercepted(replayer.Interceptor(), httpClientFunc(func(req *http.Request) (*http.Response, error) {
+		t.Fatalf("unexpected request [%s %s] sent by the replayer", req.Method, req.URL)
+		return nil, nil
+	}))
+
+	// the host of the endpoint is ignored, and the last response is served again once the responses are exhausted
+	for _, expected := range []string{"/data/bdb/key1-1", "/data/bdb/key1-2", "/data/bdb/key1-2"} {
+		resp, err = restClient.Query(context.Background(), "http://127.0.0.1:7001/data/bdb/key1", http.MethodGet, nil, nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		require.Equal(t, expected, readBody(resp))
+	}
+	resp, err = restClient.Submit(context.Background(), "http://node1:6001/data/tx", &types.DataTxEnvelope{}, 0)
+	require.NoError(t, err)
+	require.Equal(t, "/data/tx-3", readBody(resp))
+
+	_, err = restClient.Query(context.Background(), "http://node1:6001/data/bdb/key2", http.MethodGet, nil, nil)
+	require.EqualError(t, err, "no recorded exchange for request [GET /data/bdb/key2]")
+
+	_, err = NewRestReplayer(t.TempDir())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "no fixture found in")
+
+	_, err = NewRestRecorder(dir)
+	require.NoError(t, err)
+	_, err = NewRestReplayer(dir)
+	require.Error(t, err)
+}
+
+func TestRestRecorder_Session(t *testing.T) {
+	clientCertTemDir := testutils.GenerateTestCrypto(t, []string{"admin", "server"})
+	testServer, _, _, err := SetupTestServer(t, clientCertTemDir)
+	require.NoError(t, err)
+	StartTestServer(t, testServer)
+	stopped := false
+	defer func() {
+		if !stopped {
+			testServer.Stop()
+		}
+	}()
+
+	serverPort, err := testServer.Port()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	recorder, err := NewRestRecorder(dir)
+	require.NoError(t, err)
+
+	sessionConfig := func(interceptor sdkconfig.RequestInterceptor) *sdkconfig.SessionConfig {
+		return &sdkconfig.SessionConfig{
+			UserConfig: &sdkconfig.UserConfig{
+				UserID:         "admin",
+				CertPath:       path.Join(clientCertTemDir, "admin.pem"),
+				PrivateKeyPath: path.Join(clientCertTemDir, "admin.key"),
+			},
+			TxTimeout:    20 * time.Second,
+			Interceptors: []sdkconfig.RequestInterceptor{interceptor},
+		}
+	}
+	run := func(session DBSession) *types.TxReceipt {
+		tx, err := session.DataTx(WithTxID("replayed-tx"))
+		require.NoError(t, err)
+		require.NoError(t, tx.Put("bdb", "key1", []byte("value1"), nil))
+		_, receiptEnv, err := tx.Commit(true)
+		require.NoError(t, err)
+
+		tx, err = session.DataTx()
+		require.NoError(t, err)
+		val, _, err := tx.Get("bdb", "key1")
+		require.NoError(t, err)
+		require.Equal(t, []byte("value1"), val)
+		require.NoError(t, tx.Abort())
+
+		ledger, err := session.Ledger()
+		require.NoError(t, err)
+		receipt, err := ledger.GetTransactionReceipt("replayed-tx")
+		require.NoError(t, err)
+		require.Equal(t, receiptEnv.GetResponse().GetReceipt().GetHeader(), receipt.GetHeader())
+		return receipt
+	}
+
+	session, err := createDBInstance(t, clientCertTemDir, serverPort).Session(sessionConfig(recorder.Interceptor()))
+	require.NoError(t, err)
+	recorded := run(session)
+
+	// the replay is served without any server
+	require.NoError(t, testServer.Stop())
+	stopped = true
+
+	replayer, err := NewRestReplayer(dir)
+	require.NoError(t, err)
+	session, err = createDBInstance(t, clientCertTemDir, serverPort).Session(sessionConfig(replayer.Interceptor()))
+	require.NoError(t, err)
+	require.Equal(t, recorded, run(session))
+
+	t.Run("tampered response", func(t *testing.T) {
+		fixtures, err := readFixtures(dir)
+		require.NoError(t, err)
+		for name, fixture := range fixtures {
+			if strings.Contains(fixture, "/data/bdb/") {
+				tampered := strings.Replace(fixture, "dmFsdWUx", "dmFsdWUy", 1) // base64 of value1 and value2
+				require.NotEqual(t, fixture, tampered)
+				require.NoError(t, ioutil.WriteFile(name, []byte(tampered), 0644))
+			}
+		}
+
+		replayer, err := NewRestReplayer(dir)
+		require.NoError(t, err)
+		session, err := createDBInstance(t, clientCertTemDir, serverPort).Session(sessionConfig(replayer.Interceptor()))
+		require.NoError(t, err)
+		tx, err := session.DataTx()
+		require.NoError(t, err)
+		_, _, err = tx.Get("bdb", "key1")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "signature")
+	})
+}
+
+func readFixtures(dir string) (map[string]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	fixtures := make(map[string]string)
+	for _, entry := range entries {
+		name := path.Join(dir, entry.Name())
+		content, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		fixtures[name] = string(content)
+	}
+	return fixtures, nil
+}
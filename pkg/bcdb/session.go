@@ -15,6 +15,8 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hyperledger-labs/orion-sdk-go/internal"
@@ -30,24 +32,60 @@ import (
 	"google.golang.org/protobuf/encoding/protojson"
 )
 
-// TODO refresh replicaSet and signature verifier when cluster config changes.
+// defaultClusterStatusTimeout bounds a cluster status request of a session without a query timeout
+const defaultClusterStatusTimeout = 10 * time.Second
+
+// dbSession is safe for concurrent use: the credentials and the replica set are guarded by their locks and are
+// replaced as a whole, never mutated in place, so that a transaction context keeps a consistent snapshot of them.
+// The remaining fields are set when the session is created and are read only.
 type dbSession struct {
-	userID               string
-	signer               Signer
-	verifier             SignatureVerifier
-	userCert             []byte
+	*replicaSetView
+	userID             string
+	credentialsLock    sync.RWMutex // guards signer, userCert, userConfig, clientTLS, clientTlsConfig, transport and restClient
+	signer             Signer
+	userCert           []byte
+	userConfig         *config.UserConfig
+	clientTLS          config.ClientTLSConfig
+	rootCAs            *certificateauthority.CACertCollection
+	tlsEnabled         bool
+	tlsRootCAs         *certificateauthority.CACertCollection
+	clientAuthRequired bool
+	clientTlsConfig    *tls.Config
+	transport          *http.Transport // reused by all the requests of the session
+	managed            bool            // the session shares the transport and the view of a SessionManager
+	stop               chan struct{}   // closed by Close, nil if the session is managed
+	closeOnce          sync.Once
+	txTimeout          time.Duration
+	queryTimeout       time.Duration
+	logger             *logger.SugarLogger
+	restClient         RestClient
+	metrics            *sessionMetrics
+	interceptors       []config.RequestInterceptor
+	wrapTransport      func(http.RoundTripper) http.RoundTripper
+	readCache          *readCache // nil if the read cache is disabled
+}
+
+// replicaSetView is the view of the cluster a session uses, i.e., the replica set and the signature verifier built
+// from the latest cluster status. The sessions of a SessionManager share a single view, so that the cluster status
+// is queried once for all of them.
+type replicaSetView struct {
+	replicaSetLock       sync.RWMutex // guards replicaSet, replicaSetVersion, leader and verifier
 	replicaSet           internal.ReplicaSet
 	replicaSetVersion    *types.Version
-	rootCAs              *certificateauthority.CACertCollection
-	tlsEnabled           bool
-	tlsRootCAs           *certificateauthority.CACertCollection
-	clientAuthRequired   bool
-	clientTlsConfig      *tls.Config
-	txTimeout            time.Duration
-	queryTimeout         time.Duration
-	logger               *logger.SugarLogger
-	restClient           RestClient
-	updateReplicaSetFlag bool
+	leader               string
+	verifier             SignatureVerifier
+	updateReplicaSetFlag atomic.Bool
+	refreshLock          sync.Mutex // guards refreshing
+	refreshing           *replicaSetRefresh
+	health               *internal.ReplicaHealth // nil if health tracking is disabled
+	stop                 <-chan struct{}         // closed when the sessions of the view are closed, stops the probes
+}
+
+// replicaSetRefresh is an update of the replica set in progress, which concurrent refresh requests wait for
+// instead of querying the cluster again
+type replicaSetRefresh struct {
+	done chan struct{}
+	err  error
 }
 
 // TxContextOption is a function that operates on a commonTxContext and applies a configuration option.
@@ -75,6 +113,18 @@ func WithTxID(txID string) TxContextOption {
 	}
 }
 
+// TxIDFromOptions returns the txID provided by the options, or an empty string if none of them provides one. It lets
+// implementations of DBSession outside this package, e.g. the fake of package bcdbtest, honor the options.
+func TxIDFromOptions(options ...TxContextOption) (string, error) {
+	txCtx := &commonTxContext{}
+	for _, opt := range options {
+		if err := opt(txCtx); err != nil {
+			return "", errors.WithMessage(err, "error while applying option")
+		}
+	}
+	return txCtx.txID, nil
+}
+
 // UsersTx returns user's transaction context
 func (d *dbSession) UsersTx() (UsersTxContext, error) {
 	commonCtx, err := d.newCommonTxContext()
@@ -203,14 +253,64 @@ func (d *dbSession) Query() (Query, error) {
 
 func (d *dbSession) ReplicaSet(refresh bool) ([]*config.Replica, error) {
 	if refresh {
-		httpClient := newHTTPClient(d.tlsEnabled, d.clientTlsConfig, nil)
-		if err := d.updateReplicaSetAndVerifier(httpClient, d.tlsEnabled); err != nil {
+		if err := d.refreshReplicaSet(); err != nil {
 			d.logger.Errorf("cannot update the replica set and signature verifier, error: %s", err)
 			return nil, errors.Wrap(err, "cannot update the replica set and signature verifier")
 		}
 	}
 
-	return d.replicaSet.ToConfigReplicaSet(), nil
+	replicaSet, _ := d.currentReplicaSet()
+	return replicaSet.ToConfigReplicaSet(), nil
+}
+
+// Close stops the probes of the replicas and closes the idle connections of the session, unless it is managed
+func (d *dbSession) Close() {
+	if d.managed {
+		return
+	}
+	d.closeOnce.Do(func() {
+		close(d.stop)
+		d.credentialsLock.RLock()
+		d.transport.CloseIdleConnections()
+		d.credentialsLock.RUnlock()
+	})
+}
+
+// refreshReplicaSet updates the replica set and the signature verifier from the cluster. Concurrent calls are
+// coalesced: a call made while an update is in progress waits for it and returns its result, so that many
+// transactions retrying at once query the cluster only once.
+func (d *dbSession) refreshReplicaSet() error {
+	d.refreshLock.Lock()
+	if inProgress := d.refreshing; inProgress != nil {
+		d.refreshLock.Unlock()
+		<-inProgress.done
+		return inProgress.err
+	}
+	refresh := &replicaSetRefresh{done: make(chan struct{})}
+	d.refreshing = refresh
+	d.refreshLock.Unlock()
+
+	// the flag is cleared before querying the cluster, so that a redirect that occurs during the update is not lost
+	redirected := d.updateReplicaSetFlag.Swap(false)
+	httpClient := newInterceptingHttpClient(d.httpClient(nil), d.interceptors)
+	if refresh.err = d.updateReplicaSetAndVerifier(httpClient, d.tlsEnabled); refresh.err != nil && redirected {
+		d.updateReplicaSetFlag.Store(true)
+	}
+
+	d.refreshLock.Lock()
+	d.refreshing = nil
+	d.refreshLock.Unlock()
+	close(refresh.done)
+
+	return refresh.err
+}
+
+// currentReplicaSet returns the replica set of the session and the signature verifier built along with it
+func (d *dbSession) currentReplicaSet() (internal.ReplicaSet, SignatureVerifier) {
+	d.replicaSetLock.RLock()
+	defer d.replicaSetLock.RUnlock()
+
+	return d.replicaSet, d.verifier
 }
 
 func (d *dbSession) newCommonTxContext(options ...TxContextOption) (*commonTxContext, error) {
@@ -220,34 +320,42 @@ func (d *dbSession) newCommonTxContext(options ...TxContextOption) (*commonTxCon
 		if len(via) > 10 {
 			return errors.Errorf("Too many redirects: url: '%s', referrer: '%s', #via: %d", req.URL, req.Referer(), len(via))
 		}
-		d.updateReplicaSetFlag = true
+		d.updateReplicaSetFlag.Store(true)
 		return nil
 	}
 
 	// updateReplicaSetFlag becomes true when redirection occurred.
 	// In this case we want to update replica set for future requests when they are created
-	if d.updateReplicaSetFlag {
+	if d.updateReplicaSetFlag.Load() {
 		_, errRefreshRes := d.ReplicaSet(true)
 		if errRefreshRes != nil {
 			return nil, errRefreshRes
 		}
-		d.updateReplicaSetFlag = false
 	}
 
+	// the transaction context keeps the credentials of the session at its creation, even if they are rotated later
+	d.credentialsLock.Lock()
 	if d.restClient == nil {
-		d.restClient = NewRestClient(d.userID, newHTTPClient(d.tlsEnabled, d.clientTlsConfig, checkRedirectPolicyFunc), d.signer)
+		httpClient := newInterceptingHttpClient(d.httpClientLocked(checkRedirectPolicyFunc), d.interceptors)
+		d.restClient = NewRestClient(d.userID, httpClient, d.signer)
 	}
+	signer, userCert, restClient := d.signer, d.userCert, d.restClient
+	d.credentialsLock.Unlock()
+	replicaSet, verifier := d.currentReplicaSet()
 
 	commonTxCtx := &commonTxContext{
 		userID:        d.userID,
-		signer:        d.signer,
-		userCert:      d.userCert,
-		replicaSet:    d.replicaSet,
-		verifier:      d.verifier,
-		restClient:    d.restClient,
+		signer:        signer,
+		userCert:      userCert,
+		replicaSet:    replicaSet,
+		verifier:      verifier,
+		restClient:    restClient,
+		health:        d.health,
+		readCache:     d.readCache,
 		commitTimeout: d.txTimeout,
 		queryTimeout:  d.queryTimeout,
 		logger:        d.logger,
+		metrics:       d.metrics,
 		dbSession:     d,
 	}
 
@@ -259,7 +367,7 @@ func (d *dbSession) newCommonTxContext(options ...TxContextOption) (*commonTxCon
 
 	if len(commonTxCtx.txID) == 0 {
 		var err error
-		commonTxCtx.txID, err = computeTxID(d.userCert)
+		commonTxCtx.txID, err = computeTxID(userCert)
 		if err != nil {
 			return nil, err
 		}
@@ -270,15 +378,15 @@ func (d *dbSession) newCommonTxContext(options ...TxContextOption) (*commonTxCon
 
 // updateReplicaSetAndVerifier connects to the cluster, pulls the most recent cluster status, builds a signature
 // verifier from it, and updates the replica-set.
-func (d *dbSession) updateReplicaSetAndVerifier(httpClient *http.Client, tlsEnabled bool) error {
+func (d *dbSession) updateReplicaSetAndVerifier(httpClient HttpClient, tlsEnabled bool) error {
 	// get the latest status from replica set
 	clusterStatusEnv, err := d.getLatestClusterStatus(httpClient)
 	if err != nil {
 		return errors.Wrap(err, "failed to obtain the latest cluster status")
 	}
 
-	if d.replicaSetVersion != nil && compareVersion(clusterStatusEnv.GetResponse().GetVersion(), d.replicaSetVersion) < 0 {
-		d.logger.Debugf("Cluster config version from server: [%v] is smaller than the latest replica set version: [%v], skipping update.", clusterStatusEnv.GetResponse().GetVersion(), d.replicaSetVersion)
+	if version := d.currentReplicaSetVersion(); version != nil && compareVersion(clusterStatusEnv.GetResponse().GetVersion(), version) < 0 {
+		d.logger.Debugf("Cluster config version from server: [%v] is smaller than the latest replica set version: [%v], skipping update.", clusterStatusEnv.GetResponse().GetVersion(), version)
 		return nil
 	}
 
@@ -293,27 +401,58 @@ func (d *dbSession) updateReplicaSetAndVerifier(httpClient *http.Client, tlsEnab
 		return errors.Wrap(err, "failed to create replica set with role from cluster status")
 	}
 
+	d.replicaSetLock.Lock()
+	defer d.replicaSetLock.Unlock()
+
+	// a concurrent update may have already applied a more recent cluster status
+	if d.replicaSetVersion != nil && compareVersion(clusterStatusEnv.GetResponse().GetVersion(), d.replicaSetVersion) < 0 {
+		return nil
+	}
 	d.verifier = verifier
 	d.replicaSet = replicaSet
 	d.replicaSetVersion = clusterStatusEnv.GetResponse().GetVersion()
-	d.updateReplicaSetFlag = false
+	d.leader = clusterStatusEnv.GetResponse().GetLeader()
 	d.logger.Debugf("updated replica set, version: %+v, set: %v", d.replicaSetVersion, d.replicaSet)
 
 	return nil
 }
 
-func (d *dbSession) getClusterStatusFrom(replica *url.URL, httpClient *http.Client) (*types.GetClusterStatusResponseEnvelope, error) {
+func (d *dbSession) currentReplicaSetVersion() *types.Version {
+	d.replicaSetLock.RLock()
+	defer d.replicaSetLock.RUnlock()
+
+	return d.replicaSetVersion
+}
+
+func (d *dbSession) getClusterStatusFrom(ctx context.Context, replicaID string, replica *url.URL, httpClient HttpClient) (*types.GetClusterStatusResponseEnvelope, error) {
 	getStatus := &url.URL{
 		Path: constants.GetClusterStatus,
 	}
 	statusREST := replica.ResolveReference(getStatus)
-	ctx := context.TODO()
+	// a replica that does not respond must not block the update of the replica set, nor the transactions waiting
+	// for it
+	timeout := d.queryTimeout
+	if timeout <= 0 {
+		timeout = defaultClusterStatusTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ctx = withRequestInfo(ctx, &config.RequestInfo{
+		Operation: operationClusterStatus,
+		UserID:    d.userID,
+		Replica:   replicaID,
+		Attempt:   1,
+	})
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, statusREST.String(), nil)
 	if err != nil {
 		return nil, err
 	}
 
-	signature, err := cryptoservice.SignQuery(d.signer, &types.GetClusterStatusQuery{
+	d.credentialsLock.RLock()
+	signer := d.signer
+	d.credentialsLock.RUnlock()
+
+	signature, err := cryptoservice.SignQuery(signer, &types.GetClusterStatusQuery{
 		UserId:         d.userID,
 		NoCertificates: false,
 	})
@@ -325,11 +464,15 @@ func (d *dbSession) getClusterStatusFrom(replica *url.URL, httpClient *http.Clie
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set(constants.UserHeader, d.userID)
 	req.Header.Set(constants.SignatureHeader, base64.StdEncoding.EncodeToString(signature))
+	start := time.Now()
 	response, err := httpClient.Do(req)
+	replicaSet, _ := d.currentReplicaSet()
+	d.recordReplicaResult(replicaSet, &internal.ReplicaWithRole{Id: replicaID, URL: replica}, time.Since(start), response, err)
 	if err != nil {
 		d.logger.Errorf("failed to send transaction to server %s, due to %s", getStatus.String(), err)
 		return nil, err
 	}
+	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusOK {
 		d.logger.Errorf("error response from the server, %s", response.Status)
@@ -343,7 +486,10 @@ func (d *dbSession) getClusterStatusFrom(replica *url.URL, httpClient *http.Clie
 		return nil, err
 	}
 
-	err = protojson.Unmarshal(responseBytes, resEnv)
+	if err = protojson.Unmarshal(responseBytes, resEnv); err != nil {
+		d.logger.Errorf("failed to unmarshal the cluster status response from %s, due to %s", replica.String(), err)
+		return nil, errors.Wrap(err, "failed to unmarshal the cluster status response")
+	}
 
 	statusResp := resEnv.GetResponse()
 
@@ -354,13 +500,15 @@ func (d *dbSession) getClusterStatusFrom(replica *url.URL, httpClient *http.Clie
 
 // getLatestClusterStatus get the most updated cluster status out of all servers in the replica set.
 // If the replica set is empty, use the bootstrap replica set.
-func (d *dbSession) getLatestClusterStatus(httpClient *http.Client) (*types.GetClusterStatusResponseEnvelope, error) {
+func (d *dbSession) getLatestClusterStatus(httpClient HttpClient) (*types.GetClusterStatusResponseEnvelope, error) {
 	latestStatusEnv := &types.GetClusterStatusResponseEnvelope{}
 	latestFrom := ""
 	var lastErr error
 
-	for _, replica := range d.replicaSet {
-		statusRespEnv, err := d.getClusterStatusFrom(replica.URL, httpClient)
+	// replicas with an open circuit are skipped, they are probed in the background
+	replicaSet, _ := d.currentReplicaSet()
+	for _, replica := range replicaSet.Available(d.health) {
+		statusRespEnv, err := d.getClusterStatusFrom(context.Background(), replica.Id, replica.URL, httpClient)
 		if err != nil {
 			d.logger.Debugf("Failed to get cluster status from server: %s; because: %s", replica.String(), err)
 			lastErr = err
@@ -377,7 +525,7 @@ func (d *dbSession) getLatestClusterStatus(httpClient *http.Client) (*types.GetC
 	}
 
 	if latestStatusEnv.GetResponse() == nil {
-		return nil, errors.New(fmt.Sprintf("failed to get cluster status from replica set: %+v; version: %+v, last error: %s", d.replicaSet, d.replicaSetVersion, lastErr))
+		return nil, errors.New(fmt.Sprintf("failed to get cluster status from replica set: %+v; version: %+v, last error: %s", replicaSet, d.currentReplicaSetVersion(), lastErr))
 	}
 
 	d.logger.Debugf("Latest cluster status (from: %s) is: %+v", latestFrom, latestStatusEnv.GetResponse())
@@ -430,28 +578,45 @@ func (d *dbSession) createSignatureVerifier(clusterStatusEnv *types.GetClusterSt
 
 // TODO expose HTTP parameters, make client configurable, with good defaults. See:
 // https://github.com/hyperledger-labs/orion-sdk-go/issues/28
-func newHTTPClient(tlsEnabled bool, tlsConfig *tls.Config, checkRedirectPolicyFunc func(req *http.Request, via []*http.Request) error) *http.Client {
+// httpClient returns an HTTP client over the transport of the session, with its current client TLS configuration
+func (d *dbSession) httpClient(checkRedirectPolicyFunc func(req *http.Request, via []*http.Request) error) *http.Client {
+	d.credentialsLock.RLock()
+	defer d.credentialsLock.RUnlock()
+
+	return d.httpClientLocked(checkRedirectPolicyFunc)
+}
+
+// httpClientLocked is httpClient, to be called with the credentials lock held
+func (d *dbSession) httpClientLocked(checkRedirectPolicyFunc func(req *http.Request, via []*http.Request) error) *http.Client {
+	var transport http.RoundTripper = d.transport
+	if d.wrapTransport != nil {
+		transport = d.wrapTransport(transport)
+	}
+	return &http.Client{
+		Transport:     transport,
+		CheckRedirect: checkRedirectPolicyFunc,
+	}
+}
+
+func newHTTPTransport(tlsEnabled bool, tlsConfig *tls.Config) *http.Transport {
 	dialer := &net.Dialer{
 		Timeout:   30 * time.Second,
 		KeepAlive: 30 * time.Second,
 	}
-	httpClient := &http.Client{
-		Transport: &http.Transport{
-			Proxy:                 http.ProxyFromEnvironment,
-			DialContext:           dialer.DialContext,
-			ForceAttemptHTTP2:     true,
-			MaxIdleConns:          100,
-			MaxIdleConnsPerHost:   100,
-			IdleConnTimeout:       90 * time.Second,
-			TLSHandshakeTimeout:   10 * time.Second,
-			ExpectContinueTimeout: 1 * time.Second,
-		},
-		CheckRedirect: checkRedirectPolicyFunc,
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
 	}
 	if tlsEnabled {
-		httpClient.Transport.(*http.Transport).TLSClientConfig = tlsConfig
+		transport.TLSClientConfig = tlsConfig
 	}
-	return httpClient
+	return transport
 }
 
 func computeTxID(userCert []byte) (string, error) {
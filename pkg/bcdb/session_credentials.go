@@ -0,0 +1,203 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+package bcdb
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/pem"
+	"os"
+	"time"
+
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/config"
+	"github.com/hyperledger-labs/orion-server/pkg/crypto"
+	"github.com/pkg/errors"
+)
+
+// credentialsProbe is the message signed with a new signer, to check that it matches the new certificate
+var credentialsProbe = []byte("orion-sdk credentials rotation probe")
+
+// RotateCredentials atomically replaces the credentials of the session. The new signer is checked to match the new
+// certificate, and the new certificate is checked to be issued by the cluster CAs, before the swap. Note that the
+// server verifies the signatures of the user with the certificate in its user record, which must be updated to the
+// new certificate by an admin.
+func (d *dbSession) RotateCredentials(userConfig *config.UserConfig, clientTLS *config.ClientTLSConfig) error {
+	if userConfig == nil {
+		return errors.New("user configuration is nil")
+	}
+	if userConfig.UserID != d.userID {
+		return errors.Errorf("user ID [%s] differs from the session user ID [%s]", userConfig.UserID, d.userID)
+	}
+	if clientTLS != nil && !d.clientAuthRequired {
+		return errors.New("client TLS certificate cannot be rotated, as the cluster does not require client TLS authentication")
+	}
+	if clientTLS != nil && d.managed {
+		return errors.New("client TLS certificate cannot be rotated, as it is shared by the sessions of the session manager")
+	}
+
+	signer, err := loadUserSigner(userConfig, d.logger)
+	if err != nil {
+		return err
+	}
+	certBytes, err := loadUserCertificate(userConfig, d.logger)
+	if err != nil {
+		return err
+	}
+	if err = d.checkCredentials(signer, certBytes); err != nil {
+		d.logger.Errorf("cannot rotate the credentials of user [%s], due to %s", d.userID, err)
+		return err
+	}
+
+	var clientKeyPair tls.Certificate
+	if clientTLS != nil {
+		if clientKeyPair, err = loadClientKeyPair(clientTLS, d.logger); err != nil {
+			return err
+		}
+	}
+
+	newUserConfig := *userConfig
+
+	d.credentialsLock.Lock()
+	defer d.credentialsLock.Unlock()
+
+	d.signer = signer
+	d.userCert = certBytes
+	d.userConfig = &newUserConfig
+	if clientTLS != nil {
+		clientTlsConfig := d.clientTlsConfig.Clone()
+		clientTlsConfig.Certificates = []tls.Certificate{clientKeyPair}
+		d.clientTlsConfig = clientTlsConfig
+		d.clientTLS = *clientTLS
+		// the idle connections of the old transport are authenticated with the old key pair
+		d.transport.CloseIdleConnections()
+		d.transport = newHTTPTransport(d.tlsEnabled, clientTlsConfig)
+	}
+	// the REST client signs and connects with the old credentials, so a new one is created for new transactions
+	d.restClient = nil
+
+	d.logger.Infof("rotated the credentials of user [%s]", d.userID)
+	return nil
+}
+
+// WatchCredentials starts polling the files of the credentials of the session, as configured when the session was
+// created or last rotated, until the context is done. Credentials provided directly, i.e., a signer or certificate
+// bytes, are not watched.
+func (d *dbSession) WatchCredentials(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return errors.Errorf("watch interval must be positive, got %s", interval)
+	}
+	if len(d.credentialFiles()) == 0 {
+		return errors.New("the session has no credential files to watch")
+	}
+
+	digest, err := d.credentialFilesDigest()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			newDigest, err := d.credentialFilesDigest()
+			if err != nil {
+				d.logger.Warnf("failed to read the credential files of user [%s], due to %s", d.userID, err)
+				continue
+			}
+			if bytes.Equal(digest, newDigest) {
+				continue
+			}
+
+			d.credentialsLock.RLock()
+			userConfig := *d.userConfig
+			clientTLS := d.clientTLS
+			d.credentialsLock.RUnlock()
+
+			var clientTLSToRotate *config.ClientTLSConfig
+			if d.ownsClientTLS() {
+				clientTLSToRotate = &clientTLS
+			}
+			if err = d.RotateCredentials(&userConfig, clientTLSToRotate); err != nil {
+				d.logger.Warnf("failed to rotate the credentials of user [%s], will retry, due to %s", d.userID, err)
+				continue
+			}
+			digest = newDigest
+		}
+	}()
+
+	return nil
+}
+
+// credentialFiles returns the paths of the credential files of the session
+func (d *dbSession) credentialFiles() []string {
+	d.credentialsLock.RLock()
+	defer d.credentialsLock.RUnlock()
+
+	var files []string
+	if len(d.userConfig.Cert) == 0 && d.userConfig.CertPath != "" {
+		files = append(files, d.userConfig.CertPath)
+	}
+	if d.userConfig.Signer == nil && d.userConfig.PrivateKeyPath != "" {
+		files = append(files, d.userConfig.PrivateKeyPath)
+	}
+	if d.ownsClientTLS() {
+		files = append(files, d.clientTLS.ClientCertificatePath, d.clientTLS.ClientKeyPath)
+	}
+	return files
+}
+
+// ownsClientTLS returns true if the session authenticates with its own client TLS certificate, which is not the
+// case when the cluster does not require client TLS authentication, or when the certificate is shared by the
+// sessions of a session manager
+func (d *dbSession) ownsClientTLS() bool {
+	return d.clientAuthRequired && !d.managed
+}
+
+// credentialFilesDigest returns a digest of the content of all the credential files, which changes whenever any of
+// them changes, including when a file is replaced by a symbolic link swap
+func (d *dbSession) credentialFilesDigest() ([]byte, error) {
+	h := sha256.New()
+	for _, file := range d.credentialFiles() {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		fileHash := sha256.Sum256(content)
+		h.Write(fileHash[:])
+	}
+	return h.Sum(nil), nil
+}
+
+// checkCredentials checks that the certificate is issued by the cluster CAs, and that the signer signs with the
+// private key of the certificate
+func (d *dbSession) checkCredentials(signer Signer, certPEM []byte) error {
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return errors.New("failed to decode the user certificate")
+	}
+	if err := d.rootCAs.VerifyLeafCert(certBlock.Bytes); err != nil {
+		return errors.WithMessage(err, "the user certificate is not issued by the cluster CAs")
+	}
+
+	verifier, err := crypto.NewVerifier(certBlock.Bytes)
+	if err != nil {
+		return errors.WithMessage(err, "failed to create a verifier from the user certificate")
+	}
+	signature, err := signer.Sign(credentialsProbe)
+	if err != nil {
+		return errors.WithMessage(err, "failed to sign with the new signer")
+	}
+	if err = verifier.Verify(credentialsProbe, signature); err != nil {
+		return errors.New("the signer does not match the user certificate")
+	}
+	return nil
+}
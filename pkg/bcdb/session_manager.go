@@ -0,0 +1,266 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+package bcdb
+
+import (
+	"crypto/tls"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/config"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SessionManager caches the sessions of many users to the same cluster, e.g. in a gateway that acts on behalf of its
+// users. The sessions share a single HTTP transport, and a single replica set and signature verifier, so that the
+// cluster status is queried once for all of them. A SessionManager is safe for concurrent use.
+type SessionManager interface {
+	// Session returns the session of the user. The session is opened on first use, with the configuration of the
+	// user from the identity source, and is cached until it is evicted.
+	Session(userID string) (DBSession, error)
+	// Evict removes the session of the user from the cache, e.g. after the credentials of the user changed in the
+	// identity source. Sessions already returned remain usable.
+	Evict(userID string)
+	// Close stops evicting idle sessions and probing the replicas, and removes all the sessions from the cache.
+	// Sessions already returned remain usable.
+	Close()
+}
+
+// SessionManagerConfig is the configuration of a SessionManager
+type SessionManagerConfig struct {
+	// IdentitySource provides the configuration of a user when its session is first requested
+	IdentitySource IdentitySource
+	// The transaction and query timeouts of all the sessions, see config.SessionConfig
+	TxTimeout    time.Duration
+	QueryTimeout time.Duration
+	// Client side TLS configuration, shared by all the sessions, when the cluster requires client TLS authentication
+	ClientTLS config.ClientTLSConfig
+	// IdleTimeout is the time after which a session that was not requested is evicted. Zero means that sessions are
+	// never evicted.
+	IdleTimeout time.Duration
+	// MetricsRegisterer, if not nil, is the registerer of the client side metrics of all the sessions
+	MetricsRegisterer prometheus.Registerer
+	// Interceptors wrap every request of all the sessions, see config.SessionConfig
+	Interceptors []config.RequestInterceptor
+	// WrapTransport, if not nil, wraps the HTTP transport of every session, see config.SessionConfig
+	WrapTransport func(http.RoundTripper) http.RoundTripper
+	// ReadCache, if not nil, enables the read cache of every session, see config.SessionConfig. Each session caches
+	// the reads of its own user, as the access control of the reads is per user.
+	ReadCache *config.ReadCacheConfig
+}
+
+// IdentitySource provides the configuration of the users a SessionManager opens sessions for
+type IdentitySource interface {
+	// UserConfig returns the configuration of the user, i.e., its certificate and signer, or the paths to them
+	UserConfig(userID string) (*config.UserConfig, error)
+}
+
+// IdentitySourceFunc is an adapter to use a function as an IdentitySource
+type IdentitySourceFunc func(userID string) (*config.UserConfig, error)
+
+func (f IdentitySourceFunc) UserConfig(userID string) (*config.UserConfig, error) {
+	return f(userID)
+}
+
+// NewDirIdentitySource returns an IdentitySource over a directory that holds the certificate and the private key
+// of every user, in the files `<userID>.pem` and `<userID>.key`. The passphrase function, which may be nil, is
+// called for encrypted private keys.
+func NewDirIdentitySource(dir string, passphrase config.PassphraseFunc) IdentitySource {
+	return IdentitySourceFunc(func(userID string) (*config.UserConfig, error) {
+		if userID == "" || userID == "." || userID == ".." || filepath.Base(userID) != userID {
+			return nil, errors.Errorf("invalid user ID [%s]", userID)
+		}
+		return &config.UserConfig{
+			UserID:               userID,
+			CertPath:             filepath.Join(dir, userID+".pem"),
+			PrivateKeyPath:       filepath.Join(dir, userID+".key"),
+			PrivateKeyPassphrase: passphrase,
+		}, nil
+	})
+}
+
+type sessionManager struct {
+	db              *bDB
+	conf            SessionManagerConfig
+	clientTlsConfig *tls.Config
+	transport       *http.Transport
+	view            *replicaSetView
+	lock            sync.Mutex // guards sessions and closed
+	sessions        map[string]*managedSession
+	closed          bool
+	stop            chan struct{}
+}
+
+// managedSession is a cached session, which is being opened until ready is closed
+type managedSession struct {
+	ready    chan struct{}
+	session  *dbSession
+	err      error
+	lastUsed time.Time
+}
+
+// NewSessionManager creates a SessionManager over a BCDB instance created by Create
+func NewSessionManager(db BCDB, conf *SessionManagerConfig) (SessionManager, error) {
+	b, ok := db.(*bDB)
+	if !ok {
+		return nil, errors.New("the session manager requires a BCDB instance created by bcdb.Create")
+	}
+	if conf == nil {
+		return nil, errors.New("session manager configuration is nil")
+	}
+	if conf.IdentitySource == nil {
+		return nil, errors.New("identity source is nil")
+	}
+	if conf.IdleTimeout < 0 {
+		return nil, errors.Errorf("idle timeout must not be negative, got %s", conf.IdleTimeout)
+	}
+
+	clientTlsConfig, err := b.clientTLSConfig(&conf.ClientTLS)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &sessionManager{
+		db:              b,
+		conf:            *conf,
+		clientTlsConfig: clientTlsConfig,
+		transport:       newHTTPTransport(b.tlsEnabled, clientTlsConfig),
+		view: &replicaSetView{
+			replicaSet: b.bootstrapReplicaSet(),
+			health:     b.health,
+		},
+		sessions: make(map[string]*managedSession),
+		stop:     make(chan struct{}),
+	}
+	m.view.stop = m.stop
+	if conf.IdleTimeout > 0 {
+		go m.evictIdleSessions()
+	}
+	return m, nil
+}
+
+func (m *sessionManager) Session(userID string) (DBSession, error) {
+	m.lock.Lock()
+	if m.closed {
+		m.lock.Unlock()
+		return nil, errors.New("session manager is closed")
+	}
+	entry, cached := m.sessions[userID]
+	if !cached {
+		entry = &managedSession{ready: make(chan struct{})}
+		m.sessions[userID] = entry
+	}
+	entry.lastUsed = time.Now()
+	m.lock.Unlock()
+
+	if cached {
+		<-entry.ready
+	} else {
+		entry.session, entry.err = m.openSession(userID)
+		if entry.err != nil {
+			// a failed session is not cached, so that the next request tries again
+			m.lock.Lock()
+			if m.sessions[userID] == entry {
+				delete(m.sessions, userID)
+			}
+			m.lock.Unlock()
+		}
+		close(entry.ready)
+	}
+
+	if entry.err != nil {
+		return nil, entry.err
+	}
+	return entry.session, nil
+}
+
+func (m *sessionManager) Evict(userID string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.sessions, userID)
+}
+
+func (m *sessionManager) Close() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.closed {
+		return
+	}
+	m.closed = true
+	m.sessions = make(map[string]*managedSession)
+	close(m.stop)
+	m.transport.CloseIdleConnections()
+}
+
+// openSession opens a session of the user over the transport and the replica set of the manager. The cluster is
+// queried only if the replica set was never updated, e.g. for the first session.
+func (m *sessionManager) openSession(userID string) (*dbSession, error) {
+	userConfig, err := m.conf.IdentitySource.UserConfig(userID)
+	if err != nil {
+		m.db.logger.Errorf("failed to load the identity of user [%s], due to %s", userID, err)
+		return nil, errors.WithMessagef(err, "failed to load the identity of user [%s]", userID)
+	}
+	if userConfig == nil || userConfig.UserID != userID {
+		m.db.logger.Errorf("the identity source returned a configuration of another user for user [%s]", userID)
+		return nil, errors.Errorf("the identity source returned a configuration of another user for user [%s]", userID)
+	}
+
+	session, err := m.db.newSession(&config.SessionConfig{
+		UserConfig:        userConfig,
+		TxTimeout:         m.conf.TxTimeout,
+		QueryTimeout:      m.conf.QueryTimeout,
+		ClientTLS:         m.conf.ClientTLS,
+		MetricsRegisterer: m.conf.MetricsRegisterer,
+		Interceptors:      m.conf.Interceptors,
+		WrapTransport:     m.conf.WrapTransport,
+		ReadCache:         m.conf.ReadCache,
+	}, m.clientTlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	session.replicaSetView = m.view
+	session.transport = m.transport
+	session.managed = true
+
+	if session.currentReplicaSetVersion() == nil {
+		if err = session.refreshReplicaSet(); err != nil {
+			m.db.logger.Errorf("cannot update the replica set and signature verifier, error: %s", err)
+			return nil, errors.Wrap(err, "cannot update the replica set and signature verifier")
+		}
+		replicaSet, _ := session.currentReplicaSet()
+		m.db.updateReplicaMap(replicaSet.ToReplicaMap())
+	}
+
+	return session, nil
+}
+
+// evictIdleSessions periodically removes the sessions that were not requested within the idle timeout
+func (m *sessionManager) evictIdleSessions() {
+	interval := m.conf.IdleTimeout / 2
+	if interval == 0 {
+		interval = m.conf.IdleTimeout
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case now := <-ticker.C:
+			m.lock.Lock()
+			for userID, entry := range m.sessions {
+				if now.Sub(entry.lastUsed) > m.conf.IdleTimeout {
+					delete(m.sessions, userID)
+					m.db.logger.Debugf("evicted the idle session of user [%s]", userID)
+				}
+			}
+			m.lock.Unlock()
+		}
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package bcdb
+
+import (
+	"io/ioutil"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	sdkconfig "github.com/hyperledger-labs/orion-sdk-go/pkg/config"
+	"github.com/hyperledger-labs/orion-server/pkg/server/testutils"
+	"github.com/hyperledger-labs/orion-server/pkg/types"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionManager(t *testing.T) {
+	clientCertTemDir := testutils.GenerateTestCrypto(t, []string{"admin", "alice", "bob", "server"})
+	testServer, _, _, err := SetupTestServer(t, clientCertTemDir)
+	defer testServer.Stop()
+	require.NoError(t, err)
+	StartTestServer(t, testServer)
+
+	bcdb, adminSession := connectAndOpenAdminSession(t, testServer, clientCertTemDir)
+	dbPerm := map[string]types.Privilege_Access{
+		"bdb": 1,
+	}
+	for _, user := range []string{"alice", "bob"} {
+		pemUserCert, err := ioutil.ReadFile(path.Join(clientCertTemDir, user+".pem"))
+		require.NoError(t, err)
+		addUser(t, user, adminSession, pemUserCert, dbPerm)
+	}
+
+	newManager := func(t *testing.T, idleTimeout time.Duration) SessionManager {
+		manager, err := NewSessionManager(bcdb, &SessionManagerConfig{
+			IdentitySource: NewDirIdentitySource(clientCertTemDir, nil),
+			TxTimeout:      20 * time.Second,
+			IdleTimeout:    idleTimeout,
+		})
+		require.NoError(t, err)
+		t.Cleanup(manager.Close)
+		return manager
+	}
+
+	t.Run("sessions are cached and share the replica set and transport", func(t *testing.T) {
+		manager := newManager(t, 0)
+
+		aliceSession, err := manager.Session("alice")
+		require.NoError(t, err)
+		aliceSessionAgain, err := manager.Session("alice")
+		require.NoError(t, err)
+		require.Same(t, aliceSession, aliceSessionAgain)
+
+		bobSession, err := manager.Session("bob")
+		require.NoError(t, err)
+		require.NotSame(t, aliceSession, bobSession)
+
+		aliceImpl := aliceSession.(*dbSession)
+		bobImpl := bobSession.(*dbSession)
+		require.Equal(t, "alice", aliceImpl.userID)
+		require.Equal(t, "bob", bobImpl.userID)
+		require.Same(t, aliceImpl.replicaSetView, bobImpl.replicaSetView)
+		require.Same(t, aliceImpl.transport, bobImpl.transport)
+		require.NotNil(t, aliceImpl.replicaSetVersion)
+
+		putKeySync(t, "bdb", "alice-key", "alice-value", "alice", aliceSession)
+		putKeySync(t, "bdb", "bob-key", "bob-value", "bob", bobSession)
+
+		tx, err := bobSession.DataTx()
+		require.NoError(t, err)
+		val, _, err := tx.Get("bdb", "bob-key")
+		require.NoError(t, err)
+		require.Equal(t, []byte("bob-value"), val)
+		require.NoError(t, tx.Abort())
+
+		// a refresh by one session is seen by all of them
+		aliceImpl.updateReplicaSetFlag.Store(true)
+		_, err = bobSession.DataTx()
+		require.NoError(t, err)
+		require.False(t, aliceImpl.updateReplicaSetFlag.Load())
+	})
+
+	t.Run("concurrent requests open a single session", func(t *testing.T) {
+		manager := newManager(t, 0)
+
+		const numRequests = 16
+		sessions := make(chan DBSession, numRequests)
+		errs := make(chan error, numRequests)
+		var wg sync.WaitGroup
+		for i := 0; i < numRequests; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				session, err := manager.Session("alice")
+				if err != nil {
+					errs <- err
+					return
+				}
+				sessions <- session
+			}()
+		}
+		wg.Wait()
+		close(sessions)
+		close(errs)
+
+		for err := range errs {
+			require.NoError(t, err)
+		}
+		first := <-sessions
+		for session := range sessions {
+			require.Same(t, first, session)
+		}
+	})
+
+	t.Run("failed sessions are not cached", func(t *testing.T) {
+		manager := newManager(t, 0)
+
+		_, err := manager.Session("carol")
+		require.EqualError(t, err, "cannot create signer with user's private key: cannot read user's private key from "+
+			path.Join(clientCertTemDir, "carol.key")+": open "+path.Join(clientCertTemDir, "carol.key")+": no such file or directory")
+		require.Empty(t, manager.(*sessionManager).sessions)
+
+		_, err = manager.Session("../alice")
+		require.EqualError(t, err, "failed to load the identity of user [../alice]: invalid user ID [../alice]")
+		require.Empty(t, manager.(*sessionManager).sessions)
+	})
+
+	t.Run("identity source", func(t *testing.T) {
+		var loaded []string
+		manager, err := NewSessionManager(bcdb, &SessionManagerConfig{
+			IdentitySource: IdentitySourceFunc(func(userID string) (*sdkconfig.UserConfig, error) {
+				loaded = append(loaded, userID)
+				switch userID {
+				case "alice":
+					return &sdkconfig.UserConfig{
+						UserID:         "alice",
+						CertPath:       path.Join(clientCertTemDir, "alice.pem"),
+						PrivateKeyPath: path.Join(clientCertTemDir, "alice.key"),
+					}, nil
+				case "bob":
+					return &sdkconfig.UserConfig{
+						UserID:         "alice",
+						CertPath:       path.Join(clientCertTemDir, "alice.pem"),
+						PrivateKeyPath: path.Join(clientCertTemDir, "alice.key"),
+					}, nil
+				default:
+					return nil, errors.New("unknown user")
+				}
+			}),
+		})
+		require.NoError(t, err)
+		defer manager.Close()
+
+		_, err = manager.Session("alice")
+		require.NoError(t, err)
+		_, err = manager.Session("alice")
+		require.NoError(t, err)
+		_, err = manager.Session("bob")
+		require.EqualError(t, err, "the identity source returned a configuration of another user for user [bob]")
+		_, err = manager.Session("carol")
+		require.EqualError(t, err, "failed to load the identity of user [carol]: unknown user")
+		require.Equal(t, []string{"alice", "bob", "carol"}, loaded)
+	})
+
+	t.Run("evict", func(t *testing.T) {
+		manager := newManager(t, 0)
+
+		session, err := manager.Session("alice")
+		require.NoError(t, err)
+		manager.Evict("alice")
+		newSession, err := manager.Session("alice")
+		require.NoError(t, err)
+		require.NotSame(t, session, newSession)
+		// the evicted session remains usable
+		putKeySync(t, "bdb", "evicted-key", "value", "alice", session)
+	})
+
+	t.Run("idle sessions are evicted", func(t *testing.T) {
+		manager := newManager(t, 200*time.Millisecond)
+
+		session, err := manager.Session("alice")
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			m := manager.(*sessionManager)
+			m.lock.Lock()
+			defer m.lock.Unlock()
+			return len(m.sessions) == 0
+		}, 5*time.Second, 50*time.Millisecond)
+
+		newSession, err := manager.Session("alice")
+		require.NoError(t, err)
+		require.NotSame(t, session, newSession)
+	})
+
+	t.Run("close", func(t *testing.T) {
+		manager := newManager(t, time.Second)
+
+		_, err := manager.Session("alice")
+		require.NoError(t, err)
+		manager.Close()
+		manager.Close()
+		_, err = manager.Session("alice")
+		require.EqualError(t, err, "session manager is closed")
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		_, err := NewSessionManager(nil, &SessionManagerConfig{})
+		require.EqualError(t, err, "the session manager requires a BCDB instance created by bcdb.Create")
+		_, err = NewSessionManager(bcdb, nil)
+		require.EqualError(t, err, "session manager configuration is nil")
+		_, err = NewSessionManager(bcdb, &SessionManagerConfig{})
+		require.EqualError(t, err, "identity source is nil")
+		_, err = NewSessionManager(bcdb, &SessionManagerConfig{
+			IdentitySource: NewDirIdentitySource(clientCertTemDir, nil),
+			IdleTimeout:    -time.Second,
+		})
+		require.EqualError(t, err, "idle timeout must not be negative, got -1s")
+	})
+}
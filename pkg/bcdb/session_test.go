@@ -4,9 +4,14 @@
 package bcdb
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"github.com/hyperledger-labs/orion-sdk-go/internal/test"
 	"io/ioutil"
+	"net/http"
+	"os"
 	"path"
 	"sync"
 	"testing"
@@ -17,6 +22,7 @@ import (
 	"github.com/hyperledger-labs/orion-server/pkg/server/testutils"
 	"github.com/hyperledger-labs/orion-server/pkg/types"
 	"github.com/hyperledger-labs/orion-server/test/setup"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -238,7 +244,7 @@ func TestRedirectionSucceed(t *testing.T) {
 	require.Equal(t, internal.ReplicaRole_LEADER, sessionImpl.replicaSet[0].Role)
 	require.Equal(t, internal.ReplicaRole_FOLLOWER, sessionImpl.replicaSet[1].Role)
 	require.Equal(t, internal.ReplicaRole_FOLLOWER, sessionImpl.replicaSet[2].Role)
-	require.False(t, sessionImpl.updateReplicaSetFlag)
+	require.False(t, sessionImpl.updateReplicaSetFlag.Load())
 
 	// shut down the leader and wait for a new leader
 	require.NoError(t, c.ShutdownServer(c.Servers[originalLeader]))
@@ -255,7 +261,7 @@ func TestRedirectionSucceed(t *testing.T) {
 	require.Equal(t, internal.ReplicaRole_LEADER, sessionImpl2.replicaSet[0].Role)
 	require.Equal(t, internal.ReplicaRole_FOLLOWER, sessionImpl2.replicaSet[1].Role)
 	require.Equal(t, internal.ReplicaRole_UNKNOWN, sessionImpl2.replicaSet[2].Role)
-	require.False(t, sessionImpl2.updateReplicaSetFlag)
+	require.False(t, sessionImpl2.updateReplicaSetFlag.Load())
 
 	// start original leader again, which is now a follower
 	require.NoError(t, c.StartServer(c.Servers[originalLeader]))
@@ -267,7 +273,7 @@ func TestRedirectionSucceed(t *testing.T) {
 			internal.ReplicaRole_LEADER == sessionImpl2.replicaSet[0].Role &&
 			internal.ReplicaRole_FOLLOWER == sessionImpl2.replicaSet[1].Role &&
 			internal.ReplicaRole_FOLLOWER == sessionImpl2.replicaSet[2].Role &&
-			sessionImpl2.updateReplicaSetFlag == false
+			sessionImpl2.updateReplicaSetFlag.Load() == false
 	}, 30*time.Second, 100*time.Millisecond)
 
 	// prepare a data tx
@@ -283,13 +289,13 @@ func TestRedirectionSucceed(t *testing.T) {
 		SignPolicyForWrite: 0,
 	})
 	require.NoError(t, err)
-	require.False(t, sessionImpl.updateReplicaSetFlag)
+	require.False(t, sessionImpl.updateReplicaSetFlag.Load())
 
 	// commit tx
 	// commit should cause a redirection response, means commit succeeds and updateReplicaSetFlag becomes true
 	_, _, err = tx.Commit(true)
 	require.NoError(t, err)
-	require.True(t, sessionImpl.updateReplicaSetFlag)
+	require.True(t, sessionImpl.updateReplicaSetFlag.Load())
 
 	// check commit succeeded and data record exists in db
 	tx, err = session.DataTx()
@@ -305,7 +311,7 @@ func TestRedirectionSucceed(t *testing.T) {
 	err = tx.Put("bdb", "key2", []byte("val2"), nil)
 	require.NoError(t, err)
 
-	require.False(t, sessionImpl.updateReplicaSetFlag)
+	require.False(t, sessionImpl.updateReplicaSetFlag.Load())
 	newLeaderID := c.Servers[newLeader].ID()
 	require.Equal(t, session.(*dbSession).replicaSet[0].Id, newLeaderID)
 
@@ -586,3 +592,514 @@ func TestRetryMechanismShutDownAndRecover(t *testing.T) {
 	require.EqualValues(t, []byte("val2"), val)
 	require.NotNil(t, meta)
 }
+
+func TestDbSession_RotateCredentials(t *testing.T) {
+	clientCertTemDir := testutils.GenerateTestCrypto(t, []string{"admin", "alice", "server"})
+	testServer, _, _, err := SetupTestServer(t, clientCertTemDir)
+	defer testServer.Stop()
+	require.NoError(t, err)
+	bcdb, adminSession, aliceSession := startServerConnectOpenAdminCreateUserAndUserSession(t, testServer, clientCertTemDir, "alice")
+	dbPerm := map[string]types.Privilege_Access{"bdb": types.Privilege_ReadWrite}
+
+	issueAliceCert := func(name string) *sdkconfig.UserConfig {
+		caKeyPair, err := tls.LoadX509KeyPair(path.Join(clientCertTemDir, testutils.RootCAFileName+".pem"), path.Join(clientCertTemDir, testutils.RootCAFileName+".key"))
+		require.NoError(t, err)
+		certPEM, keyPEM, err := testutils.IssueCertificate("Orion cert for alice", "127.0.0.1", caKeyPair)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path.Join(clientCertTemDir, name+".pem"), certPEM, 0600))
+		require.NoError(t, os.WriteFile(path.Join(clientCertTemDir, name+".key"), keyPEM, 0600))
+		return &sdkconfig.UserConfig{
+			UserID:         "alice",
+			CertPath:       path.Join(clientCertTemDir, name+".pem"),
+			PrivateKeyPath: path.Join(clientCertTemDir, name+".key"),
+		}
+	}
+	putKey := func(tx DataTxContext, key string) {
+		require.NoError(t, tx.Put("bdb", key, []byte("value"), nil))
+		_, receiptEnv, err := tx.Commit(true)
+		require.NoError(t, err)
+		receipt := receiptEnv.GetResponse().GetReceipt()
+		require.Equal(t, types.Flag_VALID, receipt.GetHeader().GetValidationInfo()[receipt.GetTxIndex()].GetFlag())
+	}
+
+	t.Run("rotate", func(t *testing.T) {
+		oldTx, err := aliceSession.DataTx()
+		require.NoError(t, err)
+
+		newUserConfig := issueAliceCert("alice-rotated")
+		require.NoError(t, aliceSession.RotateCredentials(newUserConfig, nil))
+
+		// the transaction created before the rotation keeps the old credentials
+		newCert, err := os.ReadFile(newUserConfig.CertPath)
+		require.NoError(t, err)
+		require.NotEqual(t, newCert, oldTx.(*dataTxContext).userCert)
+		putKey(oldTx, "key1")
+
+		// once the user record holds the new certificate, new transactions are signed with the new credentials
+		addUser(t, "alice", adminSession, newCert, dbPerm)
+		newTx, err := aliceSession.DataTx()
+		require.NoError(t, err)
+		require.Equal(t, newCert, newTx.(*dataTxContext).userCert)
+		putKey(newTx, "key2")
+	})
+
+	t.Run("invalid credentials", func(t *testing.T) {
+		err := aliceSession.RotateCredentials(&sdkconfig.UserConfig{
+			UserID:         "bob",
+			CertPath:       path.Join(clientCertTemDir, "alice.pem"),
+			PrivateKeyPath: path.Join(clientCertTemDir, "alice.key"),
+		}, nil)
+		require.EqualError(t, err, "user ID [bob] differs from the session user ID [alice]")
+
+		err = aliceSession.RotateCredentials(&sdkconfig.UserConfig{
+			UserID:         "alice",
+			CertPath:       path.Join(clientCertTemDir, "alice.pem"),
+			PrivateKeyPath: path.Join(clientCertTemDir, "admin.key"),
+		}, nil)
+		require.EqualError(t, err, "the signer does not match the user certificate")
+
+		err = aliceSession.RotateCredentials(&sdkconfig.UserConfig{
+			UserID:         "alice",
+			CertPath:       path.Join(clientCertTemDir, "alice.pem"),
+			PrivateKeyPath: path.Join(clientCertTemDir, "alice.key"),
+		}, &sdkconfig.ClientTLSConfig{})
+		require.EqualError(t, err, "client TLS certificate cannot be rotated, as the cluster does not require client TLS authentication")
+	})
+
+	t.Run("watch", func(t *testing.T) {
+		watchedConfig := issueAliceCert("alice-watched")
+		watchedCert, err := os.ReadFile(watchedConfig.CertPath)
+		require.NoError(t, err)
+		addUser(t, "alice", adminSession, watchedCert, dbPerm)
+		session, err := bcdb.Session(&sdkconfig.SessionConfig{
+			UserConfig: watchedConfig,
+			TxTimeout:  20 * time.Second,
+		})
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		require.NoError(t, session.WatchCredentials(ctx, 20*time.Millisecond))
+
+		// overwrite the watched files with a new certificate and key
+		newUserConfig := issueAliceCert("alice-new")
+		newCert, err := os.ReadFile(newUserConfig.CertPath)
+		require.NoError(t, err)
+		newKey, err := os.ReadFile(newUserConfig.PrivateKeyPath)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(watchedConfig.PrivateKeyPath, newKey, 0600))
+		require.NoError(t, os.WriteFile(watchedConfig.CertPath, newCert, 0600))
+
+		require.Eventually(t, func() bool {
+			tx, err := session.DataTx()
+			return err == nil && bytes.Equal(newCert, tx.(*dataTxContext).userCert)
+		}, 10*time.Second, 20*time.Millisecond)
+
+		addUser(t, "alice", adminSession, newCert, dbPerm)
+		tx, err := session.DataTx()
+		require.NoError(t, err)
+		putKey(tx, "key3")
+
+		err = session.WatchCredentials(ctx, 0)
+		require.EqualError(t, err, "watch interval must be positive, got 0s")
+	})
+}
+
+// Scenario: the cluster watcher reports membership and leadership changes.
+// - start a 3 node cluster, open a session and start watching the cluster
+// - add a 4th node, expect config version change and node added
+// - delete the 4th node, expect config version change and node removed
+// - shutdown the leader, expect a leader change to the new leader
+func TestDbSession_CommitToNewLeader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cluster-test")
+	require.NoError(t, err)
+
+	nPort, pPort := test.GetPorts()
+	setupConfig := &setup.Config{
+		NumberOfServers:     3,
+		TestDirAbsolutePath: dir,
+		BDBBinaryPath:       "../../bin/bdb",
+		CmdTimeout:          10 * time.Second,
+		BaseNodePort:        nPort,
+		BasePeerPort:        pPort,
+	}
+	c, err := setup.NewCluster(setupConfig)
+	require.NoError(t, err)
+	defer c.ShutdownAndCleanup()
+
+	require.NoError(t, c.Start())
+	originalLeader := -1
+	require.Eventually(t, func() bool {
+		originalLeader = c.AgreedLeader(t, 0, 1, 2)
+		return originalLeader >= 0
+	}, 30*time.Second, 100*time.Millisecond)
+
+	connConfig := &sdkconfig.ConnectionConfig{
+		RootCAs: []string{path.Join(setupConfig.TestDirAbsolutePath, "ca", testutils.RootCAFileName+".pem")},
+		ReplicaSet: []*sdkconfig.Replica{
+			{
+				ID:       c.Servers[0].ID(),
+				Endpoint: c.Servers[0].URL(),
+			},
+		},
+	}
+	bcdb, err := Create(connConfig)
+	require.NoError(t, err)
+
+	var lock sync.Mutex
+	var submissions []sdkconfig.RequestInfo
+	recorder := func(info *sdkconfig.RequestInfo, req *http.Request, invoker sdkconfig.RequestInvoker) (*http.Response, error) {
+		if info.Operation == operationCommitSync {
+			lock.Lock()
+			submissions = append(submissions, *info)
+			lock.Unlock()
+		}
+		return invoker(req)
+	}
+	usersDir := path.Join(setupConfig.TestDirAbsolutePath, "users")
+	session, err := bcdb.Session(&sdkconfig.SessionConfig{
+		UserConfig: &sdkconfig.UserConfig{
+			UserID:         "admin",
+			CertPath:       path.Join(usersDir, "admin.pem"),
+			PrivateKeyPath: path.Join(usersDir, "admin.key"),
+		},
+		TxTimeout:    20 * time.Second,
+		Interceptors: []sdkconfig.RequestInterceptor{recorder},
+	})
+	require.NoError(t, err)
+
+	// the transaction is created while the original leader is at the head of the replica set
+	tx, err := session.DataTx()
+	require.NoError(t, err)
+	require.NoError(t, tx.Put("bdb", "key1", []byte("value1"), nil))
+
+	require.NoError(t, c.ShutdownServer(c.Servers[originalLeader]))
+	newLeader := -1
+	require.Eventually(t, func() bool {
+		newLeader = c.AgreedLeader(t, (originalLeader+1)%3, (originalLeader+2)%3)
+		return newLeader >= 0 && newLeader != originalLeader
+	}, 30*time.Second, 100*time.Millisecond)
+
+	// the second attempt goes straight to the leader reported by the cluster status
+	_, _, err = tx.Commit(true)
+	require.NoError(t, err)
+
+	lock.Lock()
+	defer lock.Unlock()
+	require.Len(t, submissions, 2)
+	require.Equal(t, c.Servers[originalLeader].ID(), submissions[0].Replica)
+	require.Equal(t, 1, submissions[0].Attempt)
+	require.Equal(t, c.Servers[newLeader].ID(), submissions[1].Replica)
+	require.Equal(t, 2, submissions[1].Attempt)
+}
+
+func TestDbSession_WatchCluster(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cluster-test")
+	require.NoError(t, err)
+
+	nPort, pPort := test.GetPorts()
+	setupConfig := &setup.Config{
+		NumberOfServers:     3,
+		TestDirAbsolutePath: dir,
+		BDBBinaryPath:       "../../bin/bdb",
+		CmdTimeout:          10 * time.Second,
+		BaseNodePort:        nPort,
+		BasePeerPort:        pPort,
+	}
+	c, err := setup.NewCluster(setupConfig)
+	require.NoError(t, err)
+	defer c.ShutdownAndCleanup()
+
+	require.NoError(t, c.Start())
+	originalLeader := -1
+	require.Eventually(t, func() bool {
+		originalLeader = c.AgreedLeader(t, 0, 1, 2)
+		return originalLeader >= 0
+	}, 30*time.Second, 100*time.Millisecond)
+
+	connConfig := &sdkconfig.ConnectionConfig{
+		RootCAs: []string{path.Join(setupConfig.TestDirAbsolutePath, "ca", testutils.RootCAFileName+".pem")},
+		ReplicaSet: []*sdkconfig.Replica{
+			{
+				ID:       "node-1",
+				Endpoint: c.Servers[0].URL(),
+			},
+		},
+	}
+	bcdb, err := Create(connConfig)
+	require.NoError(t, err)
+	session := openUserSession(t, bcdb, "admin", path.Join(setupConfig.TestDirAbsolutePath, "users"))
+
+	var lock sync.Mutex
+	var leaderChanges [][2]string
+	var added, removed []string
+	var versionChanges int
+	watcherConfig := &ClusterWatcherConfig{
+		Interval: 100 * time.Millisecond,
+		OnLeaderChange: func(oldLeaderID, newLeaderID string) {
+			lock.Lock()
+			defer lock.Unlock()
+			leaderChanges = append(leaderChanges, [2]string{oldLeaderID, newLeaderID})
+		},
+		OnNodeAdded: func(replica *sdkconfig.Replica) {
+			lock.Lock()
+			defer lock.Unlock()
+			added = append(added, replica.ID)
+		},
+		OnNodeRemoved: func(replica *sdkconfig.Replica) {
+			lock.Lock()
+			defer lock.Unlock()
+			removed = append(removed, replica.ID)
+		},
+		OnConfigVersionChange: func(oldVersion, newVersion *types.Version) {
+			lock.Lock()
+			defer lock.Unlock()
+			if compareVersion(newVersion, oldVersion) > 0 {
+				versionChanges++
+			}
+		},
+	}
+
+	t.Run("invalid config", func(t *testing.T) {
+		err := session.WatchCluster(context.Background(), nil)
+		require.EqualError(t, err, "cluster watcher configuration is nil")
+		err = session.WatchCluster(context.Background(), &ClusterWatcherConfig{})
+		require.EqualError(t, err, "watch interval must be positive, got 0s")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, session.WatchCluster(ctx, watcherConfig))
+
+	// the session was created with a partial replica set, the first refresh is not a change of the cluster
+	time.Sleep(500 * time.Millisecond)
+	lock.Lock()
+	require.Empty(t, leaderChanges)
+	require.Empty(t, added)
+	require.Empty(t, removed)
+	require.Zero(t, versionChanges)
+	lock.Unlock()
+
+	tx, err := session.ConfigTx()
+	require.NoError(t, err)
+	clusterConfig, _, err := tx.GetClusterConfig()
+	require.NoError(t, err)
+	err = tx.AddClusterNode(&types.NodeConfig{
+		Id:          "node-4",
+		Address:     "127.0.0.1",
+		Port:        nPort + 3,
+		Certificate: clusterConfig.Nodes[0].Certificate,
+	}, &types.PeerConfig{
+		NodeId:   "node-4",
+		RaftId:   4,
+		PeerHost: "127.0.0.1",
+		PeerPort: pPort + 3,
+	})
+	require.NoError(t, err)
+	_, _, err = tx.Commit(true)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(added) == 1 && versionChanges == 1
+	}, 30*time.Second, 100*time.Millisecond)
+	lock.Lock()
+	require.Equal(t, []string{"node-4"}, added)
+	lock.Unlock()
+	replicas, err := session.ReplicaSet(false)
+	require.NoError(t, err)
+	require.Len(t, replicas, 4)
+
+	tx, err = session.ConfigTx()
+	require.NoError(t, err)
+	require.NoError(t, tx.DeleteClusterNode("node-4"))
+	_, _, err = tx.Commit(true)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(removed) == 1 && versionChanges == 2
+	}, 30*time.Second, 100*time.Millisecond)
+	lock.Lock()
+	require.Equal(t, []string{"node-4"}, removed)
+	require.Empty(t, leaderChanges)
+	lock.Unlock()
+
+	require.NoError(t, c.ShutdownServer(c.Servers[originalLeader]))
+	newLeader := -1
+	require.Eventually(t, func() bool {
+		newLeader = c.AgreedLeader(t, (originalLeader+1)%3, (originalLeader+2)%3)
+		return newLeader >= 0 && newLeader != originalLeader
+	}, 30*time.Second, time.Second)
+
+	require.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(leaderChanges) > 0 && leaderChanges[len(leaderChanges)-1][1] == c.Servers[newLeader].ID()
+	}, 30*time.Second, 100*time.Millisecond)
+	lock.Lock()
+	require.Equal(t, c.Servers[originalLeader].ID(), leaderChanges[0][0])
+	require.Equal(t, 2, versionChanges)
+	lock.Unlock()
+
+	// no more callbacks once the context is done
+	cancel()
+	time.Sleep(300 * time.Millisecond)
+	lock.Lock()
+	numLeaderChanges := len(leaderChanges)
+	lock.Unlock()
+	require.NoError(t, c.ShutdownServer(c.Servers[newLeader]))
+	time.Sleep(time.Second)
+	lock.Lock()
+	require.Equal(t, numLeaderChanges, len(leaderChanges))
+	lock.Unlock()
+}
+
+// Scenario: a single session is shared by many goroutines, which create and commit transactions while the replica
+// set is refreshed, both explicitly and after redirects. Run with `-race`.
+func TestDbSession_ConcurrentUse(t *testing.T) {
+	clientCertTemDir := testutils.GenerateTestCrypto(t, []string{"admin", "alice", "server"})
+	testServer, _, _, err := SetupTestServer(t, clientCertTemDir)
+	defer testServer.Stop()
+	require.NoError(t, err)
+	StartTestServer(t, testServer)
+
+	_, session := connectAndOpenAdminSession(t, testServer, clientCertTemDir)
+	sessionImpl := session.(*dbSession)
+
+	const numTxs = 64
+	var refreshWG, txWG sync.WaitGroup
+	stopRefresh := make(chan struct{})
+	refreshErrs := make(chan error, 2)
+
+	refreshWG.Add(2)
+	// explicit refreshes
+	go func() {
+		defer refreshWG.Done()
+		for {
+			select {
+			case <-stopRefresh:
+				return
+			default:
+			}
+			if _, err := session.ReplicaSet(true); err != nil {
+				refreshErrs <- err
+				return
+			}
+		}
+	}()
+	// refreshes triggered by redirects, when transaction contexts are created
+	go func() {
+		defer refreshWG.Done()
+		for {
+			select {
+			case <-stopRefresh:
+				return
+			case <-time.After(time.Millisecond):
+				sessionImpl.updateReplicaSetFlag.Store(true)
+			}
+		}
+	}()
+
+	txErrs := make(chan error, numTxs)
+	for i := 0; i < numTxs; i++ {
+		txWG.Add(1)
+		go func(i int) {
+			defer txWG.Done()
+			tx, err := session.DataTx()
+			if err != nil {
+				txErrs <- err
+				return
+			}
+			if err = tx.Put("bdb", fmt.Sprintf("key-%d", i), []byte(fmt.Sprintf("value-%d", i)), nil); err != nil {
+				txErrs <- err
+				return
+			}
+			_, receiptEnv, err := tx.Commit(true)
+			if err != nil {
+				txErrs <- err
+				return
+			}
+			receipt := receiptEnv.GetResponse().GetReceipt()
+			if flag := receipt.GetHeader().GetValidationInfo()[receipt.GetTxIndex()].GetFlag(); flag != types.Flag_VALID {
+				txErrs <- errors.Errorf("tx %d is not valid: %s", i, flag)
+			}
+		}(i)
+	}
+
+	txWG.Wait()
+	close(stopRefresh)
+	refreshWG.Wait()
+	close(txErrs)
+	close(refreshErrs)
+	for err := range txErrs {
+		require.NoError(t, err)
+	}
+	for err := range refreshErrs {
+		require.NoError(t, err)
+	}
+
+	tx, err := session.DataTx()
+	require.NoError(t, err)
+	for i := 0; i < numTxs; i++ {
+		val, _, err := tx.Get("bdb", fmt.Sprintf("key-%d", i))
+		require.NoError(t, err)
+		require.Equal(t, []byte(fmt.Sprintf("value-%d", i)), val)
+	}
+	require.NoError(t, tx.Abort())
+	require.Nil(t, sessionImpl.refreshing)
+}
+
+// Scenario: concurrent refreshes of the replica set are coalesced into the update in progress, and all of them get
+// its result.
+func TestDbSession_RefreshReplicaSetCoalesced(t *testing.T) {
+	clientCertTemDir := testutils.GenerateTestCrypto(t, []string{"admin", "server"})
+	testServer, _, _, err := SetupTestServer(t, clientCertTemDir)
+	defer testServer.Stop()
+	require.NoError(t, err)
+	StartTestServer(t, testServer)
+
+	_, session := connectAndOpenAdminSession(t, testServer, clientCertTemDir)
+	sessionImpl := session.(*dbSession)
+
+	// an update in progress, which the refreshes wait for
+	inProgress := &replicaSetRefresh{done: make(chan struct{})}
+	sessionImpl.refreshing = inProgress
+
+	const numRefreshes = 16
+	var wg sync.WaitGroup
+	results := make(chan error, numRefreshes)
+	for i := 0; i < numRefreshes; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := session.ReplicaSet(true)
+			results <- err
+		}()
+	}
+
+	select {
+	case err := <-results:
+		t.Fatalf("refresh returned before the update in progress completed: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	inProgress.err = errors.New("update failed")
+	sessionImpl.refreshLock.Lock()
+	sessionImpl.refreshing = nil
+	sessionImpl.refreshLock.Unlock()
+	close(inProgress.done)
+
+	wg.Wait()
+	close(results)
+	for err := range results {
+		require.EqualError(t, err, "cannot update the replica set and signature verifier: update failed")
+	}
+
+	// once the update completed, a new refresh queries the cluster
+	replicas, err := session.ReplicaSet(true)
+	require.NoError(t, err)
+	require.Len(t, replicas, 1)
+}
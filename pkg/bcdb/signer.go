@@ -0,0 +1,134 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+package bcdb
+
+import (
+	gocrypto "crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/config"
+	"github.com/hyperledger-labs/orion-server/pkg/crypto"
+	"github.com/pkg/errors"
+)
+
+// keySigner signs with a private key held in memory, or with any key that
+// implements the standard library crypto.Signer, e.g. a key in a hardware token
+type keySigner struct {
+	key      gocrypto.Signer
+	identity string
+}
+
+// NewKeySigner creates a Signer for the user over the given private key. The key
+// signs the SHA-256 hash of the messages, as the server expects.
+func NewKeySigner(userID string, key gocrypto.Signer) (Signer, error) {
+	if key == nil {
+		return nil, errors.New("private key is nil")
+	}
+	return &keySigner{
+		key:      key,
+		identity: userID,
+	}, nil
+}
+
+func (s *keySigner) Sign(msgBytes []byte) ([]byte, error) {
+	h, err := crypto.ComputeSHA256Hash(msgBytes)
+	if err != nil {
+		return nil, err
+	}
+	return s.key.Sign(rand.Reader, h, gocrypto.SHA256)
+}
+
+func (s *keySigner) Identity() string {
+	return s.identity
+}
+
+// NewPEMSigner creates a Signer for the user over a PEM encoded private key,
+// either SEC1 EC or PKCS#8
+func NewPEMSigner(userID string, keyPEM []byte) (Signer, error) {
+	return NewEncryptedPEMSigner(userID, keyPEM, nil)
+}
+
+// NewEncryptedPEMSigner creates a Signer for the user over a PEM encoded private
+// key, which may be encrypted, either as encrypted PKCS#8 or as legacy encrypted
+// PEM. The passphrase function is called only if the key is encrypted. Legacy
+// encrypted PEM is insecure, as it has no integrity check and a weak key
+// derivation, and is supported for compatibility only.
+func NewEncryptedPEMSigner(userID string, keyPEM []byte, passphrase config.PassphraseFunc) (Signer, error) {
+	keyPEM, err := decryptPrivateKey(keyPEM, passphrase)
+	if err != nil {
+		return nil, errors.WithMessage(err, "cannot load user's private key")
+	}
+
+	block := config.PrivateKeyBlock(keyPEM)
+	if block == nil {
+		return nil, errors.New("cannot load user's private key: failed to find private key block in pem file")
+	}
+	signer, err := config.ParsePrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot load user's private key")
+	}
+	return NewKeySigner(userID, signer)
+}
+
+// NewFileSigner creates a Signer for the user over a PEM encoded private key
+// stored in a file
+func NewFileSigner(userID, keyPath string) (Signer, error) {
+	return NewEncryptedFileSigner(userID, keyPath, nil)
+}
+
+// NewEncryptedFileSigner creates a Signer for the user over a PEM encoded private
+// key stored in a file, which may be encrypted. The passphrase function is called
+// only if the key is encrypted.
+func NewEncryptedFileSigner(userID, keyPath string, passphrase config.PassphraseFunc) (Signer, error) {
+	keyPEM, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read user's private key from %s", keyPath)
+	}
+	return NewEncryptedPEMSigner(userID, keyPEM, passphrase)
+}
+
+// NewEnvSigner creates a Signer for the user over a PEM encoded private key
+// held in an environment variable. New lines in the value may be escaped as `\n`.
+func NewEnvSigner(userID, envVar string) (Signer, error) {
+	keyPEM, err := pemFromEnv(envVar)
+	if err != nil {
+		return nil, err
+	}
+	return NewPEMSigner(userID, keyPEM)
+}
+
+// CertFromEnv returns the PEM encoded certificate held in an environment variable,
+// to be set as the user's certificate in config.UserConfig. New lines in the value
+// may be escaped as `\n`.
+func CertFromEnv(envVar string) ([]byte, error) {
+	certPEM, err := pemFromEnv(envVar)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(certPEM); block == nil {
+		return nil, errors.Errorf("no PEM certificate found in environment variable %s", envVar)
+	}
+	return certPEM, nil
+}
+
+// CertFromX509 returns the PEM encoding of a parsed certificate, to be set as the
+// user's certificate in config.UserConfig
+func CertFromX509(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+func pemFromEnv(envVar string) ([]byte, error) {
+	value, ok := os.LookupEnv(envVar)
+	if !ok || value == "" {
+		return nil, errors.Errorf("environment variable %s is not set", envVar)
+	}
+	if !strings.Contains(value, "\n") {
+		value = strings.ReplaceAll(value, `\n`, "\n")
+	}
+	return []byte(value), nil
+}
@@ -251,3 +251,53 @@ func updateClientTLSConfigIncorrectCA(t *testing.T, cryptoDir, serverPort string
 		conf.TLSConfig.CaConfig.IntermediateCACertsPath = nil
 	}
 }
+
+func TestRotateClientTLSCertificate(t *testing.T) {
+	clientCertTempDir := testutils.GenerateTestCrypto(t, []string{"admin", "server"})
+	testServer, _, _, err := SetupTestServerWithParamsAndTLS(t, clientCertTempDir, 20*time.Millisecond, 1, true, true, generateCorrectTLSCrypto)
+	defer testServer.Stop()
+	require.NoError(t, err)
+	StartTestServer(t, testServer)
+
+	serverPort, err := testServer.Port()
+	require.NoError(t, err)
+	bcdb := createDBInstanceWithTLSConfig(t, clientCertTempDir, serverPort, true, true, updateClientTLSConfigCorrect)
+	session := openUserSessionWithQueryTimeout(t, bcdb, "admin", clientCertTempDir, 0, true)
+	putKeySync(t, "bdb", "key1", "value1", "admin", session)
+	oldTransport := session.(*dbSession).transport
+	_, err = session.ReplicaSet(true)
+	require.NoError(t, err)
+	require.Same(t, oldTransport, session.(*dbSession).transport)
+
+	// rotate to another client certificate issued by the TLS CA
+	newClientTLS := &sdkconfig.ClientTLSConfig{
+		ClientCertificatePath: path.Join(clientCertTempDir, "tlsServer.pem"),
+		ClientKeyPath:         path.Join(clientCertTempDir, "tlsServer.key"),
+	}
+	err = session.RotateCredentials(&sdkconfig.UserConfig{
+		UserID:         "admin",
+		CertPath:       path.Join(clientCertTempDir, "admin.pem"),
+		PrivateKeyPath: path.Join(clientCertTempDir, "admin.key"),
+	}, newClientTLS)
+	require.NoError(t, err)
+
+	newCertPEM, err := os.ReadFile(newClientTLS.ClientCertificatePath)
+	require.NoError(t, err)
+	newCertBlock, _ := pem.Decode(newCertPEM)
+	require.Equal(t, newCertBlock.Bytes, session.(*dbSession).clientTlsConfig.Certificates[0].Certificate[0])
+	require.NotSame(t, oldTransport, session.(*dbSession).transport)
+	putKeySync(t, "bdb", "key2", "value2", "admin", session)
+
+	_, err = session.ReplicaSet(true)
+	require.NoError(t, err)
+
+	err = session.RotateCredentials(&sdkconfig.UserConfig{
+		UserID:         "admin",
+		CertPath:       path.Join(clientCertTempDir, "admin.pem"),
+		PrivateKeyPath: path.Join(clientCertTempDir, "admin.key"),
+	}, &sdkconfig.ClientTLSConfig{
+		ClientCertificatePath: path.Join(clientCertTempDir, "tlsServer.pem"),
+		ClientKeyPath:         path.Join(clientCertTempDir, "tlsClient.key"),
+	})
+	require.EqualError(t, err, "cannot create x509 key pair: tls: private key does not match public key")
+}
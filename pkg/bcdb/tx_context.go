@@ -6,14 +6,19 @@ package bcdb
 import (
 	"context"
 	"encoding/json"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/hyperledger-labs/orion-sdk-go/internal"
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/config"
+	"github.com/hyperledger-labs/orion-server/pkg/constants"
 	"github.com/hyperledger-labs/orion-server/pkg/cryptoservice"
 	"github.com/hyperledger-labs/orion-server/pkg/logger"
 	"github.com/hyperledger-labs/orion-server/pkg/marshal"
@@ -31,19 +36,23 @@ const (
 var retriesTimoeoutConfig = 5 * time.Second
 
 type commonTxContext struct {
-	userID        string
-	txID          string
-	signer        Signer
-	userCert      []byte
-	replicaSet    internal.ReplicaSet
-	verifier      SignatureVerifier
-	restClient    RestClient
-	txEnvelope    proto.Message
-	commitTimeout time.Duration
-	queryTimeout  time.Duration
-	txSpent       bool
-	logger        *logger.SugarLogger
-	dbSession     *dbSession
+	userID            string
+	txID              string
+	signer            Signer
+	userCert          []byte
+	replicaSet        internal.ReplicaSet
+	verifier          SignatureVerifier
+	restClient        RestClient
+	health            *internal.ReplicaHealth
+	readCache         *readCache
+	txEnvelope        proto.Message
+	commitTimeout     time.Duration
+	queryTimeout      time.Duration
+	txSpent           bool
+	possiblySubmitted bool // a failed submission of the transaction may have reached the server
+	logger            *logger.SugarLogger
+	metrics           *sessionMetrics
+	dbSession         *dbSession
 }
 
 type txContext interface {
@@ -57,6 +66,21 @@ func (t *commonTxContext) commit(tx txContext, postEndpoint string, sync bool) (
 		return "", nil, ErrTxSpent
 	}
 
+	operation := operationCommitAsync
+	if sync {
+		operation = operationCommitSync
+	}
+	defer t.metrics.transactionStarted(operation)()
+	start := time.Now()
+
+	txID, receiptEnv, err := t.submit(tx, postEndpoint, sync, operation)
+	// the cached reads the transaction writes are invalidated at once, so that the session reads its own writes
+	t.readCache.invalidateTx(t.txID, t.txEnvelope)
+	t.metrics.observeOperation(operation, start, err)
+	return txID, receiptEnv, err
+}
+
+func (t *commonTxContext) submit(tx txContext, postEndpoint string, sync bool, operation string) (string, *types.TxReceiptResponseEnvelope, error) {
 	var err error
 	var response *http.Response
 
@@ -65,8 +89,16 @@ func (t *commonTxContext) commit(tx txContext, postEndpoint string, sync bool) (
 	retryInterval := retriesTimoeoutConfig / 64
 	countRetries := 0
 
+	// a synchronous commit shares a single deadline between all its submissions and the wait for the receipt of a
+	// transaction an earlier submission may have delivered, so that it returns within the commit timeout
 	ctx := context.Background()
-	var cancelFnc context.CancelFunc
+	var deadline time.Time
+	if sync {
+		deadline = time.Now().Add(t.commitTimeout + contextTimeoutMargin)
+		var cancelFnc context.CancelFunc
+		ctx, cancelFnc = context.WithDeadline(ctx, deadline)
+		defer cancelFnc()
+	}
 
 	for {
 		replica, err := t.selectReplica()
@@ -74,7 +106,7 @@ func (t *commonTxContext) commit(tx txContext, postEndpoint string, sync bool) (
 			t.logger.Errorf("failed to select replica, due to %s", err)
 			return t.txID, nil, errors.WithMessage(err, "failed to select replica")
 		}
-		postEndpointResolved := replica.ResolveReference(&url.URL{Path: postEndpoint})
+		postEndpointResolved := replica.URL.ResolveReference(&url.URL{Path: postEndpoint})
 
 		t.logger.Debugf("compose transaction enveloped with txID = %s", t.txID)
 
@@ -86,22 +118,48 @@ func (t *commonTxContext) commit(tx txContext, postEndpoint string, sync bool) (
 
 		serverTimeout := time.Duration(0)
 		if sync {
-			serverTimeout = t.commitTimeout
-			contextTimeout := t.commitTimeout + contextTimeoutMargin
-			ctx, cancelFnc = context.WithTimeout(context.Background(), contextTimeout)
-			defer cancelFnc()
+			// the server is given the time left before the deadline, so that it responds before the deadline expires
+			serverTimeout = time.Until(deadline) - contextTimeoutMargin
+			if serverTimeout < time.Millisecond {
+				serverTimeout = time.Millisecond
+			}
 		}
 		defer tx.cleanCtx()
 
-		response, err = t.restClient.Submit(ctx, postEndpointResolved.String(), t.txEnvelope, serverTimeout)
+		requestCtx := withRequestInfo(ctx, &config.RequestInfo{
+			Operation: operation,
+			TxID:      t.txID,
+			UserID:    t.userID,
+			Replica:   replica.Id,
+			Attempt:   countRetries + 1,
+		})
+		start := time.Now()
+		response, err = t.restClient.Submit(requestCtx, postEndpointResolved.String(), t.txEnvelope, serverTimeout)
+		t.recordReplicaResult(replica, start, response, err)
 
 		if err != nil {
-			// if error is not nil we need to check if its due to a connection refused, in such case we want to retry, otherwise we return with error
-			if !strings.Contains(err.Error(), "connection refused") {
+			switch {
+			// if error is due to a connection refused the transaction did not reach the server, and we want to retry
+			case strings.Contains(err.Error(), "connection refused"):
+				t.logger.Warnf("failed to submit transaction txID = %s, due to %s, will try again in %s ", t.txID, err, retryInterval)
+			// if the deadline of the commit expired, the server may have received the transaction, but there is no
+			// time left to look up its receipt
+			case errors.Is(err, context.DeadlineExceeded):
+				t.possiblySubmitted = t.possiblySubmitted || !isUnsentSubmitError(err)
+				t.logger.Errorf("failed to submit transaction txID = %s, due to %s", t.txID, err)
+				return t.txID, nil, err
+			// otherwise, if the connection failed after the transaction was sent, the server may have received it,
+			// so we look up its receipt before we retry
+			case isAmbiguousSubmitError(err):
+				t.possiblySubmitted = true
+				t.logger.Warnf("failed to submit transaction txID = %s, due to %s, will look up its receipt", t.txID, err)
+				if receiptEnv := t.lookupReceipt(); receiptEnv != nil {
+					t.logger.Infof("transaction txID = %s was committed before the submission failed", t.txID)
+					return t.committed(tx, receiptEnv, sync)
+				}
+			default:
 				t.logger.Errorf("failed to submit transaction txID = %s, due to %s", t.txID, err)
 				return t.txID, nil, err
-			} else {
-				t.logger.Warnf("failed to submit transaction txID = %s, due to %s, will try again in %s ", t.txID, err, retryInterval)
 			}
 		} else {
 			// if error is nil we want to check the response, if the response is 503 service unavailable we want to retry
@@ -112,6 +170,7 @@ func (t *commonTxContext) commit(tx txContext, postEndpoint string, sync bool) (
 						t.logger.Warnf("failed to submit transaction txID = %s, due to cluster leader unavailability, server returned: status: %s, will try again in %s ", t.txID, response.Status, retryInterval)
 					} else {
 						if response.StatusCode == http.StatusAccepted {
+							t.possiblySubmitted = true
 							return t.txID, nil, &ServerTimeout{TxID: t.txID}
 						}
 						if response.Body != nil {
@@ -123,6 +182,11 @@ func (t *commonTxContext) commit(tx txContext, postEndpoint string, sync bool) (
 								errMsg = errRes.Error()
 							}
 						}
+						// a duplicate txID after an earlier submission may have reached the server means that it did
+						if t.possiblySubmitted && response.StatusCode == http.StatusBadRequest && errMsg == duplicateTxIDErrMsg(t.txID) {
+							t.logger.Infof("transaction txID = %s was received by an earlier submission, will look up its receipt", t.txID)
+							return t.awaitSubmitted(tx, sync, deadline)
+						}
 						return t.txID, nil, errors.Errorf("failed to submit transaction, server returned: status: %s, message: %s", response.Status, errMsg)
 					}
 				} else {
@@ -134,15 +198,26 @@ func (t *commonTxContext) commit(tx txContext, postEndpoint string, sync bool) (
 		}
 
 		countRetries++
+		// the replica set is refreshed before waiting, so that a newly reported leader is tried at once
+		nextReplica, errReplicaSet := t.refreshReplicas()
+		if errReplicaSet != nil {
+			return t.txID, nil, errors.Errorf("failed to submit transaction, %s", errReplicaSet.Error())
+		}
+		failover := nextReplica.Id != replica.Id
+		t.metrics.commitRetried(failover)
+
+		wait := time.After(retryInterval)
+		newLeader := failover && nextReplica.Role == internal.ReplicaRole_LEADER
+		if newLeader {
+			t.logger.Infof("will submit transaction txID = %s to the new leader %s", t.txID, nextReplica.Id)
+			wait = time.After(0)
+		}
+
 		select {
-		case <-time.After(retryInterval):
-			retryInterval = 2 * retryInterval
-			_, errReplicaSet := t.dbSession.ReplicaSet(true)
-			if errReplicaSet != nil {
-				return t.txID, nil, errors.Errorf("failed to submit transaction, %s", errReplicaSet.Error())
+		case <-wait:
+			if !newLeader {
+				retryInterval = 2 * retryInterval
 			}
-			t.replicaSet = t.dbSession.replicaSet
-			t.verifier = t.dbSession.verifier
 			continue
 		case <-retriesTimeout:
 			if err != nil {
@@ -188,10 +263,17 @@ func (t *commonTxContext) commit(tx txContext, postEndpoint string, sync bool) (
 
 	err = t.verifier.Verify(nodeID, respBytes, txResponseEnvelope.GetSignature())
 	if err != nil {
+		t.metrics.verificationFailed()
 		t.logger.Errorf("signature verification failed nodeID %s, due to %s", nodeID, err)
 		return "", nil, errors.Errorf("signature verification failed nodeID %s, due to %s", nodeID, err)
 	}
 
+	return t.committed(tx, txResponseEnvelope, sync)
+}
+
+// committed marks the transaction as spent, and returns its receipt, along with its validation error for a
+// synchronous commit
+func (t *commonTxContext) committed(tx txContext, txResponseEnvelope *types.TxReceiptResponseEnvelope, sync bool) (string, *types.TxReceiptResponseEnvelope, error) {
 	t.txSpent = true
 	tx.cleanCtx()
 
@@ -203,6 +285,7 @@ func (t *commonTxContext) commit(tx txContext, postEndpoint string, sync bool) (
 			return t.txID, nil, errors.Errorf("server error: validation info is nil")
 		} else {
 			validFlag := validationInfo[receipt.TxIndex].GetFlag()
+			t.metrics.transactionCommitted(validFlag)
 			if validFlag != types.Flag_VALID {
 				return t.txID, txResponseEnvelope, &ErrorTxValidation{TxID: t.txID, Flag: validFlag.String(), Reason: validationInfo[receipt.TxIndex].ReasonIfInvalid}
 			}
@@ -212,6 +295,90 @@ func (t *commonTxContext) commit(tx txContext, postEndpoint string, sync bool) (
 	return t.txID, txResponseEnvelope, nil
 }
 
+// awaitSubmitted handles a transaction that was received by an earlier submission. An asynchronous commit returns at
+// once, as the transaction was accepted, while a synchronous commit waits for its receipt up to the deadline of the
+// commit.
+func (t *commonTxContext) awaitSubmitted(tx txContext, sync bool, deadline time.Time) (string, *types.TxReceiptResponseEnvelope, error) {
+	if !sync {
+		t.txSpent = true
+		tx.cleanCtx()
+		return t.txID, nil, nil
+	}
+
+	interval := retriesTimoeoutConfig / 64
+	for {
+		if receiptEnv := t.lookupReceipt(); receiptEnv != nil {
+			return t.committed(tx, receiptEnv, sync)
+		}
+		if time.Now().After(deadline) {
+			return t.txID, nil, &ServerTimeout{TxID: t.txID}
+		}
+		time.Sleep(interval)
+		if interval < time.Second {
+			interval = 2 * interval
+		}
+	}
+}
+
+// lookupReceipt returns the receipt of the transaction, or nil if the transaction is not committed yet, or if the
+// receipt cannot be obtained
+func (t *commonTxContext) lookupReceipt() *types.TxReceiptResponseEnvelope {
+	receiptEnv := &types.TxReceiptResponseEnvelope{}
+	err := t.handleRequest(
+		constants.URLForGetTransactionReceipt(t.txID),
+		&types.GetTxReceiptQuery{
+			UserId: t.userID,
+			TxId:   t.txID,
+		}, receiptEnv,
+	)
+	if err != nil {
+		if httpErr, ok := err.(*httpError); !ok || httpErr.statusCode != http.StatusNotFound {
+			t.logger.Warnf("failed to look up the receipt of transaction txID = %s, due to %s", t.txID, err)
+		}
+		return nil
+	}
+	return receiptEnv
+}
+
+// refreshReplicas updates the replica set of the session, adopts it, and returns the replica the next request is
+// sent to
+func (t *commonTxContext) refreshReplicas() (*internal.ReplicaWithRole, error) {
+	if _, err := t.dbSession.ReplicaSet(true); err != nil {
+		return nil, err
+	}
+	t.replicaSet, t.verifier = t.dbSession.currentReplicaSet()
+	return t.selectReplica()
+}
+
+// isAmbiguousSubmitError returns true if the submission failed after the transaction was sent, i.e. while the
+// response was read or because the connection was reset, so that the server may have received the transaction.
+// Failures to resolve or to connect to the server, and expired or canceled contexts, are not ambiguous.
+func isAmbiguousSubmitError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || isUnsentSubmitError(err) {
+		return false
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return opErr.Op == "read" || opErr.Op == "write"
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// isUnsentSubmitError returns true if the submission failed before the transaction was sent, because the server
+// could not be resolved or connected to
+func isUnsentSubmitError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func duplicateTxIDErrMsg(txID string) string {
+	return "the transaction has a duplicate txID [" + txID + "]"
+}
+
 func (t *commonTxContext) abort(tx txContext) error {
 	if t.txSpent {
 		return ErrTxSpent
@@ -226,20 +393,31 @@ func (t *commonTxContext) TxID() string {
 	return t.txID
 }
 
-func (t *commonTxContext) selectReplica() (url *url.URL, err error) {
-	// Pick first replica to send request to, as that is the leader.
-	// TODO a cyclic retry mechanism for when the last choice failed to connect.
-	for _, replica := range t.replicaSet {
-		return replica.URL, nil
+func (t *commonTxContext) selectReplica() (*internal.ReplicaWithRole, error) {
+	// Pick the leader if its circuit is closed, else the replica with the highest health score.
+	if replica := t.replicaSet.Select(t.health); replica != nil {
+		return replica, nil
 	}
 
 	return nil, errors.New("empty replica set")
 }
 
+// recordReplicaResult records the result of a request sent to the replica in the health of the replicas
+func (t *commonTxContext) recordReplicaResult(replica *internal.ReplicaWithRole, start time.Time, response *http.Response, err error) {
+	if t.health == nil || t.dbSession == nil {
+		return
+	}
+	t.dbSession.recordReplicaResult(t.replicaSet, replica, time.Since(start), response, err)
+}
+
 func (t *commonTxContext) handleRequest(rawurl string, msgToSign, res proto.Message) error {
 	return t.handleGetPostRequest(rawurl, http.MethodGet, nil, msgToSign, res)
 }
 
+func (t *commonTxContext) handleRequestWithContext(ctx context.Context, rawurl string, msgToSign, res proto.Message) error {
+	return t.handleGetPostRequestWithContext(ctx, rawurl, http.MethodGet, nil, msgToSign, res)
+}
+
 func (t *commonTxContext) handleRequestWithPost(rawurl string, postData []byte, msgToSign, res proto.Message) error {
 	return t.handleGetPostRequest(rawurl, http.MethodPost, postData, msgToSign, res)
 }
@@ -258,23 +436,30 @@ func (e *httpError) Error() string {
 }
 
 func (t *commonTxContext) handleGetPostRequest(rawurl, httpMethod string, postData []byte, msgToSign, res proto.Message) error {
+	return t.handleGetPostRequestWithContext(context.Background(), rawurl, httpMethod, postData, msgToSign, res)
+}
+
+func (t *commonTxContext) handleGetPostRequestWithContext(ctx context.Context, rawurl, httpMethod string, postData []byte, msgToSign, res proto.Message) (err error) {
+	defer func(start time.Time) {
+		t.metrics.observeOperation(queryOperation(res), start, err)
+	}(time.Now())
+
 	parsedURL, err := url.Parse(rawurl)
 	if err != nil {
 		return err
 	}
 
-	replicaURL, err := t.selectReplica()
+	replica, err := t.selectReplica()
 	if err != nil {
 		return errors.WithMessage(err, "failed to select replica")
 	}
 
-	restURL := replicaURL.ResolveReference(parsedURL).String()
+	restURL := replica.URL.ResolveReference(parsedURL).String()
 
-	ctx := context.Background()
 	if t.queryTimeout > 0 {
 		contextTimeout := t.queryTimeout
 		var cancelFnc context.CancelFunc
-		ctx, cancelFnc = context.WithTimeout(context.Background(), contextTimeout)
+		ctx, cancelFnc = context.WithTimeout(ctx, contextTimeout)
 		defer cancelFnc()
 	}
 
@@ -283,7 +468,15 @@ func (t *commonTxContext) handleGetPostRequest(rawurl, httpMethod string, postDa
 		return err
 	}
 
+	ctx = withRequestInfo(ctx, &config.RequestInfo{
+		Operation: queryOperation(res),
+		UserID:    t.userID,
+		Replica:   replica.Id,
+		Attempt:   1,
+	})
+	start := time.Now()
 	response, err := t.restClient.Query(ctx, restURL, httpMethod, postData, signature)
+	t.recordReplicaResult(replica, start, response, err)
 	if err != nil {
 		return err
 	}
@@ -329,6 +522,7 @@ func (t *commonTxContext) handleGetPostRequest(rawurl, httpMethod string, postDa
 		nodeID := responsePayload.GetHeader().GetNodeId()
 		err = t.verifier.Verify(nodeID, respBytes, res.(ResponseEnvelop).GetSignature())
 		if err != nil {
+			t.metrics.verificationFailed()
 			t.logger.Errorf("signature verification failed nodeID %s, due to %s", nodeID, err)
 			return errors.Errorf("signature verification failed nodeID %s, due to %s", nodeID, err)
 		}
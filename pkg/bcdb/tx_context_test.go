@@ -4,6 +4,7 @@ package bcdb
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/hyperledger-labs/orion-sdk-go/internal"
@@ -13,10 +14,13 @@ import (
 	"github.com/hyperledger-labs/orion-server/pkg/types"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -940,13 +944,15 @@ func getTimeout(h *http.Header) (time.Duration, error) {
 func createDBSession(emptySigner *mocks.Signer, verifier *mocks.SignatureVerifier, logger *logger.SugarLogger,
 	restClient RestClient, txTimeout time.Duration, queryTimeout time.Duration) *dbSession {
 	dbSession := &dbSession{
-		userID:   "testUser",
-		signer:   emptySigner,
-		verifier: verifier,
-		userCert: []byte{1, 2, 3},
-		replicaSet: []*internal.ReplicaWithRole{
-			{Id: "node1", URL: &url.URL{Path: "http://localhost:8888"}, Role: internal.ReplicaRole_LEADER},
+		replicaSetView: &replicaSetView{
+			verifier: verifier,
+			replicaSet: []*internal.ReplicaWithRole{
+				{Id: "node1", URL: &url.URL{Path: "http://localhost:8888"}, Role: internal.ReplicaRole_LEADER},
+			},
 		},
+		userID:       "testUser",
+		signer:       emptySigner,
+		userCert:     []byte{1, 2, 3},
 		logger:       logger,
 		txTimeout:    txTimeout,
 		queryTimeout: queryTimeout,
@@ -965,3 +971,30 @@ func (e *timeoutError) Temporary() bool { return true }
 type errorResponseEnvelope struct{}
 
 func (err *errorResponseEnvelope) GetSignature() []byte { return nil }
+
+func TestIsAmbiguousSubmitError(t *testing.T) {
+	postErr := func(err error) error {
+		return &url.Error{Op: "Post", URL: "http://127.0.0.1:6001/data/tx", Err: err}
+	}
+	tests := []struct {
+		name      string
+		err       error
+		ambiguous bool
+	}{
+		{"connection reset", postErr(&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}), true},
+		{"broken write", postErr(&net.OpError{Op: "write", Net: "tcp", Err: syscall.EPIPE}), true},
+		{"closed by the server", postErr(io.EOF), true},
+		{"truncated response", postErr(io.ErrUnexpectedEOF), true},
+		{"connection refused", postErr(&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}), false},
+		{"dial timeout", postErr(&net.OpError{Op: "dial", Net: "tcp", Err: &timeoutError{}}), false},
+		{"unknown host", postErr(&net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "node1"}}), false},
+		{"deadline exceeded", postErr(context.DeadlineExceeded), false},
+		{"canceled", postErr(context.Canceled), false},
+		{"other error", errors.New("submit error"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.ambiguous, isAmbiguousSubmitError(tt.err))
+		})
+	}
+}
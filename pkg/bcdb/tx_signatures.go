@@ -0,0 +1,222 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+package bcdb
+
+import (
+	"bytes"
+	"encoding/pem"
+	"net/http"
+	"sort"
+
+	"github.com/hyperledger-labs/orion-server/pkg/constants"
+	"github.com/hyperledger-labs/orion-server/pkg/crypto"
+	"github.com/hyperledger-labs/orion-server/pkg/marshal"
+	"github.com/hyperledger-labs/orion-server/pkg/types"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+)
+
+// SignatureStatus is the outcome of the verification of the signature of a single user
+type SignatureStatus string
+
+const (
+	// SignatureValid denotes a signature that was verified with the certificate of the user
+	SignatureValid SignatureStatus = "valid"
+	// SignatureMissing denotes a user in the must-sign users set that has not signed the transaction
+	SignatureMissing SignatureStatus = "missing"
+	// SignatureInvalid denotes a signature that does not verify with the certificate of the user, or a user whose
+	// certificate is not issued by the cluster CAs
+	SignatureInvalid SignatureStatus = "invalid"
+	// SignatureUnknown denotes a signature of a user that does not exist in the database
+	SignatureUnknown SignatureStatus = "unknown"
+)
+
+// UserSignatureVerification holds the verification outcome of the signature of a single user
+type UserSignatureVerification struct {
+	UserID string
+	Status SignatureStatus
+	// Reason explains why a signature is invalid, and is empty otherwise
+	Reason string
+}
+
+// SignaturesVerification holds the verification outcome of all the signatures of a transaction envelope, and of
+// all the users that must sign it
+type SignaturesVerification struct {
+	Users map[string]*UserSignatureVerification
+}
+
+// Valid returns true when all the signatures are valid and no signature is missing
+func (v *SignaturesVerification) Valid() bool {
+	for _, u := range v.Users {
+		if u.Status != SignatureValid {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidUsers returns the sorted IDs of the users with a valid signature
+func (v *SignaturesVerification) ValidUsers() []string {
+	return v.usersWithStatus(SignatureValid)
+}
+
+// MissingUsers returns the sorted IDs of the must-sign users that have not signed
+func (v *SignaturesVerification) MissingUsers() []string {
+	return v.usersWithStatus(SignatureMissing)
+}
+
+// InvalidUsers returns the sorted IDs of the users with an invalid signature
+func (v *SignaturesVerification) InvalidUsers() []string {
+	return v.usersWithStatus(SignatureInvalid)
+}
+
+// UnknownUsers returns the sorted IDs of the signers that do not exist in the database
+func (v *SignaturesVerification) UnknownUsers() []string {
+	return v.usersWithStatus(SignatureUnknown)
+}
+
+func (v *SignaturesVerification) usersWithStatus(status SignatureStatus) []string {
+	var users []string
+	for userID, u := range v.Users {
+		if u.Status == status {
+			users = append(users, userID)
+		}
+	}
+	sort.Strings(users)
+	return users
+}
+
+// verifyTxSignatures verifies each signature of the envelope over the marshaled payload, with the certificate of
+// the signer as recorded in the database and validated against the cluster CAs. The certificate of the session
+// user is taken from the session itself; the certificates of other users are fetched through the user API, which
+// requires read access to their user records.
+func (t *commonTxContext) verifyTxSignatures(txEnv *types.DataTxEnvelope) (*SignaturesVerification, error) {
+	payloadBytes, err := marshal.DefaultMarshaller().Marshal(txEnv.GetPayload())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal the transaction payload")
+	}
+
+	res := &SignaturesVerification{
+		Users: make(map[string]*UserSignatureVerification),
+	}
+	for _, userID := range txEnv.GetPayload().GetMustSignUserIds() {
+		res.Users[userID] = &UserSignatureVerification{
+			UserID: userID,
+			Status: SignatureMissing,
+		}
+	}
+
+	for userID, signature := range txEnv.GetSignatures() {
+		userRes := &UserSignatureVerification{
+			UserID: userID,
+			Status: SignatureValid,
+		}
+		res.Users[userID] = userRes
+
+		certBytes, err := t.signerCertificate(userID)
+		if err != nil {
+			return nil, err
+		}
+		if certBytes == nil {
+			userRes.Status = SignatureUnknown
+			continue
+		}
+
+		if err = t.dbSession.rootCAs.VerifyLeafCert(certBytes); err != nil {
+			userRes.Status = SignatureInvalid
+			userRes.Reason = err.Error()
+			continue
+		}
+		verifier, err := crypto.NewVerifier(certBytes)
+		if err != nil {
+			userRes.Status = SignatureInvalid
+			userRes.Reason = err.Error()
+			continue
+		}
+		if err = verifier.Verify(payloadBytes, signature); err != nil {
+			userRes.Status = SignatureInvalid
+			userRes.Reason = err.Error()
+		}
+	}
+
+	return res, nil
+}
+
+// signerCertificate returns the ASN.1 DER certificate of the user, or nil if the user does not exist
+func (t *commonTxContext) signerCertificate(userID string) ([]byte, error) {
+	if userID == t.userID {
+		certBlock, _ := pem.Decode(t.userCert)
+		if certBlock == nil {
+			return nil, errors.New("failed to decode the session user certificate")
+		}
+		return certBlock.Bytes, nil
+	}
+
+	path := constants.URLForGetUser(userID)
+	resEnv := &types.GetUserResponseEnvelope{}
+	err := t.handleRequest(
+		path,
+		&types.GetUserQuery{
+			UserId:       t.userID,
+			TargetUserId: userID,
+		}, resEnv,
+	)
+	if err != nil {
+		httpError, ok := err.(*httpError)
+		if ok && httpError.statusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		t.logger.Errorf("failed to execute user query, Path = %s, due to %s", path, err)
+		return nil, errors.WithMessagef(err, "failed to fetch the certificate of user [%s]", userID)
+	}
+
+	return resEnv.GetResponse().GetUser().GetCertificate(), nil
+}
+
+// MergeSignatures combines the signatures of envelopes of the same multi-sign data transaction, each co-signed by
+// a different subset of the users, e.g., when the constructed envelope is sent to all the required signers at once.
+// The payloads of all the envelopes must be byte-identical. When a user signed more than one envelope, the signature
+// from the first such envelope is kept. MergeSignatures returns the merged envelope, along with the sorted IDs of the
+// must-sign users that have not signed any of the envelopes.
+func MergeSignatures(envs ...*types.DataTxEnvelope) (*types.DataTxEnvelope, []string, error) {
+	if len(envs) == 0 {
+		return nil, nil, errors.New("no transaction envelopes to merge")
+	}
+
+	var payloadBytes []byte
+	merged := &types.DataTxEnvelope{
+		Signatures: make(map[string][]byte),
+	}
+	for i, env := range envs {
+		if env.GetPayload() == nil {
+			return nil, nil, errors.Errorf("payload in the transaction envelope [%d] is nil", i)
+		}
+		envPayloadBytes, err := marshal.DefaultMarshaller().Marshal(env.GetPayload())
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to marshal the payload of the transaction envelope [%d]", i)
+		}
+
+		if i == 0 {
+			payloadBytes = envPayloadBytes
+			merged.Payload = proto.Clone(env.GetPayload()).(*types.DataTx)
+		} else if !bytes.Equal(payloadBytes, envPayloadBytes) {
+			return nil, nil, errors.Errorf("payload of the transaction envelope [%d] differs from the payload of the transaction envelope [0]", i)
+		}
+
+		for userID, signature := range env.GetSignatures() {
+			if _, ok := merged.Signatures[userID]; !ok {
+				merged.Signatures[userID] = signature
+			}
+		}
+	}
+
+	var missing []string
+	for _, userID := range merged.Payload.GetMustSignUserIds() {
+		if _, ok := merged.Signatures[userID]; !ok {
+			missing = append(missing, userID)
+		}
+	}
+	sort.Strings(missing)
+
+	return merged, missing, nil
+}
@@ -59,27 +59,33 @@ func (u *userTxContext) GetUser(userID string) (*types.User, *types.Metadata, er
 		return nil, nil, ErrTxSpent
 	}
 
-	path := constants.URLForGetUser(userID)
-	resEnv := &types.GetUserResponseEnvelope{}
-	err := u.handleRequest(
-		path,
-		&types.GetUserQuery{
-			UserId:       u.userID,
-			TargetUserId: userID,
-		}, resEnv,
-	)
-	if err != nil {
-		u.logger.Errorf("failed to execute user query, Path = %s, due to %s", path, err)
-		return nil, nil, err
+	cacheKey := readCacheKey{kind: readCacheUser, key: userID}
+	cached, invalidations := u.readCache.get(cacheKey)
+	res, isCached := cached.(*types.GetUserResponse)
+	if !isCached {
+		path := constants.URLForGetUser(userID)
+		resEnv := &types.GetUserResponseEnvelope{}
+		err := u.handleRequest(
+			path,
+			&types.GetUserQuery{
+				UserId:       u.userID,
+				TargetUserId: userID,
+			}, resEnv,
+		)
+		if err != nil {
+			u.logger.Errorf("failed to execute user query, Path = %s, due to %s", path, err)
+			return nil, nil, err
+		}
+		res = resEnv.GetResponse()
+		u.readCache.put(cacheKey, res, invalidations)
 	}
 
-	res := resEnv.GetResponse()
 	u.userReads = append(u.userReads, &types.UserRead{
 		UserId:  userID,
 		Version: res.GetMetadata().GetVersion(),
 	})
 
-	return res.GetUser(), resEnv.GetResponse().GetMetadata(), nil
+	return res.GetUser(), res.GetMetadata(), nil
 }
 
 func (u *userTxContext) RemoveUser(userID string) error {
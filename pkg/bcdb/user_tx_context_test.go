@@ -266,11 +266,13 @@ func TestUserContext_GetUserFailureScenarios(t *testing.T) {
 						{Id: "node1", URL: &url.URL{Path: "http://localhost:8888"}, Role: internal.ReplicaRole_LEADER},
 					},
 					dbSession: &dbSession{
-						userID: "testUserId",
-						signer: signer,
-						replicaSet: []*internal.ReplicaWithRole{
-							{Id: "node1", URL: &url.URL{Path: "http://localhost:8888"}, Role: internal.ReplicaRole_LEADER},
+						replicaSetView: &replicaSetView{
+							replicaSet: []*internal.ReplicaWithRole{
+								{Id: "node1", URL: &url.URL{Path: "http://localhost:8888"}, Role: internal.ReplicaRole_LEADER},
+							},
 						},
+						userID:     "testUserId",
+						signer:     signer,
 						logger:     logger,
 						restClient: restClient,
 					},
@@ -352,12 +354,14 @@ func TestUserContext_TxSubmissionFullScenario(t *testing.T) {
 				{Id: "node1", URL: &url.URL{Path: "http://localhost:8888"}, Role: internal.ReplicaRole_LEADER},
 			},
 			dbSession: &dbSession{
-				userID:   "testUserId",
-				signer:   signer,
-				verifier: verifier,
-				replicaSet: []*internal.ReplicaWithRole{
-					{Id: "node1", URL: &url.URL{Path: "http://localhost:8888"}, Role: internal.ReplicaRole_LEADER},
+				replicaSetView: &replicaSetView{
+					verifier: verifier,
+					replicaSet: []*internal.ReplicaWithRole{
+						{Id: "node1", URL: &url.URL{Path: "http://localhost:8888"}, Role: internal.ReplicaRole_LEADER},
+					},
 				},
+				userID:     "testUserId",
+				signer:     signer,
 				logger:     logger,
 				restClient: restClient,
 			},
@@ -3,10 +3,13 @@
 package config
 
 import (
+	"net/http"
 	"time"
 
 	"github.com/hyperledger-labs/orion-server/config"
+	"github.com/hyperledger-labs/orion-server/pkg/crypto"
 	"github.com/hyperledger-labs/orion-server/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // Replica configuration
@@ -29,6 +32,24 @@ type ConnectionConfig struct {
 	TLSConfig ServerTLSConfig
 	// Logger instance, if nil an internal logger is created
 	Logger *logger.SugarLogger
+	// LogLevel is the level of the internal logger, e.g. `debug` or `warn`, `info` by default
+	LogLevel string
+	// Health tracking of the replicas, and circuit breaking of the unhealthy ones
+	ReplicaHealth ReplicaHealthConfig
+}
+
+// ReplicaHealthConfig configures the health tracking of the replicas. A replica that fails consecutively, with
+// connection errors or with service unavailable responses, has its circuit opened: requests are sent to other
+// replicas, while the replica is probed in the background until it responds again. Zero values select the defaults.
+type ReplicaHealthConfig struct {
+	// Disabled turns the health tracking off, requests are always sent to the replicas in role order
+	Disabled bool
+	// FailureThreshold is the number of consecutive failures that open the circuit of a replica, 3 by default
+	FailureThreshold int
+	// ProbeInterval is the interval between two probes of a replica with an open circuit, 1 second by default
+	ProbeInterval time.Duration
+	// SlowThreshold is the latency above which responses lower the health score of a replica, 1 second by default
+	SlowThreshold time.Duration
 }
 
 // ServerTLSConfig holds server side TLS configuration settings.
@@ -60,18 +81,80 @@ type SessionConfig struct {
 	// or for timeout error from server, whatever come first.
 	TxTimeout time.Duration
 	// The query timeout - SDK will wait for query result maximum `QueryTimeout` time.
+	// It also bounds every cluster status request, which is bounded by 10 seconds if `QueryTimeout` is zero.
 	QueryTimeout time.Duration
 	// Client side TLS configuration - client TLS certificate and private key
 	ClientTLS ClientTLSConfig
+	// MetricsRegisterer, if not nil, is the registerer of the client side metrics of the session. Sessions that
+	// share a registerer share the metrics.
+	MetricsRegisterer prometheus.Registerer `yaml:"-" json:"-"`
+	// Interceptors wrap every request the session sends to the cluster, the first interceptor being the outermost
+	Interceptors []RequestInterceptor `yaml:"-" json:"-"`
+	// WrapTransport, if not nil, wraps the HTTP transport of the session. Unlike the interceptors, which wrap the
+	// HTTP client, the transport sees every request the client sends, including the redirected ones, and the
+	// redirect responses it follows.
+	WrapTransport func(http.RoundTripper) http.RoundTripper `yaml:"-" json:"-"`
+	// ReadCache, if not nil, enables the cache of the verified reads of the session
+	ReadCache *ReadCacheConfig
+}
+
+// ReadCacheConfig configures the cache of the verified reads of a session, i.e., of `Get` in data transactions,
+// `GetUser` in user administration transactions and `GetDBIndex` in database administration transactions. The cache
+// follows the headers of the blocks committed to the ledger, and every transaction invalidates the entries it writes:
+// the cache records the writes of the transactions submitted by the session, and reads the content of the
+// transactions of other sessions from the ledger. A transaction whose content the session user is not allowed to
+// read, e.g. a data transaction the user did not sign, invalidates all the entries. Zero values select the defaults.
+type ReadCacheConfig struct {
+	// MaxEntries is the maximal number of cached entries, 1024 by default
+	MaxEntries int
+	// MaxStaleness is the maximal time an entry is served from the cache, 5 seconds by default. It bounds the
+	// staleness of the entries while the cache lags behind the ledger.
+	MaxStaleness time.Duration
+	// PollInterval is the interval at which the cache polls the ledger for new blocks, 100 milliseconds by default
+	PollInterval time.Duration
+}
+
+// RequestInfo describes a request a session sends to the cluster
+type RequestInfo struct {
+	// Operation is the operation the request is sent for, e.g. `commit_sync`, `get` or `cluster_status`
+	Operation string
+	// TxID is the ID of the transaction submitted by the request, empty for queries
+	TxID string
+	// UserID is the ID of the session user
+	UserID string
+	// Replica is the ID of the replica the request is sent to
+	Replica string
+	// Attempt is the number of the attempt to send the request, starting from 1, as submissions are retried
+	Attempt int
 }
 
+// RequestInvoker sends a request to the cluster
+type RequestInvoker func(req *http.Request) (*http.Response, error)
+
+// RequestInterceptor intercepts a request a session sends to the cluster. It may change the request, e.g. add
+// headers, and must call the invoker to send it, unless it fails the request by returning an error.
+type RequestInterceptor func(info *RequestInfo, req *http.Request, invoker RequestInvoker) (*http.Response, error)
+
 // UserConfig user related information
-// maintains wallet with public and private keys
+// maintains wallet with public and private keys.
+// The user's signer and certificate can be provided
+// either directly or by paths to files.
 type UserConfig struct {
 	// UserID the identity of the user
 	UserID string
-	// CertPath path to the user's certificate
+	// CertPath path to the user's certificate, ignored if Cert is set
 	CertPath string
-	// PrivateKeyPath path to the user's private key
+	// PrivateKeyPath path to the user's private key, ignored if Signer is set
 	PrivateKeyPath string
+	// Cert the user's PEM encoded certificate
+	Cert []byte
+	// Signer signs transactions and queries on behalf of the user,
+	// e.g. a signer over an in-memory key or an external key store
+	Signer crypto.Signer `yaml:"-" json:"-"`
+	// PrivateKeyPassphrase supplies the passphrase of an encrypted
+	// private key, called only if the key is encrypted
+	PrivateKeyPassphrase PassphraseFunc `yaml:"-" json:"-"`
 }
+
+// PassphraseFunc returns the passphrase of an encrypted private key
+type PassphraseFunc func() ([]byte, error)
@@ -0,0 +1,240 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+// Config holds the configuration of a connection to a cluster and of a session over it, as read by ReadConfig and
+// LoadConfig, e.g. from the YAML file:
+//
+//	connectionConfig:
+//	  replicaSet:
+//	    - id: node1
+//	      endpoint: http://127.0.0.1:6001
+//	  rootCAs:
+//	    - crypto/CA/CA.pem
+//	  logLevel: debug
+//	sessionConfig:
+//	  userConfig:
+//	    userID: admin
+//	    certPath: crypto/admin/admin.pem
+//	    privateKeyPath: crypto/admin/admin.key
+//	  txTimeout: 20s
+//	  queryTimeout: 10s
+type Config struct {
+	ConnectionConfig ConnectionConfig
+	SessionConfig    SessionConfig
+}
+
+// ReadConfig reads the configuration from a YAML or JSON file, according to the extension of the file. The durations
+// are either duration strings, e.g. `20s`, or numbers of nanoseconds, and the relative paths of the certificates and
+// keys are relative to the directory of the file.
+func ReadConfig(filePath string) (*Config, error) {
+	return LoadConfig(filePath, "")
+}
+
+// LoadConfig reads the configuration from a YAML or JSON file, as ReadConfig does, if the file path is not empty, and
+// then from the environment variables with the given prefix, if it is not empty. The environment variables override
+// the settings of the file. With the prefix `ORION`:
+//
+//	ORION_REPLICAS                  the replica set, as comma separated `id=endpoint` pairs
+//	ORION_ROOT_CAS                  the comma separated paths of the root CA certificates
+//	ORION_TLS_ENABLED               whether the cluster requires TLS
+//	ORION_TLS_CLIENT_AUTH_REQUIRED  whether the cluster requires client authentication
+//	ORION_TLS_ROOT_CAS              the comma separated paths of the root CA certificates of the TLS certificates
+//	ORION_TLS_INTERMEDIATE_CAS      the comma separated paths of the intermediate CA certificates of the TLS certificates
+//	ORION_LOG_LEVEL                 the level of the logger of the connection
+//	ORION_USER_ID                   the ID of the session user
+//	ORION_USER_CERT_PATH            the path of the certificate of the session user
+//	ORION_USER_KEY_PATH             the path of the private key of the session user
+//	ORION_TX_TIMEOUT                the transaction timeout of the session
+//	ORION_QUERY_TIMEOUT             the query timeout of the session
+//	ORION_CLIENT_TLS_CERT_PATH      the path of the client TLS certificate of the session
+//	ORION_CLIENT_TLS_KEY_PATH       the path of the client TLS private key of the session
+//
+// The relative paths of the environment variables are relative to the working directory.
+func LoadConfig(filePath, envPrefix string) (*Config, error) {
+	c := &Config{}
+	if filePath != "" {
+		if err := c.readFile(filePath); err != nil {
+			return nil, err
+		}
+	}
+	if envPrefix != "" {
+		if err := c.readEnv(envPrefix); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *Config) readFile(filePath string) error {
+	v := viper.New()
+	v.SetConfigFile(filePath)
+
+	if err := v.ReadInConfig(); err != nil {
+		return errors.Wrapf(err, "error reading the config file: %s", filePath)
+	}
+	if err := v.UnmarshalExact(c); err != nil {
+		return errors.Wrapf(err, "unable to unmarshal the config file: '%s' into struct", filePath)
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(filePath))
+	if err != nil {
+		return err
+	}
+	for _, p := range c.paths() {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(dir, *p)
+		}
+	}
+	return nil
+}
+
+// paths returns the paths of the certificates and keys of the configuration
+func (c *Config) paths() []*string {
+	var paths []*string
+	for i := range c.ConnectionConfig.RootCAs {
+		paths = append(paths, &c.ConnectionConfig.RootCAs[i])
+	}
+	caConfig := &c.ConnectionConfig.TLSConfig.CaConfig
+	for i := range caConfig.RootCACertsPath {
+		paths = append(paths, &caConfig.RootCACertsPath[i])
+	}
+	for i := range caConfig.IntermediateCACertsPath {
+		paths = append(paths, &caConfig.IntermediateCACertsPath[i])
+	}
+	if userConfig := c.SessionConfig.UserConfig; userConfig != nil {
+		paths = append(paths, &userConfig.CertPath, &userConfig.PrivateKeyPath)
+	}
+	return append(paths, &c.SessionConfig.ClientTLS.ClientCertificatePath, &c.SessionConfig.ClientTLS.ClientKeyPath)
+}
+
+// envSettings maps the environment variables, without their prefix, to the settings they override
+var envSettings = []struct {
+	name  string
+	apply func(c *Config, value string) error
+}{
+	{"REPLICAS", func(c *Config, value string) error {
+		c.ConnectionConfig.ReplicaSet = nil
+		for _, pair := range splitList(value) {
+			id, endpoint, ok := strings.Cut(pair, "=")
+			if !ok || id == "" || endpoint == "" {
+				return errors.Errorf("replica [%s] is not an id=endpoint pair", pair)
+			}
+			c.ConnectionConfig.ReplicaSet = append(c.ConnectionConfig.ReplicaSet, &Replica{ID: id, Endpoint: endpoint})
+		}
+		return nil
+	}},
+	{"ROOT_CAS", func(c *Config, value string) error {
+		c.ConnectionConfig.RootCAs = splitList(value)
+		return nil
+	}},
+	{"TLS_ENABLED", func(c *Config, value string) error {
+		return parseBool(value, &c.ConnectionConfig.TLSConfig.Enabled)
+	}},
+	{"TLS_CLIENT_AUTH_REQUIRED", func(c *Config, value string) error {
+		return parseBool(value, &c.ConnectionConfig.TLSConfig.ClientAuthRequired)
+	}},
+	{"TLS_ROOT_CAS", func(c *Config, value string) error {
+		c.ConnectionConfig.TLSConfig.CaConfig.RootCACertsPath = splitList(value)
+		return nil
+	}},
+	{"TLS_INTERMEDIATE_CAS", func(c *Config, value string) error {
+		c.ConnectionConfig.TLSConfig.CaConfig.IntermediateCACertsPath = splitList(value)
+		return nil
+	}},
+	{"LOG_LEVEL", func(c *Config, value string) error {
+		c.ConnectionConfig.LogLevel = value
+		return nil
+	}},
+	{"USER_ID", func(c *Config, value string) error {
+		c.userConfig().UserID = value
+		return nil
+	}},
+	{"USER_CERT_PATH", func(c *Config, value string) error {
+		c.userConfig().CertPath = value
+		return nil
+	}},
+	{"USER_KEY_PATH", func(c *Config, value string) error {
+		c.userConfig().PrivateKeyPath = value
+		return nil
+	}},
+	{"TX_TIMEOUT", func(c *Config, value string) error {
+		return parseDuration(value, &c.SessionConfig.TxTimeout)
+	}},
+	{"QUERY_TIMEOUT", func(c *Config, value string) error {
+		return parseDuration(value, &c.SessionConfig.QueryTimeout)
+	}},
+	{"CLIENT_TLS_CERT_PATH", func(c *Config, value string) error {
+		c.SessionConfig.ClientTLS.ClientCertificatePath = value
+		return nil
+	}},
+	{"CLIENT_TLS_KEY_PATH", func(c *Config, value string) error {
+		c.SessionConfig.ClientTLS.ClientKeyPath = value
+		return nil
+	}},
+}
+
+func (c *Config) readEnv(prefix string) error {
+	for _, setting := range envSettings {
+		name := prefix + "_" + setting.name
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setting.apply(c, value); err != nil {
+			return errors.WithMessagef(err, "error reading the environment variable %s", name)
+		}
+	}
+	return nil
+}
+
+func (c *Config) userConfig() *UserConfig {
+	if c.SessionConfig.UserConfig == nil {
+		c.SessionConfig.UserConfig = &UserConfig{}
+	}
+	return c.SessionConfig.UserConfig
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseBool(value string, b *bool) error {
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return errors.Errorf("[%s] is not a boolean", value)
+	}
+	*b = parsed
+	return nil
+}
+
+// parseDuration parses a duration string, or a number of nanoseconds
+func parseDuration(value string, d *time.Duration) error {
+	if nanos, err := strconv.ParseInt(value, 10, 64); err == nil {
+		*d = time.Duration(nanos)
+		return nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return errors.Errorf("[%s] is not a duration", value)
+	}
+	*d = parsed
+	return nil
+}
@@ -0,0 +1,197 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hyperledger-labs/orion-server/config"
+	"github.com/stretchr/testify/require"
+)
+
+const yamlConfig = `connectionConfig:
+  replicaSet:
+    - id: node1
+      endpoint: http://127.0.0.1:6001
+    - id: node2
+      endpoint: http://127.0.0.1:6002
+  rootCAs:
+    - crypto/CA/CA.pem
+    - /etc/orion/CA2.pem
+  tlsConfig:
+    enabled: true
+    caConfig:
+      rootCACertsPath:
+        - tls/CA.pem
+  logLevel: warn
+sessionConfig:
+  userConfig:
+    userID: admin
+    certPath: crypto/admin/admin.pem
+    privateKeyPath: ../keys/admin.key
+  txTimeout: 20s
+  queryTimeout: 1500ms
+`
+
+const jsonConfig = `{
+  "connectionConfig": {
+    "replicaSet": [{"id": "node1", "endpoint": "http://127.0.0.1:6001"}],
+    "rootCAs": ["crypto/CA/CA.pem"]
+  },
+  "sessionConfig": {
+    "userConfig": {"userID": "alice", "certPath": "/crypto/alice.pem", "privateKeyPath": "alice.key"},
+    "txTimeout": "10s",
+    "queryTimeout": 5000000000
+  }
+}`
+
+func writeConfigFile(t *testing.T, name, content string) (string, string) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(filePath, []byte(content), 0644))
+	return dir, filePath
+}
+
+func TestReadConfig(t *testing.T) {
+	t.Run("yaml", func(t *testing.T) {
+		dir, filePath := writeConfigFile(t, "config.yml", yamlConfig)
+		c, err := ReadConfig(filePath)
+		require.NoError(t, err)
+
+		require.Equal(t, []*Replica{
+			{ID: "node1", Endpoint: "http://127.0.0.1:6001"},
+			{ID: "node2", Endpoint: "http://127.0.0.1:6002"},
+		}, c.ConnectionConfig.ReplicaSet)
+		require.Equal(t, []string{filepath.Join(dir, "crypto/CA/CA.pem"), "/etc/orion/CA2.pem"}, c.ConnectionConfig.RootCAs)
+		require.True(t, c.ConnectionConfig.TLSConfig.Enabled)
+		require.Equal(t, []string{filepath.Join(dir, "tls/CA.pem")}, c.ConnectionConfig.TLSConfig.CaConfig.RootCACertsPath)
+		require.Equal(t, "warn", c.ConnectionConfig.LogLevel)
+		require.Nil(t, c.ConnectionConfig.Logger)
+
+		require.Equal(t, &UserConfig{
+			UserID:         "admin",
+			CertPath:       filepath.Join(dir, "crypto/admin/admin.pem"),
+			PrivateKeyPath: filepath.Join(filepath.Dir(dir), "keys/admin.key"),
+		}, c.SessionConfig.UserConfig)
+		require.Equal(t, 20*time.Second, c.SessionConfig.TxTimeout)
+		require.Equal(t, 1500*time.Millisecond, c.SessionConfig.QueryTimeout)
+		require.Empty(t, c.SessionConfig.ClientTLS.ClientCertificatePath)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		dir, filePath := writeConfigFile(t, "config.json", jsonConfig)
+		c, err := ReadConfig(filePath)
+		require.NoError(t, err)
+
+		require.Equal(t, []*Replica{{ID: "node1", Endpoint: "http://127.0.0.1:6001"}}, c.ConnectionConfig.ReplicaSet)
+		require.Equal(t, []string{filepath.Join(dir, "crypto/CA/CA.pem")}, c.ConnectionConfig.RootCAs)
+		require.Equal(t, "/crypto/alice.pem", c.SessionConfig.UserConfig.CertPath)
+		require.Equal(t, filepath.Join(dir, "alice.key"), c.SessionConfig.UserConfig.PrivateKeyPath)
+		require.Equal(t, 10*time.Second, c.SessionConfig.TxTimeout)
+		require.Equal(t, 5*time.Second, c.SessionConfig.QueryTimeout)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := ReadConfig(filepath.Join(t.TempDir(), "missing.yml"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "error reading the config file")
+
+		_, filePath := writeConfigFile(t, "config.yml", "connectionConfig:\n  replicas: []\n")
+		_, err = ReadConfig(filePath)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unable to unmarshal the config file")
+
+		_, filePath = writeConfigFile(t, "config.yml", "sessionConfig:\n  txTimeout: soon\n")
+		_, err = ReadConfig(filePath)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unable to unmarshal the config file")
+	})
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Run("environment only", func(t *testing.T) {
+		t.Setenv("ORION_REPLICAS", "node1=http://127.0.0.1:6001, node2=http://127.0.0.1:6002")
+		t.Setenv("ORION_ROOT_CAS", "CA.pem,/crypto/CA2.pem")
+		t.Setenv("ORION_TLS_ENABLED", "true")
+		t.Setenv("ORION_TLS_CLIENT_AUTH_REQUIRED", "1")
+		t.Setenv("ORION_TLS_ROOT_CAS", "tls/CA.pem")
+		t.Setenv("ORION_TLS_INTERMEDIATE_CAS", "tls/ICA.pem")
+		t.Setenv("ORION_LOG_LEVEL", "debug")
+		t.Setenv("ORION_USER_ID", "bob")
+		t.Setenv("ORION_USER_CERT_PATH", "bob.pem")
+		t.Setenv("ORION_USER_KEY_PATH", "bob.key")
+		t.Setenv("ORION_TX_TIMEOUT", "30s")
+		t.Setenv("ORION_QUERY_TIMEOUT", "1000")
+		t.Setenv("ORION_CLIENT_TLS_CERT_PATH", "client.pem")
+		t.Setenv("ORION_CLIENT_TLS_KEY_PATH", "client.key")
+		t.Setenv("OTHER_USER_ID", "eve")
+
+		c, err := LoadConfig("", "ORION")
+		require.NoError(t, err)
+		require.Equal(t, &Config{
+			ConnectionConfig: ConnectionConfig{
+				ReplicaSet: []*Replica{
+					{ID: "node1", Endpoint: "http://127.0.0.1:6001"},
+					{ID: "node2", Endpoint: "http://127.0.0.1:6002"},
+				},
+				RootCAs: []string{"CA.pem", "/crypto/CA2.pem"},
+				TLSConfig: ServerTLSConfig{
+					Enabled:            true,
+					ClientAuthRequired: true,
+					CaConfig: config.CAConfiguration{
+						RootCACertsPath:         []string{"tls/CA.pem"},
+						IntermediateCACertsPath: []string{"tls/ICA.pem"},
+					},
+				},
+				LogLevel: "debug",
+			},
+			SessionConfig: SessionConfig{
+				UserConfig: &UserConfig{
+					UserID:         "bob",
+					CertPath:       "bob.pem",
+					PrivateKeyPath: "bob.key",
+				},
+				TxTimeout:    30 * time.Second,
+				QueryTimeout: 1000,
+				ClientTLS: ClientTLSConfig{
+					ClientCertificatePath: "client.pem",
+					ClientKeyPath:         "client.key",
+				},
+			},
+		}, c)
+	})
+
+	t.Run("environment overrides file", func(t *testing.T) {
+		dir, filePath := writeConfigFile(t, "config.yml", yamlConfig)
+		t.Setenv("APP_REPLICAS", "node3=http://127.0.0.1:6003")
+		t.Setenv("APP_USER_ID", "alice")
+		t.Setenv("APP_QUERY_TIMEOUT", "2s")
+
+		c, err := LoadConfig(filePath, "APP")
+		require.NoError(t, err)
+		require.Equal(t, []*Replica{{ID: "node3", Endpoint: "http://127.0.0.1:6003"}}, c.ConnectionConfig.ReplicaSet)
+		require.Equal(t, "alice", c.SessionConfig.UserConfig.UserID)
+		require.Equal(t, filepath.Join(dir, "crypto/admin/admin.pem"), c.SessionConfig.UserConfig.CertPath)
+		require.Equal(t, 20*time.Second, c.SessionConfig.TxTimeout)
+		require.Equal(t, 2*time.Second, c.SessionConfig.QueryTimeout)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for name, value := range map[string]string{
+			"ORION_REPLICAS":    "node1",
+			"ORION_TLS_ENABLED": "maybe",
+			"ORION_TX_TIMEOUT":  "soon",
+		} {
+			t.Run(name, func(t *testing.T) {
+				t.Setenv(name, value)
+				_, err := LoadConfig("", "ORION")
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "error reading the environment variable "+name)
+			})
+		}
+	})
+}
@@ -0,0 +1,281 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+package config
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// logLevels are the levels of the internal logger
+var logLevels = []string{"debug", "info", "warn", "err", "panic"}
+
+// ValidationError reports all the problems found in a configuration
+type ValidationError struct {
+	// Config is the kind of the configuration, `connection` or `session`
+	Config string
+	// Problems are the descriptions of the problems, in the order of the fields of the configuration
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s configuration: %s", e.Config, strings.Join(e.Problems, "; "))
+}
+
+// problems collects the problems of a configuration
+type problems []string
+
+func (p *problems) add(format string, args ...interface{}) {
+	*p = append(*p, fmt.Sprintf(format, args...))
+}
+
+func (p problems) err(config string) error {
+	if len(p) == 0 {
+		return nil
+	}
+	return &ValidationError{Config: config, Problems: p}
+}
+
+// Validate checks the replica set, the CA certificates and the TLS settings of the connection configuration, and
+// returns a *ValidationError that reports every problem it finds, or nil.
+func (c *ConnectionConfig) Validate() error {
+	var p problems
+
+	if len(c.ReplicaSet) == 0 {
+		p.add("the replica set is empty")
+	}
+	ids := make(map[string]bool)
+	for i, replica := range c.ReplicaSet {
+		if replica == nil {
+			p.add("replica #%d is nil", i)
+			continue
+		}
+		if replica.ID == "" {
+			p.add("replica #%d has no ID", i)
+		} else if ids[replica.ID] {
+			p.add("replica ID [%s] is duplicated", replica.ID)
+		}
+		ids[replica.ID] = true
+
+		endpoint, err := url.Parse(replica.Endpoint)
+		switch {
+		case replica.Endpoint == "":
+			p.add("replica [%s] has no endpoint", replica.ID)
+		case err != nil:
+			p.add("replica [%s] endpoint [%s] cannot be parsed: %s", replica.ID, replica.Endpoint, err)
+		case endpoint.Host == "":
+			p.add("replica [%s] endpoint [%s] has no host", replica.ID, replica.Endpoint)
+		case c.TLSConfig.Enabled && endpoint.Scheme != "https":
+			p.add("replica [%s] endpoint [%s] must use https, as TLS is enabled", replica.ID, replica.Endpoint)
+		case !c.TLSConfig.Enabled && endpoint.Scheme != "http":
+			p.add("replica [%s] endpoint [%s] must use http, as TLS is disabled", replica.ID, replica.Endpoint)
+		}
+	}
+
+	if len(c.RootCAs) == 0 {
+		p.add("no root CA certificate is given")
+	}
+	for _, certPath := range c.RootCAs {
+		p.checkCACertificate("root CA certificate", certPath)
+	}
+
+	if c.TLSConfig.Enabled {
+		if len(c.TLSConfig.CaConfig.RootCACertsPath) == 0 {
+			p.add("no TLS root CA certificate is given, but TLS is enabled")
+		}
+		for _, certPath := range c.TLSConfig.CaConfig.RootCACertsPath {
+			p.checkCACertificate("TLS root CA certificate", certPath)
+		}
+		for _, certPath := range c.TLSConfig.CaConfig.IntermediateCACertsPath {
+			p.checkCACertificate("TLS intermediate CA certificate", certPath)
+		}
+	}
+
+	if c.Logger == nil && c.LogLevel != "" && !contains(logLevels, c.LogLevel) {
+		p.add("log level [%s] is not one of %s", c.LogLevel, strings.Join(logLevels, ", "))
+	}
+
+	if c.ReplicaHealth.FailureThreshold < 0 {
+		p.add("replica health failure threshold [%d] is negative", c.ReplicaHealth.FailureThreshold)
+	}
+	p.checkDuration("replica health probe interval", c.ReplicaHealth.ProbeInterval)
+	p.checkDuration("replica health slow threshold", c.ReplicaHealth.SlowThreshold)
+
+	return p.err("connection")
+}
+
+// Validate checks the user credentials, the client TLS key pair and the timeouts of the session configuration, and
+// returns a *ValidationError that reports every problem it finds, or nil. An encrypted private key is not decrypted,
+// so that no passphrase is requested, hence it is not checked against the certificate.
+func (c *SessionConfig) Validate() error {
+	var p problems
+
+	if c.UserConfig == nil {
+		p.add("the user configuration is missing")
+	} else {
+		p.checkUserConfig(c.UserConfig)
+	}
+
+	clientTLS := c.ClientTLS
+	switch {
+	case clientTLS.ClientCertificatePath == "" && clientTLS.ClientKeyPath == "":
+	case clientTLS.ClientCertificatePath == "":
+		p.add("a client TLS private key is given, but no client TLS certificate")
+	case clientTLS.ClientKeyPath == "":
+		p.add("a client TLS certificate is given, but no client TLS private key")
+	default:
+		cert := p.checkCertificate("client TLS certificate", clientTLS.ClientCertificatePath)
+		keyPEM := p.readFile("client TLS private key", clientTLS.ClientKeyPath)
+		if cert != nil && keyPEM != nil {
+			certPEM, _ := os.ReadFile(clientTLS.ClientCertificatePath)
+			if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+				p.add("client TLS private key [%s] does not match the client TLS certificate [%s]: %s",
+					clientTLS.ClientKeyPath, clientTLS.ClientCertificatePath, err)
+			}
+		}
+	}
+
+	p.checkTimeout("transaction timeout", c.TxTimeout)
+	p.checkTimeout("query timeout", c.QueryTimeout)
+
+	if c.ReadCache != nil {
+		if c.ReadCache.MaxEntries < 0 {
+			p.add("read cache max entries [%d] is negative", c.ReadCache.MaxEntries)
+		}
+		p.checkDuration("read cache max staleness", c.ReadCache.MaxStaleness)
+		p.checkDuration("read cache poll interval", c.ReadCache.PollInterval)
+	}
+
+	return p.err("session")
+}
+
+func (p *problems) checkUserConfig(userConfig *UserConfig) {
+	if userConfig.UserID == "" {
+		p.add("the user ID is empty")
+	}
+
+	var cert *x509.Certificate
+	switch {
+	case len(userConfig.Cert) > 0:
+		cert = p.parseCertificate("user certificate", "", userConfig.Cert)
+	case userConfig.CertPath == "":
+		p.add("no user certificate is given")
+	default:
+		cert = p.checkCertificate("user certificate", userConfig.CertPath)
+	}
+
+	if userConfig.Signer != nil {
+		return
+	}
+	if userConfig.PrivateKeyPath == "" {
+		p.add("no user private key nor signer is given")
+		return
+	}
+	keyPEM := p.readFile("user private key", userConfig.PrivateKeyPath)
+	if keyPEM == nil {
+		return
+	}
+	block := PrivateKeyBlock(keyPEM)
+	if block == nil {
+		p.add("user private key [%s] has no PEM encoded private key", userConfig.PrivateKeyPath)
+		return
+	}
+	if block.Type == "ENCRYPTED PRIVATE KEY" || x509.IsEncryptedPEMBlock(block) {
+		return
+	}
+	key, err := ParsePrivateKey(block.Bytes)
+	if err != nil {
+		p.add("user private key [%s] cannot be parsed: %s", userConfig.PrivateKeyPath, err)
+		return
+	}
+	if cert == nil {
+		return
+	}
+	publicKey, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !publicKey.Equal(cert.PublicKey) {
+		p.add("user private key [%s] does not match the user certificate", userConfig.PrivateKeyPath)
+	}
+}
+
+// checkCACertificate checks that the file holds a valid CA certificate
+func (p *problems) checkCACertificate(name, certPath string) {
+	if cert := p.checkCertificate(name, certPath); cert != nil && !(cert.BasicConstraintsValid && cert.IsCA) {
+		p.add("%s [%s] is not a CA certificate", name, certPath)
+	}
+}
+
+// checkCertificate checks that the file holds a valid certificate, and returns it, or nil if it is not
+func (p *problems) checkCertificate(name, certPath string) *x509.Certificate {
+	certPEM := p.readFile(name, certPath)
+	if certPEM == nil {
+		return nil
+	}
+	return p.parseCertificate(name, certPath, certPEM)
+}
+
+func (p *problems) parseCertificate(name, certPath string, certPEM []byte) *x509.Certificate {
+	if certPath != "" {
+		name = fmt.Sprintf("%s [%s]", name, certPath)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		p.add("%s is not a PEM encoded certificate", name)
+		return nil
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		p.add("%s cannot be parsed: %s", name, err)
+		return nil
+	}
+	now := time.Now()
+	if now.After(cert.NotAfter) {
+		p.add("%s expired on %s", name, cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+	if now.Before(cert.NotBefore) {
+		p.add("%s is not valid before %s", name, cert.NotBefore.UTC().Format(time.RFC3339))
+	}
+	return cert
+}
+
+// readFile returns the content of the file, or nil if it cannot be read
+func (p *problems) readFile(name, filePath string) []byte {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		p.add("%s [%s] cannot be read: %s", name, filePath, err)
+		return nil
+	}
+	return content
+}
+
+// checkTimeout checks that the timeout is not negative, and is at least a millisecond unless it is zero, as a
+// shorter timeout is most likely a duration given without a unit, i.e. in nanoseconds
+func (p *problems) checkTimeout(name string, timeout time.Duration) {
+	switch {
+	case timeout < 0:
+		p.add("%s [%s] is negative", name, timeout)
+	case timeout > 0 && timeout < time.Millisecond:
+		p.add("%s [%s] is shorter than a millisecond, is its unit missing?", name, timeout)
+	}
+}
+
+func (p *problems) checkDuration(name string, d time.Duration) {
+	if d < 0 {
+		p.add("%s [%s] is negative", name, d)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
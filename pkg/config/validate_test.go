@@ -0,0 +1,234 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hyperledger-labs/orion-server/config"
+	"github.com/stretchr/testify/require"
+)
+
+type testCrypto struct {
+	dir    string
+	caKey  *ecdsa.PrivateKey
+	caCert *x509.Certificate
+}
+
+func newTestCrypto(t *testing.T) *testCrypto {
+	c := &testCrypto{dir: t.TempDir()}
+	c.caKey, c.caCert = c.issue(t, "CA", true, nil, nil, time.Now().Add(time.Hour))
+	return c
+}
+
+// issue creates a certificate and its private key in the test directory, signed by the CA unless the
+// certificate is self-signed
+func (c *testCrypto) issue(t *testing.T, name string, isCA bool, signerKey *ecdsa.PrivateKey, signerCert *x509.Certificate, notAfter time.Time) (*ecdsa.PrivateKey, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             notAfter.Add(-2 * time.Hour),
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	if signerKey == nil {
+		signerKey, signerCert = key, template
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(c.path(name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	require.NoError(t, os.WriteFile(c.path(name+".key"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600))
+	return key, cert
+}
+
+func (c *testCrypto) issueUser(t *testing.T, name string, notAfter time.Time) {
+	c.issue(t, name, false, c.caKey, c.caCert, notAfter)
+}
+
+func (c *testCrypto) path(name string) string {
+	return filepath.Join(c.dir, name)
+}
+
+func TestConnectionConfig_Validate(t *testing.T) {
+	c := newTestCrypto(t)
+	c.issueUser(t, "alice", time.Now().Add(time.Hour))
+	c.issue(t, "expiredCA", true, nil, nil, time.Now().Add(-time.Hour))
+	require.NoError(t, os.WriteFile(c.path("garbage.pem"), []byte("not a certificate"), 0644))
+
+	t.Run("valid", func(t *testing.T) {
+		conf := &ConnectionConfig{
+			ReplicaSet: []*Replica{
+				{ID: "node1", Endpoint: "https://127.0.0.1:6001"},
+				{ID: "node2", Endpoint: "https://127.0.0.1:6002"},
+			},
+			RootCAs: []string{c.path("CA.pem")},
+			TLSConfig: ServerTLSConfig{
+				Enabled:  true,
+				CaConfig: config.CAConfiguration{RootCACertsPath: []string{c.path("CA.pem")}},
+			},
+			LogLevel: "debug",
+		}
+		require.NoError(t, conf.Validate())
+	})
+
+	t.Run("all problems", func(t *testing.T) {
+		conf := &ConnectionConfig{
+			ReplicaSet: []*Replica{
+				{ID: "node1", Endpoint: "https://127.0.0.1:6001"},
+				{ID: "node1", Endpoint: "http://127.0.0.1:6002"},
+				{ID: "node3"},
+				nil,
+			},
+			RootCAs: []string{c.path("missing.pem"), c.path("garbage.pem"), c.path("alice.pem"), c.path("expiredCA.pem")},
+			ReplicaHealth: ReplicaHealthConfig{
+				FailureThreshold: -1,
+				ProbeInterval:    -time.Second,
+			},
+			LogLevel: "verbose",
+		}
+		err := conf.Validate()
+		require.Error(t, err)
+		validationErr, ok := err.(*ValidationError)
+		require.True(t, ok)
+		require.Equal(t, "connection", validationErr.Config)
+
+		expiredCA, err := os.ReadFile(c.path("expiredCA.pem"))
+		require.NoError(t, err)
+		block, _ := pem.Decode(expiredCA)
+		expiredCACert, err := x509.ParseCertificate(block.Bytes)
+		require.NoError(t, err)
+
+		require.Equal(t, []string{
+			"replica [node1] endpoint [https://127.0.0.1:6001] must use http, as TLS is disabled",
+			"replica ID [node1] is duplicated",
+			"replica [node3] has no endpoint",
+			"replica #3 is nil",
+			fmt.Sprintf("root CA certificate [%s] cannot be read: open %s: no such file or directory", c.path("missing.pem"), c.path("missing.pem")),
+			fmt.Sprintf("root CA certificate [%s] is not a PEM encoded certificate", c.path("garbage.pem")),
+			fmt.Sprintf("root CA certificate [%s] is not a CA certificate", c.path("alice.pem")),
+			fmt.Sprintf("root CA certificate [%s] expired on %s", c.path("expiredCA.pem"), expiredCACert.NotAfter.UTC().Format(time.RFC3339)),
+			"log level [verbose] is not one of debug, info, warn, err, panic",
+			"replica health failure threshold [-1] is negative",
+			"replica health probe interval [-1s] is negative",
+		}, validationErr.Problems)
+	})
+
+	t.Run("TLS", func(t *testing.T) {
+		conf := &ConnectionConfig{
+			ReplicaSet: []*Replica{{ID: "node1", Endpoint: "http://127.0.0.1:6001"}},
+			RootCAs:    []string{c.path("CA.pem")},
+			TLSConfig:  ServerTLSConfig{Enabled: true},
+		}
+		require.EqualError(t, conf.Validate(), "invalid connection configuration: "+
+			"replica [node1] endpoint [http://127.0.0.1:6001] must use https, as TLS is enabled; "+
+			"no TLS root CA certificate is given, but TLS is enabled")
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		require.EqualError(t, (&ConnectionConfig{}).Validate(), "invalid connection configuration: "+
+			"the replica set is empty; no root CA certificate is given")
+	})
+}
+
+func TestSessionConfig_Validate(t *testing.T) {
+	c := newTestCrypto(t)
+	c.issueUser(t, "alice", time.Now().Add(time.Hour))
+	c.issueUser(t, "bob", time.Now().Add(time.Hour))
+
+	t.Run("valid", func(t *testing.T) {
+		conf := &SessionConfig{
+			UserConfig: &UserConfig{
+				UserID:         "alice",
+				CertPath:       c.path("alice.pem"),
+				PrivateKeyPath: c.path("alice.key"),
+			},
+			TxTimeout:    10 * time.Second,
+			QueryTimeout: 500 * time.Millisecond,
+			ClientTLS: ClientTLSConfig{
+				ClientCertificatePath: c.path("alice.pem"),
+				ClientKeyPath:         c.path("alice.key"),
+			},
+		}
+		require.NoError(t, conf.Validate())
+	})
+
+	t.Run("certificate bytes", func(t *testing.T) {
+		certPEM, err := os.ReadFile(c.path("alice.pem"))
+		require.NoError(t, err)
+		conf := &SessionConfig{
+			UserConfig: &UserConfig{
+				UserID:         "alice",
+				Cert:           certPEM,
+				PrivateKeyPath: c.path("bob.key"),
+			},
+		}
+		require.EqualError(t, conf.Validate(), "invalid session configuration: "+
+			fmt.Sprintf("user private key [%s] does not match the user certificate", c.path("bob.key")))
+	})
+
+	t.Run("all problems", func(t *testing.T) {
+		conf := &SessionConfig{
+			UserConfig: &UserConfig{
+				CertPath:       c.path("alice.pem"),
+				PrivateKeyPath: c.path("bob.key"),
+			},
+			TxTimeout:    10,
+			QueryTimeout: -time.Second,
+			ClientTLS: ClientTLSConfig{
+				ClientCertificatePath: c.path("alice.pem"),
+				ClientKeyPath:         c.path("bob.key"),
+			},
+			ReadCache: &ReadCacheConfig{MaxEntries: -1},
+		}
+		err := conf.Validate()
+		require.Error(t, err)
+		validationErr, ok := err.(*ValidationError)
+		require.True(t, ok)
+		require.Equal(t, "session", validationErr.Config)
+		require.Equal(t, []string{
+			"the user ID is empty",
+			fmt.Sprintf("user private key [%s] does not match the user certificate", c.path("bob.key")),
+			fmt.Sprintf("client TLS private key [%s] does not match the client TLS certificate [%s]: "+
+				"tls: private key does not match public key", c.path("bob.key"), c.path("alice.pem")),
+			"transaction timeout [10ns] is shorter than a millisecond, is its unit missing?",
+			"query timeout [-1s] is negative",
+			"read cache max entries [-1] is negative",
+		}, validationErr.Problems)
+	})
+
+	t.Run("missing credentials", func(t *testing.T) {
+		require.EqualError(t, (&SessionConfig{}).Validate(), "invalid session configuration: "+
+			"the user configuration is missing")
+
+		conf := &SessionConfig{
+			UserConfig: &UserConfig{UserID: "alice"},
+			ClientTLS:  ClientTLSConfig{ClientKeyPath: c.path("alice.key")},
+		}
+		require.EqualError(t, conf.Validate(), "invalid session configuration: "+
+			"no user certificate is given; no user private key nor signer is given; "+
+			"a client TLS private key is given, but no client TLS certificate")
+	})
+}
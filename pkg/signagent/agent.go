@@ -0,0 +1,253 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+package signagent
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/hyperledger-labs/orion-server/pkg/crypto"
+	"github.com/hyperledger-labs/orion-server/pkg/logger"
+	"github.com/pkg/errors"
+)
+
+// maxRequestSize bounds the size of a single request, to protect the agent from misbehaving clients
+var maxRequestSize = 64 * 1024 * 1024
+
+// SignRequest is a request to sign a message, as presented to the approval hook
+type SignRequest struct {
+	// Identity is the identity whose key is requested to sign
+	Identity string
+	// Message is the message to sign, i.e., the marshaled transaction or query payload
+	Message []byte
+}
+
+// ApprovalFunc decides whether a sign request is served. A non-nil error denies the request, and is returned to
+// the client.
+type ApprovalFunc func(req *SignRequest) error
+
+// AgentConfig holds the configuration of the signing agent
+type AgentConfig struct {
+	// Signers hold the keys of the identities the agent serves, each identified by its Identity()
+	Signers []crypto.Signer
+	// Approve, if set, is called for every sign request before it is served
+	Approve ApprovalFunc
+	// Logger instance, if nil an internal logger is created
+	Logger *logger.SugarLogger
+}
+
+// Agent serves sign requests over a Unix domain socket
+type Agent struct {
+	signers map[string]crypto.Signer
+	approve ApprovalFunc
+	logger  *logger.SugarLogger
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewAgent creates a signing agent holding the given signers
+func NewAgent(conf *AgentConfig) (*Agent, error) {
+	if len(conf.Signers) == 0 {
+		return nil, errors.New("at least one signer is required")
+	}
+
+	a := &Agent{
+		signers: make(map[string]crypto.Signer),
+		approve: conf.Approve,
+		logger:  conf.Logger,
+		conns:   make(map[net.Conn]struct{}),
+	}
+	for _, signer := range conf.Signers {
+		identity := signer.Identity()
+		if identity == "" {
+			return nil, errors.New("signer has an empty identity")
+		}
+		if _, ok := a.signers[identity]; ok {
+			return nil, errors.Errorf("more than one signer with identity [%s]", identity)
+		}
+		a.signers[identity] = signer
+	}
+
+	if a.logger == nil {
+		var err error
+		a.logger, err = logger.New(&logger.Config{
+			Level:         "info",
+			OutputPath:    []string{"stdout"},
+			ErrOutputPath: []string{"stderr"},
+			Encoding:      "console",
+			Name:          "signagent",
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// ListenAndServe listens on the Unix domain socket at the given path, accessible to the current user only, and
+// serves requests until the agent is closed
+func (a *Agent) ListenAndServe(socketPath string) error {
+	listener, err := listenPrivate(socketPath)
+	if err != nil {
+		a.logger.Errorf("failed to listen on socket %s, due to %s", socketPath, err)
+		return errors.Wrapf(err, "failed to listen on socket %s", socketPath)
+	}
+	return a.Serve(listener)
+}
+
+// Serve serves requests of the connections accepted by the listener until the agent is closed
+func (a *Agent) Serve(listener net.Listener) error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		listener.Close()
+		return errors.New("agent is closed")
+	}
+	a.listener = listener
+	a.mu.Unlock()
+
+	a.logger.Infof("signing agent serving identities %v on %s", a.Identities(), listener.Addr())
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			a.mu.Lock()
+			closed := a.closed
+			a.mu.Unlock()
+			if closed {
+				return nil
+			}
+			a.logger.Errorf("failed to accept connection, due to %s", err)
+			return errors.Wrap(err, "failed to accept connection")
+		}
+
+		a.mu.Lock()
+		if a.closed {
+			a.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		a.conns[conn] = struct{}{}
+		a.wg.Add(1)
+		a.mu.Unlock()
+
+		go a.serveConn(conn)
+	}
+}
+
+// Close stops accepting connections, closes the open ones, and waits for their handlers to return
+func (a *Agent) Close() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	a.closed = true
+	var err error
+	if a.listener != nil {
+		err = a.listener.Close()
+	}
+	for conn := range a.conns {
+		conn.Close()
+	}
+	a.mu.Unlock()
+
+	a.wg.Wait()
+	return err
+}
+
+// Identities returns the sorted identities the agent holds keys of
+func (a *Agent) Identities() []string {
+	var identities []string
+	for identity := range a.signers {
+		identities = append(identities, identity)
+	}
+	sort.Strings(identities)
+	return identities
+}
+
+func (a *Agent) serveConn(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		a.mu.Lock()
+		delete(a.conns, conn)
+		a.mu.Unlock()
+		a.wg.Done()
+	}()
+
+	reader := bufio.NewReader(conn)
+	encoder := json.NewEncoder(conn)
+	for {
+		line, err := readRequest(reader)
+		if err != nil {
+			if err != io.EOF {
+				a.logger.Debugf("closing connection, failed to read request due to %s", err)
+			}
+			return
+		}
+		req := &request{}
+		if err := json.Unmarshal(line, req); err != nil {
+			a.logger.Debugf("closing connection, failed to decode request due to %s", err)
+			return
+		}
+
+		if err := encoder.Encode(a.handle(req)); err != nil {
+			a.logger.Debugf("closing connection, failed to encode response due to %s", err)
+			return
+		}
+	}
+}
+
+// readRequest reads a single request, i.e. a line, and fails if it is longer than maxRequestSize
+func readRequest(reader *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > maxRequestSize {
+			return nil, errors.Errorf("request exceeds %d bytes", maxRequestSize)
+		}
+		switch {
+		case err == bufio.ErrBufferFull:
+			continue
+		case err == io.EOF && len(line) > 0:
+			return nil, io.ErrUnexpectedEOF
+		case err != nil:
+			return nil, err
+		}
+		return line, nil
+	}
+}
+
+func (a *Agent) handle(req *request) *response {
+	switch req.Op {
+	case OpIdentities:
+		return &response{Identities: a.Identities()}
+	case OpSign:
+		signer, ok := a.signers[req.Identity]
+		if !ok {
+			return &response{Error: "unknown identity [" + req.Identity + "]"}
+		}
+		if a.approve != nil {
+			if err := a.approve(&SignRequest{Identity: req.Identity, Message: req.Message}); err != nil {
+				a.logger.Infof("sign request of identity [%s] denied, due to %s", req.Identity, err)
+				return &response{Error: "sign request denied: " + err.Error()}
+			}
+		}
+		signature, err := signer.Sign(req.Message)
+		if err != nil {
+			a.logger.Errorf("failed to sign with the key of identity [%s], due to %s", req.Identity, err)
+			return &response{Error: "failed to sign: " + err.Error()}
+		}
+		return &response{Signature: signature}
+	default:
+		return &response{Error: "unknown operation [" + req.Op + "]"}
+	}
+}
@@ -0,0 +1,290 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+package signagent
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"net"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyperledger-labs/orion-sdk-go/pkg/bcdb"
+	"github.com/hyperledger-labs/orion-server/pkg/crypto"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgent(t *testing.T) {
+	keys := map[string]*ecdsa.PrivateKey{}
+	var signers []crypto.Signer
+	for _, userID := range []string{"bob", "alice"} {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		keys[userID] = key
+		signer, err := bcdb.NewKeySigner(userID, key)
+		require.NoError(t, err)
+		signers = append(signers, signer)
+	}
+
+	var approved []string
+	var lock sync.Mutex
+	agent, err := NewAgent(&AgentConfig{
+		Signers: signers,
+		Approve: func(req *SignRequest) error {
+			if string(req.Message) == "forbidden" {
+				return errors.New("forbidden message")
+			}
+			lock.Lock()
+			approved = append(approved, req.Identity)
+			lock.Unlock()
+			return nil
+		},
+	})
+	require.NoError(t, err)
+
+	socketPath := path.Join(t.TempDir(), "agent.sock")
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- agent.ListenAndServe(socketPath)
+	}()
+	require.Eventually(t, func() bool {
+		c, err := Dial(socketPath)
+		if err != nil {
+			return false
+		}
+		return c.Close() == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	info, err := os.Stat(socketPath)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	// the private directory the socket was created in is removed
+	entries, err := os.ReadDir(path.Dir(socketPath))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	// the socket of a running agent is not replaced
+	require.Error(t, agent.ListenAndServe(socketPath))
+
+	client, err := Dial(socketPath)
+	require.NoError(t, err)
+	defer client.Close()
+
+	identities, err := client.Identities()
+	require.NoError(t, err)
+	require.Equal(t, []string{"alice", "bob"}, identities)
+
+	t.Run("sign with each identity", func(t *testing.T) {
+		for userID, key := range keys {
+			signer, err := client.Signer(userID)
+			require.NoError(t, err)
+			require.Equal(t, userID, signer.Identity())
+
+			msg := []byte("message of " + userID)
+			signature, err := signer.Sign(msg)
+			require.NoError(t, err)
+			h := sha256.Sum256(msg)
+			require.True(t, ecdsa.VerifyASN1(&key.PublicKey, h[:], signature))
+		}
+	})
+
+	t.Run("concurrent sign requests", func(t *testing.T) {
+		signer, err := client.Signer("alice")
+		require.NoError(t, err)
+		errs := make(chan error, 10)
+		for i := 0; i < 10; i++ {
+			go func() {
+				_, err := signer.Sign([]byte("message"))
+				errs <- err
+			}()
+		}
+		for i := 0; i < 10; i++ {
+			require.NoError(t, <-errs)
+		}
+	})
+
+	t.Run("denied by the approval hook", func(t *testing.T) {
+		_, err := client.Sign("alice", []byte("forbidden"))
+		require.EqualError(t, err, "signing agent: sign request denied: forbidden message")
+	})
+
+	t.Run("unknown identity", func(t *testing.T) {
+		_, err := client.Signer("charlie")
+		require.EqualError(t, err, "the signing agent holds no key of identity [charlie]")
+		_, err = client.Sign("charlie", []byte("message"))
+		require.EqualError(t, err, "signing agent: unknown identity [charlie]")
+	})
+
+	t.Run("reconnect", func(t *testing.T) {
+		require.NoError(t, client.Close())
+		_, err := client.Sign("bob", []byte("message"))
+		require.NoError(t, err)
+	})
+
+	lock.Lock()
+	require.Contains(t, approved, "alice")
+	require.Contains(t, approved, "bob")
+	lock.Unlock()
+
+	require.NoError(t, agent.Close())
+	require.NoError(t, <-serveErr)
+	_, err = os.Stat(socketPath)
+	require.True(t, os.IsNotExist(err))
+	_, err = client.Sign("bob", []byte("message"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to connect to the signing agent")
+}
+
+func TestNewAgent(t *testing.T) {
+	_, err := NewAgent(&AgentConfig{})
+	require.EqualError(t, err, "at least one signer is required")
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	signer, err := bcdb.NewKeySigner("alice", key)
+	require.NoError(t, err)
+	_, err = NewAgent(&AgentConfig{Signers: []crypto.Signer{signer, signer}})
+	require.EqualError(t, err, "more than one signer with identity [alice]")
+
+	noIdentity, err := bcdb.NewKeySigner("", key)
+	require.NoError(t, err)
+	_, err = NewAgent(&AgentConfig{Signers: []crypto.Signer{noIdentity}})
+	require.EqualError(t, err, "signer has an empty identity")
+}
+
+func TestAgent_RequestSizeLimit(t *testing.T) {
+	defer func(size int) { maxRequestSize = size }(maxRequestSize)
+	maxRequestSize = 1024
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	signer, err := bcdb.NewKeySigner("alice", key)
+	require.NoError(t, err)
+	agent, err := NewAgent(&AgentConfig{Signers: []crypto.Signer{signer}})
+	require.NoError(t, err)
+	listener, err := net.Listen("unix", path.Join(t.TempDir(), "agent.sock"))
+	require.NoError(t, err)
+	go agent.Serve(listener)
+	defer agent.Close()
+
+	client, err := Dial(listener.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+
+	// the limit applies to each request, not to the whole connection
+	for i := 0; i < 10; i++ {
+		_, err = client.Sign("alice", make([]byte, 512))
+		require.NoError(t, err)
+	}
+
+	_, err = client.Sign("alice", make([]byte, 2048))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to communicate with the signing agent")
+
+	_, err = client.Sign("alice", make([]byte, 512))
+	require.NoError(t, err)
+}
+
+func TestClient_Retry(t *testing.T) {
+	// fakeAgent serves the requests of each connection with the given handler, until it returns false, and then
+	// closes the connection
+	fakeAgent := func(t *testing.T, handle func(conn net.Conn, req *request) bool) string {
+		listener, err := net.Listen("unix", path.Join(t.TempDir(), "agent.sock"))
+		require.NoError(t, err)
+		t.Cleanup(func() { listener.Close() })
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					decoder := json.NewDecoder(conn)
+					for {
+						req := &request{}
+						if err := decoder.Decode(req); err != nil || !handle(conn, req) {
+							return
+						}
+					}
+				}()
+			}
+		}()
+		return listener.Addr().String()
+	}
+	identities := func(conn net.Conn) bool {
+		return json.NewEncoder(conn).Encode(&response{Identities: []string{"alice"}}) == nil
+	}
+
+	t.Run("stale connection", func(t *testing.T) {
+		closed := make(chan struct{}, 1)
+		var lock sync.Mutex
+		served := 0
+		socketPath := fakeAgent(t, func(conn net.Conn, req *request) bool {
+			lock.Lock()
+			served++
+			first := served == 1
+			lock.Unlock()
+			if !identities(conn) {
+				return false
+			}
+			if first {
+				// the agent closes the connection after the first request
+				closed <- struct{}{}
+				return false
+			}
+			return true
+		})
+		client, err := Dial(socketPath)
+		require.NoError(t, err)
+		defer client.Close()
+
+		_, err = client.Identities()
+		require.NoError(t, err)
+		<-closed
+		// wait for the agent to close the connection, so that the next request cannot be written to it
+		time.Sleep(50 * time.Millisecond)
+
+		ids, err := client.Identities()
+		require.NoError(t, err)
+		require.Equal(t, []string{"alice"}, ids)
+		lock.Lock()
+		require.Equal(t, 2, served)
+		lock.Unlock()
+	})
+
+	t.Run("no retry once sent", func(t *testing.T) {
+		var lock sync.Mutex
+		signRequests := 0
+		socketPath := fakeAgent(t, func(conn net.Conn, req *request) bool {
+			if req.Op == OpSign {
+				// the agent receives the sign request, and breaks the connection before it responds
+				lock.Lock()
+				signRequests++
+				lock.Unlock()
+				return false
+			}
+			return identities(conn)
+		})
+		client, err := Dial(socketPath)
+		require.NoError(t, err)
+		defer client.Close()
+
+		_, err = client.Identities()
+		require.NoError(t, err)
+		_, err = client.Sign("alice", []byte("message"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to communicate with the signing agent")
+
+		// a retry, if any, would reach the agent over a new connection
+		time.Sleep(50 * time.Millisecond)
+		lock.Lock()
+		require.Equal(t, 1, signRequests)
+		lock.Unlock()
+	})
+}
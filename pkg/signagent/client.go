@@ -0,0 +1,153 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+package signagent
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+
+	"github.com/hyperledger-labs/orion-server/pkg/crypto"
+	"github.com/pkg/errors"
+)
+
+// Client is a client of a signing agent. A Client is safe for concurrent use; requests are served one at a time
+// over a single connection, which is re-established if it breaks.
+type Client struct {
+	socketPath string
+
+	mu      sync.Mutex
+	conn    net.Conn
+	encoder *json.Encoder
+	decoder *json.Decoder
+}
+
+// Dial connects to the signing agent listening on the Unix domain socket at the given path
+func Dial(socketPath string) (*Client, error) {
+	c := &Client{socketPath: socketPath}
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Identities returns the sorted identities the agent holds keys of
+func (c *Client) Identities() ([]string, error) {
+	res, err := c.call(&request{Op: OpIdentities})
+	if err != nil {
+		return nil, err
+	}
+	return res.Identities, nil
+}
+
+// Sign signs the message with the key of the identity
+func (c *Client) Sign(identity string, msgBytes []byte) ([]byte, error) {
+	res, err := c.call(&request{
+		Op:       OpSign,
+		Identity: identity,
+		Message:  msgBytes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.Signature, nil
+}
+
+// Signer returns a Signer of the identity that signs through the agent. It can be set as the signer of a session,
+// i.e., as config.UserConfig.Signer.
+func (c *Client) Signer(identity string) (crypto.Signer, error) {
+	identities, err := c.Identities()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range identities {
+		if id == identity {
+			return &agentSigner{client: c, identity: identity}, nil
+		}
+	}
+	return nil, errors.Errorf("the signing agent holds no key of identity [%s]", identity)
+}
+
+// Close closes the connection to the agent
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+func (c *Client) connect() error {
+	conn, err := net.Dial("unix", c.socketPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to connect to the signing agent at %s", c.socketPath)
+	}
+	c.conn = conn
+	c.encoder = json.NewEncoder(conn)
+	c.decoder = json.NewDecoder(conn)
+	return nil
+}
+
+func (c *Client) call(req *request) (*response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	reused := c.conn != nil
+	res, sent, err := c.roundTrip(req)
+	if err != nil && reused && !sent {
+		// the agent may have closed the idle connection, so retry once over a new connection. A request that was
+		// sent is never retried, as the agent may have served it, e.g. asked for its approval and signed it.
+		res, _, err = c.roundTrip(req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if res.Error != "" {
+		return nil, errors.Errorf("signing agent: %s", res.Error)
+	}
+	return res, nil
+}
+
+// roundTrip sends the request and receives its response, and reports whether the request was sent, i.e., whether
+// the agent may have received it
+func (c *Client) roundTrip(req *request) (*response, bool, error) {
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if err := c.encoder.Encode(req); err != nil {
+		c.disconnect()
+		return nil, false, errors.Wrap(err, "failed to communicate with the signing agent")
+	}
+	res := &response{}
+	if err := c.decoder.Decode(res); err != nil {
+		c.disconnect()
+		return nil, true, errors.Wrap(err, "failed to communicate with the signing agent")
+	}
+	return res, true, nil
+}
+
+func (c *Client) disconnect() {
+	c.conn.Close()
+	c.conn = nil
+}
+
+// agentSigner is a Signer of a single identity, which signs through the agent
+type agentSigner struct {
+	client   *Client
+	identity string
+}
+
+func (s *agentSigner) Sign(msgBytes []byte) ([]byte, error) {
+	return s.client.Sign(s.identity, msgBytes)
+}
+
+func (s *agentSigner) Identity() string {
+	return s.identity
+}
@@ -0,0 +1,27 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package signagent
+
+import (
+	"net"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// listenPrivate listens on the Unix domain socket at the given path, and then restricts its permissions to the
+// current user, as the umask cannot be set on this platform
+func listenPrivate(socketPath string) (net.Listener, error) {
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(socketPath, 0600); err != nil {
+		listener.Close()
+		return nil, errors.Wrapf(err, "failed to set the permissions of socket %s", socketPath)
+	}
+	return listener, nil
+}
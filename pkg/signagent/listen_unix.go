@@ -0,0 +1,59 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package signagent
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// listenPrivate listens on the Unix domain socket at the given path, accessible to the current user only. The socket
+// is created in a private directory, where no other user can reach it, and made accessible to the current user only
+// before it is linked at the given path, so that there is no window in which other users can connect. Linking fails
+// if the path exists, e.g. the socket of another agent.
+func listenPrivate(socketPath string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(socketPath), ".signagent-")
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create a private directory for socket %s", socketPath)
+	}
+	defer os.RemoveAll(dir)
+
+	privatePath := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", privatePath)
+	if err != nil {
+		return nil, err
+	}
+	unixListener := listener.(*net.UnixListener)
+	// the socket is unlinked from the given path on close, the private path is removed along with its directory
+	unixListener.SetUnlinkOnClose(false)
+
+	if err = os.Chmod(privatePath, 0600); err != nil {
+		listener.Close()
+		return nil, errors.Wrapf(err, "failed to set the permissions of socket %s", socketPath)
+	}
+	if err = os.Link(privatePath, socketPath); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return &linkedListener{UnixListener: unixListener, path: socketPath}, nil
+}
+
+// linkedListener is a listener on a socket linked at a path, which is unlinked when the listener is closed
+type linkedListener struct {
+	*net.UnixListener
+	path string
+}
+
+func (l *linkedListener) Close() error {
+	err := l.UnixListener.Close()
+	if err == nil {
+		os.Remove(l.path)
+	}
+	return err
+}
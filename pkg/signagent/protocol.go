@@ -0,0 +1,31 @@
+// Copyright IBM Corp. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package signagent implements a signing agent, similar to ssh-agent, which holds the private keys of users and
+// serves sign requests over a Unix domain socket, and a client Signer that signs through the agent. Processes that
+// use the client Signer never hold key material.
+//
+// The protocol is a stream of JSON encoded requests, each on a single line, and each answered by a single JSON
+// encoded response, on the same connection.
+package signagent
+
+const (
+	// OpIdentities lists the identities held by the agent
+	OpIdentities = "identities"
+	// OpSign signs a message with the key of an identity
+	OpSign = "sign"
+)
+
+// request is a request sent to the agent
+type request struct {
+	Op       string `json:"op"`
+	Identity string `json:"identity,omitempty"`
+	Message  []byte `json:"message,omitempty"`
+}
+
+// response is the response of the agent to a single request
+type response struct {
+	Identities []string `json:"identities,omitempty"`
+	Signature  []byte   `json:"signature,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}